@@ -5,25 +5,50 @@ Copyright © 2023 XIAOMIN LAI
 package analyzer
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"path/filepath"
 
 	memfs "github.com/go-git/go-billy/v5/memfs"
 	git "github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	memory "github.com/go-git/go-git/v5/storage/memory"
 
 	sthingsBase "github.com/stuttgart-things/sthingsBase"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Repository struct {
-	Name                  string
-	Url                   string
-	Revision              string
-	Username              string
-	Password              string
+	Name     string
+	Url      string
+	Revision string
+	Username string
+	// Password is never stored or logged with the repository
+	Password string `json:"-" yaml:"-"`
+	// SSHKey is the PEM encoded private key of a resolved credential, never
+	// stored or logged either
+	SSHKey string `json:"-" yaml:"-"`
+	// CredentialRef references the credential of the repository instead of
+	// the password, see ResolveCredentials
+	CredentialRef         string `json:",omitempty" yaml:",omitempty"`
 	Insecure              bool
 	ForceCompleteAnalysis *bool
+	// PatternFile overrides the pattern file of the repository, if set
+	PatternFile string
+	// AllowPlainDirectory allows a local Url to point to a directory that is
+	// no git repository
+	AllowPlainDirectory bool
+	// History backfills the technology history of the revision from the git
+	// log, if set
+	History *HistoryOptions `json:",omitempty" yaml:",omitempty"`
+	// Push are the files changed by the push triggering the analysis, if
+	// known. They are not stored with the result.
+	Push *PushChanges `json:"-" yaml:"-"`
 }
 
 // TechAndPath is a map with technology and a path
@@ -37,21 +62,43 @@ type TechAndPath struct {
 var (
 	log         = sthingsBase.StdOutFileLogger(logfilePath, "2006-01-02 15:04:05", 50, 3, 28)
 	logfilePath = "/tmp/sweatShop-analyzer.log"
+	tracer      = otel.Tracer("github.com/stuttgart-things/sweatShop-analyzer/analyzer")
 )
 
+// SetLogOutput redirects the console output of the analyzer log, e.g. to keep
+// stdout clean for command line output
+func SetLogOutput(w io.Writer) {
+	log.SetOutput(w)
+}
+
 // ConnectRepository tests the repository connection and authentication
 func (repo *Repository) ConnectRepository() error {
+	// Local repositories only need to be readable
+	if path, isLocal := localPath(repo.Url); isLocal {
+		_, err := gitOpenLocal(context.Background(), path)
+		if err == git.ErrRepositoryNotExists && repo.AllowPlainDirectory {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not open repository %s: %w", repo.Url, err)
+		}
+		return nil
+	}
+
+	auth, err := repo.authMethod()
+	if err != nil {
+		return err
+	}
+
 	// Init memory storage and fs
 	storer := memory.NewStorage()
 	fs := memfs.New()
 
 	// Clone repo into memfs
-	_, err := git.Clone(storer, fs, &git.CloneOptions{
-		URL: repo.Url,
-		Auth: &http.BasicAuth{
-			Username: repo.Username,
-			Password: repo.Password,
-		},
+	_, err = git.Clone(storer, fs, &git.CloneOptions{
+		URL:             repo.Url,
+		Auth:            auth,
+		InsecureSkipTLS: repo.Insecure,
 	})
 	if err != nil {
 		return fmt.Errorf("could not git clone repository %s: %w", repo.Url, err)
@@ -61,123 +108,194 @@ func (repo *Repository) ConnectRepository() error {
 	return nil
 }
 
-func (repo *Repository) GetMatchingFiles(ac AnalyzerCacheInterface, ajh AnalyzerJSONHandlerInterface) error {
+// GetMatchingFiles analyzes the repository and writes a new result to the
+// sink, e.g. the redis json handler or a MultiSink
+func (repo *Repository) GetMatchingFiles(ctx context.Context, ac AnalyzerCacheInterface, sink ResultSink) (_ *AnalyzerResultValue, err error) {
 	log.Println("sweatShop-analyzer started")
 	log.Println("GetMatchingFiles for repo:", repo)
 
-	// Clone the repo into memory for later use
-	gitRepo, err := gitCloneRevision(repo)
+	ctx, span := tracer.Start(ctx, "GetMatchingFiles", trace.WithAttributes(
+		attribute.String("repo.url", repo.Url),
+		attribute.String("repo.revision", repo.Revision),
+	))
+	defer func() { endSpan(span, err) }()
 
+	result, cached, err := repo.Analyze(ctx, ac)
 	if err != nil {
-		log.Errorf("could not clone repo: %v", err)
+		return nil, err
+	}
+
+	// If cached and commit ids are the same, the stored result is still valid,
+	// unless the history was requested in addition
+	if cached && result.History == nil {
+		// OUTPUT RESULT DATA TO STDOUT FOR NOW
+		fmt.Println(result.Results)
+
+		return result, nil
 	}
 
-	fmt.Println(gitRepo)
+	// OUTPUT RESULT DATA TO STDOUT FOR NOW
+	// WE MIGHT END UP USING REDIS JSON AS A OUTPUT FOMRAT AND ONLY STORE RESULT-IDS IN REDIS STREAMS
 
-	// read in patterns from config file
-	patternFile, err := getFileList(gitRepo, PATTERNFILENAME)
+	// Set the results in redis json and the other sinks
+	err = sink.SetAnalyzerResult(ctx, result)
 	if err != nil {
-		log.Errorf("could not get pattern file: %v", err)
-		return err
+		log.Errorf("could not set results: %v", err)
+		return nil, err
 	}
 
-	if len(patternFile) == 0 {
-		log.Infof("No pattern file found in git repo. Use default pattern file from sweatShop-analyzer repo.")
-		gitRoot, _ := findGitRoot()
+	return result, nil
+}
+
+// Analyze clones or opens the repository, detects the technologies and
+// caches the results. cached reports whether the results were taken from the
+// cache unchanged.
+func (repo *Repository) Analyze(ctx context.Context, ac AnalyzerCacheInterface) (_ *AnalyzerResultValue, cached bool, err error) {
+
+	// Clone the repo into memory or open the local repo for later use
+	gitRepo, commit, err := openRepository(ctx, repo)
+
+	var files []string
+	switch {
+	case err == ErrPlainDirectory:
+		log.Infof("%s is no git repository, analyze the plain directory", repo.Url)
+		path, _ := localPath(repo.Url)
+		files, err = getDirFileList(path)
+		if err != nil {
+			return nil, false, err
+		}
+
+	case err != nil:
+		log.Errorf("could not clone repo: %v", err)
+		return nil, false, err
 
-		err := getTechsAndPatternsFromFile(filepath.Join(gitRoot, PATTERNFILENAME))
+	default:
+		files, err = getFileList(commit)
 		if err != nil {
-			log.Warnf("could not get techs and patterns from file: %v", err)
+			log.Errorf("could not get file list: %v", err)
+			return nil, false, err
 		}
 	}
 
-	// get current commit id for later comparison
-	currentCommitID, err := gitRepo.Head()
+	// read in patterns from the given file or from config file
+	patterns, err := repo.loadTechsAndPatterns(commit, files)
 	if err != nil {
-		log.Errorf("could not get current commit id: %v", err)
-		return err
+		log.Errorf("could not get techs and patterns: %v", err)
+		return nil, false, err
 	}
 
+	// results of different revisions are cached separately, so that they are
+	// never compared with each other
+	revision := revisionName(gitRepo, repo.Revision)
+
+	// Without git there is no commit to compare, so always run a complete analysis
+	if gitRepo == nil {
+		res, err := initialAnalysis(ctx, files, patterns)
+		if err != nil {
+			return nil, false, err
+		}
+		return &AnalyzerResultValue{Repo: repo.withoutUserinfo(), Revision: revision, Results: res}, false, nil
+	}
+
+	// get current commit id for later comparison
+	currentCommitID := commit.Hash.String()
+	committedAt := commit.Committer.When.UTC()
 	log.Println(currentCommitID)
 
 	// Try to get cached results
-	cached, err := ac.GetMatchingFiles(repo.Url)
+	cachedValue, err := ac.GetMatchingFiles(ctx, repo.Url, revision)
 	if err != nil && err != ErrCacheMiss {
 		log.Warnf("could not get cached results: %v", err)
 	}
 
-	log.Printf("cached: %+v", cached)
+	log.Printf("cached: %+v", cachedValue)
 
 	var res []*TechAndPath
 	// compared the cached commit id with the current commit id
 	if (err != nil && err.Error() == ErrCacheMiss.Error()) || (repo.ForceCompleteAnalysis != nil && *repo.ForceCompleteAnalysis) {
 
 		// If not cached, run initial and complete analysis
-		res, err = initialAnalysis(gitRepo)
+		res, err = initialAnalysis(ctx, files, patterns)
 		if err != nil {
 			log.Warnf("could not run initial analysis: %v", err)
 		}
 
-	} else if cached != nil && cached.CommitID != currentCommitID.Hash().String() {
+	} else if cachedValue != nil && cachedValue.CommitID != currentCommitID {
 
-		// If cached but commit ids are different, run incremental analysis
-		res, err = incrementalAnalysis(gitRepo, cached.CommitID, currentCommitID.Hash().String(), cached.Results)
+		// If cached but commit ids are different, run incremental analysis,
+		// with the files of the push if it triggered the analysis
+		if changes, ok := repo.Push.changes(gitRepo, cachedValue.CommitID, currentCommitID); ok {
+			res, err = pushAnalysis(ctx, gitRepo, currentCommitID, cachedValue.Results, changes, patterns)
+		} else {
+			res, err = incrementalAnalysis(ctx, gitRepo, cachedValue.CommitID, currentCommitID, cachedValue.Results, patterns)
+		}
 		if err != nil {
 			log.Errorf("could not run incremental analysis: %v", err)
-			return err
+			return nil, false, err
 		}
 
 	} else {
 
 		// If cached and commit ids are the same, return cached results
-		res = cached.Results
+		res = cachedValue.Results
 		log.Infof("Using cached results for repo %s: %+v", repo.Url, res)
 
-		// OUTPUT RESULT DATA TO STDOUT FOR NOW
-		fmt.Println(res)
-
-		return nil
+		result, err := repo.withHistory(ctx, gitRepo, commit, patterns, &AnalyzerResultValue{Repo: repo.withoutUserinfo(), Revision: revision, Commit: currentCommitID, CommittedAt: committedAt, Results: res})
+		return result, true, err
 	}
 
 	// cache the new commit id and results
-	err = ac.SetMatchingFiles(repo.Url, currentCommitID.Hash().String(), res)
+	err = ac.SetMatchingFiles(ctx, repo.Url, revision, currentCommitID, res)
 	if err != nil {
 		log.Errorf("could not cache results: %v", err)
-		return err
+		return nil, false, err
 	}
 	log.Infof("Cached results for repo %s: %+v", repo.Url, res)
 
-	// OUTPUT RESULT DATA TO STDOUT FOR NOW
-	// WE MIGHT END UP USING REDIS JSON AS A OUTPUT FOMRAT AND ONLY STORE RESULT-IDS IN REDIS STREAMS
+	result, err := repo.withHistory(ctx, gitRepo, commit, patterns, &AnalyzerResultValue{Repo: repo.withoutUserinfo(), Revision: revision, Commit: currentCommitID, CommittedAt: committedAt, Results: res})
+	return result, false, err
+}
+
+// withHistory adds the technology history to the result, if requested
+func (repo *Repository) withHistory(ctx context.Context, gitRepo *git.Repository, commit *object.Commit, patterns techsAndPatterns, result *AnalyzerResultValue) (*AnalyzerResultValue, error) {
 
-	// Set the results in redis json
-	err = ajh.SetAnalyzerResult(repo, currentCommitID.Hash().String(), res)
+	if repo.History == nil {
+		return result, nil
+	}
+
+	history, err := technologyHistory(ctx, gitRepo, commit, *repo.History, patterns)
 	if err != nil {
-		log.Errorf("could not set results in redis json: %v", err)
-		return err
+		log.Errorf("could not walk the history: %v", err)
+		return nil, err
 	}
+	result.History = history
 
-	return nil
+	return result, nil
 }
 
-func initialAnalysis(gitRepo *git.Repository) ([]*TechAndPath, error) {
+func initialAnalysis(ctx context.Context, files []string, patterns techsAndPatterns) (res []*TechAndPath, err error) {
 
 	log.Infof("Running initial analysis")
 
+	_, span := tracer.Start(ctx, "initialAnalysis", trace.WithAttributes(
+		attribute.Int("analysis.files", len(files)),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("analysis.results", len(res)))
+		endSpan(span, err)
+	}()
+
 	// init results
-	res := make([]*TechAndPath, 0)
+	res = make([]*TechAndPath, 0)
 
-	for t, pattern := range techsAndPatterns {
+	for t, pattern := range patterns {
 		log.Debugf("Checking for technology %s", t)
 
 		matchingFiles := make([]string, 0)
 		// Iterate over the patterns
 		for _, p := range pattern {
 
-			mf, err := getFileList(gitRepo, p)
-			if err != nil {
-				return nil, fmt.Errorf("could not get file list: %v", err)
-			}
+			mf := filterFileList(files, p)
 			log.Debugf("Gathered matching file list: %v", mf)
 
 			matchingFiles = append(matchingFiles, mf...)
@@ -199,16 +317,28 @@ func initialAnalysis(gitRepo *git.Repository) ([]*TechAndPath, error) {
 	return res, nil
 }
 
-func incrementalAnalysis(gitRepo *git.Repository, oldCommitID, newCommitID string, cachedResult []*TechAndPath) ([]*TechAndPath, error) {
+// incrementalAnalysis updates the results of the old commit to the new one by
+// the files created and deleted in between. Like the initial analysis, results
+// are directories: a created file adds its directory, a deleted file removes it
+// only if no other file in the directory still matches the technology.
+func incrementalAnalysis(ctx context.Context, gitRepo *git.Repository, oldCommitID, newCommitID string, cachedResult []*TechAndPath, patterns techsAndPatterns) (_ []*TechAndPath, err error) {
 
 	log.Infof("Running incremental analysis")
 
+	_, span := tracer.Start(ctx, "incrementalAnalysis", trace.WithAttributes(
+		attribute.String("git.commit.old", oldCommitID),
+		attribute.String("git.commit.new", newCommitID),
+	))
+	defer func() { endSpan(span, err) }()
+
 	// git diff
 	patch, err := gitDiff(gitRepo, oldCommitID, newCommitID)
 	if err != nil {
 		return nil, fmt.Errorf("could not get git diff: %v", err)
 	}
 
+	changes := make([]*fileStat, 0)
+
 	// iterate over git diff output
 	for _, fpatch := range patch.FilePatches() {
 		log.Tracef("FilePatch: %+v\n", fpatch)
@@ -219,68 +349,124 @@ func incrementalAnalysis(gitRepo *git.Repository, oldCommitID, newCommitID strin
 		// if renamed, function returns filesAndStats with two entries, to
 		// remove the old file and add the new file
 		// if modified, function returns filesAndStats with zero entries
-		filesAndStats := getFilePathAndStatus(fpatch)
-
-		// iterate over files and stats
-		for _, v := range filesAndStats {
-			file := v.Name
-			fstat := v.Stat
-
-			// check if file already in cache
-			inCache := checkIfFileInCachedResult(file, cachedResult)
-
-			// If file is new and not in cache, run analysis for file
-			if fstat == CREATED && !inCache {
-				log.Infof("File %s is new and not in cache", file)
-
-				for t, pattern := range techsAndPatterns {
-					log.Debugf("Checking for technology %s", t)
-
-					// Iterate over the patterns
-					for _, p := range pattern {
-
-						// Check if file matches pattern
-						matches, err := filepath.Match(p, file)
-						if err != nil {
-							return nil, fmt.Errorf("could not check if file matches pattern: %v", err)
-						}
-
-						if matches {
-							log.Debugf("File %s matches pattern %s", file, p)
-
-							// Append the matching paths to the result
-							cachedResult = append(cachedResult, &TechAndPath{
-								Technology: t,
-								Path:       file,
-							})
-						}
-					}
+		changes = append(changes, getFilePathAndStatus(fpatch)...)
+	}
+
+	return applyChanges(gitRepo, newCommitID, cachedResult, changes, patterns)
+}
+
+// applyChanges updates the cached results by the created and deleted files of
+// the new commit. A file created and deleted again in between must be listed
+// as created first.
+func applyChanges(gitRepo *git.Repository, newCommitID string, cachedResult []*TechAndPath, changes []*fileStat, patterns techsAndPatterns) ([]*TechAndPath, error) {
+
+	// never modify the cached results in place
+	res := append(make([]*TechAndPath, 0, len(cachedResult)), cachedResult...)
+
+	// results of deleted files, which are rechecked against the new tree
+	removed := make([]*TechAndPath, 0)
+
+	for _, v := range changes {
+		file := v.Name
+
+		techs, err := matchingTechnologies(file, patterns)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range techs {
+			tp := &TechAndPath{Technology: t, Path: filepath.Dir(file)}
+
+			switch v.Stat {
+			case CREATED:
+				if !containsTechAndPath(res, tp) {
+					log.Infof("File %s adds %s in %s", file, tp.Technology, tp.Path)
+					res = append(res, tp)
+				}
+			case DELETED:
+				if containsTechAndPath(res, tp) && !containsTechAndPath(removed, tp) {
+					removed = append(removed, tp)
 				}
 			}
+		}
+	}
 
-			// If file is deleted and in cache, remove file from cache
-			if fstat == DELETED && inCache {
-				log.Infof("File %s is deleted and in cache", file)
+	if len(removed) == 0 {
+		return res, nil
+	}
 
-				for i, res := range cachedResult {
-					if res.Path == file {
-						cachedResult = append(cachedResult[:i], cachedResult[i+1:]...)
-					}
-				}
+	// a directory keeps its technology, as long as any file still matches
+	newCommit, err := gitRepo.CommitObject(plumbing.NewHash(newCommitID))
+	if err != nil {
+		return nil, fmt.Errorf("could not get commit object from new commit id: %w", err)
+	}
+	files, err := getFileList(newCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tp := range removed {
+		if directoryMatchesTechnology(files, tp, patterns) {
+			continue
+		}
+
+		log.Infof("%s in %s is removed", tp.Technology, tp.Path)
+		for i, r := range res {
+			if r.Technology == tp.Technology && r.Path == tp.Path {
+				res = append(res[:i], res[i+1:]...)
+				break
 			}
 		}
 	}
 
-	return cachedResult, nil
+	return res, nil
 }
 
-func checkIfFileInCachedResult(file string, cachedResult []*TechAndPath) bool {
+// matchingTechnologies returns the technologies with a pattern matching the file
+func matchingTechnologies(file string, patterns techsAndPatterns) ([]string, error) {
+
+	techs := make([]string, 0)
 
-	for _, res := range cachedResult {
-		if res.Path == file {
-			return true
+	for t, techPatterns := range patterns {
+		for _, p := range techPatterns {
+			matches, err := filepath.Match(p, file)
+			if err != nil {
+				return nil, fmt.Errorf("could not check if file matches pattern: %v", err)
+			}
+			if matches {
+				log.Debugf("File %s matches pattern %s", file, p)
+				techs = append(techs, t)
+				break
+			}
+		}
+	}
+
+	return techs, nil
+}
+
+// directoryMatchesTechnology checks if any of the files in the directory of
+// tp matches a pattern of its technology
+func directoryMatchesTechnology(files []string, tp *TechAndPath, patterns techsAndPatterns) bool {
+
+	for _, f := range files {
+		if filepath.Dir(f) != tp.Path {
+			continue
+		}
+		for _, p := range patterns[tp.Technology] {
+			if matches, err := filepath.Match(p, f); matches && err == nil {
+				return true
+			}
 		}
 	}
 
 	return false
 }
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -2,9 +2,15 @@ package analyzer
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
 	"github.com/go-redis/redismock/v9"
 	"github.com/nitishm/go-rejson/v4"
 	"github.com/stretchr/testify/assert"
@@ -96,7 +102,7 @@ func TestGetMatchingFiles(t *testing.T) {
 			return nil, ErrCacheMiss
 		}
 
-		err := tc.Repo.GetMatchingFiles(cache, h)
+		_, err := tc.Repo.GetMatchingFiles(context.Background(), cache, h)
 		assert.Nil(t, err)
 
 		// change GetMatchingFiles to return cached results
@@ -108,8 +114,141 @@ func TestGetMatchingFiles(t *testing.T) {
 		}
 
 		// use cached results
-		err = tc.Repo.GetMatchingFiles(cache, h)
+		_, err = tc.Repo.GetMatchingFiles(context.Background(), cache, h)
 		assert.Nil(t, err)
 	}
 
 }
+
+func TestAnalyzeLocalRepository(t *testing.T) {
+
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	first := commitTestFiles(t, r, "go.mod", "Dockerfile")
+
+	repo := &Repository{
+		Url:         dir,
+		PatternFile: filepath.Join("..", PATTERNFILENAME),
+	}
+
+	var cached *MatchingFilesValue
+	cache := &AnalyzerCacheMock{}
+	cache.MockedGetMatchingFiles = func(repoURL string) (*MatchingFilesValue, error) {
+		if cached == nil {
+			return nil, ErrCacheMiss
+		}
+		return cached, nil
+	}
+	cache.MockedSetMatchingFiles = func(repoURL, commitId string, res []*TechAndPath) error {
+		cached = &MatchingFilesValue{CommitID: commitId, Results: res}
+		return nil
+	}
+
+	// initial analysis
+	result, fromCache, err := repo.Analyze(context.Background(), cache)
+	assert.NoError(t, err)
+	assert.False(t, fromCache)
+	assert.Equal(t, "master", result.Revision)
+	assert.False(t, result.CommittedAt.IsZero())
+	assert.Equal(t, first.String(), result.Commit)
+	assert.ElementsMatch(t, []*TechAndPath{
+		{Technology: "golang", Path: "."},
+		{Technology: "docker", Path: "."},
+	}, result.Results)
+
+	// unchanged commit
+	_, fromCache, err = repo.Analyze(context.Background(), cache)
+	assert.NoError(t, err)
+	assert.True(t, fromCache)
+
+	// incremental analysis
+	second := commitTestFiles(t, r, "ansible.cfg")
+	result, fromCache, err = repo.Analyze(context.Background(), cache)
+	assert.NoError(t, err)
+	assert.False(t, fromCache)
+	assert.Equal(t, second.String(), result.Commit)
+	techs := make([]string, 0)
+	for _, tp := range result.Results {
+		techs = append(techs, tp.Technology)
+	}
+	assert.ElementsMatch(t, []string{"golang", "docker", "ansible"}, techs)
+}
+
+func TestAnalyzePlainDirectory(t *testing.T) {
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module test"), 0644))
+
+	repo := &Repository{
+		Url:                 dir,
+		PatternFile:         filepath.Join("..", PATTERNFILENAME),
+		AllowPlainDirectory: true,
+	}
+
+	// the cache must not be used without commits
+	cache := &AnalyzerCacheMock{}
+
+	result, fromCache, err := repo.Analyze(context.Background(), cache)
+	assert.NoError(t, err)
+	assert.False(t, fromCache)
+	assert.Empty(t, result.Commit)
+	assert.Equal(t, DefaultRevision, result.Revision)
+	assert.Equal(t, []*TechAndPath{{Technology: "golang", Path: "."}}, result.Results)
+}
+
+// removeTestFiles removes the files from the work tree of r and commits it
+func removeTestFiles(t *testing.T, r *git.Repository, files ...string) plumbing.Hash {
+
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+
+	for _, f := range files {
+		_, err = w.Remove(f)
+		assert.NoError(t, err)
+	}
+
+	hash, err := w.Commit("remove files", &git.CommitOptions{
+		Author: &object.Signature{Name: "sweatShop", Email: "sweatshop@example.com", When: time.Now()},
+	})
+	assert.NoError(t, err)
+
+	return hash
+}
+
+func Test_incrementalAnalysisDirectories(t *testing.T) {
+
+	patterns, err := getTechsAndPatternsFromFile(filepath.Join("..", PATTERNFILENAME))
+	assert.NoError(t, err)
+
+	r, err := git.PlainInit(t.TempDir(), false)
+	assert.NoError(t, err)
+	first := commitTestFiles(t, r, "go.mod", "chart/Chart.yaml")
+
+	commit, err := r.CommitObject(first)
+	assert.NoError(t, err)
+	files, err := getFileList(commit)
+	assert.NoError(t, err)
+	res, err := initialAnalysis(context.Background(), files, patterns)
+	assert.NoError(t, err)
+
+	// a second matching file in the same directory adds nothing
+	second := commitTestFiles(t, r, "chart/Chart.yml", "go.sum")
+	res2, err := incrementalAnalysis(context.Background(), r, first.String(), second.String(), res, patterns)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, res, res2)
+
+	// the directory is kept, as long as one file still matches
+	third := removeTestFiles(t, r, "chart/Chart.yaml", "go.mod")
+	res3, err := incrementalAnalysis(context.Background(), r, second.String(), third.String(), res2, patterns)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, res, res3)
+
+	fourth := removeTestFiles(t, r, "chart/Chart.yml")
+	res4, err := incrementalAnalysis(context.Background(), r, third.String(), fourth.String(), res3, patterns)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*TechAndPath{{Technology: "golang", Path: "."}}, res4)
+
+	// the cached results are not modified
+	assert.Len(t, res3, 2)
+}
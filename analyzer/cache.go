@@ -11,6 +11,9 @@ import (
 
 	gorediscache "github.com/go-redis/cache/v9"
 	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var ErrCacheMiss = errors.New("cache: key is missing")
@@ -18,6 +21,8 @@ var ErrCacheMiss = errors.New("cache: key is missing")
 type MatchingFilesValue struct {
 	CommitID string
 	Results  []*TechAndPath
+	// CachedAt is the time the results were cached
+	CachedAt time.Time
 }
 
 type Item struct {
@@ -28,17 +33,17 @@ type Item struct {
 }
 
 type AnalyzerCacheInterface interface {
-	GetMatchingFiles(repoURL string) (*MatchingFilesValue, error)
-	SetMatchingFiles(repoURL, commitId string, res []*TechAndPath) error
+	GetMatchingFiles(ctx context.Context, repoURL, revision string) (*MatchingFilesValue, error)
+	SetMatchingFiles(ctx context.Context, repoURL, revision, commitId string, res []*TechAndPath) error
 }
 
 type AnalyzerCache struct {
-	client     *goredis.Client
+	client     goredis.UniversalClient
 	cache      *gorediscache.Cache
 	expiration time.Duration
 }
 
-func NewAnalyzerCache(client *goredis.Client, expiration time.Duration) *AnalyzerCache {
+func NewAnalyzerCache(client goredis.UniversalClient, expiration time.Duration) *AnalyzerCache {
 	return &AnalyzerCache{
 		client:     client,
 		cache:      gorediscache.New(&gorediscache.Options{Redis: client}),
@@ -46,39 +51,59 @@ func NewAnalyzerCache(client *goredis.Client, expiration time.Duration) *Analyze
 	}
 }
 
-func matchingFilesKey(repoURL string) string {
-	return fmt.Sprintf("matchingfiles|%s", repoURL)
+func matchingFilesKey(repoID, revision string) string {
+	return schema.MatchingFilesKey(repoID, revision)
 }
 
-func (c *AnalyzerCache) GetMatchingFiles(repoURL string) (*MatchingFilesValue, error) {
+func (c *AnalyzerCache) GetMatchingFiles(ctx context.Context, repoURL, revision string) (_ *MatchingFilesValue, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerCache.GetMatchingFiles", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+		if err == ErrCacheMiss {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+
 	item := &MatchingFilesValue{}
-	return item, c.GetItem(matchingFilesKey(repoURL), item)
+	return item, c.GetItem(ctx, matchingFilesKey(RepositoryID(repoURL), revision), item)
 }
 
-func (c *AnalyzerCache) SetMatchingFiles(repoURL, commitId string, res []*TechAndPath) error {
-	item := &MatchingFilesValue{commitId, res}
-	return c.SetItem(matchingFilesKey(repoURL), item, c.expiration, false)
+func (c *AnalyzerCache) SetMatchingFiles(ctx context.Context, repoURL, revision, commitId string, res []*TechAndPath) (err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerCache.SetMatchingFiles", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+		attribute.String("git.commit", commitId),
+	))
+	defer func() { endSpan(span, err) }()
+
+	item := &MatchingFilesValue{commitId, res, time.Now().UTC()}
+	return c.SetItem(ctx, matchingFilesKey(RepositoryID(repoURL), revision), item, c.expiration, false)
 }
 
-func (c *AnalyzerCache) SetItem(key string, item interface{}, expiration time.Duration, delete bool) error {
+func (c *AnalyzerCache) SetItem(ctx context.Context, key string, item interface{}, expiration time.Duration, delete bool) error {
 	if delete {
-		return c.Delete(key)
+		return c.Delete(ctx, key)
 	} else {
 		if item == nil {
 			return fmt.Errorf("cannot set item to nil for key %s", key)
 		}
-		return c.Set(&Item{Object: item, Key: key, Expiration: expiration})
+		return c.Set(ctx, &Item{Object: item, Key: key, Expiration: expiration})
 	}
 }
 
-func (c *AnalyzerCache) GetItem(key string, item interface{}) error {
+func (c *AnalyzerCache) GetItem(ctx context.Context, key string, item interface{}) error {
 	if item == nil {
 		return fmt.Errorf("cannot get item into a nil for key %s", key)
 	}
-	return c.Get(key, item)
+	return c.Get(ctx, key, item)
 }
 
-func (c *AnalyzerCache) Set(item *Item) error {
+func (c *AnalyzerCache) Set(ctx context.Context, item *Item) error {
 	expiration := item.Expiration
 	if expiration == 0 {
 		expiration = c.expiration
@@ -90,16 +115,17 @@ func (c *AnalyzerCache) Set(item *Item) error {
 	}
 
 	return c.cache.Set(&gorediscache.Item{
+		Ctx:   ctx,
 		Key:   item.Key,
 		Value: val,
 		TTL:   expiration,
 	})
 }
 
-func (c *AnalyzerCache) Get(key string, obj interface{}) error {
+func (c *AnalyzerCache) Get(ctx context.Context, key string, obj interface{}) error {
 	var data []byte
-	err := c.cache.Get(context.TODO(), key, &data)
-	if err == ErrCacheMiss {
+	err := c.cache.Get(ctx, key, &data)
+	if err == gorediscache.ErrCacheMiss {
 		err = ErrCacheMiss
 	}
 	if err != nil {
@@ -108,8 +134,8 @@ func (c *AnalyzerCache) Get(key string, obj interface{}) error {
 	return c.unmarshal(data, obj)
 }
 
-func (c *AnalyzerCache) Delete(key string) error {
-	return c.cache.Delete(context.TODO(), key)
+func (c *AnalyzerCache) Delete(ctx context.Context, key string) error {
+	return c.cache.Delete(ctx, key)
 }
 
 func (c *AnalyzerCache) marshal(obj interface{}) ([]byte, error) {
@@ -136,3 +162,15 @@ func (c *AnalyzerCache) unmarshal(data []byte, obj interface{}) error {
 	}
 	return nil
 }
+
+// NoopAnalyzerCache never holds any results, so every analysis is a complete
+// one. It is used where no redis is available, e.g. for one-shot analyses.
+type NoopAnalyzerCache struct{}
+
+func (NoopAnalyzerCache) GetMatchingFiles(ctx context.Context, repoURL, revision string) (*MatchingFilesValue, error) {
+	return nil, ErrCacheMiss
+}
+
+func (NoopAnalyzerCache) SetMatchingFiles(ctx context.Context, repoURL, revision, commitId string, res []*TechAndPath) error {
+	return nil
+}
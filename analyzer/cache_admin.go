@@ -0,0 +1,198 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CacheEntry describes a cached analysis result
+type CacheEntry struct {
+	Key string
+	// RepoID is the identity of the repository, see RepositoryID
+	RepoID   string
+	Revision string
+	CommitID string
+	// CachedAt is zero for entries cached before it was recorded
+	CachedAt time.Time
+	// TTL is the remaining time to live, negative if the key does not expire
+	TTL     time.Duration
+	Results int
+}
+
+// Age returns how long ago the entry was cached, or zero if unknown
+func (e *CacheEntry) Age(now time.Time) time.Duration {
+	if e.CachedAt.IsZero() {
+		return 0
+	}
+	return now.Sub(e.CachedAt)
+}
+
+// CacheStats summarizes the cached analysis results
+type CacheStats struct {
+	Entries int
+	Results int
+	Oldest  time.Time
+	Newest  time.Time
+	// MinTTL is the remaining time to live of the entry expiring next
+	MinTTL time.Duration
+}
+
+// ListEntries returns the cache entries of all repositories whose url matches
+// the glob pattern, e.g. "https://github.com/stuttgart-things/*"
+func (c *AnalyzerCache) ListEntries(ctx context.Context, urlPattern string) (_ []*CacheEntry, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerCache.ListEntries", trace.WithAttributes(
+		attribute.String("cache.pattern", urlPattern),
+	))
+	defer func() { endSpan(span, err) }()
+
+	keys, err := c.scanKeys(ctx, RepositoryID(urlPattern), "*")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*CacheEntry, 0, len(keys))
+	for _, key := range keys {
+		item := &MatchingFilesValue{}
+		err := c.Get(ctx, key, item)
+		if err == ErrCacheMiss {
+			// expired since the scan
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not get cache entry %s: %w", key, err)
+		}
+
+		ttl, err := c.client.TTL(ctx, key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("could not get ttl of cache entry %s: %w", key, err)
+		}
+
+		repoID, revision := splitRevisionKey(key)
+		entries = append(entries, &CacheEntry{
+			Key:      key,
+			RepoID:   repoID,
+			Revision: revision,
+			CommitID: item.CommitID,
+			CachedAt: item.CachedAt,
+			TTL:      ttl,
+			Results:  len(item.Results),
+		})
+	}
+
+	return entries, nil
+}
+
+// Invalidate removes the cached results of the repository, of all revisions
+// if revision is empty. If commitId is set, they are only removed if they
+// belong to that commit. It reports whether an entry was removed.
+func (c *AnalyzerCache) Invalidate(ctx context.Context, repoURL, revision, commitId string) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerCache.Invalidate", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+		attribute.String("git.commit", commitId),
+	))
+	defer func() { endSpan(span, err) }()
+
+	repoID := RepositoryID(repoURL)
+
+	keys := []string{matchingFilesKey(repoID, revision)}
+	if revision == "" {
+		keys, err = c.scanKeys(ctx, escapeGlob(repoID), "*")
+		if err != nil {
+			return false, err
+		}
+	}
+
+	removed := false
+	for _, key := range keys {
+		if commitId != "" {
+			item := &MatchingFilesValue{}
+			err := c.Get(ctx, key, item)
+			if err == ErrCacheMiss {
+				continue
+			}
+			if err != nil {
+				return removed, err
+			}
+			if item.CommitID != commitId {
+				continue
+			}
+		}
+
+		n, err := c.client.Del(ctx, key).Result()
+		if err != nil {
+			return removed, fmt.Errorf("could not delete cache entry %s: %w", key, err)
+		}
+		removed = removed || n > 0
+	}
+
+	return removed, nil
+}
+
+// Purge removes the cached results of all revisions of the repositories
+// whose url matches the glob pattern, "*" flushes the whole cache. It returns
+// the number of removed entries.
+func (c *AnalyzerCache) Purge(ctx context.Context, urlPattern string) (_ int, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerCache.Purge", trace.WithAttributes(
+		attribute.String("cache.pattern", urlPattern),
+	))
+	defer func() { endSpan(span, err) }()
+
+	keys, err := c.scanKeys(ctx, RepositoryID(urlPattern), "*")
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, key := range keys {
+		n, err := c.client.Del(ctx, key).Result()
+		if err != nil {
+			return purged, fmt.Errorf("could not delete cache entry %s: %w", key, err)
+		}
+		purged += int(n)
+	}
+
+	return purged, nil
+}
+
+// Stats summarizes all cache entries
+func (c *AnalyzerCache) Stats(ctx context.Context) (*CacheStats, error) {
+
+	entries, err := c.ListEntries(ctx, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &CacheStats{Entries: len(entries)}
+	expiring := false
+	for _, e := range entries {
+		stats.Results += e.Results
+
+		if !e.CachedAt.IsZero() && (stats.Oldest.IsZero() || e.CachedAt.Before(stats.Oldest)) {
+			stats.Oldest = e.CachedAt
+		}
+		if e.CachedAt.After(stats.Newest) {
+			stats.Newest = e.CachedAt
+		}
+		if e.TTL >= 0 && (!expiring || e.TTL < stats.MinTTL) {
+			stats.MinTTL = e.TTL
+			expiring = true
+		}
+	}
+
+	return stats, nil
+}
+
+// scanKeys returns the keys matching the patterns, idPattern must already be
+// normalized
+func (c *AnalyzerCache) scanKeys(ctx context.Context, idPattern, revisionPattern string) ([]string, error) {
+	return c.scan(ctx, matchingFilesKey(idPattern, revisionPattern))
+}
+
+func (c *AnalyzerCache) scan(ctx context.Context, pattern string) ([]string, error) {
+	return scanKeys(ctx, c.client, pattern)
+}
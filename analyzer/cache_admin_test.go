@@ -0,0 +1,61 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheAdministration(t *testing.T) {
+
+	redisClient, mock := redismock.NewClientMock()
+	cache := NewAnalyzerCache(redisClient, time.Hour)
+	ctx := context.Background()
+
+	cachedAt := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	value := `{"CommitID":"my-commit-id","Results":[{"Technology":"go","Path":"."}],"CachedAt":"2023-08-01T12:00:00Z"}`
+
+	// list
+	mock.ExpectScan(0, "matchingfiles|github.com/*|*", 100).SetVal([]string{"matchingfiles|github.com/fluxcd/flux2|main"}, 0)
+	mock.ExpectGet("matchingfiles|github.com/fluxcd/flux2|main").SetVal(value)
+	mock.ExpectTTL("matchingfiles|github.com/fluxcd/flux2|main").SetVal(30 * time.Minute)
+
+	entries, err := cache.ListEntries(ctx, "https://github.com/*")
+	assert.NoError(t, err)
+	assert.Equal(t, []*CacheEntry{{
+		Key:      "matchingfiles|github.com/fluxcd/flux2|main",
+		RepoID:   "github.com/fluxcd/flux2",
+		Revision: "main",
+		CommitID: "my-commit-id",
+		CachedAt: cachedAt,
+		TTL:      30 * time.Minute,
+		Results:  1,
+	}}, entries)
+	assert.Equal(t, time.Hour, entries[0].Age(cachedAt.Add(time.Hour)))
+
+	// invalidate other commit
+	mock.ExpectGet("matchingfiles|github.com/fluxcd/flux2|main").SetVal(value)
+	removed, err := cache.Invalidate(ctx, "https://github.com/fluxcd/flux2", "main", "other-commit-id")
+	assert.NoError(t, err)
+	assert.False(t, removed)
+
+	// invalidate all revisions of the url
+	mock.ExpectScan(0, "matchingfiles|github.com/fluxcd/flux2|*", 100).SetVal([]string{"matchingfiles|github.com/fluxcd/flux2|main"}, 0)
+	mock.ExpectDel("matchingfiles|github.com/fluxcd/flux2|main").SetVal(1)
+	removed, err = cache.Invalidate(ctx, "https://github.com/fluxcd/flux2", "", "")
+	assert.NoError(t, err)
+	assert.True(t, removed)
+
+	// purge
+	mock.ExpectScan(0, "matchingfiles|*|*", 100).SetVal([]string{"matchingfiles|a|main", "matchingfiles|b|main"}, 0)
+	mock.ExpectDel("matchingfiles|a|main").SetVal(1)
+	mock.ExpectDel("matchingfiles|b|main").SetVal(1)
+	purged, err := cache.Purge(ctx, "*")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
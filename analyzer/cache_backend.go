@@ -0,0 +1,58 @@
+package analyzer
+
+import (
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// cache backends
+const (
+	CacheBackendRedis  = "redis"
+	CacheBackendMemory = "memory"
+	CacheBackendBolt   = "bolt"
+	CacheBackendNone   = "none"
+)
+
+// CacheConfig selects and configures the backend of the analyzer cache
+type CacheConfig struct {
+	// Backend is one of the CacheBackend constants, redis if empty
+	Backend    string
+	Expiration time.Duration
+	// MaxEntries bounds the memory backend
+	MaxEntries int
+	// Path is the file of the bolt backend
+	Path string
+}
+
+// OpenAnalyzerCache returns the cache of the configured backend and a
+// function closing it. The redis backend uses the client, which is not
+// closed.
+func OpenAnalyzerCache(client goredis.UniversalClient, config CacheConfig) (AnalyzerCacheInterface, func() error, error) {
+
+	noClose := func() error { return nil }
+
+	switch config.Backend {
+	case "", CacheBackendRedis:
+		if client == nil {
+			return nil, noClose, fmt.Errorf("the %s cache needs a redis client", CacheBackendRedis)
+		}
+		return NewAnalyzerCache(client, config.Expiration), noClose, nil
+	case CacheBackendMemory:
+		return NewMemoryAnalyzerCache(config.MaxEntries, config.Expiration), noClose, nil
+	case CacheBackendBolt:
+		if config.Path == "" {
+			return nil, noClose, fmt.Errorf("the %s cache needs a path", CacheBackendBolt)
+		}
+		c, err := OpenBoltAnalyzerCache(config.Path, config.Expiration)
+		if err != nil {
+			return nil, noClose, err
+		}
+		return c, c.Close, nil
+	case CacheBackendNone:
+		return NoopAnalyzerCache{}, noClose, nil
+	}
+
+	return nil, noClose, fmt.Errorf("unknown cache backend %q, expected %s, %s, %s or %s", config.Backend, CacheBackendRedis, CacheBackendMemory, CacheBackendBolt, CacheBackendNone)
+}
@@ -0,0 +1,259 @@
+package analyzer
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adminCache is a cache whose entries can be removed by the cache commands
+type adminCache interface {
+	AnalyzerCacheInterface
+	Invalidate(ctx context.Context, repoURL, revision, commitId string) (bool, error)
+	Purge(ctx context.Context, urlPattern string) (int, error)
+}
+
+// testBackend is a cache under test and a function advancing its clock
+type testBackend struct {
+	cache   adminCache
+	advance func(d time.Duration)
+}
+
+var cacheBackends = map[string]func(t *testing.T, expiration time.Duration) *testBackend{
+	CacheBackendRedis: func(t *testing.T, expiration time.Duration) *testBackend {
+		s := miniredis.RunT(t)
+		client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+		return &testBackend{cache: NewAnalyzerCache(client, expiration), advance: s.FastForward}
+	},
+	CacheBackendMemory: func(t *testing.T, expiration time.Duration) *testBackend {
+		return localTestBackend(NewMemoryAnalyzerCache(100, expiration))
+	},
+	CacheBackendBolt: func(t *testing.T, expiration time.Duration) *testBackend {
+		c, err := OpenBoltAnalyzerCache(filepath.Join(t.TempDir(), "cache.db"), expiration)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return localTestBackend(c)
+	},
+}
+
+func localTestBackend(c *LocalAnalyzerCache) *testBackend {
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	return &testBackend{cache: c, advance: func(d time.Duration) { now = now.Add(d) }}
+}
+
+// TestCacheBackends runs the same tests against all cache backends
+func TestCacheBackends(t *testing.T) {
+
+	for name, open := range cacheBackends {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			testCacheBackend(t, func(expiration time.Duration) *testBackend { return open(t, expiration) })
+		})
+	}
+}
+
+func testCacheBackend(t *testing.T, open func(expiration time.Duration) *testBackend) {
+
+	ctx := context.Background()
+	golang := []*TechAndPath{{Technology: "golang", Path: "."}}
+	docker := []*TechAndPath{{Technology: "docker", Path: "build"}}
+
+	t.Run("get and set", func(t *testing.T) {
+		b := open(time.Hour)
+
+		_, err := b.cache.GetMatchingFiles(ctx, "https://github.com/org/repo", "main")
+		assert.Equal(t, ErrCacheMiss, err)
+
+		assert.NoError(t, b.cache.SetMatchingFiles(ctx, "https://github.com/org/repo", "main", "abc", golang))
+		assert.NoError(t, b.cache.SetMatchingFiles(ctx, "https://github.com/org/repo", "dev", "def", docker))
+
+		// another url of the same repository
+		value, err := b.cache.GetMatchingFiles(ctx, "git@github.com:org/repo.git", "main")
+		require.NoError(t, err)
+		assert.Equal(t, "abc", value.CommitID)
+		assert.Equal(t, golang, value.Results)
+		assert.WithinDuration(t, time.Now(), value.CachedAt, time.Minute)
+
+		// the result is replaced
+		assert.NoError(t, b.cache.SetMatchingFiles(ctx, "https://github.com/org/repo", "main", "ghi", docker))
+		value, err = b.cache.GetMatchingFiles(ctx, "https://github.com/org/repo", "main")
+		require.NoError(t, err)
+		assert.Equal(t, "ghi", value.CommitID)
+		assert.Equal(t, docker, value.Results)
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		b := open(time.Hour)
+
+		assert.NoError(t, b.cache.SetMatchingFiles(ctx, "https://github.com/org/repo", "main", "abc", golang))
+		b.advance(59 * time.Minute)
+		_, err := b.cache.GetMatchingFiles(ctx, "https://github.com/org/repo", "main")
+		assert.NoError(t, err)
+
+		b.advance(2 * time.Minute)
+		_, err = b.cache.GetMatchingFiles(ctx, "https://github.com/org/repo", "main")
+		assert.Equal(t, ErrCacheMiss, err)
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		b := open(time.Hour)
+
+		for _, revision := range []string{"main", "dev"} {
+			assert.NoError(t, b.cache.SetMatchingFiles(ctx, "https://github.com/org/repo", revision, "abc-"+revision, golang))
+		}
+		assert.NoError(t, b.cache.SetMatchingFiles(ctx, "https://github.com/org/repo2", "main", "abc-main", golang))
+
+		// another commit
+		removed, err := b.cache.Invalidate(ctx, "https://github.com/org/repo", "main", "def")
+		assert.NoError(t, err)
+		assert.False(t, removed)
+
+		removed, err = b.cache.Invalidate(ctx, "https://github.com/org/repo", "main", "abc-main")
+		assert.NoError(t, err)
+		assert.True(t, removed)
+		_, err = b.cache.GetMatchingFiles(ctx, "https://github.com/org/repo", "main")
+		assert.Equal(t, ErrCacheMiss, err)
+
+		// all revisions, not those of repo2
+		removed, err = b.cache.Invalidate(ctx, "https://github.com/org/repo", "", "")
+		assert.NoError(t, err)
+		assert.True(t, removed)
+		_, err = b.cache.GetMatchingFiles(ctx, "https://github.com/org/repo", "dev")
+		assert.Equal(t, ErrCacheMiss, err)
+		_, err = b.cache.GetMatchingFiles(ctx, "https://github.com/org/repo2", "main")
+		assert.NoError(t, err)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		b := open(time.Hour)
+
+		for _, url := range []string{"https://github.com/org/a", "https://github.com/org/b", "https://gitlab.com/org/a"} {
+			assert.NoError(t, b.cache.SetMatchingFiles(ctx, url, "main", "abc", golang))
+		}
+
+		purged, err := b.cache.Purge(ctx, "https://github.com/org/*")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, purged)
+
+		_, err = b.cache.GetMatchingFiles(ctx, "https://gitlab.com/org/a", "main")
+		assert.NoError(t, err)
+
+		purged, err = b.cache.Purge(ctx, "*")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, purged)
+	})
+}
+
+func TestMemoryAnalyzerCache(t *testing.T) {
+
+	ctx := context.Background()
+	c := NewMemoryAnalyzerCache(2, time.Hour)
+
+	results := []*TechAndPath{{Technology: "golang", Path: "."}}
+	assert.NoError(t, c.SetMatchingFiles(ctx, "https://github.com/org/a", "main", "abc", results))
+	assert.NoError(t, c.SetMatchingFiles(ctx, "https://github.com/org/b", "main", "abc", results))
+
+	// the cached results are copies
+	results[0].Path = "changed"
+	value, err := c.GetMatchingFiles(ctx, "https://github.com/org/a", "main")
+	require.NoError(t, err)
+	assert.Equal(t, ".", value.Results[0].Path)
+	value.Results[0].Path = "changed"
+
+	// b is the least recently used entry
+	assert.NoError(t, c.SetMatchingFiles(ctx, "https://github.com/org/c", "main", "abc", results))
+	_, err = c.GetMatchingFiles(ctx, "https://github.com/org/b", "main")
+	assert.Equal(t, ErrCacheMiss, err)
+
+	value, err = c.GetMatchingFiles(ctx, "https://github.com/org/a", "main")
+	require.NoError(t, err)
+	assert.Equal(t, ".", value.Results[0].Path)
+
+	keys, err := c.store.keys()
+	assert.NoError(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{"matchingfiles|github.com/org/a|main", "matchingfiles|github.com/org/c|main"}, keys)
+}
+
+func TestBoltAnalyzerCache(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.db")
+
+	c, err := OpenBoltAnalyzerCache(path, time.Hour)
+	require.NoError(t, err)
+	assert.NoError(t, c.SetMatchingFiles(ctx, "https://github.com/org/a", "main", "abc", []*TechAndPath{{Technology: "golang", Path: "."}}))
+	assert.NoError(t, c.Close())
+
+	// the entries outlive the process
+	c, err = OpenBoltAnalyzerCache(path, time.Hour)
+	require.NoError(t, err)
+	defer c.Close()
+	value, err := c.GetMatchingFiles(ctx, "https://github.com/org/a", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", value.CommitID)
+}
+
+func TestOpenAnalyzerCache(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+
+	c, closeCache, err := OpenAnalyzerCache(client, CacheConfig{Expiration: time.Hour})
+	assert.NoError(t, err)
+	assert.IsType(t, &AnalyzerCache{}, c)
+	assert.NoError(t, closeCache())
+
+	c, _, err = OpenAnalyzerCache(nil, CacheConfig{Backend: CacheBackendMemory})
+	assert.NoError(t, err)
+	assert.IsType(t, &LocalAnalyzerCache{}, c)
+
+	c, closeCache, err = OpenAnalyzerCache(nil, CacheConfig{Backend: CacheBackendBolt, Path: filepath.Join(t.TempDir(), "cache.db")})
+	assert.NoError(t, err)
+	assert.IsType(t, &LocalAnalyzerCache{}, c)
+	assert.NoError(t, closeCache())
+
+	c, _, err = OpenAnalyzerCache(nil, CacheConfig{Backend: CacheBackendNone})
+	assert.NoError(t, err)
+	assert.Equal(t, NoopAnalyzerCache{}, c)
+
+	_, _, err = OpenAnalyzerCache(nil, CacheConfig{Backend: CacheBackendBolt})
+	assert.EqualError(t, err, "the bolt cache needs a path")
+	_, _, err = OpenAnalyzerCache(nil, CacheConfig{})
+	assert.EqualError(t, err, "the redis cache needs a redis client")
+	_, _, err = OpenAnalyzerCache(nil, CacheConfig{Backend: "badger"})
+	assert.EqualError(t, err, `unknown cache backend "badger", expected redis, memory, bolt or none`)
+}
+
+func Test_globRegexp(t *testing.T) {
+
+	for pattern, matches := range map[string]map[string]bool{
+		"matchingfiles|github.com/org/*|*": {
+			"matchingfiles|github.com/org/repo|main":  true,
+			"matchingfiles|github.com/org/a/b|main":   true,
+			"matchingfiles|github.com/other/repo|dev": false,
+		},
+		`matchingfiles|github.com/org/\*|?ain`: {
+			"matchingfiles|github.com/org/*|main":    true,
+			"matchingfiles|github.com/org/repo|main": false,
+		},
+		"matchingfiles|github.com/org/[ab]|main": {
+			"matchingfiles|github.com/org/a|main": true,
+			"matchingfiles|github.com/org/c|main": false,
+		},
+	} {
+		re, err := globRegexp(pattern)
+		require.NoError(t, err)
+		for key, match := range matches {
+			assert.Equal(t, match, re.MatchString(key), "%s %s", pattern, key)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("matchingfiles")
+
+// OpenBoltAnalyzerCache returns a cache in the bbolt file at path, which is
+// created if missing. The file is locked until the cache is closed.
+func OpenBoltAnalyzerCache(path string, expiration time.Duration) (*LocalAnalyzerCache, error) {
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open cache file %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create bucket in cache file %s: %w", path, err)
+	}
+
+	return newLocalAnalyzerCache(&boltStore{db: db}, expiration), nil
+}
+
+// boltStore is a cache store in a bbolt file, the entries are json encoded
+type boltStore struct {
+	db *bolt.DB
+}
+
+func (s *boltStore) get(key string) (*cacheEntry, error) {
+
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// the value is only valid in the transaction
+		if v := tx.Bucket(boltBucket).Get([]byte(key)); v != nil {
+			data = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("failed to decode cached data: %w", err)
+	}
+
+	return entry, nil
+}
+
+func (s *boltStore) put(key string, entry *cacheEntry) error {
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *boltStore) remove(key string) (bool, error) {
+
+	removed := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b.Get([]byte(key)) == nil {
+			return nil
+		}
+		removed = true
+		return b.Delete([]byte(key))
+	})
+
+	return removed, err
+}
+
+func (s *boltStore) keys() ([]string, error) {
+
+	keys := make([]string, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+
+	return keys, err
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
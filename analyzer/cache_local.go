@@ -0,0 +1,246 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// cacheEntry is an entry of a local cache store
+type cacheEntry struct {
+	Value *MatchingFilesValue
+	// ExpiresAt is zero for entries that do not expire
+	ExpiresAt time.Time
+}
+
+// cacheStore stores the entries of a local cache by their redis key, so the
+// patterns of the cache commands match the same entries in all backends
+type cacheStore interface {
+	// get returns nil for missing entries
+	get(key string) (*cacheEntry, error)
+	put(key string, entry *cacheEntry) error
+	remove(key string) (bool, error)
+	keys() ([]string, error)
+	close() error
+}
+
+// LocalAnalyzerCache caches the matching files in the process or on disk,
+// without redis. Entries expire like those of the AnalyzerCache.
+type LocalAnalyzerCache struct {
+	store      cacheStore
+	expiration time.Duration
+	now        func() time.Time
+}
+
+func newLocalAnalyzerCache(store cacheStore, expiration time.Duration) *LocalAnalyzerCache {
+	return &LocalAnalyzerCache{store: store, expiration: expiration, now: time.Now}
+}
+
+func (c *LocalAnalyzerCache) GetMatchingFiles(ctx context.Context, repoURL, revision string) (_ *MatchingFilesValue, err error) {
+	_, span := tracer.Start(ctx, "LocalAnalyzerCache.GetMatchingFiles", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+		if err == ErrCacheMiss {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+
+	entry, err := c.get(matchingFilesKey(RepositoryID(repoURL), revision))
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.Value, nil
+}
+
+func (c *LocalAnalyzerCache) SetMatchingFiles(ctx context.Context, repoURL, revision, commitId string, res []*TechAndPath) (err error) {
+	_, span := tracer.Start(ctx, "LocalAnalyzerCache.SetMatchingFiles", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+		attribute.String("git.commit", commitId),
+	))
+	defer func() { endSpan(span, err) }()
+
+	now := c.now()
+	entry := &cacheEntry{Value: &MatchingFilesValue{commitId, res, now.UTC()}}
+	if c.expiration > 0 {
+		entry.ExpiresAt = now.Add(c.expiration)
+	}
+
+	return c.store.put(matchingFilesKey(RepositoryID(repoURL), revision), entry)
+}
+
+// Invalidate removes the cached results like AnalyzerCache.Invalidate
+func (c *LocalAnalyzerCache) Invalidate(ctx context.Context, repoURL, revision, commitId string) (_ bool, err error) {
+	_, span := tracer.Start(ctx, "LocalAnalyzerCache.Invalidate", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+		attribute.String("git.commit", commitId),
+	))
+	defer func() { endSpan(span, err) }()
+
+	repoID := RepositoryID(repoURL)
+
+	keys := []string{matchingFilesKey(repoID, revision)}
+	if revision == "" {
+		keys, err = c.match(matchingFilesKey(escapeGlob(repoID), "*"))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	removed := false
+	for _, key := range keys {
+		if commitId != "" {
+			entry, err := c.get(key)
+			if err == ErrCacheMiss {
+				continue
+			}
+			if err != nil {
+				return removed, err
+			}
+			if entry.Value.CommitID != commitId {
+				continue
+			}
+		}
+
+		ok, err := c.store.remove(key)
+		if err != nil {
+			return removed, fmt.Errorf("could not delete cache entry %s: %w", key, err)
+		}
+		removed = removed || ok
+	}
+
+	return removed, nil
+}
+
+// Purge removes the cached results like AnalyzerCache.Purge
+func (c *LocalAnalyzerCache) Purge(ctx context.Context, urlPattern string) (_ int, err error) {
+	_, span := tracer.Start(ctx, "LocalAnalyzerCache.Purge", trace.WithAttributes(
+		attribute.String("cache.pattern", urlPattern),
+	))
+	defer func() { endSpan(span, err) }()
+
+	keys, err := c.match(matchingFilesKey(RepositoryID(urlPattern), "*"))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, key := range keys {
+		ok, err := c.store.remove(key)
+		if err != nil {
+			return purged, fmt.Errorf("could not delete cache entry %s: %w", key, err)
+		}
+		if ok {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// Close closes the store of the cache
+func (c *LocalAnalyzerCache) Close() error {
+	return c.store.close()
+}
+
+// get returns the entry of the key, expired entries are removed and missed
+func (c *LocalAnalyzerCache) get(key string) (*cacheEntry, error) {
+
+	entry, err := c.store.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrCacheMiss
+	}
+
+	if !entry.ExpiresAt.IsZero() && !c.now().Before(entry.ExpiresAt) {
+		if _, err := c.store.remove(key); err != nil {
+			return nil, fmt.Errorf("could not delete expired cache entry %s: %w", key, err)
+		}
+		return nil, ErrCacheMiss
+	}
+
+	return entry, nil
+}
+
+// match returns the keys of the entries matching the redis glob pattern
+func (c *LocalAnalyzerCache) match(pattern string) ([]string, error) {
+
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := c.store.keys()
+	if err != nil {
+		return nil, fmt.Errorf("could not list cache entries: %w", err)
+	}
+
+	matching := make([]string, 0)
+	for _, key := range keys {
+		if re.MatchString(key) {
+			matching = append(matching, key)
+		}
+	}
+
+	return matching, nil
+}
+
+// globRegexp translates a redis glob pattern: "*" and "?" also match "/",
+// unlike path.Match, and "\" escapes the next character
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if negated, ok := strings.CutPrefix(class, "^"); ok {
+				class = "^" + strings.ReplaceAll(negated, `\`, `\\`)
+			} else {
+				class = strings.ReplaceAll(class, `\`, `\\`)
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	return re, nil
+}
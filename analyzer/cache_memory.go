@@ -0,0 +1,114 @@
+package analyzer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// NewMemoryAnalyzerCache returns a cache in the memory of the process. It
+// holds at most maxEntries entries, unbounded if zero, and evicts the least
+// recently used one first.
+func NewMemoryAnalyzerCache(maxEntries int, expiration time.Duration) *LocalAnalyzerCache {
+	return newLocalAnalyzerCache(&memoryStore{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}, expiration)
+}
+
+// memoryStore is a cache store in the memory, the most recently used entry
+// first in order
+type memoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry cacheEntry
+}
+
+func (s *memoryStore) get(key string) (*cacheEntry, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	s.order.MoveToFront(el)
+
+	return copyEntry(&el.Value.(*memoryItem).entry), nil
+}
+
+func (s *memoryStore) put(key string, entry *cacheEntry) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*memoryItem).entry = *copyEntry(entry)
+		s.order.MoveToFront(el)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryItem{key: key, entry: *copyEntry(entry)})
+
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryItem).key)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) remove(key string) (bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+	s.order.Remove(el)
+	delete(s.entries, key)
+
+	return true, nil
+}
+
+func (s *memoryStore) keys() ([]string, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *memoryStore) close() error {
+	return nil
+}
+
+// copyEntry copies the entry and its results, so the cached results are not
+// changed through the ones passed in or returned
+func copyEntry(entry *cacheEntry) *cacheEntry {
+
+	value := *entry.Value
+	value.Results = make([]*TechAndPath, 0, len(entry.Value.Results))
+	for _, tp := range entry.Value.Results {
+		tp := *tp
+		value.Results = append(value.Results, &tp)
+	}
+
+	return &cacheEntry{Value: &value, ExpiresAt: entry.ExpiresAt}
+}
@@ -1,6 +1,7 @@
 package analyzer
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -14,11 +15,11 @@ type AnalyzerCacheMock struct {
 	MockedSetMatchingFiles func(repoURL, commitId string, res []*TechAndPath) error
 }
 
-func (acm *AnalyzerCacheMock) GetMatchingFiles(repoURL string) (*MatchingFilesValue, error) {
+func (acm *AnalyzerCacheMock) GetMatchingFiles(ctx context.Context, repoURL, revision string) (*MatchingFilesValue, error) {
 	return acm.MockedGetMatchingFiles(repoURL)
 }
 
-func (acm *AnalyzerCacheMock) SetMatchingFiles(repoURL, commitId string, res []*TechAndPath) error {
+func (acm *AnalyzerCacheMock) SetMatchingFiles(ctx context.Context, repoURL, revision, commitId string, res []*TechAndPath) error {
 	return acm.MockedSetMatchingFiles(repoURL, commitId, res)
 }
 
@@ -42,14 +43,14 @@ func TestCache_GetMatchingFiles(t *testing.T) {
 	cache.MockedGetMatchingFiles = func(repoURL string) (*MatchingFilesValue, error) {
 		return nil, ErrCacheMiss
 	}
-	_, err := cache.GetMatchingFiles("my-repo-url")
+	_, err := cache.GetMatchingFiles(context.Background(), "my-repo-url", "main")
 	assert.Equal(t, ErrCacheMiss, err)
 
 	// populate cache
 	cache.MockedSetMatchingFiles = func(repoURL, commitId string, res []*TechAndPath) error {
 		return nil
 	}
-	err = cache.SetMatchingFiles("my-repo-url", "my-commit-id", tcCache)
+	err = cache.SetMatchingFiles(context.Background(), "my-repo-url", "main", "my-commit-id", tcCache)
 	assert.NoError(t, err)
 
 	// cache hit
@@ -59,7 +60,7 @@ func TestCache_GetMatchingFiles(t *testing.T) {
 			Results:  tcCache,
 		}, nil
 	}
-	value, err := cache.GetMatchingFiles("my-repo-url")
+	value, err := cache.GetMatchingFiles(context.Background(), "my-repo-url", "main")
 	assert.NoError(t, err)
 	assert.Equal(t, &MatchingFilesValue{
 		CommitID: "my-commit-id",
@@ -0,0 +1,139 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+)
+
+// CredentialResolver resolves credential references bound to the host of a
+// url and finds the credentials of git servers, implemented by
+// credentials.Resolver
+type CredentialResolver interface {
+	ResolveFor(ctx context.Context, ref, rawURL string) (*credentials.Credential, error)
+	ForURL(ctx context.Context, repoURL string) (*credentials.Credential, error)
+}
+
+// ResolveCredentials sets the credential of the repository. The first of
+// these wins:
+//  1. the password of the request
+//  2. the credential reference of the request, if it is bound to the host of
+//     the url, see credentials.Resolver.ResolveFor
+//  3. the credential of the host of the url, see credentials.Resolver.ForURL
+//
+// The username of the request wins over the one of the credential. Without
+// credential, the repository is cloned anonymously.
+func (repo *Repository) ResolveCredentials(ctx context.Context, resolver CredentialResolver) error {
+
+	if repo.Password != "" || repo.SSHKey != "" {
+		return nil
+	}
+
+	if repo.CredentialRef == "" && resolver == nil {
+		return nil
+	}
+	if resolver == nil {
+		return fmt.Errorf("could not resolve credential %s: no credential providers configured", repo.CredentialRef)
+	}
+
+	var c *credentials.Credential
+	var err error
+	if repo.CredentialRef != "" {
+		c, err = resolver.ResolveFor(ctx, repo.CredentialRef, repo.Url)
+	} else {
+		c, err = resolver.ForURL(ctx, repo.Url)
+	}
+	if err != nil || c == nil {
+		return err
+	}
+
+	if repo.Username == "" {
+		repo.Username = c.Username
+	}
+	repo.Password = c.Password
+	repo.SSHKey = c.SSHKey
+
+	return nil
+}
+
+// authMethod returns the authentication of git clones and pulls: the ssh key
+// of the credential, else basic auth, else none
+func (repo *Repository) authMethod() (transport.AuthMethod, error) {
+
+	if repo.SSHKey != "" {
+		user := repo.Username
+		if user == "" {
+			user = "git"
+		}
+		auth, err := ssh.NewPublicKeys(user, []byte(repo.SSHKey), "")
+		if err != nil {
+			return nil, fmt.Errorf("could not parse ssh key: %w", err)
+		}
+		if repo.Insecure {
+			auth.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+		}
+		return auth, nil
+	}
+
+	if repo.Username != "" || repo.Password != "" {
+		return &http.BasicAuth{
+			Username: repo.Username,
+			Password: repo.Password,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// String describes the repository for logs, without its password, its ssh
+// key and the password of its url
+func (repo *Repository) String() string {
+
+	type repository Repository
+	r := repository(*repo)
+	if r.Password != "" {
+		r.Password = "REDACTED"
+	}
+	if r.SSHKey != "" {
+		r.SSHKey = "REDACTED"
+	}
+	if u, err := url.Parse(r.Url); err == nil && u.User != nil {
+		r.Url = u.Redacted()
+	}
+
+	return fmt.Sprintf("%+v", r)
+}
+
+// URLWithoutUserinfo returns the url without its username and password, so
+// that results and jobs never store them
+func URLWithoutUserinfo(rawURL string) string {
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = nil
+
+	return u.String()
+}
+
+// withoutUserinfo returns the repository of a result, a copy without the
+// username and password of its url if it has them
+func (repo *Repository) withoutUserinfo() *Repository {
+
+	stripped := URLWithoutUserinfo(repo.Url)
+	if stripped == repo.Url {
+		return repo
+	}
+
+	r := *repo
+	r.Url = stripped
+	return &r
+}
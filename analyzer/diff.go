@@ -0,0 +1,39 @@
+package analyzer
+
+// ResultDiff lists the technologies and paths that differ between two results
+type ResultDiff struct {
+	Added   []*TechAndPath
+	Removed []*TechAndPath
+}
+
+// DiffResults compares two results of the same repository
+func DiffResults(from, to []*TechAndPath) *ResultDiff {
+
+	diff := &ResultDiff{
+		Added:   make([]*TechAndPath, 0),
+		Removed: make([]*TechAndPath, 0),
+	}
+
+	for _, tp := range to {
+		if !containsTechAndPath(from, tp) {
+			diff.Added = append(diff.Added, tp)
+		}
+	}
+
+	for _, tp := range from {
+		if !containsTechAndPath(to, tp) {
+			diff.Removed = append(diff.Removed, tp)
+		}
+	}
+
+	return diff
+}
+
+func containsTechAndPath(res []*TechAndPath, tp *TechAndPath) bool {
+	for _, r := range res {
+		if r.Technology == tp.Technology && r.Path == tp.Path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package analyzer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffResults(t *testing.T) {
+
+	from := []*TechAndPath{
+		{Technology: "golang", Path: "."},
+		{Technology: "ansible", Path: "deploy"},
+	}
+	to := []*TechAndPath{
+		{Technology: "golang", Path: "."},
+		{Technology: "helm", Path: "charts/app"},
+	}
+
+	diff := DiffResults(from, to)
+	assert.Equal(t, []*TechAndPath{{Technology: "helm", Path: "charts/app"}}, diff.Added)
+	assert.Equal(t, []*TechAndPath{{Technology: "ansible", Path: "deploy"}}, diff.Removed)
+
+	diff = DiffResults(to, to)
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Removed)
+}
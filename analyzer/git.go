@@ -5,24 +5,41 @@ Copyright © 2023 XIAOMIN LAI
 package analyzer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-git/go-billy/v5/memfs"
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage/memory"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func gitCloneRevision(repo *Repository) (*git.Repository, error) {
+// ErrPlainDirectory is returned for local directories that are no git
+// repository. They are analyzed as is, without incremental analysis.
+var ErrPlainDirectory = errors.New("local directory is not a git repository")
+
+func gitCloneRevision(ctx context.Context, repo *Repository) (_ *git.Repository, err error) {
+
+	ctx, span := tracer.Start(ctx, "gitCloneRevision", trace.WithAttributes(
+		attribute.String("repo.url", repo.Url),
+		attribute.String("repo.revision", repo.Revision),
+	))
+	defer func() { endSpan(span, err) }()
 
 	// Create credentials
-	creds := &http.BasicAuth{
-		Username: repo.Username,
-		Password: repo.Password,
+	creds, err := repo.authMethod()
+	if err != nil {
+		return nil, err
 	}
 
 	// Init memory storage and fs
@@ -30,9 +47,10 @@ func gitCloneRevision(repo *Repository) (*git.Repository, error) {
 	fs := memfs.New()
 
 	// Clone repo into memfs
-	r, err := git.Clone(storer, fs, &git.CloneOptions{
-		URL:  repo.Url,
-		Auth: creds,
+	r, err := git.CloneContext(ctx, storer, fs, &git.CloneOptions{
+		URL:             repo.Url,
+		Auth:            creds,
+		InsecureSkipTLS: repo.Insecure,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("could not git clone: %w", err)
@@ -46,23 +64,23 @@ func gitCloneRevision(repo *Repository) (*git.Repository, error) {
 
 	// If revision is empty, pull master or main branch, otherwise return error
 	if repo.Revision == "" {
-		err = w.Pull(&git.PullOptions{
+		err = w.PullContext(ctx, &git.PullOptions{
 			RemoteName:      git.DefaultRemoteName,
 			Auth:            creds,
-			InsecureSkipTLS: true,
+			InsecureSkipTLS: repo.Insecure,
 		})
-		if err != nil {
+		if err != nil && err != git.NoErrAlreadyUpToDate {
 			return nil, fmt.Errorf("could not git pull master or main: %w", err)
 		}
 
 	} else {
 		// Pull the revision
-		err = w.Pull(&git.PullOptions{
+		err = w.PullContext(ctx, &git.PullOptions{
 			RemoteName:      git.DefaultRemoteName,
 			ReferenceName:   plumbing.NewBranchReferenceName(repo.Revision),
 			Auth:            creds,
 			Force:           true,
-			InsecureSkipTLS: true, // TODO: implement repo.Insecure
+			InsecureSkipTLS: repo.Insecure,
 		})
 		if err != nil && err != git.NoErrAlreadyUpToDate {
 			return nil, fmt.Errorf("could not git pull the given revision: %w", err)
@@ -98,24 +116,150 @@ func gitCloneRevision(repo *Repository) (*git.Repository, error) {
 	return r, nil
 }
 
-func getFileList(r *git.Repository, path string) ([]string, error) {
+// openRepository opens a local repository in place or clones a remote one.
+// It returns the commit to analyze: the given revision of a local repository,
+// or HEAD of the clone, which is checked out at the revision.
+func openRepository(ctx context.Context, repo *Repository) (*git.Repository, *object.Commit, error) {
 
-	// ... retrieving the branch being pointed by HEAD
-	ref, err := r.Head()
-	log.Debugf("output ref: %#v", ref)
+	path, isLocal := localPath(repo.Url)
+	if !isLocal {
+		r, err := gitCloneRevision(ctx, repo)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		commit, err := resolveCommit(r, "")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return r, commit, nil
+	}
+
+	r, err := gitOpenLocal(ctx, path)
+	if err != nil {
+		if err == git.ErrRepositoryNotExists && repo.AllowPlainDirectory {
+			return nil, nil, ErrPlainDirectory
+		}
+		return nil, nil, err
+	}
+
+	commit, err := resolveCommit(r, repo.Revision)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, commit, nil
+}
+
+// localPath returns the path of a file:// url or of an existing local
+// directory, and whether the url points to the local file system at all
+func localPath(repoURL string) (string, bool) {
+
+	if strings.HasPrefix(repoURL, "file://") {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return "", false
+		}
+		return u.Path, true
+	}
+
+	// scp-like ssh urls and remote urls never exist as a directory
+	if strings.Contains(repoURL, "://") {
+		return "", false
+	}
+
+	if fi, err := os.Stat(repoURL); err == nil && fi.IsDir() {
+		return repoURL, true
+	}
+
+	return "", false
+}
+
+// gitOpenLocal opens a working tree or a bare repository without cloning it
+func gitOpenLocal(ctx context.Context, path string) (_ *git.Repository, err error) {
+
+	_, span := tracer.Start(ctx, "gitOpenLocal", trace.WithAttributes(
+		attribute.String("repo.path", path),
+	))
+	defer func() {
+		if err == git.ErrRepositoryNotExists {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("could not open local repository: %w", err)
+	}
+
+	r, err := git.PlainOpen(path)
 	if err != nil {
-		log.Debug(err)
-		return nil, fmt.Errorf("could not get HEAD: %w", err)
+		if err == git.ErrRepositoryNotExists {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not open local repository: %w", err)
+	}
+
+	return r, nil
+}
+
+// DefaultRevision is the revision results are stored under, if the revision
+// is empty and HEAD is no branch, e.g. detached or a plain directory
+const DefaultRevision = "HEAD"
+
+// revisionName returns the revision results are stored under: the given
+// revision, or the branch HEAD points to if it is empty
+func revisionName(r *git.Repository, revision string) string {
+
+	if revision != "" {
+		return revision
+	}
+	if r == nil {
+		return DefaultRevision
+	}
+
+	ref, err := r.Head()
+	if err != nil || !ref.Name().IsBranch() {
+		return DefaultRevision
 	}
 
-	// ... retrieving the commit object
-	commit, err := r.CommitObject(ref.Hash())
-	log.Debugf("output commit id: %#v", commit.ID().String())
+	return ref.Name().Short()
+}
+
+// resolveCommit returns the commit of a branch, tag or commit id, or of HEAD
+// if the revision is empty
+func resolveCommit(r *git.Repository, revision string) (*object.Commit, error) {
+
+	var hash plumbing.Hash
+
+	if revision == "" {
+		ref, err := r.Head()
+		if err != nil {
+			return nil, fmt.Errorf("could not get HEAD: %w", err)
+		}
+		hash = ref.Hash()
+
+	} else {
+		h, err := r.ResolveRevision(plumbing.Revision(revision))
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve the given revision %s: %w", revision, err)
+		}
+		hash = *h
+	}
+
+	commit, err := r.CommitObject(hash)
 	if err != nil {
-		log.Debug(err)
 		return nil, fmt.Errorf("could not get commit object: %w", err)
 	}
 
+	return commit, nil
+}
+
+// getFileList returns all files in the tree of the commit
+func getFileList(commit *object.Commit) ([]string, error) {
+
 	// ... retrieve the tree from the commit
 	tree, err := commit.Tree()
 	log.Tracef("output tree: %#v", tree)
@@ -127,21 +271,65 @@ func getFileList(r *git.Repository, path string) ([]string, error) {
 
 	// ... get the files iterator
 	err = tree.Files().ForEach(func(f *object.File) error {
-		if yes, err := filepath.Match(path, f.Name); yes && err == nil {
-			log.Debugf("file: %s, hash: %s", f.Name, f.Hash)
-			files = append(files, f.Name)
-		}
+		log.Tracef("file: %s, hash: %s", f.Name, f.Hash)
+		files = append(files, f.Name)
 		return nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("could not git ls-files: %w", err)
 	}
 
-	log.Debugf("getFileList output: %+v\n", files)
+	return files, nil
+}
+
+// getDirFileList returns all files below dir, relative to dir and slash
+// separated like the paths in a git tree. A .git directory is skipped.
+func getDirFileList(dir string) ([]string, error) {
+
+	files := make([]string, 0)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == git.GitDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list files of directory %s: %w", dir, err)
+	}
 
 	return files, nil
 }
 
+// filterFileList returns the files matching the pattern
+func filterFileList(files []string, pattern string) []string {
+
+	matching := make([]string, 0)
+
+	for _, f := range files {
+		if yes, err := filepath.Match(pattern, f); yes && err == nil {
+			log.Debugf("file %s matches pattern %s", f, pattern)
+			matching = append(matching, f)
+		}
+	}
+
+	return matching
+}
+
 func gitDiff(r *git.Repository, oldCommitID, newCommitID string) (*object.Patch, error) {
 
 	// retrieve the commit object from old commit id
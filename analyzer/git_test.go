@@ -1,11 +1,24 @@
 package analyzer
 
 import (
+	"context"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
 	"github.com/go-redis/redismock/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
 )
 
 func Test_gitDiff(t *testing.T) {
@@ -24,14 +37,14 @@ func Test_gitDiff(t *testing.T) {
 		Revision: "wasm",
 	}
 
-	gitRepo, _ := gitCloneRevision(repo)
+	gitRepo, _ := gitCloneRevision(context.Background(), repo)
 	firstCommitID, _ := gitRepo.Head()
 
 	// populate cache
 	cache.MockedSetMatchingFiles = func(repoURL, commitId string, res []*TechAndPath) error {
 		return nil
 	}
-	err := cache.SetMatchingFiles(repo.Url, firstCommitID.Hash().String(), nil)
+	err := cache.SetMatchingFiles(context.Background(), repo.Url, repo.Revision, firstCommitID.Hash().String(), nil)
 	assert.NoError(t, err)
 	cache.MockedGetMatchingFiles = func(repoURL string) (*MatchingFilesValue, error) {
 		return &MatchingFilesValue{
@@ -41,12 +54,188 @@ func Test_gitDiff(t *testing.T) {
 
 	// change the revision
 	repo.Revision = "master"
-	gitRepo, _ = gitCloneRevision(repo)
+	gitRepo, _ = gitCloneRevision(context.Background(), repo)
 	secondCommitID, _ := gitRepo.Head()
 
-	cached, _ := cache.GetMatchingFiles(repo.Url)
+	cached, _ := cache.GetMatchingFiles(context.Background(), repo.Url, repo.Revision)
 
 	diff, err := gitDiff(gitRepo, cached.CommitID, secondCommitID.Hash().String())
 	assert.NoError(t, err)
 	assert.NotNil(t, diff)
 }
+
+// commitTestFiles writes the files into the work tree of r and commits them
+func commitTestFiles(t *testing.T, r *git.Repository, files ...string) plumbing.Hash {
+
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+
+	for _, f := range files {
+		path := filepath.Join(w.Filesystem.Root(), f)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(f), 0644))
+		_, err = w.Add(f)
+		assert.NoError(t, err)
+	}
+
+	hash, err := w.Commit("add "+strings.Join(files, ", "), &git.CommitOptions{
+		Author: &object.Signature{Name: "sweatShop", Email: "sweatshop@example.com", When: time.Now()},
+	})
+	assert.NoError(t, err)
+
+	return hash
+}
+
+func Test_openRepositoryLocal(t *testing.T) {
+
+	// working tree
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	first := commitTestFiles(t, r, "go.mod")
+	assert.NoError(t, r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("develop"), first)))
+	second := commitTestFiles(t, r, "charts/app/Chart.yaml")
+
+	_, commit, err := openRepository(context.Background(), &Repository{Url: dir})
+	assert.NoError(t, err)
+	assert.Equal(t, second, commit.Hash)
+
+	_, commit, err = openRepository(context.Background(), &Repository{Url: "file://" + dir, Revision: "develop"})
+	assert.NoError(t, err)
+	assert.Equal(t, first, commit.Hash)
+
+	files, err := getFileList(commit)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"go.mod"}, files)
+
+	// bare repository
+	bare := filepath.Join(t.TempDir(), "bare.git")
+	_, err = git.PlainClone(bare, true, &git.CloneOptions{URL: dir})
+	assert.NoError(t, err)
+
+	_, commit, err = openRepository(context.Background(), &Repository{Url: bare, Revision: "master"})
+	assert.NoError(t, err)
+	assert.Equal(t, second, commit.Hash)
+
+	// plain directory
+	plain := t.TempDir()
+	_, _, err = openRepository(context.Background(), &Repository{Url: plain})
+	assert.Error(t, err)
+	_, _, err = openRepository(context.Background(), &Repository{Url: plain, AllowPlainDirectory: true})
+	assert.Equal(t, ErrPlainDirectory, err)
+}
+
+// gitHTTPBackend serves the bare repository /repo.git with git http-backend
+// and returns its head
+func gitHTTPBackend(t *testing.T) (http.Handler, plumbing.Hash) {
+
+	gitPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	head := commitTestFiles(t, r, "go.mod")
+	root := t.TempDir()
+	_, err = git.PlainClone(filepath.Join(root, "repo.git"), true, &git.CloneOptions{URL: dir})
+	assert.NoError(t, err)
+
+	return &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(gitPath)), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}, head
+}
+
+func Test_gitCloneRevisionCredentials(t *testing.T) {
+
+	// served behind basic auth
+	backend, head := gitHTTPBackend(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "analyzer" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	url := server.URL + "/repo.git"
+
+	// anonymous
+	_, err := gitCloneRevision(ctx, &Repository{Url: url})
+	assert.Error(t, err)
+
+	// the credential of the host
+	netrc, err := credentials.ParseNetrc("machine 127.0.0.1 login analyzer password secret")
+	assert.NoError(t, err)
+	repo := &Repository{Url: url, Revision: "master"}
+	assert.NoError(t, repo.ResolveCredentials(ctx, &credentials.Resolver{Hosts: []credentials.HostSource{netrc}}))
+
+	cloned, err := gitCloneRevision(ctx, repo)
+	if assert.NoError(t, err) {
+		ref, err := cloned.Head()
+		assert.NoError(t, err)
+		assert.Equal(t, head, ref.Hash())
+	}
+}
+
+func Test_gitCloneRevisionInsecure(t *testing.T) {
+
+	// self-signed certificate
+	backend, head := gitHTTPBackend(t)
+	server := httptest.NewTLSServer(backend)
+	defer server.Close()
+
+	ctx := context.Background()
+	url := server.URL + "/repo.git"
+
+	_, err := gitCloneRevision(ctx, &Repository{Url: url})
+	assert.Error(t, err)
+	assert.Error(t, (&Repository{Url: url}).ConnectRepository())
+
+	for _, revision := range []string{"", "master"} {
+		r, err := gitCloneRevision(ctx, &Repository{Url: url, Revision: revision, Insecure: true})
+		if assert.NoError(t, err) {
+			ref, err := r.Head()
+			assert.NoError(t, err)
+			assert.Equal(t, head, ref.Hash())
+		}
+	}
+	assert.NoError(t, (&Repository{Url: url, Insecure: true}).ConnectRepository())
+}
+
+func Test_localPath(t *testing.T) {
+
+	dir := t.TempDir()
+
+	for url, expected := range map[string]bool{
+		dir:                                  true,
+		"file://" + dir:                      true,
+		"https://github.com/fluxcd/flux2":    false,
+		"git@github.com:fluxcd/flux2.git":    false,
+		filepath.Join(dir, "does-not-exist"): false,
+	} {
+		_, isLocal := localPath(url)
+		assert.Equal(t, expected, isLocal, url)
+	}
+}
+
+func Test_revisionName(t *testing.T) {
+
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	first := commitTestFiles(t, r, "go.mod")
+
+	assert.Equal(t, "develop", revisionName(r, "develop"))
+	assert.Equal(t, "master", revisionName(r, ""))
+	assert.Equal(t, DefaultRevision, revisionName(nil, ""))
+
+	// detached HEAD
+	assert.NoError(t, r.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, first)))
+	assert.Equal(t, DefaultRevision, revisionName(r, ""))
+}
@@ -0,0 +1,237 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HistorySampling reduces the commits of a history walk to one per period
+type HistorySampling string
+
+const (
+	// SampleCommits walks every commit
+	SampleCommits HistorySampling = ""
+	// SampleDay walks the last commit of each day
+	SampleDay HistorySampling = "day"
+	// SampleWeek walks the last commit of each ISO week
+	SampleWeek HistorySampling = "week"
+)
+
+// ParseHistorySampling validates a sampling, "commit" and "" walk every commit
+func ParseHistorySampling(s string) (HistorySampling, error) {
+
+	switch HistorySampling(s) {
+	case SampleCommits, "commit":
+		return SampleCommits, nil
+	case SampleDay, SampleWeek:
+		return HistorySampling(s), nil
+	}
+
+	return "", fmt.Errorf("unknown history sampling %q, expected commit, day or week", s)
+}
+
+// HistoryOptions configure the walk of the git log
+type HistoryOptions struct {
+	// FirstParent only follows the first parent of merge commits, i.e. the
+	// history of the analyzed branch itself
+	FirstParent bool
+	// Sample walks only the last commit of each period. Changes are then
+	// attributed to the sampled commit, not to the one which made them.
+	Sample HistorySampling `json:",omitempty" yaml:",omitempty"`
+}
+
+// TechnologyHistory is the timeline of the technologies and paths of a
+// revision, backfilled by walking its git log
+type TechnologyHistory struct {
+	FirstParent bool
+	Sample      HistorySampling `json:",omitempty" yaml:",omitempty"`
+	// Commits is the number of walked commits
+	Commits int
+	Entries []*TechAndPathHistory
+}
+
+// TechAndPathHistory is a period a technology was present in a path. A path
+// which is removed and added again has an entry per period.
+type TechAndPathHistory struct {
+	Technology string
+	Path       string
+	Introduced *HistoryCommit
+	// Removed is nil if the path is still present
+	Removed *HistoryCommit `json:",omitempty" yaml:",omitempty"`
+}
+
+// HistoryCommit is the commit which introduced or removed a path
+type HistoryCommit struct {
+	Commit string
+	Author string
+	Date   time.Time
+}
+
+func newHistoryCommit(c *object.Commit) *HistoryCommit {
+	return &HistoryCommit{
+		Commit: c.Hash.String(),
+		Author: fmt.Sprintf("%s <%s>", c.Author.Name, c.Author.Email),
+		Date:   c.Author.When.UTC(),
+	}
+}
+
+// technologyHistory walks the log up to head, the oldest commit first. The
+// first commit is analyzed completely, every following one incrementally
+// against its predecessor in the walk, so each change of the results is
+// attributed to the commit that made it.
+func technologyHistory(ctx context.Context, r *git.Repository, head *object.Commit, opts HistoryOptions, patterns techsAndPatterns) (_ *TechnologyHistory, err error) {
+
+	ctx, span := tracer.Start(ctx, "technologyHistory", trace.WithAttributes(
+		attribute.String("git.commit", head.Hash.String()),
+		attribute.Bool("history.first_parent", opts.FirstParent),
+		attribute.String("history.sample", string(opts.Sample)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	commits, err := historyCommits(r, head, opts.FirstParent)
+	if err != nil {
+		return nil, err
+	}
+	commits = sampleCommits(commits, opts.Sample)
+	span.SetAttributes(attribute.Int("history.commits", len(commits)))
+
+	history := &TechnologyHistory{
+		FirstParent: opts.FirstParent,
+		Sample:      opts.Sample,
+		Commits:     len(commits),
+		Entries:     make([]*TechAndPathHistory, 0),
+	}
+
+	// the entries of the paths present in the previous commit
+	open := make(map[TechAndPath]*TechAndPathHistory)
+
+	var res []*TechAndPath
+	for i, c := range commits {
+
+		var next []*TechAndPath
+		if i == 0 {
+			files, err := getFileList(c)
+			if err != nil {
+				return nil, err
+			}
+			next, err = initialAnalysis(ctx, files, patterns)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			next, err = incrementalAnalysis(ctx, r, commits[i-1].Hash.String(), c.Hash.String(), res, patterns)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		diff := DiffResults(res, next)
+
+		for _, tp := range diff.Removed {
+			if entry := open[*tp]; entry != nil {
+				entry.Removed = newHistoryCommit(c)
+				delete(open, *tp)
+			}
+		}
+
+		for _, tp := range diff.Added {
+			entry := &TechAndPathHistory{
+				Technology: tp.Technology,
+				Path:       tp.Path,
+				Introduced: newHistoryCommit(c),
+			}
+			history.Entries = append(history.Entries, entry)
+			open[*tp] = entry
+		}
+
+		res = next
+	}
+
+	sort.SliceStable(history.Entries, func(i, j int) bool {
+		a, b := history.Entries[i], history.Entries[j]
+		if a.Technology != b.Technology {
+			return a.Technology < b.Technology
+		}
+		return a.Path < b.Path
+	})
+
+	return history, nil
+}
+
+// historyCommits returns the commits reachable from head, the oldest first.
+// Without firstParent all commits are returned ordered by commit time.
+func historyCommits(r *git.Repository, head *object.Commit, firstParent bool) ([]*object.Commit, error) {
+
+	commits := make([]*object.Commit, 0)
+
+	if firstParent {
+		c := head
+		for {
+			commits = append(commits, c)
+			if c.NumParents() == 0 {
+				break
+			}
+
+			parent, err := c.Parent(0)
+			if err != nil {
+				return nil, fmt.Errorf("could not get parent of commit %s: %w", c.Hash, err)
+			}
+			c = parent
+		}
+
+	} else {
+		iter, err := r.Log(&git.LogOptions{From: head.Hash, Order: git.LogOrderCommitterTime})
+		if err != nil {
+			return nil, fmt.Errorf("could not get git log: %w", err)
+		}
+
+		err = iter.ForEach(func(c *object.Commit) error {
+			commits = append(commits, c)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not walk git log: %w", err)
+		}
+	}
+
+	// the log is walked from head backwards
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+
+	return commits, nil
+}
+
+// sampleCommits keeps the last commit of each period of the commit time. The
+// commits are the oldest first, the last one is always kept.
+func sampleCommits(commits []*object.Commit, sample HistorySampling) []*object.Commit {
+
+	if sample == SampleCommits {
+		return commits
+	}
+
+	period := func(c *object.Commit) string {
+		when := c.Committer.When.UTC()
+		if sample == SampleWeek {
+			year, week := when.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}
+		return when.Format("2006-01-02")
+	}
+
+	sampled := make([]*object.Commit, 0)
+	for i, c := range commits {
+		if i == len(commits)-1 || period(c) != period(commits[i+1]) {
+			sampled = append(sampled, c)
+		}
+	}
+
+	return sampled
+}
@@ -0,0 +1,94 @@
+package analyzer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_technologyHistory(t *testing.T) {
+
+	patterns, err := getTechsAndPatternsFromFile(filepath.Join("..", PATTERNFILENAME))
+	assert.NoError(t, err)
+
+	r, err := git.PlainInit(t.TempDir(), false)
+	assert.NoError(t, err)
+	first := commitTestFiles(t, r, "go.mod")
+	second := commitTestFiles(t, r, "Dockerfile")
+	third := removeTestFiles(t, r, "Dockerfile")
+	fourth := commitTestFiles(t, r, "Dockerfile", "chart/Chart.yaml")
+
+	head, err := r.CommitObject(fourth)
+	assert.NoError(t, err)
+
+	history, err := technologyHistory(context.Background(), r, head, HistoryOptions{FirstParent: true}, patterns)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, history.Commits)
+
+	type period struct{ Technology, Path, Introduced, Removed string }
+	periods := make([]period, 0)
+	for _, e := range history.Entries {
+		p := period{Technology: e.Technology, Path: e.Path, Introduced: e.Introduced.Commit}
+		if e.Removed != nil {
+			p.Removed = e.Removed.Commit
+		}
+		periods = append(periods, p)
+	}
+
+	assert.Equal(t, []period{
+		{"docker", ".", second.String(), third.String()},
+		{"docker", ".", fourth.String(), ""},
+		{"golang", ".", first.String(), ""},
+		{"helm", "chart", fourth.String(), ""},
+	}, periods)
+	assert.Equal(t, "sweatShop <sweatshop@example.com>", history.Entries[0].Introduced.Author)
+
+	// the full log of a linear history is the same
+	all, err := technologyHistory(context.Background(), r, head, HistoryOptions{}, patterns)
+	assert.NoError(t, err)
+	assert.Equal(t, history.Entries, all.Entries)
+}
+
+func Test_sampleCommits(t *testing.T) {
+
+	commitAt := func(when string) *object.Commit {
+		at, err := time.Parse(time.RFC3339, when)
+		assert.NoError(t, err)
+		return &object.Commit{Message: when, Committer: object.Signature{When: at}}
+	}
+
+	commits := []*object.Commit{
+		commitAt("2023-06-05T08:00:00Z"), // monday
+		commitAt("2023-06-05T18:00:00Z"),
+		commitAt("2023-06-07T09:00:00Z"),
+		commitAt("2023-06-12T09:00:00Z"), // next monday
+		commitAt("2023-06-12T10:00:00Z"),
+	}
+
+	messages := func(commits []*object.Commit) []string {
+		m := make([]string, 0)
+		for _, c := range commits {
+			m = append(m, c.Message)
+		}
+		return m
+	}
+
+	assert.Equal(t, messages(commits), messages(sampleCommits(commits, SampleCommits)))
+	assert.Equal(t, []string{
+		"2023-06-05T18:00:00Z", "2023-06-07T09:00:00Z", "2023-06-12T10:00:00Z",
+	}, messages(sampleCommits(commits, SampleDay)))
+	assert.Equal(t, []string{
+		"2023-06-07T09:00:00Z", "2023-06-12T10:00:00Z",
+	}, messages(sampleCommits(commits, SampleWeek)))
+
+	_, err := ParseHistorySampling("month")
+	assert.Error(t, err)
+	sample, err := ParseHistorySampling("commit")
+	assert.NoError(t, err)
+	assert.Equal(t, SampleCommits, sample)
+}
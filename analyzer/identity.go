@@ -0,0 +1,79 @@
+package analyzer
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scpLikeURL matches the scp-like ssh syntax, e.g. git@github.com:org/repo.git
+var scpLikeURL = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// defaultPorts are dropped from the identity, as they are implied by the scheme
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ssh":   "22",
+	"git":   "9418",
+}
+
+// RepositoryID returns the canonical identity of a repository url. Urls of the
+// same repository get the same identity, regardless of the scheme (https, http,
+// ssh, scp-like ssh or git), user info, default port, host case, a ".git"
+// suffix or a trailing slash, e.g. "github.com/org/repo". Local repositories
+// are identified by "file://" and their absolute path. All cache, result and
+// lock keys are built from the identity, the url itself is kept for display.
+//
+// Glob characters are kept, so url patterns can be normalized the same way.
+func RepositoryID(repoURL string) string {
+
+	repoURL = strings.TrimSpace(repoURL)
+
+	if path, isLocal := localPath(repoURL); isLocal {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		return "file://" + filepath.ToSlash(filepath.Clean(path))
+	}
+
+	var host, port, path string
+
+	if !strings.Contains(repoURL, "://") {
+		m := scpLikeURL.FindStringSubmatch(repoURL)
+		if m == nil {
+			return repoURL
+		}
+		host, path = m[1], m[2]
+
+	} else {
+		u, err := url.Parse(repoURL)
+		if err != nil || u.Host == "" {
+			return repoURL
+		}
+		host, port, path = u.Hostname(), u.Port(), u.Path
+
+		if port == defaultPorts[strings.ToLower(u.Scheme)] {
+			port = ""
+		}
+	}
+
+	id := strings.ToLower(host)
+	if port != "" {
+		id += ":" + port
+	}
+
+	path = strings.Trim(path, "/")
+	path = strings.TrimSuffix(path, ".git")
+	path = strings.TrimRight(path, "/")
+	if path != "" {
+		id += "/" + path
+	}
+
+	return id
+}
+
+// ID returns the canonical identity of the repository
+func (repo *Repository) ID() string {
+	return RepositoryID(repo.Url)
+}
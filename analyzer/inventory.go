@@ -0,0 +1,230 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// inventoryKey is the key of the periodically refreshed inventory document
+const inventoryKey = schema.InventoryKey
+
+// Inventory is the technology inventory of all analyzed repositories, built
+// from the latest result of every analyzed revision
+type Inventory struct {
+	GeneratedAt time.Time
+	// Repositories is the number of distinct repositories
+	Repositories int
+	// Expected are the technologies every repository should contain
+	Expected     []string `json:",omitempty" yaml:",omitempty"`
+	Technologies []*TechnologyInventory
+	// Missing lists the results lacking expected technologies
+	Missing []*MissingTechnologies
+	Results []*ResultInventory
+}
+
+// TechnologyInventory lists the repositories and paths of a technology
+type TechnologyInventory struct {
+	Technology string
+	// Repositories is the number of distinct repositories containing it
+	Repositories int
+	Usages       []*TechnologyUsage
+}
+
+// TechnologyUsage are the paths of a technology in a revision of a repository
+type TechnologyUsage struct {
+	RepoID   string
+	Url      string
+	Revision string
+	Paths    []string
+}
+
+// MissingTechnologies are the expected technologies a result lacks
+type MissingTechnologies struct {
+	RepoID       string
+	Url          string
+	Revision     string
+	Technologies []string
+}
+
+// ResultInventory is the last analysis of a revision of a repository
+type ResultInventory struct {
+	RepoID       string
+	Url          string
+	Revision     string
+	Commit       string
+	AnalyzedAt   time.Time
+	Age          time.Duration
+	Technologies []string
+}
+
+// BuildInventory aggregates the results. Technologies are sorted by the
+// number of repositories, the most common first.
+func BuildInventory(results []*AnalyzerResultValue, expected []string, now time.Time) *Inventory {
+
+	inv := &Inventory{
+		GeneratedAt:  now.UTC(),
+		Expected:     expected,
+		Technologies: make([]*TechnologyInventory, 0),
+		Missing:      make([]*MissingTechnologies, 0),
+		Results:      make([]*ResultInventory, 0, len(results)),
+	}
+
+	repos := make(map[string]bool)
+	techs := make(map[string]*TechnologyInventory)
+	techRepos := make(map[string]map[string]bool)
+
+	for _, r := range results {
+		if r.Repo == nil {
+			continue
+		}
+		repoID := r.Repo.ID()
+		repos[repoID] = true
+
+		// paths per technology, in the order of the results
+		paths := make(map[string][]string)
+		names := make([]string, 0)
+		for _, tp := range r.Results {
+			if _, ok := paths[tp.Technology]; !ok {
+				names = append(names, tp.Technology)
+			}
+			paths[tp.Technology] = append(paths[tp.Technology], tp.Path)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			t := techs[name]
+			if t == nil {
+				t = &TechnologyInventory{Technology: name}
+				techs[name] = t
+				techRepos[name] = make(map[string]bool)
+			}
+			techRepos[name][repoID] = true
+
+			sort.Strings(paths[name])
+			t.Usages = append(t.Usages, &TechnologyUsage{
+				RepoID:   repoID,
+				Url:      r.Repo.Url,
+				Revision: r.Revision,
+				Paths:    paths[name],
+			})
+		}
+
+		missing := make([]string, 0)
+		for _, e := range expected {
+			if _, ok := paths[e]; !ok {
+				missing = append(missing, e)
+			}
+		}
+		if len(missing) > 0 {
+			inv.Missing = append(inv.Missing, &MissingTechnologies{
+				RepoID:       repoID,
+				Url:          r.Repo.Url,
+				Revision:     r.Revision,
+				Technologies: missing,
+			})
+		}
+
+		ri := &ResultInventory{
+			RepoID:       repoID,
+			Url:          r.Repo.Url,
+			Revision:     r.Revision,
+			Commit:       r.Commit,
+			AnalyzedAt:   r.AnalyzedAt,
+			Technologies: names,
+		}
+		if !r.AnalyzedAt.IsZero() {
+			ri.Age = now.Sub(r.AnalyzedAt).Truncate(time.Second)
+		}
+		inv.Results = append(inv.Results, ri)
+	}
+
+	inv.Repositories = len(repos)
+
+	for name, t := range techs {
+		t.Repositories = len(techRepos[name])
+		sort.Slice(t.Usages, func(i, j int) bool {
+			if t.Usages[i].RepoID != t.Usages[j].RepoID {
+				return t.Usages[i].RepoID < t.Usages[j].RepoID
+			}
+			return t.Usages[i].Revision < t.Usages[j].Revision
+		})
+		inv.Technologies = append(inv.Technologies, t)
+	}
+
+	sort.Slice(inv.Technologies, func(i, j int) bool {
+		a, b := inv.Technologies[i], inv.Technologies[j]
+		if a.Repositories != b.Repositories {
+			return a.Repositories > b.Repositories
+		}
+		return a.Technology < b.Technology
+	})
+
+	sort.Slice(inv.Missing, func(i, j int) bool {
+		if inv.Missing[i].RepoID != inv.Missing[j].RepoID {
+			return inv.Missing[i].RepoID < inv.Missing[j].RepoID
+		}
+		return inv.Missing[i].Revision < inv.Missing[j].Revision
+	})
+
+	// the longest unanalyzed first
+	sort.SliceStable(inv.Results, func(i, j int) bool {
+		if inv.Results[i].Age != inv.Results[j].Age {
+			return inv.Results[i].Age > inv.Results[j].Age
+		}
+		if inv.Results[i].RepoID != inv.Results[j].RepoID {
+			return inv.Results[i].RepoID < inv.Results[j].RepoID
+		}
+		return inv.Results[i].Revision < inv.Results[j].Revision
+	})
+
+	return inv
+}
+
+// BuildInventory aggregates all stored results
+func (h *AnalyzerJSONHandler) BuildInventory(ctx context.Context, expected []string) (_ *Inventory, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.BuildInventory")
+	defer func() { endSpan(span, err) }()
+
+	results, err := h.ListAnalyzerResults(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("inventory.results", len(results)))
+
+	return BuildInventory(results, expected, time.Now()), nil
+}
+
+// RefreshInventory builds the inventory and stores it as a document
+func (h *AnalyzerJSONHandler) RefreshInventory(ctx context.Context, expected []string) (_ *Inventory, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.RefreshInventory", trace.WithAttributes(
+		attribute.StringSlice("inventory.expected", expected),
+	))
+	defer func() { endSpan(span, err) }()
+
+	inv, err := h.BuildInventory(ctx, expected)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.SetItem(inventoryKey, inv, false); err != nil {
+		return nil, fmt.Errorf("could not store inventory: %w", err)
+	}
+
+	return inv, nil
+}
+
+// GetInventory returns the stored inventory. The ages are those at the time
+// it was generated.
+func (h *AnalyzerJSONHandler) GetInventory(ctx context.Context) (_ *Inventory, err error) {
+	_, span := tracer.Start(ctx, "AnalyzerJSONHandler.GetInventory")
+	defer func() { endSpan(span, err) }()
+
+	inv := &Inventory{}
+	return inv, h.GetItem(inventoryKey, inv)
+}
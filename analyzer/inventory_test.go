@@ -0,0 +1,76 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInventory(t *testing.T) {
+
+	now := time.Date(2023, 6, 10, 12, 0, 0, 0, time.UTC)
+
+	results := []*AnalyzerResultValue{
+		{
+			Repo:       &Repository{Url: "https://github.com/org/platform"},
+			Revision:   "main",
+			Commit:     "platform-main",
+			AnalyzedAt: now.Add(-time.Hour),
+			Results: []*TechAndPath{
+				{Technology: "terraform", Path: "infra/gcp"},
+				{Technology: "terraform", Path: "infra/aws"},
+				{Technology: "golang", Path: "."},
+			},
+		},
+		{
+			// the same repository with another url and revision
+			Repo:       &Repository{Url: "git@github.com:org/platform.git"},
+			Revision:   "develop",
+			Commit:     "platform-develop",
+			AnalyzedAt: now.Add(-48 * time.Hour),
+			Results: []*TechAndPath{
+				{Technology: "golang", Path: "."},
+			},
+		},
+		{
+			Repo:     &Repository{Url: "https://github.com/org/docs"},
+			Revision: "main",
+			Results: []*TechAndPath{
+				{Technology: "golang", Path: "tools"},
+			},
+		},
+	}
+
+	inv := BuildInventory(results, []string{"golang", "terraform"}, now)
+
+	assert.Equal(t, 2, inv.Repositories)
+	assert.Equal(t, now, inv.GeneratedAt)
+
+	assert.Len(t, inv.Technologies, 2)
+	golang, terraform := inv.Technologies[0], inv.Technologies[1]
+	assert.Equal(t, "golang", golang.Technology)
+	assert.Equal(t, 2, golang.Repositories)
+	assert.Len(t, golang.Usages, 3)
+	assert.Equal(t, "terraform", terraform.Technology)
+	assert.Equal(t, 1, terraform.Repositories)
+	assert.Equal(t, []*TechnologyUsage{{
+		RepoID:   "github.com/org/platform",
+		Url:      "https://github.com/org/platform",
+		Revision: "main",
+		Paths:    []string{"infra/aws", "infra/gcp"},
+	}}, terraform.Usages)
+
+	assert.Equal(t, []*MissingTechnologies{
+		{RepoID: "github.com/org/docs", Url: "https://github.com/org/docs", Revision: "main", Technologies: []string{"terraform"}},
+		{RepoID: "github.com/org/platform", Url: "git@github.com:org/platform.git", Revision: "develop", Technologies: []string{"terraform"}},
+	}, inv.Missing)
+
+	// the longest unanalyzed first, unknown ages last
+	assert.Len(t, inv.Results, 3)
+	assert.Equal(t, "platform-develop", inv.Results[0].Commit)
+	assert.Equal(t, 48*time.Hour, inv.Results[0].Age)
+	assert.Equal(t, "platform-main", inv.Results[1].Commit)
+	assert.Equal(t, []string{"golang", "terraform"}, inv.Results[1].Technologies)
+	assert.True(t, inv.Results[2].AnalyzedAt.IsZero())
+}
@@ -0,0 +1,66 @@
+package analyzer
+
+import (
+	"context"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// Done reports whether the job reached a final state
+func (s JobState) Done() bool {
+	return s == JobSucceeded || s == JobFailed
+}
+
+// JobStatus tracks an enqueued analysis from the producer to its result
+type JobStatus struct {
+	ID string
+	// Type is empty for analysis jobs
+	Type     string `json:",omitempty" yaml:",omitempty"`
+	RepoURL  string
+	Revision string
+	State    JobState
+	// Children are the analysis jobs enqueued by a discovery job
+	Children []string `json:",omitempty" yaml:",omitempty"`
+	// Commit is the analyzed commit, once the job succeeded
+	Commit string
+	// Error describes why the job failed
+	Error     string
+	UpdatedAt time.Time
+}
+
+func analyzerJobKey(jobID string) string {
+	return schema.JobKey(jobID)
+}
+
+func (h *AnalyzerJSONHandler) SetJobStatus(ctx context.Context, status *JobStatus) (err error) {
+	_, span := tracer.Start(ctx, "AnalyzerJSONHandler.SetJobStatus", trace.WithAttributes(
+		attribute.String("job.id", status.ID),
+		attribute.String("job.state", string(status.State)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	status.UpdatedAt = time.Now().UTC()
+	return h.SetItem(analyzerJobKey(status.ID), status, false)
+}
+
+func (h *AnalyzerJSONHandler) GetJobStatus(ctx context.Context, jobID string) (_ *JobStatus, err error) {
+	_, span := tracer.Start(ctx, "AnalyzerJSONHandler.GetJobStatus", trace.WithAttributes(
+		attribute.String("job.id", jobID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	item := &JobStatus{}
+	return item, h.GetItem(analyzerJobKey(jobID), item)
+}
@@ -0,0 +1,89 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrLocked is returned if a repository lock is still held by another
+// analysis after waiting for it
+var ErrLocked = errors.New("repository is locked by another analysis")
+
+// releaseScript deletes the lock only if it is still held with the token,
+// so an expired and reacquired lock is never released by its former owner
+var releaseScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// RepositoryLock prevents concurrent analyses of the same repository, also
+// if it is requested with different urls
+type RepositoryLock struct {
+	client goredis.UniversalClient
+	key    string
+	token  string
+}
+
+func analyzerLockKey(repoID string) string {
+	return schema.LockKey(repoID)
+}
+
+// AcquireRepositoryLock locks the repository for ttl. If it is locked, it
+// retries every interval until wait is over and returns ErrLocked then.
+func AcquireRepositoryLock(ctx context.Context, client goredis.UniversalClient, repoURL string, ttl, wait, interval time.Duration) (_ *RepositoryLock, err error) {
+
+	repoID := RepositoryID(repoURL)
+
+	ctx, span := tracer.Start(ctx, "AcquireRepositoryLock", trace.WithAttributes(
+		attribute.String("repo.id", repoID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	lock := &RepositoryLock{
+		client: client,
+		key:    analyzerLockKey(repoID),
+		token:  uuid.NewString(),
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := client.SetNX(ctx, lock.key, lock.token, ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("could not lock repository %s: %w", repoID, err)
+		}
+		if ok {
+			return lock, nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return nil, ErrLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Release unlocks the repository, if the lock is still held
+func (l *RepositoryLock) Release(ctx context.Context) error {
+
+	err := releaseScript.Run(ctx, l.client, []string{l.key}, l.token).Err()
+	if err != nil {
+		return fmt.Errorf("could not release lock %s: %w", l.key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepositoryLock(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+	ctx := context.Background()
+
+	lock, err := AcquireRepositoryLock(ctx, client, "https://github.com/fluxcd/flux2", time.Minute, 0, time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, s.Exists("analyzerlock|github.com/fluxcd/flux2"))
+
+	// another url of the same repository
+	_, err = AcquireRepositoryLock(ctx, client, "git@github.com:fluxcd/flux2.git", time.Minute, 10*time.Millisecond, time.Millisecond)
+	assert.Equal(t, ErrLocked, err)
+
+	assert.NoError(t, lock.Release(ctx))
+	assert.False(t, s.Exists("analyzerlock|github.com/fluxcd/flux2"))
+
+	// an expired lock is not released by its former owner
+	lock, err = AcquireRepositoryLock(ctx, client, "https://github.com/fluxcd/flux2", time.Minute, 0, time.Millisecond)
+	assert.NoError(t, err)
+	s.FastForward(2 * time.Minute)
+	other, err := AcquireRepositoryLock(ctx, client, "https://github.com/fluxcd/flux2", time.Minute, 0, time.Millisecond)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Release(ctx))
+	assert.True(t, s.Exists("analyzerlock|github.com/fluxcd/flux2"))
+	assert.NoError(t, other.Release(ctx))
+}
@@ -0,0 +1,157 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MigrationReport counts the keys handled by MigrateRevisionKeys
+type MigrationReport struct {
+	// Results moved to a repository id and revision key
+	Results int
+	// CacheEntries moved to a repository id and revision key
+	CacheEntries int
+	// Dropped cache entries, whose revision is unknown or which are already
+	// superseded. They are recreated by the next analysis.
+	Dropped int
+}
+
+// MigrateRevisionKeys rewrites results and cache entries, which are stored
+// under their url only, to repository id and revision keys and indexes the
+// revisions.
+// Results get the revision of their request, DefaultRevision if it was empty.
+// Cache entries get the revision of the result of the same commit and are
+// dropped without one. With dryRun nothing is changed, only counted.
+func MigrateRevisionKeys(ctx context.Context, ac *AnalyzerCache, ajh *AnalyzerJSONHandler, dryRun bool) (_ *MigrationReport, err error) {
+	ctx, span := tracer.Start(ctx, "MigrateRevisionKeys", trace.WithAttributes(
+		attribute.Bool("migration.dry_run", dryRun),
+	))
+	defer func() { endSpan(span, err) }()
+
+	report := &MigrationReport{}
+
+	// commits and revisions of the migrated results by url
+	migrated := make(map[string]*AnalyzerResultValue)
+
+	resultKeys, err := ajh.scanKeys(ctx, legacyAnalyzerResultKey("*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range legacyKeys(resultKeys) {
+		item := &AnalyzerResultValue{}
+		if err := ajh.GetItem(key, item); err != nil {
+			return report, fmt.Errorf("could not get result %s: %w", key, err)
+		}
+
+		repoID := RepositoryID(strings.TrimPrefix(key, legacyAnalyzerResultKey("")))
+		item.Revision = DefaultRevision
+		if item.Repo != nil && item.Repo.Revision != "" {
+			item.Revision = item.Repo.Revision
+		}
+		migrated[repoID] = item
+		report.Results++
+
+		if dryRun {
+			continue
+		}
+		if err := ajh.migrateResult(ctx, key, repoID, item); err != nil {
+			return report, err
+		}
+	}
+
+	cacheKeys, err := ac.scan(ctx, legacyMatchingFilesKey("*"))
+	if err != nil {
+		return report, err
+	}
+	for _, key := range legacyKeys(cacheKeys) {
+		repoID := RepositoryID(strings.TrimPrefix(key, legacyMatchingFilesKey("")))
+
+		cached := &MatchingFilesValue{}
+		err := ac.Get(ctx, key, cached)
+		if err == ErrCacheMiss {
+			// expired since the scan
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("could not get cache entry %s: %w", key, err)
+		}
+
+		result, ok := migrated[repoID]
+		moved := false
+		if ok && result.Commit == cached.CommitID {
+			moved = true
+			if !dryRun {
+				// keeps the ttl and never overwrites newer entries
+				moved, err = renameNX(ctx, ac.client, key, matchingFilesKey(repoID, result.Revision))
+				if err != nil {
+					return report, fmt.Errorf("could not rename cache entry %s: %w", key, err)
+				}
+			}
+		}
+
+		if moved {
+			report.CacheEntries++
+			continue
+		}
+
+		report.Dropped++
+		if !dryRun {
+			if err := ac.client.Del(ctx, key).Err(); err != nil {
+				return report, fmt.Errorf("could not delete cache entry %s: %w", key, err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// migrateResult stores the result under its url and revision, unless a newer
+// result exists there, and removes the url only key
+func (h *AnalyzerJSONHandler) migrateResult(ctx context.Context, key, repoID string, item *AnalyzerResultValue) error {
+
+	exists, err := h.client.Exists(ctx, analyzerResultKey(repoID, item.Revision)).Result()
+	if err != nil {
+		return fmt.Errorf("could not check result of %s %s: %w", repoID, item.Revision, err)
+	}
+
+	if exists == 0 {
+		if err := h.SetItem(analyzerResultKey(repoID, item.Revision), item, false); err != nil {
+			return err
+		}
+
+		// the time of the analysis is unknown, so it is never the latest one
+		err = h.client.ZAddNX(ctx, analyzerRevisionsKey(repoID), goredis.Z{Member: item.Revision}).Err()
+		if err != nil {
+			return fmt.Errorf("could not index revision %s of %s: %w", item.Revision, repoID, err)
+		}
+	}
+
+	return h.Delete(key)
+}
+
+func legacyAnalyzerResultKey(repoURL string) string {
+	return fmt.Sprintf("analyzerresult|%s", repoURL)
+}
+
+func legacyMatchingFilesKey(repoURL string) string {
+	return fmt.Sprintf("matchingfiles|%s", repoURL)
+}
+
+// legacyKeys returns the keys of a "<prefix>|*" scan, which have no revision
+func legacyKeys(keys []string) []string {
+
+	legacy := make([]string, 0)
+	for _, key := range keys {
+		_, rest, _ := strings.Cut(key, "|")
+		if !strings.Contains(rest, "|") {
+			legacy = append(legacy, key)
+		}
+	}
+
+	return legacy
+}
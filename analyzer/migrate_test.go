@@ -0,0 +1,58 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/nitishm/go-rejson/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateRevisionKeys(t *testing.T) {
+
+	redisClient, mock := redismock.NewClientMock()
+	rh := rejson.NewReJSONHandler()
+	rh.SetGoRedisClientWithContext(context.Background(), redisClient)
+	ajh := NewAnalyzerJSONHandlerWithClient(rh, redisClient)
+	ac := NewAnalyzerCache(redisClient, time.Hour)
+
+	migrated := &AnalyzerResultValue{
+		Repo:     &Repository{Url: "my-repo-url", Revision: "main"},
+		Revision: "main",
+		Commit:   "my-commit-id",
+		Results:  []*TechAndPath{{Technology: "go", Path: "."}},
+	}
+	doc, err := json.Marshal(migrated)
+	assert.NoError(t, err)
+
+	// results
+	mock.ExpectScan(0, "analyzerresult|*", 100).SetVal([]string{"analyzerresult|my-repo-url", "analyzerresult|my-repo-url|develop"}, 0)
+	mock.ExpectDo("JSON.GET", "analyzerresult|my-repo-url", ".").SetVal(`{"Repo":{"Url":"my-repo-url","Revision":"main"},"Commit":"my-commit-id","Results":[{"Technology":"go","Path":"."}]}`)
+	mock.ExpectExists("analyzerresult|my-repo-url|main").SetVal(0)
+	mock.ExpectDo("JSON.SET", "analyzerresult|my-repo-url|main", ".", doc).SetVal("OK")
+	mock.ExpectZAddNX("analyzerrevisions|my-repo-url", goredis.Z{Member: "main"}).SetVal(1)
+	mock.ExpectDo("JSON.DEL", "analyzerresult|my-repo-url", ".").SetVal(int64(1))
+
+	// cache entries
+	mock.ExpectScan(0, "matchingfiles|*", 100).SetVal([]string{"matchingfiles|my-repo-url", "matchingfiles|other-repo-url"}, 0)
+	mock.ExpectGet("matchingfiles|my-repo-url").SetVal(`{"CommitID":"my-commit-id","Results":[]}`)
+	mock.ExpectRenameNX("matchingfiles|my-repo-url", "matchingfiles|my-repo-url|main").SetVal(true)
+	mock.ExpectGet("matchingfiles|other-repo-url").SetVal(`{"CommitID":"other-commit-id","Results":[]}`)
+	mock.ExpectDel("matchingfiles|other-repo-url").SetVal(1)
+
+	report, err := MigrateRevisionKeys(context.Background(), ac, ajh, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &MigrationReport{Results: 1, CacheEntries: 1, Dropped: 1}, report)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_legacyKeys(t *testing.T) {
+	assert.Equal(t, []string{"matchingfiles|https://github.com/fluxcd/flux2"}, legacyKeys([]string{
+		"matchingfiles|https://github.com/fluxcd/flux2",
+		"matchingfiles|https://github.com/fluxcd/flux2|main",
+	}))
+}
@@ -1,46 +1,89 @@
 package analyzer
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 
+	"github.com/go-git/go-git/v5/plumbing/object"
 	yaml "gopkg.in/yaml.v2"
 )
 
 const PATTERNFILENAME = "sweatShop-analyzer.yaml"
 
-var techsAndPatterns = make(map[string][]string)
+// techsAndPatterns maps technologies to the patterns of their files. Every
+// analysis reads its own, analyses run concurrently.
+type techsAndPatterns map[string][]string
 
 // getTechsAndPatternsFromFile returns a map of technologies and their patterns
-func getTechsAndPatternsFromFile(path string) error {
+func getTechsAndPatternsFromFile(path string) (techsAndPatterns, error) {
 	log.Infof("Get techs and patterns from file %s", path)
 
-	// If file does not exist, return empty map
-	_, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Debugf("Path does not exist")
-			return err
-		} else {
-			log.Debugf("Error occurred while checking path: %v", err)
-			return err
-		}
-	}
-
 	// If file exists, read in file
 	yamlFile, err := os.ReadFile(path)
 	if err != nil {
 		log.Debugf("Error reading YAML file: %v", err)
-		return err
+		return nil, err
 	}
 
-	// Parse file into map
-	err = yaml.Unmarshal(yamlFile, &techsAndPatterns)
+	return parseTechsAndPatterns(yamlFile)
+}
+
+// parseTechsAndPatterns parses the yaml of a pattern file
+func parseTechsAndPatterns(data []byte) (techsAndPatterns, error) {
+
+	patterns := make(techsAndPatterns)
+	err := yaml.Unmarshal(data, &patterns)
 	if err != nil {
 		log.Debugf("Error parsing YAML file: %v", err)
-		return err
+		return nil, err
+	}
+
+	return patterns, nil
+}
+
+// loadTechsAndPatterns returns the patterns of the analysis: the pattern file of
+// the request, else the one in the root of the repository, else the default
+// pattern file of the sweatShop-analyzer repo. commit is nil for plain
+// directories.
+func (repo *Repository) loadTechsAndPatterns(commit *object.Commit, files []string) (techsAndPatterns, error) {
+
+	if repo.PatternFile != "" {
+		patterns, err := getTechsAndPatternsFromFile(repo.PatternFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not get techs and patterns from file: %w", err)
+		}
+		return patterns, nil
+	}
+
+	if len(filterFileList(files, PATTERNFILENAME)) != 0 {
+		if commit == nil {
+			path, _ := localPath(repo.Url)
+			return getTechsAndPatternsFromFile(filepath.Join(path, PATTERNFILENAME))
+		}
+
+		log.Infof("Get techs and patterns from file %s of commit %s", PATTERNFILENAME, commit.Hash)
+		f, err := commit.File(PATTERNFILENAME)
+		if err != nil {
+			return nil, fmt.Errorf("could not get pattern file of the repository: %w", err)
+		}
+		data, err := f.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("could not read pattern file of the repository: %w", err)
+		}
+		return parseTechsAndPatterns([]byte(data))
+	}
+
+	log.Infof("No pattern file found in git repo. Use default pattern file from sweatShop-analyzer repo.")
+	gitRoot, _ := findGitRoot()
+
+	patterns, err := getTechsAndPatternsFromFile(filepath.Join(gitRoot, PATTERNFILENAME))
+	if err != nil {
+		log.Warnf("could not get techs and patterns from file: %v", err)
+		return make(techsAndPatterns), nil
 	}
 
-	return nil
+	return patterns, nil
 }
 
 // TODO: how to make sure the techs are consistent as key?
@@ -0,0 +1,56 @@
+package analyzer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzePatternsPerAnalysis(t *testing.T) {
+
+	// a repository with its own pattern file
+	own := t.TempDir()
+	r, err := git.PlainInit(own, false)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(own, PATTERNFILENAME), []byte("custom:\n  - \"*.custom\"\n"), 0644))
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+	_, err = w.Add(PATTERNFILENAME)
+	assert.NoError(t, err)
+	commitTestFiles(t, r, "app.custom", "go.mod")
+
+	// a repository analyzed with the pattern file of the request
+	other := t.TempDir()
+	r, err = git.PlainInit(other, false)
+	assert.NoError(t, err)
+	commitTestFiles(t, r, "app.custom", "go.mod")
+
+	cache, _, err := OpenAnalyzerCache(nil, CacheConfig{Backend: CacheBackendNone})
+	assert.NoError(t, err)
+
+	// the patterns of concurrent analyses never leak into each other
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		repo := &Repository{Url: own}
+		expected := []*TechAndPath{{Technology: "custom", Path: "."}}
+		if i%2 == 1 {
+			repo = &Repository{Url: other, PatternFile: filepath.Join("..", PATTERNFILENAME)}
+			expected = []*TechAndPath{{Technology: "golang", Path: "."}}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, _, err := repo.Analyze(context.Background(), cache)
+			if assert.NoError(t, err) {
+				assert.ElementsMatch(t, expected, result.Results)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,108 @@
+package analyzer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+)
+
+// ProxyDirect as proxy of a host connects to it without proxy
+const ProxyDirect = "direct"
+
+// ProxyConfig selects the http proxy of git servers. The proxy of the host
+// wins, then hosts in NoProxy are connected directly, then the proxy of the
+// scheme of the url is used.
+type ProxyConfig struct {
+	// HTTPProxy and HTTPSProxy are the proxies of http and https urls,
+	// like HTTP_PROXY and HTTPS_PROXY
+	HTTPProxy  string
+	HTTPSProxy string
+	// NoProxy lists the hosts connected without proxy, like NO_PROXY
+	NoProxy string
+	// Hosts are the proxies of hosts, see credentials.MatchHost, or
+	// ProxyDirect
+	Hosts map[string]string
+}
+
+// ProxyURL returns the proxy of the url, nil to connect directly
+func (c *ProxyConfig) ProxyURL(u *url.URL) (*url.URL, error) {
+
+	if proxy, ok := credentials.MatchHost(c.Hosts, u); ok {
+		if proxy == ProxyDirect {
+			return nil, nil
+		}
+		return parseProxy(proxy)
+	}
+
+	config := httpproxy.Config{
+		HTTPProxy:  c.HTTPProxy,
+		HTTPSProxy: c.HTTPSProxy,
+		NoProxy:    c.NoProxy,
+	}
+
+	return config.ProxyFunc()(u)
+}
+
+// validate parses the proxies, so that invalid ones fail at startup
+func (c *ProxyConfig) validate() error {
+
+	proxies := map[string]string{"http": c.HTTPProxy, "https": c.HTTPSProxy}
+	for host, proxy := range c.Hosts {
+		proxies[host] = proxy
+	}
+
+	for name, proxy := range proxies {
+		if proxy == "" || proxy == ProxyDirect {
+			continue
+		}
+		if _, err := parseProxy(proxy); err != nil {
+			return fmt.Errorf("invalid proxy of %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// parseProxy parses the url of a proxy, http if it has no scheme like
+// "proxy:3128"
+func parseProxy(proxy string) (*url.URL, error) {
+
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %q", proxy)
+	}
+
+	return u, nil
+}
+
+// InstallProxy connects the git clones, pulls and connectivity probes of http
+// and https repositories through the proxies of the configuration. It
+// replaces the proxy of the environment used by go-git by default.
+func InstallProxy(c ProxyConfig) error {
+
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return c.ProxyURL(req.URL)
+	}
+
+	gitClient := githttp.NewClient(&http.Client{Transport: transport})
+	client.InstallProtocol("http", gitClient)
+	client.InstallProtocol("https", gitClient)
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package analyzer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// restoreGitClients installs the default go-git http clients after the test
+func restoreGitClients(t *testing.T) {
+	t.Cleanup(func() {
+		client.InstallProtocol("http", githttp.DefaultClient)
+		client.InstallProtocol("https", githttp.DefaultClient)
+	})
+}
+
+func TestProxyURL(t *testing.T) {
+
+	restoreGitClients(t)
+
+	config := &ProxyConfig{
+		HTTPProxy:  "http://proxy:3128",
+		HTTPSProxy: "http://secure:3128",
+		NoProxy:    "internal.example.com,.corp",
+		Hosts: map[string]string{
+			"github.com":           "http://github:3128",
+			"*.corp":               "http://corp:3128",
+			"git.example.com:8443": ProxyDirect,
+			"gitlab.com":           "gitlab:3128",
+		},
+	}
+
+	tests := []struct {
+		url   string
+		proxy string
+	}{
+		{"https://github.com/org/repo.git", "http://github:3128"},
+		{"https://gitlab.com/org/repo.git", "http://gitlab:3128"},
+		// the host wins over NoProxy
+		{"https://gitea.corp/org/repo.git", "http://corp:3128"},
+		{"https://internal.example.com/org/repo.git", ""},
+		{"https://git.example.com:8443/org/repo.git", ""},
+		{"https://git.example.com/org/repo.git", "http://secure:3128"},
+		{"http://git.example.com/org/repo.git", "http://proxy:3128"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		assert.NoError(t, err)
+		proxy, err := config.ProxyURL(u)
+		assert.NoError(t, err)
+		if tt.proxy == "" {
+			assert.Nil(t, proxy, tt.url)
+		} else if assert.NotNil(t, proxy, tt.url) {
+			assert.Equal(t, tt.proxy, proxy.String(), tt.url)
+		}
+	}
+
+	assert.NoError(t, InstallProxy(ProxyConfig{HTTPSProxy: "proxy:3128"}))
+	assert.Error(t, InstallProxy(ProxyConfig{HTTPSProxy: "http://"}))
+	assert.Error(t, InstallProxy(ProxyConfig{Hosts: map[string]string{"github.com": "http://%zz"}}))
+}
+
+func TestInstallProxy(t *testing.T) {
+
+	restoreGitClients(t)
+
+	// proxy stand-in, serving the repository of every host it is asked for
+	backend, head := gitHTTPBackend(t)
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.IsAbs() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		proxied = append(proxied, r.URL.Host)
+		mu.Unlock()
+		backend.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	requests := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		hosts := proxied
+		proxied = nil
+		return hosts
+	}
+
+	ctx := context.Background()
+	repoURL := "http://git.invalid/repo.git"
+
+	// clone, pull and connectivity probe through the global proxy
+	assert.NoError(t, InstallProxy(ProxyConfig{HTTPProxy: proxy.URL}))
+	r, err := gitCloneRevision(ctx, &Repository{Url: repoURL})
+	if assert.NoError(t, err) {
+		ref, err := r.Head()
+		assert.NoError(t, err)
+		assert.Equal(t, head, ref.Hash())
+	}
+	assert.NoError(t, (&Repository{Url: repoURL}).ConnectRepository())
+	assert.Contains(t, requests(), "git.invalid")
+
+	// through the proxy of the host
+	assert.NoError(t, InstallProxy(ProxyConfig{HTTPProxy: "http://127.0.0.1:1", Hosts: map[string]string{"*.invalid": proxy.URL}}))
+	_, err = gitCloneRevision(ctx, &Repository{Url: repoURL, Revision: "master"})
+	assert.NoError(t, err)
+	assert.Contains(t, requests(), "git.invalid")
+
+	// directly
+	assert.NoError(t, InstallProxy(ProxyConfig{HTTPProxy: proxy.URL, NoProxy: "git.invalid"}))
+	_, err = gitCloneRevision(ctx, &Repository{Url: repoURL})
+	assert.Error(t, err)
+	assert.NoError(t, InstallProxy(ProxyConfig{HTTPProxy: proxy.URL, Hosts: map[string]string{"git.invalid": ProxyDirect}}))
+	assert.Error(t, (&Repository{Url: repoURL}).ConnectRepository())
+	assert.Empty(t, requests())
+}
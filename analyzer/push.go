@@ -0,0 +1,76 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PushChanges are the files added and removed by a push, as listed by the
+// webhook of the forge. If the cached result is of the commit before the push
+// and the revision is at the commit after it, the incremental analysis takes
+// them instead of computing the diff.
+type PushChanges struct {
+	Before  string
+	After   string
+	Added   []string `json:",omitempty" yaml:",omitempty"`
+	Removed []string `json:",omitempty" yaml:",omitempty"`
+}
+
+// changes returns the changed files, if they lead from the old to the new
+// commit. Force pushes are detected by the ancestry of the commits, their
+// listed files miss the changes of the replaced commits.
+func (p *PushChanges) changes(gitRepo *git.Repository, oldCommitID, newCommitID string) ([]*fileStat, bool) {
+
+	if p == nil || p.Before != oldCommitID || p.After != newCommitID {
+		return nil, false
+	}
+
+	before, err := gitRepo.CommitObject(plumbing.NewHash(p.Before))
+	if err != nil {
+		return nil, false
+	}
+	after, err := gitRepo.CommitObject(plumbing.NewHash(p.After))
+	if err != nil {
+		return nil, false
+	}
+	if ok, err := before.IsAncestor(after); err != nil || !ok {
+		log.Infof("commit %s is no ancestor of %s, ignoring the pushed files", p.Before, p.After)
+		return nil, false
+	}
+
+	// created files first, so a file added and removed again is rechecked
+	changes := make([]*fileStat, 0, len(p.Added)+len(p.Removed))
+	for _, f := range p.Added {
+		changes = append(changes, &fileStat{Name: f, Stat: CREATED})
+	}
+	for _, f := range p.Removed {
+		changes = append(changes, &fileStat{Name: f, Stat: DELETED})
+	}
+
+	return changes, true
+}
+
+// pushAnalysis updates the results of the commit before the push by the files
+// listed by the webhook
+func pushAnalysis(ctx context.Context, gitRepo *git.Repository, newCommitID string, cachedResult []*TechAndPath, changes []*fileStat, patterns techsAndPatterns) (_ []*TechAndPath, err error) {
+
+	log.Infof("Running incremental analysis of pushed files")
+
+	_, span := tracer.Start(ctx, "pushAnalysis", trace.WithAttributes(
+		attribute.String("git.commit.new", newCommitID),
+		attribute.Int("analysis.push.files", len(changes)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	res, err := applyChanges(gitRepo, newCommitID, cachedResult, changes, patterns)
+	if err != nil {
+		return nil, fmt.Errorf("could not apply pushed files: %w", err)
+	}
+
+	return res, nil
+}
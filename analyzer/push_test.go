@@ -0,0 +1,60 @@
+package analyzer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pushAnalysis(t *testing.T) {
+
+	patterns, err := getTechsAndPatternsFromFile(filepath.Join("..", PATTERNFILENAME))
+	assert.NoError(t, err)
+
+	r, err := git.PlainInit(t.TempDir(), false)
+	assert.NoError(t, err)
+	first := commitTestFiles(t, r, "go.mod", "chart/Chart.yaml")
+	commitTestFiles(t, r, "Dockerfile", "tmp/Chart.yaml")
+	removeTestFiles(t, r, "tmp/Chart.yaml")
+	third := removeTestFiles(t, r, "chart/Chart.yaml")
+
+	cached := []*TechAndPath{{Technology: "golang", Path: "."}, {Technology: "helm", Path: "chart"}}
+
+	// the files of all pushed commits, tmp/Chart.yaml was added and removed
+	push := &PushChanges{
+		Before:  first.String(),
+		After:   third.String(),
+		Added:   []string{"Dockerfile", "tmp/Chart.yaml"},
+		Removed: []string{"tmp/Chart.yaml", "chart/Chart.yaml"},
+	}
+
+	changes, ok := push.changes(r, first.String(), third.String())
+	assert.True(t, ok)
+	res, err := pushAnalysis(context.Background(), r, third.String(), cached, changes, patterns)
+	assert.NoError(t, err)
+
+	expected, err := incrementalAnalysis(context.Background(), r, first.String(), third.String(), cached, patterns)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expected, res)
+	assert.ElementsMatch(t, []*TechAndPath{{Technology: "golang", Path: "."}, {Technology: "docker", Path: "."}}, res)
+
+	// the listed files are used instead of the diff
+	changes, ok = (&PushChanges{Before: first.String(), After: third.String()}).changes(r, first.String(), third.String())
+	assert.True(t, ok)
+	res, err = pushAnalysis(context.Background(), r, third.String(), cached, changes, patterns)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, cached, res)
+
+	// other commits than the cached and the current one
+	_, ok = push.changes(r, first.String(), first.String())
+	assert.False(t, ok)
+	_, ok = (*PushChanges)(nil).changes(r, first.String(), third.String())
+	assert.False(t, ok)
+
+	// a force push replacing the cached commit
+	_, ok = (&PushChanges{Before: third.String(), After: first.String()}).changes(r, third.String(), first.String())
+	assert.False(t, ok)
+}
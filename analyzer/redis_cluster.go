@@ -0,0 +1,94 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// scanKeys returns the keys matching the pattern. A cluster is scanned on
+// all of its masters, each holding a part of the keys.
+func scanKeys(ctx context.Context, client goredis.UniversalClient, pattern string) ([]string, error) {
+
+	cluster, ok := client.(*goredis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, client, pattern)
+	}
+
+	var mu sync.Mutex
+	keys := make([]string, 0)
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *goredis.Client) error {
+		nodeKeys, err := scanNode(ctx, node, pattern)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		keys = append(keys, nodeKeys...)
+		mu.Unlock()
+		return nil
+	})
+
+	return keys, err
+}
+
+func scanNode(ctx context.Context, client goredis.UniversalClient, pattern string) ([]string, error) {
+
+	keys := make([]string, 0)
+	iter := client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("could not scan keys %s: %w", pattern, err)
+	}
+
+	return keys, nil
+}
+
+// txPipelined runs the commands in a transaction. The keys of a transaction
+// must be in one hash slot of a cluster, so there they are only pipelined.
+func txPipelined(ctx context.Context, client goredis.UniversalClient, fn func(goredis.Pipeliner) error) ([]goredis.Cmder, error) {
+
+	if _, ok := client.(*goredis.ClusterClient); ok {
+		return client.Pipelined(ctx, fn)
+	}
+
+	return client.TxPipelined(ctx, fn)
+}
+
+// renameNX renames the key, unless the new key exists, and keeps its ttl. The
+// keys of a cluster are in different hash slots, which RENAMENX refuses with
+// CROSSSLOT, so there the value is copied with SET NX and the key deleted.
+// It returns false if the key is missing.
+func renameNX(ctx context.Context, client goredis.UniversalClient, key, newKey string) (bool, error) {
+
+	if _, ok := client.(*goredis.ClusterClient); !ok {
+		return client.RenameNX(ctx, key, newKey).Result()
+	}
+
+	value, err := client.Get(ctx, key).Bytes()
+	if err == goredis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ttl, err := client.PTTL(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if ttl < 0 {
+		// no ttl
+		ttl = 0
+	}
+
+	set, err := client.SetNX(ctx, newKey, value, ttl).Result()
+	if err != nil || !set {
+		return false, err
+	}
+
+	return true, client.Del(ctx, key).Err()
+}
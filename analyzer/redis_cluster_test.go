@@ -0,0 +1,83 @@
+package analyzer
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nitishm/go-rejson/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCluster runs the commands spanning several keys against a cluster of
+// one miniredis node
+func TestCluster(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	client := goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{s.Addr()}})
+	ctx := context.Background()
+
+	cache := NewAnalyzerCache(client, time.Hour)
+	assert.NoError(t, cache.SetMatchingFiles(ctx, "https://github.com/org/a", "main", "abc", nil))
+	assert.NoError(t, cache.SetMatchingFiles(ctx, "https://github.com/org/b", "main", "abc", nil))
+
+	keys, err := scanKeys(ctx, client, "matchingfiles|*")
+	assert.NoError(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{"matchingfiles|github.com/org/a|main", "matchingfiles|github.com/org/b|main"}, keys)
+
+	purged, err := cache.Purge(ctx, "https://github.com/org/*")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	// the schedule hash and runs are in different hash slots
+	h := NewAnalyzerJSONHandlerWithClient(rejson.NewReJSONHandler(), client)
+	now := time.Date(2023, 8, 10, 12, 30, 0, 0, time.UTC)
+	assert.NoError(t, h.SetSchedule(ctx, &Schedule{Url: "https://github.com/org/a", Cron: "@daily"}, now))
+	due, err := h.DueSchedules(ctx, now.Add(24*time.Hour), 0)
+	assert.NoError(t, err)
+	assert.Len(t, due, 1)
+
+	// the index of a node does not cover the cluster
+	indexed, err := h.EnsureSearchIndex(ctx)
+	assert.NoError(t, err)
+	assert.False(t, indexed)
+}
+
+func Test_renameNX(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+
+	// a cluster copies the value, as the keys are in different hash slots
+	for _, client := range []goredis.UniversalClient{
+		goredis.NewClient(&goredis.Options{Addr: s.Addr()}),
+		goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{s.Addr()}}),
+	} {
+		s.FlushAll()
+		assert.NoError(t, s.Set("matchingfiles|a", "old"))
+		s.SetTTL("matchingfiles|a", time.Hour)
+		assert.NoError(t, s.Set("matchingfiles|b", "legacy"))
+		assert.NoError(t, s.Set("matchingfiles|b|main", "newer"))
+
+		moved, err := renameNX(ctx, client, "matchingfiles|a", "matchingfiles|a|main")
+		assert.NoError(t, err)
+		assert.True(t, moved)
+		assert.False(t, s.Exists("matchingfiles|a"))
+		value, err := s.Get("matchingfiles|a|main")
+		assert.NoError(t, err)
+		assert.Equal(t, "old", value)
+		assert.Equal(t, time.Hour, s.TTL("matchingfiles|a|main"))
+
+		// newer entries are never overwritten
+		moved, err = renameNX(ctx, client, "matchingfiles|b", "matchingfiles|b|main")
+		assert.NoError(t, err)
+		assert.False(t, moved)
+		value, err = s.Get("matchingfiles|b|main")
+		assert.NoError(t, err)
+		assert.Equal(t, "newer", value)
+	}
+}
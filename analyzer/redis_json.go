@@ -1,10 +1,17 @@
 package analyzer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/nitishm/go-rejson/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ErrJSONMissWithGoRedisClient has ideally the type RedisError of "github.com/redis/go-redis/v9/internal/proto"
@@ -13,18 +20,42 @@ var ErrJSONMissWithGoRedisClient = "redis: nil"
 // var ErrJSONMissWithRedigoConn = errors.New("redigo: nil returned")
 
 type AnalyzerResultValue struct {
-	Repo    *Repository
-	Commit  string
-	Results []*TechAndPath
+	Repo *Repository
+	// Revision is the revision the result is stored under, Repo.Revision
+	// resolved to the default branch if it was empty
+	Revision string
+	Commit   string
+	// CommittedAt is the commit time, zero for plain directories
+	CommittedAt time.Time
+	// AnalyzedAt is the time the result was stored
+	AnalyzedAt time.Time
+	Results    []*TechAndPath
+	// RepoID is the identity of the repository and AnalyzedAtUnix the time of
+	// the analysis in seconds, both are set when the result is stored for the
+	// search index
+	RepoID         string `json:",omitempty" yaml:",omitempty"`
+	AnalyzedAtUnix int64  `json:",omitempty" yaml:",omitempty"`
+	// History is the technology history from the git log, if requested
+	History *TechnologyHistory `json:",omitempty" yaml:",omitempty"`
 }
 
 type AnalyzerJSONHandlerInterface interface {
-	SetAnalyzerResult(repo *Repository, commitId string, res []*TechAndPath) error
-	GetAnalyzerResult(repoURL string) (*AnalyzerResultValue, error)
+	ResultSink
+	GetAnalyzerResult(ctx context.Context, repoURL, revision string) (*AnalyzerResultValue, error)
 }
 
 type AnalyzerJSONHandler struct {
 	handler *rejson.Handler
+	// client is needed to scan keys and to index revisions and snapshots
+	client goredis.UniversalClient
+	// retention limits the snapshots kept per repository
+	retention RetentionPolicy
+	// searchAvailable reports whether the search index can be used, once
+	// searchChecked. The handler is shared by concurrent requests, searchMu
+	// guards both.
+	searchMu        sync.Mutex
+	searchChecked   bool
+	searchAvailable bool
 }
 
 // attention: go-rejson/v4@v4.1.0 does not support redis/go-redis/v9 (but redis/go-redis/v8)
@@ -35,6 +66,15 @@ func NewAnalyzerJSONHandler(rh *rejson.Handler) *AnalyzerJSONHandler {
 	}
 }
 
+// NewAnalyzerJSONHandlerWithClient creates a handler which can also store and
+// list results
+func NewAnalyzerJSONHandlerWithClient(rh *rejson.Handler, client goredis.UniversalClient) *AnalyzerJSONHandler {
+	return &AnalyzerJSONHandler{
+		handler: rh,
+		client:  client,
+	}
+}
+
 /*
 func NewAnalyzerJSONHandlerWithRedigoConn(rs string) *AnalyzerJSONHandler {
 
@@ -56,18 +96,136 @@ func NewAnalyzerJSONHandlerWithRedigoConn(rs string) *AnalyzerJSONHandler {
 	}
 }*/
 
-func analyzerResultKey(repoURL string) string {
-	return fmt.Sprintf("analyzerresult|%s", repoURL)
+func analyzerResultKey(repoID, revision string) string {
+	return schema.ResultKey(repoID, revision)
 }
 
-func (h *AnalyzerJSONHandler) SetAnalyzerResult(repo *Repository, commitId string, res []*TechAndPath) error {
-	item := &AnalyzerResultValue{repo, commitId, res}
-	return h.SetItem(analyzerResultKey(repo.Url), item, false)
+// analyzerSnapshotKey is the key of the result of a single analyzed commit
+func analyzerSnapshotKey(repoID, commitId string) string {
+	return schema.SnapshotKey(repoID, commitId)
 }
 
-func (h *AnalyzerJSONHandler) GetAnalyzerResult(repoURL string) (*AnalyzerResultValue, error) {
+// SetAnalyzerResult stores the result as the latest one of its revision, adds
+// the revision to the index of analyzed revisions of the repository and keeps
+// a snapshot of the analyzed commit. The username and password of the url are
+// not stored.
+func (h *AnalyzerJSONHandler) SetAnalyzerResult(ctx context.Context, result *AnalyzerResultValue) (err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.SetAnalyzerResult", trace.WithAttributes(
+		attribute.String("repo.url", URLWithoutUserinfo(result.Repo.Url)),
+		attribute.String("repo.revision", result.Revision),
+		attribute.String("git.commit", result.Commit),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if result.AnalyzedAt.IsZero() {
+		result.AnalyzedAt = time.Now().UTC()
+	}
+	repoID := result.Repo.ID()
+	result.RepoID = repoID
+	result.AnalyzedAtUnix = result.AnalyzedAt.Unix()
+
+	if repo := result.Repo.withoutUserinfo(); repo != result.Repo {
+		stored := *result
+		stored.Repo = repo
+		result = &stored
+	}
+
+	// keep the result of every analyzed commit, to compare them later
+	if result.Commit != "" {
+		err = h.addSnapshot(ctx, repoID, result)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = h.SetItem(analyzerResultKey(repoID, result.Revision), result, false)
+	if err != nil {
+		return err
+	}
+
+	return h.addRevision(ctx, repoID, result.Revision, result.AnalyzedAt)
+}
+
+// GetAnalyzerResult returns the result of the revision, or of the most recently
+// analyzed revision if it is empty
+func (h *AnalyzerJSONHandler) GetAnalyzerResult(ctx context.Context, repoURL, revision string) (_ *AnalyzerResultValue, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.GetAnalyzerResult", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+	))
+	defer func() { endSpan(span, err) }()
+
+	repoID := RepositoryID(repoURL)
+
+	if revision == "" {
+		revision, err = h.latestRevision(ctx, repoID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	item := &AnalyzerResultValue{}
+	return item, h.GetItem(analyzerResultKey(repoID, revision), item)
+}
+
+// GetAnalyzerResultAt returns the result of the given analyzed commit
+func (h *AnalyzerJSONHandler) GetAnalyzerResultAt(ctx context.Context, repoURL, commitId string) (_ *AnalyzerResultValue, err error) {
+	_, span := tracer.Start(ctx, "AnalyzerJSONHandler.GetAnalyzerResultAt", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("git.commit", commitId),
+	))
+	defer func() { endSpan(span, err) }()
+
 	item := &AnalyzerResultValue{}
-	return item, h.GetItem(analyzerResultKey(repoURL), item)
+	return item, h.GetItem(analyzerSnapshotKey(RepositoryID(repoURL), commitId), item)
+}
+
+// ListAnalyzerResults returns the latest result of every analyzed revision of
+// all repositories. If technology is set, only results containing it are
+// returned.
+func (h *AnalyzerJSONHandler) ListAnalyzerResults(ctx context.Context, technology string) (_ []*AnalyzerResultValue, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.ListAnalyzerResults", trace.WithAttributes(
+		attribute.String("technology", technology),
+	))
+	defer func() { endSpan(span, err) }()
+
+	keys, err := h.scanKeys(ctx, analyzerResultKey("*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*AnalyzerResultValue, 0, len(keys))
+	for _, key := range keys {
+		item := &AnalyzerResultValue{}
+		if err := h.GetItem(key, item); err != nil {
+			return nil, fmt.Errorf("could not get result %s: %w", key, err)
+		}
+
+		if technology != "" && !item.HasTechnology(technology) {
+			continue
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// HasTechnology reports whether the technology was found anywhere in the repository
+func (v *AnalyzerResultValue) HasTechnology(technology string) bool {
+	for _, tp := range v.Results {
+		if tp.Technology == technology {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *AnalyzerJSONHandler) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	if h.client == nil {
+		return nil, fmt.Errorf("cannot scan keys without redis client")
+	}
+
+	return scanKeys(ctx, h.client, pattern)
 }
 
 func (h *AnalyzerJSONHandler) SetItem(key string, item interface{}, delete bool) error {
@@ -0,0 +1,111 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RevisionEntry is an analyzed revision of a repository
+type RevisionEntry struct {
+	Revision string
+	// AnalyzedAt is zero for revisions migrated from url only keys
+	AnalyzedAt time.Time
+}
+
+// analyzerRevisionsKey is the key of the sorted set of analyzed revisions of a
+// repository, scored by the time of the last analysis
+func analyzerRevisionsKey(repoID string) string {
+	return schema.RevisionsKey(repoID)
+}
+
+func (h *AnalyzerJSONHandler) addRevision(ctx context.Context, repoID, revision string, analyzedAt time.Time) error {
+	if h.client == nil {
+		return fmt.Errorf("cannot index revision without redis client")
+	}
+
+	err := h.client.ZAdd(ctx, analyzerRevisionsKey(repoID), goredis.Z{
+		Score:  float64(analyzedAt.Unix()),
+		Member: revision,
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("could not index revision %s of %s: %w", revision, repoID, err)
+	}
+
+	return nil
+}
+
+// latestRevision returns the most recently analyzed revision of the
+// repository, or goredis.Nil if none was analyzed yet
+func (h *AnalyzerJSONHandler) latestRevision(ctx context.Context, repoID string) (string, error) {
+	if h.client == nil {
+		return "", fmt.Errorf("cannot get latest revision without redis client")
+	}
+
+	revisions, err := h.client.ZRevRange(ctx, analyzerRevisionsKey(repoID), 0, 0).Result()
+	if err != nil {
+		return "", fmt.Errorf("could not get latest revision of %s: %w", repoID, err)
+	}
+	if len(revisions) == 0 {
+		return "", goredis.Nil
+	}
+
+	return revisions[0], nil
+}
+
+// ListRevisions returns the analyzed revisions of the repository, the most
+// recently analyzed first
+func (h *AnalyzerJSONHandler) ListRevisions(ctx context.Context, repoURL string) (_ []*RevisionEntry, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.ListRevisions", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return nil, fmt.Errorf("cannot list revisions without redis client")
+	}
+
+	members, err := h.client.ZRevRangeWithScores(ctx, analyzerRevisionsKey(RepositoryID(repoURL)), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not list revisions of %s: %w", repoURL, err)
+	}
+
+	revisions := make([]*RevisionEntry, 0, len(members))
+	for _, m := range members {
+		entry := &RevisionEntry{Revision: fmt.Sprint(m.Member)}
+		// migrated revisions have no time of analysis
+		if m.Score > 0 {
+			entry.AnalyzedAt = time.Unix(int64(m.Score), 0).UTC()
+		}
+		revisions = append(revisions, entry)
+	}
+
+	return revisions, nil
+}
+
+// splitRevisionKey returns the repository id and revision of a
+// "<prefix>|<id>|<revision>" key. Revisions never contain a "|", ids might.
+func splitRevisionKey(key string) (repoID, revision string) {
+
+	_, rest, _ := strings.Cut(key, "|")
+
+	i := strings.LastIndex(rest, "|")
+	if i < 0 {
+		return rest, ""
+	}
+
+	return rest[:i], rest[i+1:]
+}
+
+// escapeGlob escapes the glob characters of redis key patterns
+func escapeGlob(s string) string {
+	return globReplacer.Replace(s)
+}
+
+var globReplacer = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
@@ -0,0 +1,390 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// schedulesKey is the hash of the registered schedules, by schedule id
+	schedulesKey = schema.SchedulesKey
+	// scheduleRunsKey is the sorted set of schedule ids, scored by their next
+	// run without jitter
+	scheduleRunsKey = schema.ScheduleRunsKey
+	// scheduleClaimTTL is the time a claimed run is blocked for the other
+	// replicas, if the claiming one fails to complete it
+	scheduleClaimTTL = time.Hour
+)
+
+// Schedule periodically re-analyzes a revision of a repository
+type Schedule struct {
+	Url      string
+	Revision string
+	// Cron is a five field cron expression or a descriptor like "@daily" or
+	// "@every 6h", evaluated in UTC
+	Cron string
+	// Request are further fields of the enqueued analysis request, e.g. the
+	// name or the history options
+	Request   map[string]string `json:",omitempty" yaml:",omitempty"`
+	CreatedAt time.Time
+	// NextRun is the next time of the cron expression, the run is delayed by
+	// the jitter of the scheduler
+	NextRun time.Time
+	// LastRun is zero until the schedule enqueued its first analysis
+	LastRun   time.Time
+	LastJobID string `json:",omitempty" yaml:",omitempty"`
+}
+
+// ParseCron parses a five field cron expression or a descriptor
+func ParseCron(expr string) (cron.Schedule, error) {
+
+	s, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+	}
+
+	return s, nil
+}
+
+// ScheduleID is the identity of the schedule of a revision of a repository
+func ScheduleID(repoURL, revision string) string {
+	return RepositoryID(repoURL) + "|" + revision
+}
+
+// ID returns the identity of the schedule
+func (s *Schedule) ID() string {
+	return ScheduleID(s.Url, s.Revision)
+}
+
+// Host returns the git server of the repository, empty for local ones
+func (s *Schedule) Host() string {
+
+	id := RepositoryID(s.Url)
+	if strings.HasPrefix(id, "file://") {
+		return ""
+	}
+
+	return strings.SplitN(id, "/", 2)[0]
+}
+
+// Jitter returns the delay of the next run, below max. It is derived from the
+// schedule and its next run, so all replicas agree on it, and spreads the runs
+// of schedules with the same cron expression.
+func (s *Schedule) Jitter(max time.Duration) time.Duration {
+
+	if max <= 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%d", s.ID(), s.NextRun.Unix())
+
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+// scheduleRunKey claims a single run of a schedule
+func scheduleRunKey(id string, nextRun time.Time) string {
+	return fmt.Sprintf("analyzerschedulerun|%s|%d", id, nextRun.Unix())
+}
+
+// rateLimitKey counts the runs of a git server in a window
+func rateLimitKey(host string, window time.Time) string {
+	return fmt.Sprintf("analyzerratelimit|%s|%d", host, window.Unix())
+}
+
+// SetSchedule registers the schedule, or updates a registered one of the same
+// repository and revision, keeping its creation and last run. The next run is
+// the first time of the cron expression after now.
+func (h *AnalyzerJSONHandler) SetSchedule(ctx context.Context, s *Schedule, now time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.SetSchedule", trace.WithAttributes(
+		attribute.String("schedule.id", s.ID()),
+		attribute.String("schedule.cron", s.Cron),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return fmt.Errorf("cannot set schedule without redis client")
+	}
+
+	c, err := ParseCron(s.Cron)
+	if err != nil {
+		return err
+	}
+
+	existing, err := h.GetSchedule(ctx, s.Url, s.Revision)
+	switch {
+	case err == nil:
+		s.CreatedAt, s.LastRun, s.LastJobID = existing.CreatedAt, existing.LastRun, existing.LastJobID
+	case err != goredis.Nil:
+		return err
+	default:
+		s.CreatedAt = now.UTC()
+	}
+	s.NextRun = c.Next(now.UTC())
+
+	return h.storeSchedule(ctx, s)
+}
+
+func (h *AnalyzerJSONHandler) storeSchedule(ctx context.Context, s *Schedule) error {
+
+	// the schedules are listed and fired from redis, without the credentials of the url
+	s.Url = URLWithoutUserinfo(s.Url)
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("could not marshal schedule %s: %w", s.ID(), err)
+	}
+
+	_, err = txPipelined(ctx, h.client, func(pipe goredis.Pipeliner) error {
+		pipe.HSet(ctx, schedulesKey, s.ID(), data)
+		pipe.ZAdd(ctx, scheduleRunsKey, goredis.Z{Score: float64(s.NextRun.Unix()), Member: s.ID()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not store schedule %s: %w", s.ID(), err)
+	}
+
+	return nil
+}
+
+// GetSchedule returns the schedule of the revision, or goredis.Nil if none is
+// registered
+func (h *AnalyzerJSONHandler) GetSchedule(ctx context.Context, repoURL, revision string) (_ *Schedule, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.GetSchedule", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return nil, fmt.Errorf("cannot get schedule without redis client")
+	}
+
+	data, err := h.client.HGet(ctx, schedulesKey, ScheduleID(repoURL, revision)).Bytes()
+	if err != nil {
+		if err == goredis.Nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not get schedule of %s: %w", repoURL, err)
+	}
+
+	s := &Schedule{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("could not unmarshal schedule of %s: %w", repoURL, err)
+	}
+
+	return s, nil
+}
+
+// RemoveSchedule unregisters the schedule of the revision and reports whether
+// it was registered
+func (h *AnalyzerJSONHandler) RemoveSchedule(ctx context.Context, repoURL, revision string) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.RemoveSchedule", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return false, fmt.Errorf("cannot remove schedule without redis client")
+	}
+
+	id := ScheduleID(repoURL, revision)
+
+	var removed *goredis.IntCmd
+	_, err = txPipelined(ctx, h.client, func(pipe goredis.Pipeliner) error {
+		removed = pipe.HDel(ctx, schedulesKey, id)
+		pipe.ZRem(ctx, scheduleRunsKey, id)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not remove schedule %s: %w", id, err)
+	}
+
+	return removed.Val() > 0, nil
+}
+
+// ListSchedules returns all registered schedules, the next run first
+func (h *AnalyzerJSONHandler) ListSchedules(ctx context.Context) (_ []*Schedule, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.ListSchedules")
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return nil, fmt.Errorf("cannot list schedules without redis client")
+	}
+
+	all, err := h.client.HGetAll(ctx, schedulesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not list schedules: %w", err)
+	}
+
+	schedules := make([]*Schedule, 0, len(all))
+	for id, data := range all {
+		s := &Schedule{}
+		if err := json.Unmarshal([]byte(data), s); err != nil {
+			return nil, fmt.Errorf("could not unmarshal schedule %s: %w", id, err)
+		}
+		schedules = append(schedules, s)
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].NextRun.Equal(schedules[j].NextRun) {
+			return schedules[i].NextRun.Before(schedules[j].NextRun)
+		}
+		return schedules[i].ID() < schedules[j].ID()
+	})
+
+	return schedules, nil
+}
+
+// DueSchedules returns the schedules whose next run, delayed by their jitter
+// below maxJitter, is not after now
+func (h *AnalyzerJSONHandler) DueSchedules(ctx context.Context, now time.Time, maxJitter time.Duration) (_ []*Schedule, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.DueSchedules")
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return nil, fmt.Errorf("cannot get due schedules without redis client")
+	}
+
+	ids, err := h.client.ZRangeByScore(ctx, scheduleRunsKey, &goredis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprint(now.Unix()),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get due schedules: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	data, err := h.client.HMGet(ctx, schedulesKey, ids...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not get due schedules: %w", err)
+	}
+
+	due := make([]*Schedule, 0, len(ids))
+	for i, d := range data {
+		raw, ok := d.(string)
+		if !ok {
+			// removed since it was found
+			continue
+		}
+
+		s := &Schedule{}
+		if err := json.Unmarshal([]byte(raw), s); err != nil {
+			return nil, fmt.Errorf("could not unmarshal schedule %s: %w", ids[i], err)
+		}
+
+		if !s.NextRun.Add(s.Jitter(maxJitter)).After(now) {
+			due = append(due, s)
+		}
+	}
+	span.SetAttributes(attribute.Int("schedules.due", len(due)))
+
+	return due, nil
+}
+
+// ClaimScheduleRun claims the next run of the schedule and reports whether it
+// succeeded. Only one replica claims a run; it must complete or release it.
+func (h *AnalyzerJSONHandler) ClaimScheduleRun(ctx context.Context, s *Schedule) (bool, error) {
+
+	if h.client == nil {
+		return false, fmt.Errorf("cannot claim schedule without redis client")
+	}
+
+	ok, err := h.client.SetNX(ctx, scheduleRunKey(s.ID(), s.NextRun), "claimed", scheduleClaimTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("could not claim schedule %s: %w", s.ID(), err)
+	}
+
+	return ok, nil
+}
+
+// ReleaseScheduleRun releases a claimed run, which is then due again
+func (h *AnalyzerJSONHandler) ReleaseScheduleRun(ctx context.Context, s *Schedule) error {
+
+	if h.client == nil {
+		return fmt.Errorf("cannot release schedule without redis client")
+	}
+
+	if err := h.client.Del(ctx, scheduleRunKey(s.ID(), s.NextRun)).Err(); err != nil {
+		return fmt.Errorf("could not release schedule %s: %w", s.ID(), err)
+	}
+
+	return nil
+}
+
+// CompleteScheduleRun records the enqueued job of a claimed run and moves the
+// schedule to the first time of its cron expression after now. Missed runs
+// are not caught up.
+func (h *AnalyzerJSONHandler) CompleteScheduleRun(ctx context.Context, s *Schedule, jobID string, now time.Time) (err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.CompleteScheduleRun", trace.WithAttributes(
+		attribute.String("schedule.id", s.ID()),
+		attribute.String("job.id", jobID),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return fmt.Errorf("cannot complete schedule without redis client")
+	}
+
+	// the schedule may have been updated or removed in the meantime
+	current, err := h.GetSchedule(ctx, s.Url, s.Revision)
+	if err == goredis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := ParseCron(current.Cron)
+	if err != nil {
+		return err
+	}
+
+	current.LastRun = now.UTC()
+	current.LastJobID = jobID
+	current.NextRun = c.Next(now.UTC())
+
+	return h.storeSchedule(ctx, current)
+}
+
+// AllowHostRun counts a run against the git server and reports whether it is
+// within the limit of runs per window. The windows are fixed and shared by
+// all replicas. A limit of 0 allows all runs.
+func (h *AnalyzerJSONHandler) AllowHostRun(ctx context.Context, host string, limit int, window time.Duration, now time.Time) (bool, error) {
+
+	if limit <= 0 || host == "" || window <= 0 {
+		return true, nil
+	}
+
+	if h.client == nil {
+		return false, fmt.Errorf("cannot limit runs without redis client")
+	}
+
+	key := rateLimitKey(host, now.Truncate(window))
+
+	var count *goredis.IntCmd
+	_, err := txPipelined(ctx, h.client, func(pipe goredis.Pipeliner) error {
+		count = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not count runs of %s: %w", host, err)
+	}
+
+	return count.Val() <= int64(limit), nil
+}
@@ -0,0 +1,465 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SearchIndex is the RediSearch index over the latest result documents. Its
+// name is versioned, a changed schema gets a new index.
+const SearchIndex = schema.SearchIndex
+
+// searchBatch is the number of keys fetched per FT.SEARCH
+const searchBatch = 1000
+
+// searchSchema indexes the result documents of all repositories and revisions
+var searchSchema = []interface{}{
+	"ON", "JSON",
+	"PREFIX", 1, schema.ResultKeyPrefix,
+	"SCHEMA",
+	"$.Results[*].Technology", "AS", "technology", "TAG",
+	"$.Results[*].Path", "AS", "path", "TAG", "CASESENSITIVE",
+	"$.Repo.Name", "AS", "name", "TAG", "CASESENSITIVE",
+	"$.RepoID", "AS", "repo", "TAG", "CASESENSITIVE",
+	"$.Repo.Url", "AS", "url", "TAG", "CASESENSITIVE",
+	"$.Revision", "AS", "revision", "TAG", "CASESENSITIVE",
+	"$.Commit", "AS", "commit", "TAG",
+	"$.AnalyzedAtUnix", "AS", "analyzedat", "NUMERIC", "SORTABLE",
+}
+
+// ResultQuery filters the latest results of all repositories and revisions
+type ResultQuery struct {
+	// Technology is the name of a technology
+	Technology string
+	// Path matches the path and all paths below it, e.g. "infra" matches
+	// "infra" and "infra/aws"
+	Path string
+	// Repo is a glob pattern of the name or the url of the repository
+	Repo string
+	// AnalyzedAfter excludes results analyzed before it, if set
+	AnalyzedAfter time.Time
+	// Offset skips the first results, the most recently analyzed first
+	Offset int
+	// Limit is the maximum number of results, 0 returns all
+	Limit int
+}
+
+// ResultPage is a page of results of a query. If the query filters by
+// technology or path, the results only contain the matching paths.
+type ResultPage struct {
+	// Total is the number of results of the query on all pages
+	Total   int
+	Offset  int
+	Results []*AnalyzerResultValue
+	// Indexed reports whether the search index was used instead of a scan
+	Indexed bool
+}
+
+// EnsureSearchIndex creates the search index, unless it exists. It reports
+// whether the search module is available, without it queries scan all keys.
+// Documents are indexed by redis when they are written.
+func (h *AnalyzerJSONHandler) EnsureSearchIndex(ctx context.Context) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.EnsureSearchIndex")
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return false, fmt.Errorf("cannot create search index without redis client")
+	}
+
+	available, err := h.ensureSearchIndex(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	h.searchMu.Lock()
+	h.searchChecked = true
+	h.searchAvailable = available
+	h.searchMu.Unlock()
+
+	return available, nil
+}
+
+// searchIndexed reports whether the search index can be used. It is checked
+// on first use, unless EnsureSearchIndex was called, and again after errors.
+func (h *AnalyzerJSONHandler) searchIndexed(ctx context.Context) bool {
+
+	h.searchMu.Lock()
+	checked, available := h.searchChecked, h.searchAvailable
+	h.searchMu.Unlock()
+	if checked {
+		return available
+	}
+
+	available, err := h.EnsureSearchIndex(ctx)
+	if err != nil {
+		log.Warnf("could not use search index, scanning results: %v", err)
+	}
+
+	return available
+}
+
+func (h *AnalyzerJSONHandler) ensureSearchIndex(ctx context.Context) (bool, error) {
+
+	// the index of a cluster node only holds the keys of the node
+	if _, ok := h.client.(*goredis.ClusterClient); ok {
+		return false, nil
+	}
+
+	err := h.client.Do(ctx, "FT.INFO", SearchIndex).Err()
+	switch {
+	case err == nil:
+		return true, nil
+	case isUnknownCommand(err):
+		return false, nil
+	case !isUnknownIndex(err):
+		return false, fmt.Errorf("could not get search index %s: %w", SearchIndex, err)
+	}
+
+	log.Infof("Creating search index %s", SearchIndex)
+
+	args := append([]interface{}{"FT.CREATE", SearchIndex}, searchSchema...)
+	err = h.client.Do(ctx, args...).Err()
+	switch {
+	case err == nil:
+		return true, nil
+	case isUnknownCommand(err):
+		return false, nil
+	case strings.Contains(strings.ToLower(err.Error()), "index already exists"):
+		// created concurrently
+		return true, nil
+	}
+
+	return false, fmt.Errorf("could not create search index %s: %w", SearchIndex, err)
+}
+
+func isUnknownCommand(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "unknown command")
+}
+
+func isUnknownIndex(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unknown index") || strings.Contains(msg, "no such index")
+}
+
+// QueryResults returns the latest results matching the query, the most
+// recently analyzed first. If the search index matches the query exactly, it
+// sorts and pages the results, otherwise it narrows down the candidates. If
+// the search module is absent, all results are scanned instead.
+func (h *AnalyzerJSONHandler) QueryResults(ctx context.Context, q *ResultQuery) (_ *ResultPage, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.QueryResults", trace.WithAttributes(
+		attribute.String("query.technology", q.Technology),
+		attribute.String("query.path", q.Path),
+		attribute.String("query.repo", q.Repo),
+	))
+	defer func() { endSpan(span, err) }()
+
+	indexed := h.searchIndexed(ctx)
+	span.SetAttributes(attribute.Bool("query.indexed", indexed))
+
+	if indexed && q.searchExact() {
+		return h.searchPage(ctx, q)
+	}
+
+	var candidates []*AnalyzerResultValue
+	if indexed {
+		candidates, err = h.searchResults(ctx, q)
+	} else {
+		candidates, err = h.ListAnalyzerResults(ctx, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	page := filterResults(candidates, q)
+	page.Indexed = indexed
+
+	return page, nil
+}
+
+// searchExact reports whether the search query matches exactly the results
+// of the query. Glob patterns are not expressed and multi value fields are
+// matched independently, e.g. a technology and a path of different results.
+func (q *ResultQuery) searchExact() bool {
+
+	if q.Repo != "" && strings.ContainsAny(q.Repo, "*?[") {
+		return false
+	}
+
+	return q.Technology == "" || cleanQueryPath(q.Path) == ""
+}
+
+// searchPage returns the page of the query sorted and paged by the search
+// index, with the total it found
+func (h *AnalyzerJSONHandler) searchPage(ctx context.Context, q *ResultQuery) (*ResultPage, error) {
+
+	total, keys, err := h.searchKeys(ctx, searchQuery(q), true, q.Offset, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := h.getResults(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ResultPage{Total: total, Offset: q.Offset, Results: make([]*AnalyzerResultValue, 0, len(results)), Indexed: true}
+	for _, v := range results {
+		// only keeps the matching paths
+		if m := q.match(v); m != nil {
+			page.Results = append(page.Results, m)
+		}
+	}
+
+	return page, nil
+}
+
+// searchResults returns the documents found by the search index, which are
+// filtered again by filterResults
+func (h *AnalyzerJSONHandler) searchResults(ctx context.Context, q *ResultQuery) ([]*AnalyzerResultValue, error) {
+
+	_, keys, err := h.searchKeys(ctx, searchQuery(q), false, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.getResults(keys)
+}
+
+// searchKeys returns the total and the keys of the search from the offset on,
+// at most limit keys or all if it is 0. If sorted, the most recently analyzed
+// come first.
+func (h *AnalyzerJSONHandler) searchKeys(ctx context.Context, query string, sorted bool, offset, limit int) (int, []string, error) {
+
+	keys := make([]string, 0)
+
+	for {
+		count := searchBatch
+		if limit > 0 && limit-len(keys) < count {
+			count = limit - len(keys)
+		}
+
+		args := []interface{}{"FT.SEARCH", SearchIndex, query, "NOCONTENT"}
+		if sorted {
+			args = append(args, "SORTBY", "analyzedat", "DESC")
+		}
+		args = append(args, "LIMIT", offset+len(keys), count)
+
+		res, err := h.client.Do(ctx, args...).Result()
+		if err != nil {
+			return 0, nil, fmt.Errorf("could not search %q: %w", query, err)
+		}
+
+		total, batch, err := parseSearchKeys(res)
+		if err != nil {
+			return 0, nil, fmt.Errorf("could not search %q: %w", query, err)
+		}
+
+		keys = append(keys, batch...)
+		if len(batch) == 0 || offset+len(keys) >= total || limit > 0 && len(keys) >= limit {
+			return total, keys, nil
+		}
+	}
+}
+
+// getResults returns the result documents of the keys, without the ones
+// removed since they were found
+func (h *AnalyzerJSONHandler) getResults(keys []string) ([]*AnalyzerResultValue, error) {
+
+	results := make([]*AnalyzerResultValue, 0, len(keys))
+	for _, key := range keys {
+		item := &AnalyzerResultValue{}
+		if err := h.GetItem(key, item); err != nil {
+			if err.Error() == ErrJSONMissWithGoRedisClient {
+				continue
+			}
+			return nil, fmt.Errorf("could not get result %s: %w", key, err)
+		}
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+// searchQuery translates the query into the RediSearch query syntax. Filters
+// the syntax cannot express exactly are left to filterResults.
+func searchQuery(q *ResultQuery) string {
+
+	clauses := make([]string, 0)
+
+	if q.Technology != "" {
+		clauses = append(clauses, fmt.Sprintf("@technology:{%s}", escapeTag(q.Technology)))
+	}
+
+	if p := cleanQueryPath(q.Path); p != "" {
+		clauses = append(clauses, fmt.Sprintf("@path:{%s | %s*}", escapeTag(p), escapeTag(p+"/")))
+	}
+
+	// glob patterns are left to filterResults
+	if q.Repo != "" && !strings.ContainsAny(q.Repo, "*?[") {
+		clauses = append(clauses, fmt.Sprintf("(@name:{%s} | @repo:{%s})", escapeTag(q.Repo), escapeTag(RepositoryID(q.Repo))))
+	}
+
+	if !q.AnalyzedAfter.IsZero() {
+		clauses = append(clauses, fmt.Sprintf("@analyzedat:[%d +inf]", q.AnalyzedAfter.Unix()))
+	}
+
+	if len(clauses) == 0 {
+		return "*"
+	}
+
+	return strings.Join(clauses, " ")
+}
+
+// escapeTag escapes the punctuation and spaces of a tag value
+func escapeTag(s string) string {
+
+	var b strings.Builder
+	for _, r := range s {
+		if !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// parseSearchKeys returns the total and the keys of a FT.SEARCH ... NOCONTENT
+// reply, of both the RESP2 array and the RESP3 map
+func parseSearchKeys(res interface{}) (int, []string, error) {
+
+	switch reply := res.(type) {
+	case []interface{}:
+		if len(reply) == 0 {
+			return 0, nil, fmt.Errorf("empty search reply")
+		}
+		total, ok := reply[0].(int64)
+		if !ok {
+			return 0, nil, fmt.Errorf("unexpected search total %v", reply[0])
+		}
+
+		keys := make([]string, 0, len(reply)-1)
+		for _, k := range reply[1:] {
+			key, ok := k.(string)
+			if !ok {
+				return 0, nil, fmt.Errorf("unexpected search key %v", k)
+			}
+			keys = append(keys, key)
+		}
+		return int(total), keys, nil
+
+	case map[interface{}]interface{}:
+		total, ok := reply["total_results"].(int64)
+		if !ok {
+			return 0, nil, fmt.Errorf("unexpected search total %v", reply["total_results"])
+		}
+		docs, _ := reply["results"].([]interface{})
+
+		keys := make([]string, 0, len(docs))
+		for _, d := range docs {
+			doc, _ := d.(map[interface{}]interface{})
+			key, ok := doc["id"].(string)
+			if !ok {
+				return 0, nil, fmt.Errorf("unexpected search result %v", d)
+			}
+			keys = append(keys, key)
+		}
+		return int(total), keys, nil
+	}
+
+	return 0, nil, fmt.Errorf("unexpected search reply %T", res)
+}
+
+// filterResults applies the query to the candidates, sorts and pages them
+func filterResults(candidates []*AnalyzerResultValue, q *ResultQuery) *ResultPage {
+
+	matching := make([]*AnalyzerResultValue, 0)
+	for _, v := range candidates {
+		if m := q.match(v); m != nil {
+			matching = append(matching, m)
+		}
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		if !matching[i].AnalyzedAt.Equal(matching[j].AnalyzedAt) {
+			return matching[i].AnalyzedAt.After(matching[j].AnalyzedAt)
+		}
+		if a, b := matching[i].Repo.ID(), matching[j].Repo.ID(); a != b {
+			return a < b
+		}
+		return matching[i].Revision < matching[j].Revision
+	})
+
+	page := &ResultPage{Total: len(matching), Offset: q.Offset, Results: make([]*AnalyzerResultValue, 0)}
+
+	if q.Offset >= len(matching) {
+		return page
+	}
+	matching = matching[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matching) {
+		matching = matching[:q.Limit]
+	}
+	page.Results = matching
+
+	return page
+}
+
+// match returns the result, with only the matching paths if the query filters
+// by technology or path, or nil if it does not match
+func (q *ResultQuery) match(v *AnalyzerResultValue) *AnalyzerResultValue {
+
+	if v.Repo == nil {
+		return nil
+	}
+
+	if q.Repo != "" {
+		byName, _ := path.Match(q.Repo, v.Repo.Name)
+		byID, _ := path.Match(RepositoryID(q.Repo), v.Repo.ID())
+		if !byName && !byID {
+			return nil
+		}
+	}
+
+	if !q.AnalyzedAfter.IsZero() && v.AnalyzedAt.Before(q.AnalyzedAfter) {
+		return nil
+	}
+
+	queryPath := cleanQueryPath(q.Path)
+	if q.Technology == "" && queryPath == "" {
+		return v
+	}
+
+	res := make([]*TechAndPath, 0)
+	for _, tp := range v.Results {
+		if q.Technology != "" && tp.Technology != q.Technology {
+			continue
+		}
+		if queryPath != "" && tp.Path != queryPath && !strings.HasPrefix(tp.Path, queryPath+"/") {
+			continue
+		}
+		res = append(res, tp)
+	}
+	if len(res) == 0 {
+		return nil
+	}
+
+	m := *v
+	m.Results = res
+	return &m
+}
+
+// cleanQueryPath returns the path relative to the repository root, empty for
+// the root itself, which contains all paths
+func cleanQueryPath(p string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	return p
+}
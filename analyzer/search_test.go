@@ -0,0 +1,216 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redismock/v9"
+	"github.com/nitishm/go-rejson/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var searchTestResults = []*AnalyzerResultValue{
+	{
+		Repo:       &Repository{Name: "platform", Url: "https://github.com/org/platform.git"},
+		Revision:   "main",
+		AnalyzedAt: time.Date(2023, 6, 2, 0, 0, 0, 0, time.UTC),
+		Results: []*TechAndPath{
+			{Technology: "terraform", Path: "infra/aws"},
+			{Technology: "golang", Path: "."},
+		},
+	},
+	{
+		Repo:       &Repository{Name: "legacy", Url: "git@github.com:org/legacy.git"},
+		Revision:   "main",
+		AnalyzedAt: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+		Results: []*TechAndPath{
+			{Technology: "terraform", Path: "."},
+			{Technology: "ansible", Path: "infra"},
+		},
+	},
+	{
+		Repo:       &Repository{Name: "docs", Url: "https://gitlab.com/org/docs"},
+		Revision:   "main",
+		AnalyzedAt: time.Date(2023, 6, 3, 0, 0, 0, 0, time.UTC),
+		Results: []*TechAndPath{
+			{Technology: "terraform", Path: "infrastructure"},
+		},
+	},
+}
+
+func Test_filterResults(t *testing.T) {
+
+	urls := func(page *ResultPage) []string {
+		u := make([]string, 0)
+		for _, r := range page.Results {
+			u = append(u, r.Repo.Url)
+		}
+		return u
+	}
+
+	// technology and path must match the same result
+	page := filterResults(searchTestResults, &ResultQuery{Technology: "terraform", Path: "infra/"})
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, []string{"https://github.com/org/platform.git"}, urls(page))
+	assert.Equal(t, []*TechAndPath{{Technology: "terraform", Path: "infra/aws"}}, page.Results[0].Results)
+	assert.Len(t, searchTestResults[0].Results, 2)
+
+	// the most recently analyzed first
+	page = filterResults(searchTestResults, &ResultQuery{Technology: "terraform"})
+	assert.Equal(t, []string{"https://gitlab.com/org/docs", "https://github.com/org/platform.git", "git@github.com:org/legacy.git"}, urls(page))
+
+	// pagination
+	page = filterResults(searchTestResults, &ResultQuery{Technology: "terraform", Offset: 1, Limit: 1})
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, []string{"https://github.com/org/platform.git"}, urls(page))
+	page = filterResults(searchTestResults, &ResultQuery{Offset: 5})
+	assert.Equal(t, 3, page.Total)
+	assert.Empty(t, page.Results)
+
+	// repository by name, by url in any form and by glob
+	page = filterResults(searchTestResults, &ResultQuery{Repo: "legacy"})
+	assert.Equal(t, []string{"git@github.com:org/legacy.git"}, urls(page))
+	page = filterResults(searchTestResults, &ResultQuery{Repo: "https://github.com/org/legacy"})
+	assert.Equal(t, []string{"git@github.com:org/legacy.git"}, urls(page))
+	page = filterResults(searchTestResults, &ResultQuery{Repo: "github.com/org/*"})
+	assert.Equal(t, []string{"https://github.com/org/platform.git", "git@github.com:org/legacy.git"}, urls(page))
+
+	// analyzed after
+	page = filterResults(searchTestResults, &ResultQuery{AnalyzedAfter: time.Date(2023, 6, 2, 0, 0, 0, 0, time.UTC)})
+	assert.Equal(t, []string{"https://gitlab.com/org/docs", "https://github.com/org/platform.git"}, urls(page))
+}
+
+func Test_searchQuery(t *testing.T) {
+
+	assert.Equal(t, "*", searchQuery(&ResultQuery{Path: "."}))
+	assert.Equal(t, `@technology:{terraform} @path:{infra\/aws | infra\/aws\/*}`,
+		searchQuery(&ResultQuery{Technology: "terraform", Path: "/infra/aws/"}))
+	assert.Equal(t, `(@name:{legacy} | @repo:{legacy})`, searchQuery(&ResultQuery{Repo: "legacy"}))
+	assert.Equal(t, `(@name:{https\:\/\/github\.com\/org\/legacy\.git} | @repo:{github\.com\/org\/legacy})`,
+		searchQuery(&ResultQuery{Repo: "https://github.com/org/legacy.git"}))
+	assert.Equal(t, "*", searchQuery(&ResultQuery{Repo: "github.com/org/*"}))
+	assert.Equal(t, "@analyzedat:[1685750400 +inf]", searchQuery(&ResultQuery{AnalyzedAfter: time.Date(2023, 6, 3, 0, 0, 0, 0, time.UTC)}))
+}
+
+func Test_searchExact(t *testing.T) {
+
+	assert.True(t, (&ResultQuery{}).searchExact())
+	assert.True(t, (&ResultQuery{Technology: "terraform", Repo: "platform"}).searchExact())
+	assert.True(t, (&ResultQuery{Technology: "terraform", Path: "/"}).searchExact())
+	assert.False(t, (&ResultQuery{Technology: "terraform", Path: "infra"}).searchExact())
+	assert.False(t, (&ResultQuery{Repo: "github.com/org/*"}).searchExact())
+}
+
+func Test_parseSearchKeys(t *testing.T) {
+
+	total, keys, err := parseSearchKeys([]interface{}{int64(3), "analyzerresult|a|main", "analyzerresult|b|main"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []string{"analyzerresult|a|main", "analyzerresult|b|main"}, keys)
+
+	total, keys, err = parseSearchKeys(map[interface{}]interface{}{
+		"total_results": int64(1),
+		"results":       []interface{}{map[interface{}]interface{}{"id": "analyzerresult|a|main"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []string{"analyzerresult|a|main"}, keys)
+
+	_, _, err = parseSearchKeys("OK")
+	assert.Error(t, err)
+}
+
+func TestQueryResults(t *testing.T) {
+
+	client, mock := redismock.NewClientMock()
+	rh := rejson.NewReJSONHandler()
+	rh.SetGoRedisClientWithContext(context.Background(), client)
+
+	// the index is created, if it is missing
+	h := NewAnalyzerJSONHandlerWithClient(rh, client)
+	mock.ExpectDo("FT.INFO", SearchIndex).SetErr(errors.New("Unknown Index name"))
+	mock.ExpectDo(append([]interface{}{"FT.CREATE", SearchIndex}, searchSchema...)...).SetVal("OK")
+	mock.ExpectDo("FT.SEARCH", SearchIndex, "@technology:{terraform}", "NOCONTENT", "SORTBY", "analyzedat", "DESC", "LIMIT", 0, searchBatch).
+		SetVal([]interface{}{int64(2), "analyzerresult|github.com/org/platform|main", "analyzerresult|github.com/org/gone|main"})
+	mock.ExpectDo("JSON.GET", "analyzerresult|github.com/org/platform|main", ".").
+		SetVal(`{"Repo":{"Url":"https://github.com/org/platform"},"Revision":"main","Results":[{"Technology":"terraform","Path":"infra"},{"Technology":"golang","Path":"."}]}`)
+	mock.ExpectDo("JSON.GET", "analyzerresult|github.com/org/gone|main", ".").RedisNil()
+
+	page, err := h.QueryResults(context.Background(), &ResultQuery{Technology: "terraform"})
+	assert.NoError(t, err)
+	assert.True(t, page.Indexed)
+	assert.Equal(t, 2, page.Total)
+	if assert.Len(t, page.Results, 1) {
+		assert.Equal(t, "https://github.com/org/platform", page.Results[0].Repo.Url)
+		assert.Equal(t, []*TechAndPath{{Technology: "terraform", Path: "infra"}}, page.Results[0].Results)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the search sorts and pages the results
+	mock.ExpectDo("FT.SEARCH", SearchIndex, "(@name:{platform} | @repo:{platform})", "NOCONTENT", "SORTBY", "analyzedat", "DESC", "LIMIT", 20, 10).
+		SetVal([]interface{}{int64(21), "analyzerresult|github.com/org/platform|main"})
+	mock.ExpectDo("JSON.GET", "analyzerresult|github.com/org/platform|main", ".").
+		SetVal(`{"Repo":{"Name":"platform","Url":"https://github.com/org/platform"},"Revision":"main","Results":[]}`)
+
+	page, err = h.QueryResults(context.Background(), &ResultQuery{Repo: "platform", Offset: 20, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 21, page.Total)
+	assert.Equal(t, 20, page.Offset)
+	assert.Len(t, page.Results, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// a technology and a path may match different results, the candidates
+	// are filtered again
+	mock.ExpectDo("FT.SEARCH", SearchIndex, `@technology:{terraform} @path:{infra | infra\/*}`, "NOCONTENT", "LIMIT", 0, searchBatch).
+		SetVal([]interface{}{int64(1), "analyzerresult|github.com/org/platform|main"})
+	mock.ExpectDo("JSON.GET", "analyzerresult|github.com/org/platform|main", ".").
+		SetVal(`{"Repo":{"Url":"https://github.com/org/platform"},"Revision":"main","Results":[{"Technology":"terraform","Path":"."},{"Technology":"ansible","Path":"infra"}]}`)
+
+	page, err = h.QueryResults(context.Background(), &ResultQuery{Technology: "terraform", Path: "infra"})
+	assert.NoError(t, err)
+	assert.True(t, page.Indexed)
+	assert.Equal(t, 0, page.Total)
+	assert.Empty(t, page.Results)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// without search module, all results are scanned
+	h = NewAnalyzerJSONHandlerWithClient(rh, client)
+	mock.ExpectDo("FT.INFO", SearchIndex).SetErr(errors.New("ERR unknown command 'FT.INFO'"))
+	mock.ExpectScan(0, "analyzerresult|*|*", 100).SetVal([]string{"analyzerresult|github.com/org/platform|main"}, 0)
+	mock.ExpectDo("JSON.GET", "analyzerresult|github.com/org/platform|main", ".").
+		SetVal(`{"Repo":{"Url":"https://github.com/org/platform"},"Revision":"main","Results":[{"Technology":"terraform","Path":"infra"}]}`)
+
+	page, err = h.QueryResults(context.Background(), &ResultQuery{Technology: "terraform"})
+	assert.NoError(t, err)
+	assert.False(t, page.Indexed)
+	assert.Equal(t, 1, page.Total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryResultsConcurrent(t *testing.T) {
+
+	// the handler is shared by the api and the grpc service
+	s := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+	rh := rejson.NewReJSONHandler()
+	rh.SetGoRedisClientWithContext(context.Background(), client)
+	h := NewAnalyzerJSONHandlerWithClient(rh, client)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			page, err := h.QueryResults(context.Background(), &ResultQuery{Technology: "terraform"})
+			if assert.NoError(t, err) {
+				assert.False(t, page.Indexed)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,43 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ResultSink stores the results of analyses, e.g. the redis json handler, the
+// files or the sql databases of the sink package
+type ResultSink interface {
+	SetAnalyzerResult(ctx context.Context, result *AnalyzerResultValue) error
+}
+
+// NamedSink is a result sink with a name for errors and logs
+type NamedSink struct {
+	Name string
+	ResultSink
+	// Optional sinks only log their errors. A result missing in them is not
+	// written again with the next analysis, which takes the cache.
+	Optional bool
+}
+
+// MultiSink writes the results to all of its sinks. A failing sink does not
+// keep the result from the others.
+type MultiSink []NamedSink
+
+func (m MultiSink) SetAnalyzerResult(ctx context.Context, result *AnalyzerResultValue) error {
+
+	var errs []error
+	for _, s := range m {
+		err := s.SetAnalyzerResult(ctx, result)
+		switch {
+		case err == nil:
+		case s.Optional:
+			log.Errorf("could not write result to %s: %v", s.Name, err)
+		default:
+			errs = append(errs, fmt.Errorf("could not write result to %s: %w", s.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,48 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sinkMock struct {
+	results []*AnalyzerResultValue
+	err     error
+}
+
+func (s *sinkMock) SetAnalyzerResult(ctx context.Context, result *AnalyzerResultValue) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.results = append(s.results, result)
+	return nil
+}
+
+func TestMultiSink(t *testing.T) {
+
+	result := &AnalyzerResultValue{Commit: "abc"}
+	required, optional, failing := &sinkMock{}, &sinkMock{}, &sinkMock{err: errors.New("disk full")}
+
+	// an optional sink does not fail the write
+	sinks := MultiSink{
+		{Name: "redisjson", ResultSink: required},
+		{Name: "file", ResultSink: failing, Optional: true},
+		{Name: "sqlite", ResultSink: optional, Optional: true},
+	}
+	assert.NoError(t, sinks.SetAnalyzerResult(context.Background(), result))
+	assert.Equal(t, []*AnalyzerResultValue{result}, required.results)
+	assert.Equal(t, []*AnalyzerResultValue{result}, optional.results)
+
+	// a required one does, after the others are written
+	sinks = MultiSink{
+		{Name: "redisjson", ResultSink: failing},
+		{Name: "sqlite", ResultSink: optional, Optional: true},
+	}
+	err := sinks.SetAnalyzerResult(context.Background(), result)
+	assert.EqualError(t, err, "could not write result to redisjson: disk full")
+	assert.ErrorIs(t, err, failing.err)
+	assert.Len(t, optional.results, 2)
+}
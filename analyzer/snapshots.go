@@ -0,0 +1,318 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nitishm/go-rejson/v4/rjs"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetentionPolicy limits the snapshots kept per repository. The latest
+// snapshot is always kept. Zero values disable the limits.
+type RetentionPolicy struct {
+	// MaxSnapshots keeps only the newest snapshots
+	MaxSnapshots int
+	// MaxAge removes snapshots of commits older than it
+	MaxAge time.Duration
+}
+
+// SetRetention sets the policy applied whenever a snapshot is added
+func (h *AnalyzerJSONHandler) SetRetention(retention RetentionPolicy) {
+	h.retention = retention
+}
+
+// analyzerSnapshotsKey is the key of the sorted set of snapshot commits of a
+// repository, scored by the time of the snapshot
+func analyzerSnapshotsKey(repoID string) string {
+	return schema.SnapshotsKey(repoID)
+}
+
+// analyzerSnapshotRevisionsKey is the key of the set of revisions a snapshot
+// commit was analyzed on
+func analyzerSnapshotRevisionsKey(repoID, commitId string) string {
+	return schema.SnapshotRevisionsKey(repoID, commitId)
+}
+
+// snapshotTime orders the snapshots: the commit time, or the time of the
+// analysis if there is none
+func (v *AnalyzerResultValue) snapshotTime() time.Time {
+	if !v.CommittedAt.IsZero() {
+		return v.CommittedAt
+	}
+	return v.AnalyzedAt
+}
+
+// addSnapshot stores the result of the commit, unless it already exists, as
+// snapshots are immutable. The revision is recorded for every analysis of the
+// commit, also on another branch. It indexes the snapshot and applies the
+// retention.
+func (h *AnalyzerJSONHandler) addSnapshot(ctx context.Context, repoID string, result *AnalyzerResultValue) error {
+	if h.client == nil {
+		return fmt.Errorf("cannot index snapshot without redis client")
+	}
+
+	key := analyzerSnapshotKey(repoID, result.Commit)
+
+	if result.Revision != "" {
+		if err := h.client.SAdd(ctx, analyzerSnapshotRevisionsKey(repoID, result.Commit), result.Revision).Err(); err != nil {
+			return fmt.Errorf("could not add revision %s to snapshot %s: %w", result.Revision, key, err)
+		}
+	}
+
+	// NX: the first analysis of a commit is kept
+	res, err := h.handler.JSONSet(key, ".", result, rjs.SetOptionNX)
+	if err != nil {
+		return fmt.Errorf("could not set snapshot %s: %v", key, err)
+	}
+	if res == nil {
+		return nil
+	}
+
+	err = h.client.ZAdd(ctx, analyzerSnapshotsKey(repoID), goredis.Z{
+		Score:  float64(result.snapshotTime().Unix()),
+		Member: result.Commit,
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("could not index snapshot %s: %w", key, err)
+	}
+
+	if _, err := h.applyRetention(ctx, repoID, time.Now()); err != nil {
+		log.Warnf("could not apply snapshot retention of %s: %v", repoID, err)
+	}
+
+	return nil
+}
+
+// applyRetention removes the snapshots exceeding the retention policy and
+// returns their number
+func (h *AnalyzerJSONHandler) applyRetention(ctx context.Context, repoID string, now time.Time) (int, error) {
+
+	if h.retention.MaxSnapshots <= 0 && h.retention.MaxAge <= 0 {
+		return 0, nil
+	}
+
+	key := analyzerSnapshotsKey(repoID)
+	expired := make([]string, 0)
+
+	if h.retention.MaxSnapshots > 0 {
+		// all but the newest MaxSnapshots
+		commits, err := h.client.ZRange(ctx, key, 0, int64(-h.retention.MaxSnapshots-1)).Result()
+		if err != nil {
+			return 0, fmt.Errorf("could not get snapshots of %s: %w", repoID, err)
+		}
+		expired = append(expired, commits...)
+	}
+
+	if h.retention.MaxAge > 0 {
+		commits, err := h.client.ZRangeByScore(ctx, key, &goredis.ZRangeBy{
+			Min: "-inf",
+			Max: "(" + strconv.FormatInt(now.Add(-h.retention.MaxAge).Unix(), 10),
+		}).Result()
+		if err != nil {
+			return 0, fmt.Errorf("could not get snapshots of %s: %w", repoID, err)
+		}
+
+		latest, err := h.client.ZRevRange(ctx, key, 0, 0).Result()
+		if err != nil {
+			return 0, fmt.Errorf("could not get latest snapshot of %s: %w", repoID, err)
+		}
+
+		for _, commit := range commits {
+			if len(latest) == 0 || commit != latest[0] {
+				expired = append(expired, commit)
+			}
+		}
+	}
+
+	removed := 0
+	seen := make(map[string]bool)
+	for _, commit := range expired {
+		if seen[commit] {
+			continue
+		}
+		seen[commit] = true
+
+		if err := h.client.Del(ctx, analyzerSnapshotKey(repoID, commit)).Err(); err != nil {
+			return removed, fmt.Errorf("could not delete snapshot %s of %s: %w", commit, repoID, err)
+		}
+		if err := h.client.Del(ctx, analyzerSnapshotRevisionsKey(repoID, commit)).Err(); err != nil {
+			return removed, fmt.Errorf("could not delete revisions of snapshot %s of %s: %w", commit, repoID, err)
+		}
+		if err := h.client.ZRem(ctx, key, commit).Err(); err != nil {
+			return removed, fmt.Errorf("could not unindex snapshot %s of %s: %w", commit, repoID, err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// ListSnapshots returns the snapshots of the repository, the oldest first. If
+// revision is set, only snapshots of commits analyzed on that revision are
+// returned, with it as their revision.
+func (h *AnalyzerJSONHandler) ListSnapshots(ctx context.Context, repoURL, revision string) (_ []*AnalyzerResultValue, err error) {
+	ctx, span := tracer.Start(ctx, "AnalyzerJSONHandler.ListSnapshots", trace.WithAttributes(
+		attribute.String("repo.url", repoURL),
+		attribute.String("repo.revision", revision),
+	))
+	defer func() { endSpan(span, err) }()
+
+	if h.client == nil {
+		return nil, fmt.Errorf("cannot list snapshots without redis client")
+	}
+
+	repoID := RepositoryID(repoURL)
+
+	commits, err := h.client.ZRange(ctx, analyzerSnapshotsKey(repoID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not list snapshots of %s: %w", repoID, err)
+	}
+
+	snapshots := make([]*AnalyzerResultValue, 0, len(commits))
+	for _, commit := range commits {
+		item := &AnalyzerResultValue{}
+		if err := h.GetItem(analyzerSnapshotKey(repoID, commit), item); err != nil {
+			return nil, fmt.Errorf("could not get snapshot %s of %s: %w", commit, repoID, err)
+		}
+
+		if revision != "" && item.Revision != revision {
+			// the commit was analyzed on another revision first
+			analyzed, err := h.client.SIsMember(ctx, analyzerSnapshotRevisionsKey(repoID, commit), revision).Result()
+			if err != nil {
+				return nil, fmt.Errorf("could not get revisions of snapshot %s of %s: %w", commit, repoID, err)
+			}
+			if !analyzed {
+				continue
+			}
+			item.Revision = revision
+		}
+		snapshots = append(snapshots, item)
+	}
+
+	return snapshots, nil
+}
+
+// PresenceTimeline is the presence of technologies in a repository over time,
+// built from its snapshots
+type PresenceTimeline struct {
+	RepoID       string
+	Snapshots    []*SnapshotPresence
+	Technologies []*TechnologyPresence
+}
+
+// SnapshotPresence lists the technologies of a single snapshot
+type SnapshotPresence struct {
+	Commit   string
+	Revision string
+	// Time is the commit time, or the time of the analysis if there is none
+	Time time.Time
+	// Technologies maps the present technologies to the number of their paths
+	Technologies map[string]int
+}
+
+// TechnologyPresence are the periods a technology was present in
+type TechnologyPresence struct {
+	Technology string
+	Periods    []*PresencePeriod
+}
+
+// PresencePeriod starts with the first snapshot containing the technology and
+// ends with the first one without it. Until is nil if it is still present.
+type PresencePeriod struct {
+	SinceCommit string
+	Since       time.Time
+	UntilCommit string     `json:",omitempty" yaml:",omitempty"`
+	Until       *time.Time `json:",omitempty" yaml:",omitempty"`
+}
+
+// GetTechnologyPresence returns the presence timeline of the repository, of
+// all snapshots or only of those of the revision
+func (h *AnalyzerJSONHandler) GetTechnologyPresence(ctx context.Context, repoURL, revision string) (*PresenceTimeline, error) {
+
+	snapshots, err := h.ListSnapshots(ctx, repoURL, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildPresenceTimeline(RepositoryID(repoURL), snapshots), nil
+}
+
+// BuildPresenceTimeline compares consecutive snapshots to find the periods each
+// technology was present in
+func BuildPresenceTimeline(repoID string, snapshots []*AnalyzerResultValue) *PresenceTimeline {
+
+	snapshots = append([]*AnalyzerResultValue{}, snapshots...)
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].snapshotTime().Before(snapshots[j].snapshotTime())
+	})
+
+	timeline := &PresenceTimeline{
+		RepoID:       repoID,
+		Snapshots:    make([]*SnapshotPresence, 0, len(snapshots)),
+		Technologies: make([]*TechnologyPresence, 0),
+	}
+
+	// the technologies present in the previous snapshot
+	open := make(map[string]*TechnologyPresence)
+
+	for _, s := range snapshots {
+		sp := &SnapshotPresence{
+			Commit:       s.Commit,
+			Revision:     s.Revision,
+			Time:         s.snapshotTime(),
+			Technologies: make(map[string]int),
+		}
+		for _, tp := range s.Results {
+			sp.Technologies[tp.Technology]++
+		}
+		timeline.Snapshots = append(timeline.Snapshots, sp)
+
+		// technologies, which disappeared
+		for tech, presence := range open {
+			if sp.Technologies[tech] == 0 {
+				period := presence.Periods[len(presence.Periods)-1]
+				until := sp.Time
+				period.UntilCommit = sp.Commit
+				period.Until = &until
+				delete(open, tech)
+			}
+		}
+
+		// technologies, which appeared
+		for tech := range sp.Technologies {
+			if open[tech] != nil {
+				continue
+			}
+
+			var presence *TechnologyPresence
+			for _, p := range timeline.Technologies {
+				if p.Technology == tech {
+					presence = p
+				}
+			}
+			if presence == nil {
+				presence = &TechnologyPresence{Technology: tech}
+				timeline.Technologies = append(timeline.Technologies, presence)
+			}
+
+			presence.Periods = append(presence.Periods, &PresencePeriod{
+				SinceCommit: sp.Commit,
+				Since:       sp.Time,
+			})
+			open[tech] = presence
+		}
+	}
+
+	sort.Slice(timeline.Technologies, func(i, j int) bool {
+		return timeline.Technologies[i].Technology < timeline.Technologies[j].Technology
+	})
+
+	return timeline
+}
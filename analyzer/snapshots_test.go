@@ -0,0 +1,151 @@
+package analyzer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/nitishm/go-rejson/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildPresenceTimeline(t *testing.T) {
+
+	day := func(d int) time.Time { return time.Date(2023, 8, d, 0, 0, 0, 0, time.UTC) }
+	snapshot := func(commit string, d int, techs ...string) *AnalyzerResultValue {
+		v := &AnalyzerResultValue{Commit: commit, Revision: "main", CommittedAt: day(d)}
+		for _, tech := range techs {
+			v.Results = append(v.Results, &TechAndPath{Technology: tech, Path: "."})
+		}
+		return v
+	}
+
+	// unordered, as the order of the snapshots must not matter
+	timeline := BuildPresenceTimeline("github.com/org/repo", []*AnalyzerResultValue{
+		snapshot("c3", 3, "helm"),
+		snapshot("c1", 1, "ansible", "golang"),
+		snapshot("c4", 4, "ansible", "helm", "helm"),
+		snapshot("c2", 2, "ansible", "helm"),
+	})
+
+	assert.Len(t, timeline.Snapshots, 4)
+	assert.Equal(t, "c1", timeline.Snapshots[0].Commit)
+	assert.Equal(t, map[string]int{"ansible": 1, "helm": 2}, timeline.Snapshots[3].Technologies)
+
+	until := func(d int) *time.Time { t := day(d); return &t }
+	assert.Equal(t, []*TechnologyPresence{
+		{Technology: "ansible", Periods: []*PresencePeriod{
+			{SinceCommit: "c1", Since: day(1), UntilCommit: "c3", Until: until(3)},
+			{SinceCommit: "c4", Since: day(4)},
+		}},
+		{Technology: "golang", Periods: []*PresencePeriod{
+			{SinceCommit: "c1", Since: day(1), UntilCommit: "c2", Until: until(2)},
+		}},
+		{Technology: "helm", Periods: []*PresencePeriod{
+			{SinceCommit: "c2", Since: day(2)},
+		}},
+	}, timeline.Technologies)
+}
+
+func TestSnapshotRetention(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+	ctx := context.Background()
+
+	h := NewAnalyzerJSONHandlerWithClient(rejson.NewReJSONHandler(), client)
+	now := time.Date(2023, 8, 10, 0, 0, 0, 0, time.UTC)
+
+	for i, commit := range []string{"c1", "c2", "c3", "c4"} {
+		assert.NoError(t, s.Set(analyzerSnapshotKey("github.com/org/repo", commit), "{}"))
+		_, err := s.ZAdd(analyzerSnapshotsKey("github.com/org/repo"), float64(now.AddDate(0, 0, i-3).Unix()), commit)
+		assert.NoError(t, err)
+	}
+
+	// disabled
+	removed, err := h.applyRetention(ctx, "github.com/org/repo", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	// last 3
+	h.SetRetention(RetentionPolicy{MaxSnapshots: 3})
+	removed, err = h.applyRetention(ctx, "github.com/org/repo", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.False(t, s.Exists(analyzerSnapshotKey("github.com/org/repo", "c1")))
+
+	// max age keeps the latest snapshot, even if it is too old
+	h.SetRetention(RetentionPolicy{MaxAge: 36 * time.Hour})
+	removed, err = h.applyRetention(ctx, "github.com/org/repo", now.AddDate(0, 0, 7))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	members, err := s.ZMembers(analyzerSnapshotsKey("github.com/org/repo"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c4"}, members)
+	assert.True(t, s.Exists(analyzerSnapshotKey("github.com/org/repo", "c4")))
+}
+
+func TestSnapshotRevisions(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	// the json commands of the snapshots, documents are stored as strings
+	assert.NoError(t, s.Server().Register("JSON.SET", func(c *server.Peer, cmd string, args []string) {
+		if len(args) > 3 && strings.EqualFold(args[3], "NX") && s.Exists(args[0]) {
+			c.WriteNull()
+			return
+		}
+		assert.NoError(t, s.Set(args[0], args[2]))
+		c.WriteOK()
+	}))
+	assert.NoError(t, s.Server().Register("JSON.GET", func(c *server.Peer, cmd string, args []string) {
+		v, err := s.Get(args[0])
+		if err != nil {
+			c.WriteNull()
+			return
+		}
+		c.WriteBulk(v)
+	}))
+	client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+	ctx := context.Background()
+
+	rh := rejson.NewReJSONHandler()
+	rh.SetGoRedisClientWithContext(ctx, client)
+	h := NewAnalyzerJSONHandlerWithClient(rh, client)
+
+	committedAt := time.Date(2023, 8, 10, 0, 0, 0, 0, time.UTC)
+	snapshot := func(commit, revision string) *AnalyzerResultValue {
+		return &AnalyzerResultValue{Repo: &Repository{Url: "https://github.com/org/repo"}, Revision: revision, Commit: commit, CommittedAt: committedAt}
+	}
+	assert.NoError(t, h.addSnapshot(ctx, "github.com/org/repo", snapshot("c1", "main")))
+	assert.NoError(t, h.addSnapshot(ctx, "github.com/org/repo", snapshot("c2", "develop")))
+
+	// the same commit on another branch is a snapshot of both
+	assert.NoError(t, h.addSnapshot(ctx, "github.com/org/repo", snapshot("c1", "develop")))
+
+	snapshots, err := h.ListSnapshots(ctx, "https://github.com/org/repo", "develop")
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+	for _, snapshot := range snapshots {
+		assert.Equal(t, "develop", snapshot.Revision)
+	}
+
+	snapshots, err = h.ListSnapshots(ctx, "https://github.com/org/repo", "main")
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	assert.Equal(t, "c1", snapshots[0].Commit)
+
+	snapshots, err = h.ListSnapshots(ctx, "https://github.com/org/repo", "")
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+
+	// the revisions are removed with the snapshot
+	h.SetRetention(RetentionPolicy{MaxSnapshots: 1})
+	_, err = h.applyRetention(ctx, "github.com/org/repo", committedAt)
+	assert.NoError(t, err)
+	assert.False(t, s.Exists(analyzerSnapshotRevisionsKey("github.com/org/repo", "c1")))
+	assert.True(t, s.Exists(analyzerSnapshotRevisionsKey("github.com/org/repo", "c2")))
+}
@@ -0,0 +1,340 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+// Package api serves analysis requests, job states and results over http,
+// for consumers that do not speak redis streams.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenAPI is the OpenAPI document of the routes, served at /openapi.yaml
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
+
+// maxRequestBody is the maximum size of an analysis request
+const maxRequestBody = 1 << 20
+
+// defaultLimit is the page size of repository lists without a limit
+const defaultLimit = 50
+
+var tracer = otel.Tracer("github.com/stuttgart-things/sweatShop-analyzer/api")
+
+// Enqueuer validates and enqueues analysis requests, implemented by the
+// stream producer
+type Enqueuer interface {
+	Enqueue(ctx context.Context, values map[string]interface{}) (string, error)
+}
+
+// Store reads the jobs and results, implemented by the analyzer json handler
+type Store interface {
+	GetJobStatus(ctx context.Context, jobID string) (*analyzer.JobStatus, error)
+	GetAnalyzerResult(ctx context.Context, repoURL, revision string) (*analyzer.AnalyzerResultValue, error)
+	GetAnalyzerResultAt(ctx context.Context, repoURL, commitId string) (*analyzer.AnalyzerResultValue, error)
+	QueryResults(ctx context.Context, q *analyzer.ResultQuery) (*analyzer.ResultPage, error)
+}
+
+// Server serves the routes of the api
+type Server struct {
+	producer Enqueuer
+	store    Store
+	// tokens are the accepted bearer tokens, none disables the authentication
+	tokens []string
+}
+
+// TechnologyRepositories are the repositories containing a technology
+type TechnologyRepositories struct {
+	Technology string
+	// Total is the number of matching revisions on all pages
+	Total        int
+	Offset       int
+	Repositories []*analyzer.TechnologyUsage
+}
+
+// NewServer returns the server of the api. Requests must carry one of the
+// tokens as bearer token, unless there is none.
+func NewServer(producer Enqueuer, store Store, tokens []string) *Server {
+	return &Server{producer: producer, store: store, tokens: tokens}
+}
+
+// Handler returns the routes of the api. The OpenAPI document is served
+// without authentication.
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openapi.yaml", s.openAPI)
+	mux.Handle("/analyses", s.authenticated(s.analyses))
+	mux.Handle("/analyses/", s.authenticated(s.analysis))
+	mux.Handle("/repositories/", s.authenticated(s.repositoryResults))
+	mux.Handle("/technologies/", s.authenticated(s.technologyRepositories))
+
+	return mux
+}
+
+// ListenAndServe serves the api on addr until the server fails
+func (s *Server) ListenAndServe(addr string) error {
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+	}
+
+	return srv.ListenAndServe()
+}
+
+// authenticated checks the bearer token and traces the request
+func (s *Server) authenticated(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ctx, span := tracer.Start(r.Context(), r.Method+" "+route(r.URL.Path), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+		))
+		defer span.End()
+
+		if !s.authorized(r) {
+			span.SetStatus(codes.Error, "unauthorized")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sweatShop-analyzer"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+
+		next(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+
+	if len(s.tokens) == 0 {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+
+	// compare with all tokens, so the time does not tell which one matched
+	authorized := 0
+	for _, t := range s.tokens {
+		authorized |= subtle.ConstantTimeCompare([]byte(t), []byte(token))
+	}
+
+	return authorized == 1
+}
+
+// route returns the route of a path, without the ids, as span name
+func route(path string) string {
+
+	switch {
+	case strings.HasPrefix(path, "/analyses/"):
+		return "/analyses/{id}"
+	case strings.HasPrefix(path, "/repositories/"):
+		return "/repositories/{id}/results"
+	case strings.HasPrefix(path, "/technologies/"):
+		return "/technologies/{name}/repositories"
+	}
+
+	return path
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(OpenAPI)
+}
+
+// analyses enqueues an analysis request, a json object with the fields of a
+// stream message
+func (s *Server) analyses(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	request := make(map[string]string)
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err := dec.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid analysis request: %v", err))
+		return
+	}
+
+	values := make(map[string]interface{}, len(request))
+	for k, v := range request {
+		if v != "" {
+			values[k] = v
+		}
+	}
+
+	if err := stream.ValidateValues(values); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := s.producer.Enqueue(r.Context(), values)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/analyses/"+id)
+	writeJSON(w, http.StatusAccepted, map[string]string{stream.FieldJobID: id})
+}
+
+// analysis returns the state of a job
+func (s *Server) analysis(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/analyses/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	job, err := s.store.GetJobStatus(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err, fmt.Sprintf("job %s not found", id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// repositoryResults returns the result of a repository, identified by its
+// url or identity, e.g. "github.com/org/repo". Without a commit, the latest
+// result of the revision, or of the most recently analyzed one, is returned.
+func (s *Server) repositoryResults(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	repo, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/repositories/"), "/results")
+	if !ok || repo == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var result *analyzer.AnalyzerResultValue
+	var err error
+	if commit := r.URL.Query().Get("commit"); commit != "" {
+		result, err = s.store.GetAnalyzerResultAt(r.Context(), repo, commit)
+	} else {
+		result, err = s.store.GetAnalyzerResult(r.Context(), repo, r.URL.Query().Get("revision"))
+	}
+	if err != nil {
+		writeStoreError(w, err, fmt.Sprintf("no result of %s found", repo))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// technologyRepositories returns the paths of a technology per repository
+// and revision, the most recently analyzed first
+func (s *Server) technologyRepositories(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/technologies/"), "/repositories")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	q := &analyzer.ResultQuery{Technology: name, Limit: defaultLimit}
+	for param, target := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		if v := r.URL.Query().Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %q", param, v))
+				return
+			}
+			*target = n
+		}
+	}
+
+	page, err := s.store.QueryResults(r.Context(), q)
+	if err != nil {
+		writeStoreError(w, err, "")
+		return
+	}
+
+	res := &TechnologyRepositories{
+		Technology:   name,
+		Total:        page.Total,
+		Offset:       page.Offset,
+		Repositories: make([]*analyzer.TechnologyUsage, 0, len(page.Results)),
+	}
+	for _, v := range page.Results {
+		usage := &analyzer.TechnologyUsage{RepoID: v.Repo.ID(), Url: v.Repo.Url, Revision: v.Revision}
+		for _, tp := range v.Results {
+			usage.Paths = append(usage.Paths, tp.Path)
+		}
+		res.Repositories = append(res.Repositories, usage)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// writeStoreError answers 404 for missing keys and 500 for other errors
+func writeStoreError(w http.ResponseWriter, err error, notFound string) {
+
+	if err.Error() == analyzer.ErrJSONMissWithGoRedisClient && notFound != "" {
+		writeError(w, http.StatusNotFound, notFound)
+		return
+	}
+
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
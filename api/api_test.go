@@ -0,0 +1,248 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+type enqueuerMock struct {
+	requests []map[string]interface{}
+	err      error
+}
+
+func (e *enqueuerMock) Enqueue(ctx context.Context, values map[string]interface{}) (string, error) {
+	if e.err != nil {
+		return "", e.err
+	}
+	e.requests = append(e.requests, values)
+	return fmt.Sprintf("job-%d", len(e.requests)), nil
+}
+
+type storeMock struct {
+	jobs    map[string]*analyzer.JobStatus
+	results map[string]*analyzer.AnalyzerResultValue
+	queries []*analyzer.ResultQuery
+}
+
+func (s *storeMock) GetJobStatus(ctx context.Context, jobID string) (*analyzer.JobStatus, error) {
+	if job, ok := s.jobs[jobID]; ok {
+		return job, nil
+	}
+	return nil, goredis.Nil
+}
+
+func (s *storeMock) GetAnalyzerResult(ctx context.Context, repoURL, revision string) (*analyzer.AnalyzerResultValue, error) {
+	if res, ok := s.results[repoURL+"|"+revision]; ok {
+		return res, nil
+	}
+	return nil, errors.New(analyzer.ErrJSONMissWithGoRedisClient)
+}
+
+func (s *storeMock) GetAnalyzerResultAt(ctx context.Context, repoURL, commitId string) (*analyzer.AnalyzerResultValue, error) {
+	return s.GetAnalyzerResult(ctx, repoURL, "@"+commitId)
+}
+
+func (s *storeMock) QueryResults(ctx context.Context, q *analyzer.ResultQuery) (*analyzer.ResultPage, error) {
+	s.queries = append(s.queries, q)
+	if q.Technology == "broken" {
+		return nil, errors.New("search failed")
+	}
+	return &analyzer.ResultPage{Total: 3, Offset: q.Offset, Results: []*analyzer.AnalyzerResultValue{s.results["github.com/org/repo|main"]}}, nil
+}
+
+func newTestServer(tokens []string) (*Server, *enqueuerMock, *storeMock) {
+
+	result := &analyzer.AnalyzerResultValue{
+		Repo:     &analyzer.Repository{Name: "repo", Url: "https://github.com/org/repo.git", Revision: "main", Username: "bot", Password: "secret"},
+		Revision: "main",
+		Commit:   "abc",
+		Results: []*analyzer.TechAndPath{
+			{Technology: "terraform", Path: "infra"},
+			{Technology: "terraform", Path: "infra/aws"},
+		},
+	}
+
+	producer := &enqueuerMock{}
+	store := &storeMock{
+		jobs: map[string]*analyzer.JobStatus{
+			"job-1": {ID: "job-1", RepoURL: "https://github.com/org/repo.git", Revision: "main", State: analyzer.JobSucceeded, Commit: "abc"},
+		},
+		results: map[string]*analyzer.AnalyzerResultValue{
+			"github.com/org/repo|main": result,
+			"github.com/org/repo|":     result,
+			"github.com/org/repo|@abc": result,
+		},
+	}
+
+	return NewServer(producer, store, tokens), producer, store
+}
+
+func serve(s *Server, method, target, token, body string) *httptest.ResponseRecorder {
+
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthentication(t *testing.T) {
+
+	s, _, _ := newTestServer([]string{"first", "second"})
+
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"third", http.StatusUnauthorized},
+		{"firs", http.StatusUnauthorized},
+		{"first", http.StatusOK},
+		{"second", http.StatusOK},
+	} {
+		w := serve(s, http.MethodGet, "/analyses/job-1", tc.token, "")
+		assert.Equal(t, tc.want, w.Code, tc.token)
+	}
+
+	w := serve(s, http.MethodGet, "/analyses/job-1", "", "")
+	assert.Equal(t, `Bearer realm="sweatShop-analyzer"`, w.Header().Get("WWW-Authenticate"))
+	assert.JSONEq(t, `{"error": "missing or invalid bearer token"}`, w.Body.String())
+
+	// the document is public
+	w = serve(s, http.MethodGet, "/openapi.yaml", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, OpenAPI, w.Body.Bytes())
+
+	// without tokens, no authentication is required
+	s, _, _ = newTestServer(nil)
+	assert.Equal(t, http.StatusOK, serve(s, http.MethodGet, "/analyses/job-1", "", "").Code)
+}
+
+func TestCreateAnalysis(t *testing.T) {
+
+	s, producer, _ := newTestServer([]string{"token"})
+
+	w := serve(s, http.MethodPost, "/analyses", "token", `{"url": "https://github.com/org/repo.git", "revision": "main", "username": ""}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/analyses/job-1", w.Header().Get("Location"))
+	assert.JSONEq(t, `{"job_id": "job-1"}`, w.Body.String())
+	assert.Equal(t, []map[string]interface{}{{"url": "https://github.com/org/repo.git", "revision": "main"}}, producer.requests)
+
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"url": "https://github.com/org/repo.git"`, "invalid analysis request: unexpected EOF"},
+		{`{"url": "https://github.com/org/repo.git", "insecure": true}`, "invalid analysis request: json: cannot unmarshal bool"},
+		{`{}`, "no values received"},
+		{`{"url": "https://github.com/org/repo.git"}`, "no revision received"},
+		{`{"url": "https://github.com/org/repo.git", "revision": "main", "insecure": "yes"}`, "invalid boolean received for insecure: yes"},
+		// the file system of the analyzer
+		{`{"url": "/", "revision": "main", "allow_plain_directory": "true"}`, "not below the roots of ANALYZER_LOCAL_ROOTS"},
+		{`{"url": "file:///etc", "revision": "main"}`, "not below the roots of ANALYZER_LOCAL_ROOTS"},
+	} {
+		w := serve(s, http.MethodPost, "/analyses", "token", tc.body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, tc.body)
+		assert.Contains(t, w.Body.String(), tc.want, tc.body)
+	}
+	assert.Len(t, producer.requests, 1)
+
+	producer.err = errors.New("could not enqueue job")
+	w = serve(s, http.MethodPost, "/analyses", "token", `{"url": "https://github.com/org/repo.git", "revision": "main"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = serve(s, http.MethodGet, "/analyses", "token", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+}
+
+func TestGetAnalysis(t *testing.T) {
+
+	s, _, _ := newTestServer(nil)
+
+	w := serve(s, http.MethodGet, "/analyses/job-1", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	job := &analyzer.JobStatus{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), job))
+	assert.Equal(t, analyzer.JobSucceeded, job.State)
+	assert.Equal(t, "abc", job.Commit)
+
+	w = serve(s, http.MethodGet, "/analyses/job-2", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": "job job-2 not found"}`, w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, serve(s, http.MethodGet, "/analyses/job-1/children", "", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(s, http.MethodDelete, "/analyses/job-1", "", "").Code)
+}
+
+func TestGetRepositoryResults(t *testing.T) {
+
+	s, _, _ := newTestServer(nil)
+
+	for _, target := range []string{
+		"/repositories/github.com/org/repo/results",
+		"/repositories/github.com/org/repo/results?revision=main",
+		"/repositories/github.com/org/repo/results?revision=dev&commit=abc",
+	} {
+		w := serve(s, http.MethodGet, target, "", "")
+		assert.Equal(t, http.StatusOK, w.Code, target)
+
+		result := &analyzer.AnalyzerResultValue{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+		assert.Equal(t, "abc", result.Commit)
+		assert.Len(t, result.Results, 2)
+		assert.Equal(t, "bot", result.Repo.Username)
+		assert.Empty(t, result.Repo.Password)
+	}
+
+	w := serve(s, http.MethodGet, "/repositories/github.com/org/repo/results?revision=dev", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": "no result of github.com/org/repo found"}`, w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, serve(s, http.MethodGet, "/repositories/github.com/org/repo", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(s, http.MethodGet, "/repositories/results", "", "").Code)
+}
+
+func TestListTechnologyRepositories(t *testing.T) {
+
+	s, _, store := newTestServer(nil)
+
+	w := serve(s, http.MethodGet, "/technologies/terraform/repositories?offset=2&limit=1", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"Technology": "terraform",
+		"Total": 3,
+		"Offset": 2,
+		"Repositories": [{"RepoID": "github.com/org/repo", "Url": "https://github.com/org/repo.git", "Revision": "main", "Paths": ["infra", "infra/aws"]}]
+	}`, w.Body.String())
+	assert.Equal(t, &analyzer.ResultQuery{Technology: "terraform", Offset: 2, Limit: 1}, store.queries[0])
+
+	serve(s, http.MethodGet, "/technologies/terraform/repositories", "", "")
+	assert.Equal(t, defaultLimit, store.queries[1].Limit)
+
+	for _, target := range []string{
+		"/technologies/terraform/repositories?offset=-1",
+		"/technologies/terraform/repositories?limit=ten",
+	} {
+		assert.Equal(t, http.StatusBadRequest, serve(s, http.MethodGet, target, "", "").Code, target)
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, serve(s, http.MethodGet, "/technologies/broken/repositories", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(s, http.MethodGet, "/technologies/terraform", "", "").Code)
+}
@@ -0,0 +1,323 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+// Package client enqueues analyses, waits for them and fetches their results,
+// for go programs talking to the analyzer through redis, or NATS for the
+// requests if the analyzer polls NATS JetStream.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nitishm/go-rejson/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+)
+
+// defaultPollInterval is the interval the job status is checked while
+// waiting, if no completion event arrives
+const defaultPollInterval = time.Second
+
+var (
+	// ErrNotFound is returned for unknown jobs and missing results
+	ErrNotFound = errors.New("not found")
+	// ErrJobFailed is returned by Analyze for failed jobs
+	ErrJobFailed = errors.New("job failed")
+)
+
+// Request is an analysis request
+type Request struct {
+	Name     string
+	Url      string
+	Revision string
+	Username string
+	Password string
+	// CredentialRef references the credential instead of the password, e.g.
+	// "redis:github", resolved by the analyzer
+	CredentialRef string
+	Insecure      bool
+	// ForceCompleteAnalysis ignores cached results, if true
+	ForceCompleteAnalysis *bool
+	// History backfills the technology history of the revision, if set
+	History *analyzer.HistoryOptions
+	// JobID is assigned on enqueue, if empty
+	JobID string
+}
+
+// Values returns the message values of the request
+func (r *Request) Values() map[string]interface{} {
+
+	values := make(map[string]interface{})
+	set := func(key, value string) {
+		if value != "" {
+			values[key] = value
+		}
+	}
+
+	set(FieldName, r.Name)
+	set(FieldURL, r.Url)
+	set(FieldRevision, r.Revision)
+	set(FieldUsername, r.Username)
+	set(FieldPassword, r.Password)
+	set(FieldCredentialRef, r.CredentialRef)
+	set(FieldJobID, r.JobID)
+
+	if r.Insecure {
+		values[FieldInsecure] = "true"
+	}
+	if r.ForceCompleteAnalysis != nil {
+		values[FieldForceCompleteAnalysis] = strconv.FormatBool(*r.ForceCompleteAnalysis)
+	}
+	if r.History != nil {
+		values[FieldHistory] = "true"
+		values[FieldHistoryFirstParent] = strconv.FormatBool(r.History.FirstParent)
+		set(FieldHistorySample, string(r.History.Sample))
+	}
+
+	return values
+}
+
+type enqueuer interface {
+	Enqueue(ctx context.Context, values map[string]interface{}) (string, error)
+	Close() error
+}
+
+type store interface {
+	GetJobStatus(ctx context.Context, jobID string) (*analyzer.JobStatus, error)
+	GetAnalyzerResult(ctx context.Context, repoURL, revision string) (*analyzer.AnalyzerResultValue, error)
+	GetAnalyzerResultAt(ctx context.Context, repoURL, commitId string) (*analyzer.AnalyzerResultValue, error)
+}
+
+// Client talks to the analyzer through its redis streams and documents
+type Client struct {
+	redis    goredis.UniversalClient
+	producer enqueuer
+	store    store
+	// PollInterval is the interval the job status is checked while waiting,
+	// if no completion event arrives
+	PollInterval time.Duration
+}
+
+// New returns a client of the analyzer using the redis client, e.g. a
+// *goredis.Client or a *goredis.ClusterClient
+func New(client goredis.UniversalClient) (*Client, error) {
+
+	rh := rejson.NewReJSONHandler()
+	rh.SetGoRedisClientWithContext(context.Background(), client)
+
+	r := &redisutil.Redis{Client: client, JSONHandler: rh}
+	p, err := stream.NewProducer(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(r, p), nil
+}
+
+// NewNATS returns a client publishing the requests to the NATS JetStream
+// subjects of the options, for analyzers polling NATS (JOB_SOURCE=nats). The
+// jobs and results are read from redis.
+func NewNATS(client goredis.UniversalClient, options stream.NATSOptions) (*Client, error) {
+
+	rh := rejson.NewReJSONHandler()
+	rh.SetGoRedisClientWithContext(context.Background(), client)
+
+	r := &redisutil.Redis{Client: client, JSONHandler: rh}
+	p, err := stream.NewNATSProducer(r, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(r, p), nil
+}
+
+func newClient(r *redisutil.Redis, p enqueuer) *Client {
+	return &Client{
+		redis:        r.Client,
+		producer:     p,
+		store:        analyzer.NewAnalyzerJSONHandlerWithClient(r.JSONHandler, r.Client),
+		PollInterval: defaultPollInterval,
+	}
+}
+
+// NewFromEnv returns a client of the redis server of the environment, see
+// redisutil.OptionsFromEnv, enqueuing into the job source of JOB_SOURCE
+func NewFromEnv() (*Client, error) {
+
+	r, err := redisutil.NewRedisWithClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := stream.ProducerFromEnv(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(r, p), nil
+}
+
+// Close closes the connection to NATS of a client publishing to NATS, the
+// redis client is closed by its owner
+func (c *Client) Close() error {
+	return c.producer.Close()
+}
+
+// Enqueue validates the request and adds it to the analyze stream. It returns
+// the job id.
+func (c *Client) Enqueue(ctx context.Context, req *Request) (string, error) {
+	return c.producer.Enqueue(ctx, req.Values())
+}
+
+// Job returns the status of a job
+func (c *Client) Job(ctx context.Context, jobID string) (*analyzer.JobStatus, error) {
+
+	job, err := c.store.GetJobStatus(ctx, jobID)
+	if err != nil {
+		return nil, notFound(err, "job "+jobID)
+	}
+
+	return job, nil
+}
+
+// Wait returns the status of the job once it succeeded or failed. It wakes up
+// on the completion events of the analyzed stream and checks the job status
+// every PollInterval, in case an event got lost.
+func (c *Client) Wait(ctx context.Context, jobID string) (*analyzer.JobStatus, error) {
+
+	// read the events after the job status, so none is missed in between
+	lastID, err := c.lastEventID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		job, err := c.Job(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+
+		if job.State.Done() {
+			return job, nil
+		}
+
+		lastID, err = c.waitForEvent(ctx, jobID, lastID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return job, fmt.Errorf("job %s is still %s: %w", jobID, job.State, ctx.Err())
+			}
+			return nil, err
+		}
+	}
+}
+
+// Analyze enqueues the request, waits for the job and returns the result of
+// the analyzed commit
+func (c *Client) Analyze(ctx context.Context, req *Request) (*analyzer.AnalyzerResultValue, error) {
+
+	jobID, err := c.Enqueue(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := c.Wait(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.State == analyzer.JobFailed {
+		return nil, fmt.Errorf("%w: %s: %s", ErrJobFailed, jobID, job.Error)
+	}
+
+	return c.ResultAt(ctx, req.Url, job.Commit)
+}
+
+// Result returns the latest result of the revision, or of the most recently
+// analyzed one if revision is empty
+func (c *Client) Result(ctx context.Context, repoURL, revision string) (*analyzer.AnalyzerResultValue, error) {
+
+	result, err := c.store.GetAnalyzerResult(ctx, repoURL, revision)
+	if err != nil {
+		return nil, notFound(err, "result of "+repoURL)
+	}
+
+	return result, nil
+}
+
+// ResultAt returns the result of an analyzed commit
+func (c *Client) ResultAt(ctx context.Context, repoURL, commitID string) (*analyzer.AnalyzerResultValue, error) {
+
+	result, err := c.store.GetAnalyzerResultAt(ctx, repoURL, commitID)
+	if err != nil {
+		return nil, notFound(err, "result of "+repoURL+" at "+commitID)
+	}
+
+	return result, nil
+}
+
+// lastEventID returns the id of the latest completion event
+func (c *Client) lastEventID(ctx context.Context) (string, error) {
+
+	msgs, err := c.redis.XRevRangeN(ctx, AnalyzedStream, "+", "-", 1).Result()
+	if err != nil {
+		return "", fmt.Errorf("could not read stream %s: %w", AnalyzedStream, err)
+	}
+
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+
+	return msgs[0].ID, nil
+}
+
+// waitForEvent blocks until the completion event of the job after lastID or
+// the poll interval is over. It returns the id of the last read event.
+func (c *Client) waitForEvent(ctx context.Context, jobID, lastID string) (string, error) {
+
+	deadline := time.Now().Add(c.PollInterval)
+	for {
+		// a block of 0 would wait forever
+		block := time.Until(deadline)
+		if block < time.Millisecond {
+			return lastID, nil
+		}
+
+		streams, err := c.redis.XRead(ctx, &goredis.XReadArgs{
+			Streams: []string{AnalyzedStream, lastID},
+			Count:   100,
+			Block:   block,
+		}).Result()
+		if errors.Is(err, goredis.Nil) {
+			return lastID, nil
+		}
+		if err != nil {
+			return lastID, fmt.Errorf("could not read stream %s: %w", AnalyzedStream, err)
+		}
+
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				lastID = msg.ID
+				if msg.Values[FieldJobID] == jobID {
+					return lastID, nil
+				}
+			}
+		}
+	}
+}
+
+// notFound wraps missing keys into ErrNotFound
+func notFound(err error, what string) error {
+
+	if err.Error() == analyzer.ErrJSONMissWithGoRedisClient {
+		return fmt.Errorf("%s: %w", what, ErrNotFound)
+	}
+
+	return err
+}
@@ -0,0 +1,221 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+type enqueuerMock struct {
+	requests []map[string]interface{}
+}
+
+func (e *enqueuerMock) Enqueue(ctx context.Context, values map[string]interface{}) (string, error) {
+	e.requests = append(e.requests, values)
+	return "job-1", nil
+}
+
+func (e *enqueuerMock) Close() error {
+	return nil
+}
+
+type storeMock struct {
+	mu   sync.Mutex
+	jobs map[string]*analyzer.JobStatus
+	// reads counts the status reads
+	reads  int
+	result *analyzer.AnalyzerResultValue
+}
+
+func (s *storeMock) setJob(job *analyzer.JobStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+}
+
+func (s *storeMock) GetJobStatus(ctx context.Context, jobID string) (*analyzer.JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads++
+
+	if job, ok := s.jobs[jobID]; ok {
+		copied := *job
+		return &copied, nil
+	}
+	return nil, goredis.Nil
+}
+
+func (s *storeMock) GetAnalyzerResult(ctx context.Context, repoURL, revision string) (*analyzer.AnalyzerResultValue, error) {
+	if revision == "main" {
+		return s.result, nil
+	}
+	return nil, errors.New(analyzer.ErrJSONMissWithGoRedisClient)
+}
+
+func (s *storeMock) GetAnalyzerResultAt(ctx context.Context, repoURL, commitId string) (*analyzer.AnalyzerResultValue, error) {
+	if commitId == s.result.Commit {
+		return s.result, nil
+	}
+	return nil, errors.New(analyzer.ErrJSONMissWithGoRedisClient)
+}
+
+func newTestClient(t *testing.T) (*Client, *storeMock, *goredis.Client) {
+
+	s := miniredis.RunT(t)
+	r := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+	store := &storeMock{
+		jobs:   map[string]*analyzer.JobStatus{"job-1": {ID: "job-1", State: analyzer.JobQueued}},
+		result: &analyzer.AnalyzerResultValue{Revision: "main", Commit: "abc"},
+	}
+
+	return &Client{redis: r, producer: &enqueuerMock{}, store: store, PollInterval: time.Hour}, store, r
+}
+
+// complete stores the final job status and publishes its completion event,
+// like the analyzer
+func complete(t *testing.T, store *storeMock, r *goredis.Client, job *analyzer.JobStatus) {
+
+	store.setJob(job)
+	assert.NoError(t, r.XAdd(context.Background(), &goredis.XAddArgs{
+		Stream: AnalyzedStream,
+		Values: map[string]interface{}{FieldJobID: job.ID, FieldState: string(job.State), FieldCommit: job.Commit},
+	}).Err())
+}
+
+func TestRequestValues(t *testing.T) {
+
+	force := false
+	assert.Equal(t, map[string]interface{}{
+		"url":                     "https://github.com/org/repo.git",
+		"revision":                "main",
+		"password":                "secret",
+		"insecure":                "true",
+		"force_complete_analysis": "false",
+		"history":                 "true",
+		"history_first_parent":    "true",
+		"history_sample":          "week",
+	}, (&Request{
+		Url:                   "https://github.com/org/repo.git",
+		Revision:              "main",
+		Password:              "secret",
+		Insecure:              true,
+		ForceCompleteAnalysis: &force,
+		History:               &analyzer.HistoryOptions{FirstParent: true, Sample: analyzer.SampleWeek},
+	}).Values())
+
+	assert.Equal(t, map[string]interface{}{
+		"url":            "https://github.com/org/repo.git",
+		"revision":       "main",
+		"username":       "bot",
+		"credential_ref": "redis:github",
+	}, (&Request{Url: "https://github.com/org/repo.git", Revision: "main", Username: "bot", CredentialRef: "redis:github"}).Values())
+}
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, "analyzerresult|github.com/org/repo|main", ResultKey("git@github.com:org/repo.git", "main"))
+	assert.Equal(t, "analyzersnapshot|github.com/org/repo|abc", SnapshotKey("https://github.com/org/repo", "abc"))
+	assert.Equal(t, "analyzerjob|job-1", JobKey("job-1"))
+}
+
+func TestWait(t *testing.T) {
+
+	c, store, r := newTestClient(t)
+	ctx := context.Background()
+
+	// an event published before waiting is not taken for the job
+	complete(t, store, r, &analyzer.JobStatus{ID: "job-0", State: analyzer.JobSucceeded})
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		complete(t, store, r, &analyzer.JobStatus{ID: "job-2", State: analyzer.JobSucceeded})
+		time.Sleep(20 * time.Millisecond)
+		complete(t, store, r, &analyzer.JobStatus{ID: "job-1", State: analyzer.JobSucceeded, Commit: "abc"})
+	}()
+
+	// woken up by the event, long before the poll interval
+	job, err := c.Wait(ctx, "job-1")
+	assert.NoError(t, err)
+	assert.Equal(t, analyzer.JobSucceeded, job.State)
+	assert.Equal(t, "abc", job.Commit)
+	assert.Equal(t, 2, store.reads)
+
+	// a done job returns at once
+	job, err = c.Wait(ctx, "job-1")
+	assert.NoError(t, err)
+	assert.Equal(t, analyzer.JobSucceeded, job.State)
+
+	_, err = c.Wait(ctx, "job-3")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestWaitPolls(t *testing.T) {
+
+	c, store, _ := newTestClient(t)
+	c.PollInterval = 10 * time.Millisecond
+
+	// the status changes without an event
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		store.setJob(&analyzer.JobStatus{ID: "job-1", State: analyzer.JobFailed, Error: "clone failed"})
+	}()
+
+	job, err := c.Wait(context.Background(), "job-1")
+	assert.NoError(t, err)
+	assert.Equal(t, analyzer.JobFailed, job.State)
+
+	// until the context expires
+	store.setJob(&analyzer.JobStatus{ID: "job-1", State: analyzer.JobRunning})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	job, err = c.Wait(ctx, "job-1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, analyzer.JobRunning, job.State)
+}
+
+func TestAnalyze(t *testing.T) {
+
+	c, store, r := newTestClient(t)
+	ctx := context.Background()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		complete(t, store, r, &analyzer.JobStatus{ID: "job-1", State: analyzer.JobSucceeded, Commit: "abc"})
+	}()
+
+	result, err := c.Analyze(ctx, &Request{Url: "https://github.com/org/repo.git", Revision: "main"})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", result.Commit)
+	assert.Equal(t, []map[string]interface{}{{"url": "https://github.com/org/repo.git", "revision": "main"}}, c.producer.(*enqueuerMock).requests)
+
+	store.setJob(&analyzer.JobStatus{ID: "job-1", State: analyzer.JobFailed, Error: "clone failed"})
+	_, err = c.Analyze(ctx, &Request{Url: "https://github.com/org/repo.git", Revision: "main"})
+	assert.ErrorIs(t, err, ErrJobFailed)
+	assert.ErrorContains(t, err, "clone failed")
+}
+
+func TestResult(t *testing.T) {
+
+	c, _, _ := newTestClient(t)
+	ctx := context.Background()
+
+	result, err := c.Result(ctx, "https://github.com/org/repo.git", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", result.Commit)
+
+	_, err = c.Result(ctx, "https://github.com/org/repo.git", "dev")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = c.ResultAt(ctx, "https://github.com/org/repo.git", "def")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package client
+
+import (
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+)
+
+// streams of the analyzer
+const (
+	// AnalyzeStream receives the analysis requests
+	AnalyzeStream = schema.AnalyzeStream
+	// AnalyzedStream receives a completion event per analysis request
+	AnalyzedStream = schema.AnalyzedStream
+	// ControlStream receives administrative commands for the analyzer
+	ControlStream = schema.ControlStream
+)
+
+// fields of an analysis request message
+const (
+	FieldName                  = schema.FieldName
+	FieldURL                   = schema.FieldURL
+	FieldRevision              = schema.FieldRevision
+	FieldUsername              = schema.FieldUsername
+	FieldPassword              = schema.FieldPassword
+	FieldCredentialRef         = schema.FieldCredentialRef
+	FieldInsecure              = schema.FieldInsecure
+	FieldForceCompleteAnalysis = schema.FieldForceCompleteAnalysis
+	FieldJobID                 = schema.FieldJobID
+	FieldHistory               = schema.FieldHistory
+	FieldHistoryFirstParent    = schema.FieldHistoryFirstParent
+	FieldHistorySample         = schema.FieldHistorySample
+	FieldJobType               = schema.FieldJobType
+)
+
+// additional fields of a completion event message
+const (
+	FieldState  = schema.FieldState
+	FieldCommit = schema.FieldCommit
+	FieldError  = schema.FieldError
+)
+
+// ResultKey is the key of the latest result document of a revision
+func ResultKey(repoURL, revision string) string {
+	return schema.ResultKey(analyzer.RepositoryID(repoURL), revision)
+}
+
+// SnapshotKey is the key of the result document of a commit
+func SnapshotKey(repoURL, commitID string) string {
+	return schema.SnapshotKey(analyzer.RepositoryID(repoURL), commitID)
+}
+
+// JobKey is the key of the status document of a job
+func JobKey(jobID string) string {
+	return schema.JobKey(jobID)
+}
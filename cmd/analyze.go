@@ -0,0 +1,265 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stuttgart-things/redisqueue"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+)
+
+const analyzeUsage = "analyze (<url-or-local-path> [--revision <revision>] [--allow-plain-dir] [--history [--first-parent] [--sample commit|day|week]] | --file <repos.yaml|repos.csv> [--concurrency <n>] [--retries <n>] [--retry-delay <duration>]) [--patterns <file>] [--output json|yaml|table] [--cache-file <path>]"
+
+// runAnalyze runs a one-shot analysis without redis and prints the result
+func runAnalyze(ctx context.Context, args []string) int {
+	return analyze(ctx, args, os.Stdout, os.Stderr)
+}
+
+func analyze(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: sweatShop-analyzer %s\n", analyzeUsage)
+		fs.PrintDefaults()
+	}
+
+	revision := fs.String("revision", "", "branch to analyze (default: the default branch)")
+	patterns := fs.String("patterns", "", "pattern file to use instead of the repository's or the default one")
+	output := fs.String("output", outputTable, "output format: json, yaml or table")
+	name := fs.String("name", "", "name of the repository")
+	allowPlainDir := fs.Bool("allow-plain-dir", false, "analyze a local directory that is no git repository")
+	history := fs.Bool("history", false, "backfill when each technology and path was introduced and removed from the git log")
+	firstParent := fs.Bool("first-parent", false, "only follow the first parent of merge commits in the history")
+	sample := fs.String("sample", "commit", "walk every commit of the history, or only the last one of each day or week")
+	cacheFile := fs.String("cache-file", "", "cache the matching files in this file, so later analyses of the revision only analyze the changed files")
+	file := fs.String("file", "", "yaml or csv file with one repository per entry/row, keys as in the stream message")
+	concurrency := fs.Int("concurrency", 4, "repositories of the file analyzed at the same time")
+	retries := fs.Int("retries", 2, "times a failed analysis of the file is retried")
+	retryDelay := fs.Duration("retry-delay", 5*time.Second, "time a failed analysis of the file is retried after")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+
+	if (*file == "") != (len(positional) == 1) || len(positional) > 1 || *concurrency < 1 || *retries < 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	if err := validOutput(*output); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+
+	sampling, err := analyzer.ParseHistorySampling(*sample)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+
+	if *file != "" {
+		pipeline := &analyzePipeline{
+			patternFile: *patterns,
+			cacheFile:   *cacheFile,
+			concurrency: *concurrency,
+			retries:     *retries,
+			retryDelay:  *retryDelay,
+		}
+		return pipeline.run(ctx, *file, *output, stdout, stderr)
+	}
+
+	repo := &analyzer.Repository{
+		Name:                *name,
+		Url:                 positional[0],
+		Revision:            *revision,
+		PatternFile:         *patterns,
+		AllowPlainDirectory: *allowPlainDir,
+	}
+	if *history {
+		repo.History = &analyzer.HistoryOptions{FirstParent: *firstParent, Sample: sampling}
+	}
+
+	if err := analyzer.InstallProxy(stream.ProxyConfigFromEnv()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+
+	cache, closeCache, err := openAnalyzeCache(*cacheFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+	defer closeCache()
+
+	result, _, err := repo.Analyze(ctx, cache)
+	if err != nil {
+		fmt.Fprintf(stderr, "analysis of %s failed: %v\n", repo.Url, err)
+		return ExitFailure
+	}
+
+	sort.Slice(result.Results, func(i, j int) bool {
+		if result.Results[i].Technology != result.Results[j].Technology {
+			return result.Results[i].Technology < result.Results[j].Technology
+		}
+		return result.Results[i].Path < result.Results[j].Path
+	})
+
+	if err := printResult(stdout, *output, result); err != nil {
+		fmt.Fprintf(stderr, "could not print result: %v\n", err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+// openAnalyzeCache opens the bolt cache of the file, or no cache
+func openAnalyzeCache(cacheFile string) (analyzer.AnalyzerCacheInterface, func() error, error) {
+
+	config := analyzer.CacheConfig{Backend: analyzer.CacheBackendNone}
+	if cacheFile != "" {
+		config = analyzer.CacheConfig{Backend: analyzer.CacheBackendBolt, Path: cacheFile}
+	}
+
+	return analyzer.OpenAnalyzerCache(nil, config)
+}
+
+// analyzePipeline analyzes the repositories of a request file without redis.
+// The requests are handled by a stream.MemorySource like the messages of the
+// poller: a failed analysis is retried after the retry delay, until it failed
+// retries times more.
+type analyzePipeline struct {
+	patternFile string
+	cacheFile   string
+	concurrency int
+	retries     int
+	retryDelay  time.Duration
+}
+
+func (p *analyzePipeline) run(ctx context.Context, file, output string, stdout, stderr io.Writer) int {
+
+	requests, err := readRequestFile(file)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+
+	for i, values := range requests {
+		if err := prepareRequest(values, stream.ValidateLocalValues); err != nil {
+			fmt.Fprintf(stderr, "invalid request %d of %s: %v\n", i+1, file, err)
+			return ExitUsage
+		}
+		if values[stream.FieldJobType] == stream.JobTypeDiscovery {
+			fmt.Fprintf(stderr, "invalid request %d of %s: discovery requests are only handled by the poller\n", i+1, file)
+			return ExitUsage
+		}
+	}
+
+	if err := analyzer.InstallProxy(stream.ProxyConfigFromEnv()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+
+	resolver, err := credentials.FromEnv(nil)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+
+	cache, closeCache, err := openAnalyzeCache(p.cacheFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+	defer closeCache()
+
+	results := make([]*analyzer.AnalyzerResultValue, len(requests))
+	errs := make([]error, len(requests))
+	attempts := make([]int, len(requests))
+	var mu sync.Mutex
+
+	// all requests are added before the source runs, so that the ids are
+	// known to the handler
+	source := stream.NewMemorySource(len(requests))
+	source.Concurrency = p.concurrency
+	source.RetryDelay = p.retryDelay
+	index := make(map[string]int, len(requests))
+	for i, values := range requests {
+		index[source.Add(stream.AnalyzeStream, values)] = i
+	}
+
+	source.Register(stream.AnalyzeStream, func(msg *redisqueue.Message) error {
+
+		i := index[msg.ID]
+		repo := stream.RepositoryFromValues(msg.Values)
+		if p.patternFile != "" {
+			repo.PatternFile = p.patternFile
+		}
+
+		err := repo.ResolveCredentials(ctx, resolver)
+		if err == nil {
+			results[i], _, err = repo.Analyze(ctx, cache)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		errs[i] = err
+		if err != nil && attempts[i] < p.retries && ctx.Err() == nil {
+			attempts[i]++
+			return err
+		}
+		return nil
+	})
+
+	go source.Run()
+	defer source.Shutdown()
+
+	// requests not yet analyzed are dropped when the command is interrupted
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			source.Shutdown()
+		case <-done:
+		}
+	}()
+	source.Wait()
+	close(done)
+
+	code := ExitOK
+	analyzed := make([]*analyzer.AnalyzerResultValue, 0, len(results))
+	for i, result := range results {
+		url, _ := requests[i][stream.FieldURL].(string)
+		switch {
+		case errs[i] != nil:
+			fmt.Fprintf(stderr, "analysis of %s failed: %v\n", url, errs[i])
+			code = ExitFailure
+		case result == nil:
+			fmt.Fprintf(stderr, "analysis of %s was not run: %v\n", url, ctx.Err())
+			code = ExitFailure
+		default:
+			analyzed = append(analyzed, result)
+		}
+	}
+
+	if err := printResultList(stdout, output, analyzed); err != nil {
+		fmt.Fprintf(stderr, "could not print results: %v\n", err)
+		return ExitFailure
+	}
+
+	return code
+}
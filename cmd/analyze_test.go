@@ -0,0 +1,177 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+// initTestRepository creates a git repository with the given files committed
+func initTestRepository(t *testing.T, files ...string) string {
+
+	dir := t.TempDir()
+
+	r, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+
+	w, err := r.Worktree()
+	assert.NoError(t, err)
+
+	for _, f := range files {
+		path := filepath.Join(dir, f)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(f), 0644))
+		_, err = w.Add(f)
+		assert.NoError(t, err)
+	}
+
+	_, err = w.Commit("initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "sweatShop", Email: "sweatshop@example.com", When: time.Now()},
+	})
+	assert.NoError(t, err)
+
+	return dir
+}
+
+func TestAnalyze(t *testing.T) {
+
+	dir := initTestRepository(t, "go.mod", "main.go", "Dockerfile", "roles/web/meta/main.yml")
+
+	var stdout, stderr bytes.Buffer
+	code := analyze(context.Background(), []string{dir, "--output", "json", "--patterns", "../sweatShop-analyzer.yaml"}, &stdout, &stderr)
+	assert.Equal(t, ExitOK, code, stderr.String())
+
+	result := &analyzer.AnalyzerResultValue{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), result))
+	assert.Equal(t, dir, result.Repo.Url)
+	assert.Len(t, result.Commit, 40)
+	assert.Equal(t, []*analyzer.TechAndPath{
+		{Technology: "docker", Path: "."},
+		{Technology: "golang", Path: "."},
+	}, result.Results)
+}
+
+func TestAnalyzeCacheFile(t *testing.T) {
+
+	dir := initTestRepository(t, "go.mod")
+	cacheFile := filepath.Join(t.TempDir(), "cache.db")
+
+	for i := 0; i < 2; i++ {
+		var stdout, stderr bytes.Buffer
+		code := analyze(context.Background(), []string{dir, "--output", "json", "--patterns", "../sweatShop-analyzer.yaml", "--cache-file", cacheFile}, &stdout, &stderr)
+		assert.Equal(t, ExitOK, code, stderr.String())
+	}
+
+	// the matching files outlive the command
+	c, err := analyzer.OpenBoltAnalyzerCache(cacheFile, 0)
+	assert.NoError(t, err)
+	defer c.Close()
+	value, err := c.GetMatchingFiles(context.Background(), dir, "master")
+	assert.NoError(t, err)
+	assert.Equal(t, []*analyzer.TechAndPath{{Technology: "golang", Path: "."}}, value.Results)
+}
+
+func TestAnalyzeHistory(t *testing.T) {
+
+	dir := initTestRepository(t, "go.mod", "Dockerfile")
+
+	var stdout, stderr bytes.Buffer
+	code := analyze(context.Background(), []string{dir, "--output", "json", "--patterns", "../sweatShop-analyzer.yaml", "--history", "--sample", "day"}, &stdout, &stderr)
+	assert.Equal(t, ExitOK, code, stderr.String())
+
+	result := &analyzer.AnalyzerResultValue{}
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), result))
+	assert.NotNil(t, result.History)
+	assert.Equal(t, analyzer.SampleDay, result.History.Sample)
+	assert.Equal(t, 1, result.History.Commits)
+	assert.Len(t, result.History.Entries, 2)
+	for _, e := range result.History.Entries {
+		assert.Equal(t, result.Commit, e.Introduced.Commit)
+		assert.Nil(t, e.Removed)
+	}
+
+	stdout.Reset()
+	code = analyze(context.Background(), []string{dir, "--patterns", "../sweatShop-analyzer.yaml", "--history"}, &stdout, &stderr)
+	assert.Equal(t, ExitOK, code, stderr.String())
+	assert.Contains(t, stdout.String(), "HISTORY:    1 commits")
+	assert.Contains(t, stdout.String(), "sweatShop <sweatshop@example.com>")
+
+	// unknown sampling
+	assert.Equal(t, ExitUsage, analyze(context.Background(), []string{dir, "--history", "--sample", "month"}, &stdout, &stderr))
+}
+
+func TestAnalyzeFailures(t *testing.T) {
+
+	var stdout, stderr bytes.Buffer
+
+	// missing repository
+	assert.Equal(t, ExitUsage, analyze(context.Background(), []string{}, &stdout, &stderr))
+
+	// unknown output format
+	assert.Equal(t, ExitUsage, analyze(context.Background(), []string{"/tmp", "--output", "xml"}, &stdout, &stderr))
+
+	// repository does not exist
+	assert.Equal(t, ExitFailure, analyze(context.Background(), []string{filepath.Join(t.TempDir(), "missing")}, &stdout, &stderr))
+}
+
+func TestAnalyzeFile(t *testing.T) {
+
+	golang := initTestRepository(t, "go.mod")
+	docker := initTestRepository(t, "Dockerfile")
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	file := filepath.Join(t.TempDir(), "repos.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(fmt.Sprintf("- {url: %s, revision: master}\n- {url: %s, revision: master}\n- {url: %s, revision: master}\n", golang, missing, docker)), 0644))
+
+	var stdout, stderr bytes.Buffer
+	code := analyze(context.Background(), []string{"--file", file, "--output", "json", "--patterns", "../sweatShop-analyzer.yaml", "--retries", "1", "--retry-delay", "10ms"}, &stdout, &stderr)
+	assert.Equal(t, ExitFailure, code)
+	assert.Contains(t, stderr.String(), "analysis of "+missing+" failed")
+
+	// the analyzed repositories are printed
+	results := make([]*analyzer.AnalyzerResultValue, 0)
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &results))
+	if assert.Len(t, results, 2) {
+		technologies := map[string]string{}
+		for _, r := range results {
+			if assert.Len(t, r.Results, 1) {
+				technologies[r.Repo.Url] = r.Results[0].Technology
+			}
+		}
+		assert.Equal(t, map[string]string{golang: "golang", docker: "docker"}, technologies)
+	}
+
+	// a url and a file, discovery requests
+	assert.Equal(t, ExitUsage, analyze(context.Background(), []string{golang, "--file", file}, &stdout, &stderr))
+	assert.NoError(t, os.WriteFile(file, []byte("- job_type: discovery\n  provider: github\n  owner: org\n"), 0644))
+	assert.Equal(t, ExitUsage, analyze(context.Background(), []string{"--file", file}, &stdout, &stderr))
+}
+
+func Test_parseArgs(t *testing.T) {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	revision := fs.String("revision", "", "")
+	output := fs.String("output", "", "")
+
+	positional, err := parseArgs(fs, []string{"--output", "json", "my-repo", "--revision", "main", "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"my-repo", "other"}, positional)
+	assert.Equal(t, "main", *revision)
+	assert.Equal(t, "json", *output)
+}
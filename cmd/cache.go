@@ -0,0 +1,171 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+)
+
+const cacheUsage = "cache list [--url <glob>] | invalidate <url> [--revision <revision>] [--commit <commit>] | purge <url-glob> | stats [--output json|yaml|table]"
+
+// runCache administrates the cached analysis results
+func runCache(ctx context.Context, args []string) int {
+
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "usage: sweatShop-analyzer %s\n", cacheUsage)
+		return ExitUsage
+	}
+
+	if len(args) == 0 {
+		return usage()
+	}
+
+	fs := flag.NewFlagSet("cache "+args[0], flag.ContinueOnError)
+	output := fs.String("output", outputTable, "output format: json, yaml or table")
+
+	var urlPattern, revision, commit *string
+	var expectedArgs int
+
+	switch args[0] {
+	case "list":
+		urlPattern = fs.String("url", "*", "only list urls matching the glob pattern")
+	case "invalidate":
+		revision = fs.String("revision", "", "only invalidate the results of this revision (default: all revisions)")
+		commit = fs.String("commit", "", "only invalidate the results of this commit")
+		expectedArgs = 1
+	case "purge":
+		expectedArgs = 1
+	case "stats":
+	default:
+		return usage()
+	}
+
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != expectedArgs {
+		return usage()
+	}
+	if err := validOutput(*output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+
+	r, err := redisutil.NewRedisWithClientFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+	ac := analyzer.NewAnalyzerCache(r.Client, time.Hour)
+
+	switch args[0] {
+	case "list":
+		var entries []*analyzer.CacheEntry
+		entries, err = ac.ListEntries(ctx, *urlPattern)
+		if err == nil {
+			err = printCacheEntries(os.Stdout, *output, entries, time.Now())
+		}
+
+	case "invalidate":
+		var removed bool
+		removed, err = ac.Invalidate(ctx, positional[0], *revision, *commit)
+		if err == nil {
+			err = printStructuredOrLine(os.Stdout, *output, map[string]interface{}{"invalidated": removed},
+				fmt.Sprintf("invalidated: %t", removed))
+		}
+
+	case "purge":
+		var purged int
+		purged, err = ac.Purge(ctx, positional[0])
+		if err == nil {
+			err = printStructuredOrLine(os.Stdout, *output, map[string]interface{}{"purged": purged},
+				fmt.Sprintf("purged: %d", purged))
+		}
+
+	case "stats":
+		var stats *analyzer.CacheStats
+		stats, err = ac.Stats(ctx)
+		if err == nil {
+			err = printCacheStats(os.Stdout, *output, stats, time.Now())
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache %s failed: %v\n", args[0], err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+func printStructuredOrLine(w io.Writer, format string, v interface{}, line string) error {
+	if format != outputTable {
+		return printStructured(w, format, v)
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+func printCacheEntries(w io.Writer, format string, entries []*analyzer.CacheEntry, now time.Time) error {
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].RepoID != entries[j].RepoID {
+			return entries[i].RepoID < entries[j].RepoID
+		}
+		return entries[i].Revision < entries[j].Revision
+	})
+
+	if format != outputTable {
+		return printStructured(w, format, entries)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tREVISION\tCOMMIT\tRESULTS\tAGE\tTTL")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", e.RepoID, e.Revision, e.CommitID, e.Results, formatAge(e.CachedAt, now), formatTTL(e.TTL))
+	}
+
+	return tw.Flush()
+}
+
+func printCacheStats(w io.Writer, format string, stats *analyzer.CacheStats, now time.Time) error {
+
+	if format != outputTable {
+		return printStructured(w, format, stats)
+	}
+
+	fmt.Fprintf(w, "ENTRIES: %d\n", stats.Entries)
+	fmt.Fprintf(w, "RESULTS: %d\n", stats.Results)
+	fmt.Fprintf(w, "OLDEST:  %s\n", formatAge(stats.Oldest, now))
+	fmt.Fprintf(w, "NEWEST:  %s\n", formatAge(stats.Newest, now))
+	_, err := fmt.Fprintf(w, "MIN TTL: %s\n", formatTTL(stats.MinTTL))
+
+	return err
+}
+
+func formatAge(t, now time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return now.Sub(t).Truncate(time.Second).String()
+}
+
+func formatTTL(ttl time.Duration) string {
+	if ttl < 0 {
+		return "none"
+	}
+	return ttl.Truncate(time.Second).String()
+}
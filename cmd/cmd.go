@@ -0,0 +1,102 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	"github.com/stuttgart-things/sweatShop-analyzer/utils/tracing"
+)
+
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) int
+}
+
+var (
+	commands = map[string]command{
+		"analyze":     {analyzeUsage, runAnalyze},
+		"enqueue":     {enqueueUsage, runEnqueue},
+		"results":     {resultsUsage, runResults},
+		"cache":       {cacheUsage, runCache},
+		"migrate":     {migrateUsage, runMigrate},
+		"inventory":   {inventoryUsage, runInventory},
+		"schedule":    {scheduleUsage, runSchedule},
+		"serve":       {serveUsage, runServe},
+		"credentials": {credentialsUsage, runCredentials},
+	}
+	commandOrder = []string{"analyze", "enqueue", "results", "inventory", "schedule", "serve", "cache", "credentials", "migrate"}
+)
+
+// Run executes the subcommand named by args[0] and returns its exit code
+func Run(args []string) int {
+
+	if len(args) == 0 {
+		printUsage()
+		return ExitUsage
+	}
+
+	c, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printUsage()
+		return ExitUsage
+	}
+
+	// keep stdout for the command output
+	analyzer.SetLogOutput(os.Stderr)
+	credentials.SetLogOutput(os.Stderr)
+
+	ctx := context.Background()
+
+	shutdown, err := tracing.InitTracerProvider(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not init tracing: %v\n", err)
+	} else {
+		defer shutdown(ctx)
+	}
+
+	return c.run(ctx, args[1:])
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: sweatShop-analyzer [command]")
+	fmt.Fprintln(os.Stderr, "\nwithout a command, the analyzer polls the redis streams\n\ncommands:")
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+// parseArgs parses the flags of fs, which may appear before, between or after
+// the positional arguments, and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+
+	positional := make([]string, 0)
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+)
+
+const credentialsUsage = "credentials set <name> --password-env <variable> | --ssh-key-file <path> [--username <username>] [--hosts <host,...>] | list [--output json|yaml|table] | remove <name>"
+
+// runCredentials administrates the encrypted credentials of the redis store,
+// referenced as redis:<name>
+func runCredentials(ctx context.Context, args []string) int {
+
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "usage: sweatShop-analyzer %s\n", credentialsUsage)
+		return ExitUsage
+	}
+
+	if len(args) == 0 {
+		return usage()
+	}
+
+	fs := flag.NewFlagSet("credentials "+args[0], flag.ContinueOnError)
+	output := fs.String("output", outputTable, "output format: json, yaml or table")
+
+	var username, passwordEnv, sshKeyFile, hosts *string
+	var expectedArgs int
+
+	switch args[0] {
+	case "set":
+		username = fs.String("username", "", "username of the credential")
+		passwordEnv = fs.String("password-env", "", "environment variable holding the password or token")
+		sshKeyFile = fs.String("ssh-key-file", "", "file holding the private ssh key, e.g. a deploy key")
+		hosts = fs.String("hosts", "", "comma separated hosts the credential is sent to, e.g. github.com,*.example.com")
+		expectedArgs = 1
+	case "remove":
+		expectedArgs = 1
+	case "list":
+	default:
+		return usage()
+	}
+
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != expectedArgs {
+		return usage()
+	}
+	if err := validOutput(*output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+
+	var credential *credentials.Credential
+	if args[0] == "set" {
+		if *passwordEnv == "" && *sshKeyFile == "" {
+			return usage()
+		}
+		credential = &credentials.Credential{Username: *username}
+		for _, host := range strings.Split(*hosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				credential.Hosts = append(credential.Hosts, host)
+			}
+		}
+		if *passwordEnv != "" {
+			password, ok := os.LookupEnv(*passwordEnv)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "environment variable %s is not set\n", *passwordEnv)
+				return ExitUsage
+			}
+			credential.Password = password
+		}
+		if *sshKeyFile != "" {
+			key, err := os.ReadFile(*sshKeyFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not read ssh key file: %v\n", err)
+				return ExitUsage
+			}
+			credential.SSHKey = string(key)
+		}
+	}
+
+	r, err := redisutil.NewRedisWithClientFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	store, err := credentials.StoreFromEnv(r.Client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	switch args[0] {
+	case "set":
+		err = store.Set(ctx, positional[0], credential)
+		if err == nil {
+			err = printStructuredOrLine(os.Stdout, *output, map[string]interface{}{"ref": credentials.ProviderRedis + ":" + positional[0]},
+				fmt.Sprintf("ref: %s:%s", credentials.ProviderRedis, positional[0]))
+		}
+
+	case "list":
+		var names []string
+		names, err = store.Names(ctx)
+		if err == nil {
+			err = printStructuredOrLine(os.Stdout, *output, names, strings.Join(names, "\n"))
+		}
+
+	case "remove":
+		var removed bool
+		removed, err = store.Remove(ctx, positional[0])
+		if err == nil {
+			err = printStructuredOrLine(os.Stdout, *output, map[string]interface{}{"removed": removed},
+				fmt.Sprintf("removed: %t", removed))
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credentials %s failed: %v\n", args[0], err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
@@ -0,0 +1,301 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const enqueueUsage = "enqueue (--url <url> --revision <revision> | --file <repos.yaml|repos.csv>) [--history [--first-parent] [--sample commit|day|week]] [--wait] [--output json|yaml|table]"
+
+// passwordEnvField references an environment variable holding the password,
+// so files and command lines don't need to carry it
+const passwordEnvField = "password_env"
+
+// tokenEnvField references an environment variable holding the forge api
+// token of a discovery request
+const tokenEnvField = "token_env"
+
+type enqueuedJob struct {
+	Job    *analyzer.JobStatus
+	Result *analyzer.AnalyzerResultValue `json:",omitempty" yaml:",omitempty"`
+}
+
+// runEnqueue adds analysis requests to the analyze stream
+func runEnqueue(ctx context.Context, args []string) int {
+
+	fs := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sweatShop-analyzer %s\n", enqueueUsage)
+		fs.PrintDefaults()
+	}
+
+	repoURL := fs.String("url", "", "url of the repository")
+	revision := fs.String("revision", "", "revision of the repository")
+	name := fs.String("name", "", "name of the repository")
+	username := fs.String("username", "", "username for the repository")
+	passwordEnv := fs.String("password-env", "", "environment variable holding the password for the repository, sent in cleartext")
+	credentialRef := fs.String("credential-ref", "", "credential of the repository, resolved by the analyzer: env:<variable>, file:<name> or redis:<name>")
+	insecure := fs.Bool("insecure", false, "skip tls verification")
+	force := fs.Bool("force-complete-analysis", false, "ignore cached results")
+	history := fs.Bool("history", false, "backfill the technology history from the git log")
+	firstParent := fs.Bool("first-parent", false, "only follow the first parent of merge commits in the history")
+	sample := fs.String("sample", "commit", "walk every commit of the history, or only the last one of each day or week")
+	file := fs.String("file", "", "yaml or csv file with one repository per entry/row, keys as in the stream message")
+	wait := fs.Bool("wait", false, "wait for the jobs to complete and print their results")
+	timeout := fs.Duration("timeout", 10*time.Minute, "maximum time to wait")
+	output := fs.String("output", outputTable, "output format: json, yaml or table")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+
+	if len(positional) != 0 || (*file == "") == (*repoURL == "") {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	if err := validOutput(*output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+
+	var requests []map[string]interface{}
+	if *file != "" {
+		requests, err = readRequestFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitUsage
+		}
+	} else {
+		requests = []map[string]interface{}{{
+			stream.FieldURL:                   *repoURL,
+			stream.FieldRevision:              *revision,
+			stream.FieldName:                  *name,
+			stream.FieldUsername:              *username,
+			passwordEnvField:                  *passwordEnv,
+			stream.FieldCredentialRef:         *credentialRef,
+			stream.FieldInsecure:              strconv.FormatBool(*insecure),
+			stream.FieldForceCompleteAnalysis: strconv.FormatBool(*force),
+			stream.FieldHistory:               strconv.FormatBool(*history),
+			stream.FieldHistoryFirstParent:    strconv.FormatBool(*firstParent),
+			stream.FieldHistorySample:         *sample,
+		}}
+	}
+
+	// validate all requests, before anything is enqueued
+	for i, values := range requests {
+		if err := prepareRequest(values, stream.ValidateValues); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid request %d: %v\n", i+1, err)
+			return ExitUsage
+		}
+	}
+
+	r, err := redisutil.NewRedisWithClientFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	p, err := stream.ProducerFromEnv(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+	defer p.Close()
+
+	jobs := make([]*enqueuedJob, 0, len(requests))
+	for _, values := range requests {
+		id, err := p.Enqueue(ctx, values)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitFailure
+		}
+
+		job := newJobStatus(id, values)
+		jobs = append(jobs, &enqueuedJob{Job: job})
+	}
+
+	code := ExitOK
+	if *wait {
+		waitCtx, cancel := context.WithTimeout(ctx, *timeout)
+		defer cancel()
+
+		for _, j := range jobs {
+			status, err := p.WaitForJob(waitCtx, j.Job.ID, time.Second)
+			if status != nil {
+				j.Job = status
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = ExitFailure
+				continue
+			}
+
+			if status.State != analyzer.JobSucceeded {
+				code = ExitFailure
+				continue
+			}
+
+			// discovery jobs have no result, but the ids of their analysis jobs
+			if status.Type == stream.JobTypeDiscovery {
+				continue
+			}
+
+			j.Result, err = p.GetResult(ctx, status.RepoURL, status.Revision)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not get result of job %s: %v\n", status.ID, err)
+				code = ExitFailure
+			}
+		}
+	}
+
+	if err := printJobs(os.Stdout, *output, jobs); err != nil {
+		fmt.Fprintf(os.Stderr, "could not print jobs: %v\n", err)
+		return ExitFailure
+	}
+
+	return code
+}
+
+// prepareRequest resolves the password and token references, drops empty values and
+// validates the request against the message schema with validate
+func prepareRequest(values map[string]interface{}, validate func(map[string]interface{}) error) error {
+
+	if env, _ := values[passwordEnvField].(string); env != "" {
+		password, ok := os.LookupEnv(env)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", env)
+		}
+		values[stream.FieldPassword] = password
+	}
+	delete(values, passwordEnvField)
+
+	if env, _ := values[tokenEnvField].(string); env != "" {
+		token, ok := os.LookupEnv(env)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", env)
+		}
+		values[stream.FieldToken] = token
+	}
+	delete(values, tokenEnvField)
+
+	for key, value := range values {
+		if value == "" {
+			delete(values, key)
+		}
+	}
+
+	return validate(values)
+}
+
+// readRequestFile reads analysis requests from a yaml list of maps or from a
+// csv file with a header row
+func readRequestFile(path string) ([]map[string]interface{}, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open request file: %w", err)
+	}
+	defer f.Close()
+
+	rows := make([]map[string]string, 0)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not read request file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("could not parse request file: %w", err)
+		}
+
+	case ".csv":
+		records, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse request file: %w", err)
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("request file %s has no header row", path)
+		}
+		for _, record := range records[1:] {
+			row := make(map[string]string)
+			for i, key := range records[0] {
+				row[strings.TrimSpace(key)] = strings.TrimSpace(record[i])
+			}
+			rows = append(rows, row)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown request file type %s, expected yaml or csv", path)
+	}
+
+	requests := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values := make(map[string]interface{})
+		for k, v := range row {
+			values[k] = v
+		}
+		requests = append(requests, values)
+	}
+
+	return requests, nil
+}
+
+func newJobStatus(id string, values map[string]interface{}) *analyzer.JobStatus {
+	job := &analyzer.JobStatus{ID: id, State: analyzer.JobQueued}
+	repoURL, _ := values[stream.FieldURL].(string)
+	job.RepoURL = analyzer.URLWithoutUserinfo(repoURL)
+	job.Revision, _ = values[stream.FieldRevision].(string)
+	if t, _ := values[stream.FieldJobType].(string); t == stream.JobTypeDiscovery {
+		job.Type = t
+	}
+	return job
+}
+
+func printJobs(w io.Writer, format string, jobs []*enqueuedJob) error {
+
+	if format != outputTable {
+		return printStructured(w, format, jobs)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tURL\tREVISION\tSTATE\tCOMMIT\tERROR")
+	for _, j := range jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", j.Job.ID, j.Job.RepoURL, j.Job.Revision, j.Job.State, j.Job.Commit, j.Job.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, j := range jobs {
+		if j.Result == nil {
+			continue
+		}
+		fmt.Fprintln(w)
+		if err := printResult(w, format, j.Result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
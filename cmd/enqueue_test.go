@@ -0,0 +1,105 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+)
+
+func Test_readRequestFile(t *testing.T) {
+
+	dir := t.TempDir()
+
+	yamlFile := filepath.Join(dir, "repos.yaml")
+	assert.NoError(t, os.WriteFile(yamlFile, []byte(`
+- name: flux2
+  url: https://github.com/fluxcd/flux2
+  revision: main
+  insecure: false
+- url: https://github.com/geerlingguy/ansible-role-gitlab
+  revision: master
+`), 0644))
+
+	csvFile := filepath.Join(dir, "repos.csv")
+	assert.NoError(t, os.WriteFile(csvFile, []byte(`name,url,revision,insecure
+flux2,https://github.com/fluxcd/flux2,main,false
+,https://github.com/geerlingguy/ansible-role-gitlab,master,
+`), 0644))
+
+	for _, file := range []string{yamlFile, csvFile} {
+		requests, err := readRequestFile(file)
+		assert.NoError(t, err)
+		assert.Len(t, requests, 2)
+
+		for _, values := range requests {
+			assert.NoError(t, prepareRequest(values, stream.ValidateValues), file)
+		}
+
+		assert.Equal(t, map[string]interface{}{
+			"name":     "flux2",
+			"url":      "https://github.com/fluxcd/flux2",
+			"revision": "main",
+			"insecure": "false",
+		}, requests[0], file)
+		assert.Equal(t, map[string]interface{}{
+			"url":      "https://github.com/geerlingguy/ansible-role-gitlab",
+			"revision": "master",
+		}, requests[1], file)
+	}
+
+	_, err := readRequestFile(filepath.Join(dir, "repos.txt"))
+	assert.Error(t, err)
+}
+
+func Test_prepareRequest(t *testing.T) {
+
+	t.Setenv("MY_GIT_PASSWORD", "secret")
+
+	values := map[string]interface{}{
+		stream.FieldURL:      "https://github.com/fluxcd/flux2",
+		stream.FieldRevision: "main",
+		passwordEnvField:     "MY_GIT_PASSWORD",
+	}
+	assert.NoError(t, prepareRequest(values, stream.ValidateValues))
+	assert.Equal(t, "secret", values[stream.FieldPassword])
+	assert.NotContains(t, values, passwordEnvField)
+
+	// unset password reference
+	values[passwordEnvField] = "MY_MISSING_PASSWORD"
+	assert.Error(t, prepareRequest(values, stream.ValidateValues))
+
+	// the password and a credential reference are exclusive
+	values[passwordEnvField] = "MY_GIT_PASSWORD"
+	values[stream.FieldCredentialRef] = "redis:github"
+	assert.ErrorContains(t, prepareRequest(values, stream.ValidateValues), "exclusive")
+
+	delete(values, stream.FieldPassword)
+	assert.NoError(t, prepareRequest(values, stream.ValidateValues))
+	values[stream.FieldCredentialRef] = "github"
+	assert.ErrorContains(t, prepareRequest(values, stream.ValidateValues), "invalid credential reference")
+
+	// missing revision
+	assert.Error(t, prepareRequest(map[string]interface{}{
+		stream.FieldURL: "https://github.com/fluxcd/flux2",
+	}, stream.ValidateValues))
+
+	// discovery with token reference
+	t.Setenv("MY_FORGE_TOKEN", "token")
+	values = map[string]interface{}{
+		stream.FieldJobType:  stream.JobTypeDiscovery,
+		stream.FieldProvider: "github",
+		stream.FieldOwner:    "fluxcd",
+		tokenEnvField:        "MY_FORGE_TOKEN",
+	}
+	assert.NoError(t, prepareRequest(values, stream.ValidateValues))
+	assert.Equal(t, "token", values[stream.FieldToken])
+	assert.NotContains(t, values, tokenEnvField)
+}
@@ -0,0 +1,199 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+const (
+	outputCSV      = "csv"
+	outputMarkdown = "markdown"
+)
+
+const inventoryUsage = "inventory [--expect <tech,...>] [--stored] [--output markdown|csv|json|yaml]"
+
+// runInventory prints the technology inventory of all analyzed repositories
+func runInventory(ctx context.Context, args []string) int {
+
+	fs := flag.NewFlagSet("inventory", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sweatShop-analyzer %s\n", inventoryUsage)
+		fs.PrintDefaults()
+	}
+
+	expect := fs.String("expect", "", "comma separated technologies every repository should contain")
+	stored := fs.Bool("stored", false, "print the inventory last refreshed by the poller instead of building it")
+	output := fs.String("output", outputMarkdown, "output format: markdown, csv, json or yaml")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	switch *output {
+	case outputMarkdown, outputCSV, outputJSON, outputYAML:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q, expected markdown, csv, json or yaml\n", *output)
+		return ExitUsage
+	}
+
+	ajh, err := newAnalyzerJSONHandler()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	var inv *analyzer.Inventory
+	if *stored {
+		inv, err = ajh.GetInventory(ctx)
+		if err != nil && err.Error() == analyzer.ErrJSONMissWithGoRedisClient {
+			err = fmt.Errorf("no inventory stored yet")
+		}
+	} else {
+		inv, err = ajh.BuildInventory(ctx, splitList(*expect))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get inventory: %v\n", err)
+		return ExitFailure
+	}
+
+	if err := printInventory(os.Stdout, *output, inv); err != nil {
+		fmt.Fprintf(os.Stderr, "could not print inventory: %v\n", err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+// splitList splits a comma separated list, ignoring empty entries
+func splitList(s string) []string {
+
+	list := make([]string, 0)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+
+	return list
+}
+
+func printInventory(w io.Writer, format string, inv *analyzer.Inventory) error {
+
+	switch format {
+	case outputCSV:
+		return printInventoryCSV(w, inv)
+	case outputMarkdown:
+		return printInventoryMarkdown(w, inv)
+	}
+
+	return printStructured(w, format, inv)
+}
+
+// printInventoryCSV writes a row per technology and revision of a repository,
+// and a row with present "false" per missing expected technology
+func printInventoryCSV(w io.Writer, inv *analyzer.Inventory) error {
+
+	cw := csv.NewWriter(w)
+
+	analyzed := make(map[string]*analyzer.ResultInventory)
+	for _, r := range inv.Results {
+		analyzed[r.RepoID+"|"+r.Revision] = r
+	}
+
+	row := func(technology string, repositories int, repoID, url, revision string, present bool, paths []string) error {
+		analyzedAt, age := "", ""
+		if r := analyzed[repoID+"|"+revision]; r != nil && !r.AnalyzedAt.IsZero() {
+			analyzedAt, age = r.AnalyzedAt.Format(time.RFC3339), strconv.FormatInt(int64(r.Age.Seconds()), 10)
+		}
+		return cw.Write([]string{technology, strconv.Itoa(repositories), repoID, url, revision,
+			strconv.FormatBool(present), strings.Join(paths, ";"), analyzedAt, age})
+	}
+
+	err := cw.Write([]string{"technology", "technology_repositories", "repository", "url", "revision", "present", "paths", "analyzed_at", "age_seconds"})
+	if err != nil {
+		return err
+	}
+
+	repositories := make(map[string]int)
+	for _, t := range inv.Technologies {
+		repositories[t.Technology] = t.Repositories
+		for _, u := range t.Usages {
+			if err := row(t.Technology, t.Repositories, u.RepoID, u.Url, u.Revision, true, u.Paths); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, m := range inv.Missing {
+		for _, t := range m.Technologies {
+			if err := row(t, repositories[t], m.RepoID, m.Url, m.Revision, false, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func printInventoryMarkdown(w io.Writer, inv *analyzer.Inventory) error {
+
+	fmt.Fprintf(w, "# Technology inventory\n\n")
+	fmt.Fprintf(w, "%d repositories, generated %s\n\n", inv.Repositories, inv.GeneratedAt.Format(time.RFC3339))
+
+	fmt.Fprintf(w, "## Technologies\n\n")
+	fmt.Fprintf(w, "| Technology | Repositories | Paths |\n|---|---|---|\n")
+	for _, t := range inv.Technologies {
+		usages := make([]string, 0, len(t.Usages))
+		for _, u := range t.Usages {
+			usages = append(usages, fmt.Sprintf("%s@%s: %s", u.RepoID, u.Revision, strings.Join(u.Paths, ", ")))
+		}
+		fmt.Fprintf(w, "| %s | %d | %s |\n", markdownCell(t.Technology), t.Repositories, markdownCell(strings.Join(usages, "<br>")))
+	}
+
+	if len(inv.Expected) > 0 {
+		fmt.Fprintf(w, "\n## Missing technologies\n\n")
+		fmt.Fprintf(w, "expected: %s\n\n", strings.Join(inv.Expected, ", "))
+		fmt.Fprintf(w, "| Repository | Revision | Missing |\n|---|---|---|\n")
+		for _, m := range inv.Missing {
+			fmt.Fprintf(w, "| %s | %s | %s |\n", markdownCell(m.RepoID), markdownCell(m.Revision), markdownCell(strings.Join(m.Technologies, ", ")))
+		}
+	}
+
+	fmt.Fprintf(w, "\n## Last analysis\n\n")
+	fmt.Fprintf(w, "| Repository | Revision | Commit | Analyzed | Age |\n|---|---|---|---|---|\n")
+	for _, r := range inv.Results {
+		analyzedAt, age := "unknown", "unknown"
+		if !r.AnalyzedAt.IsZero() {
+			analyzedAt, age = r.AnalyzedAt.Format(time.RFC3339), r.Age.String()
+		}
+		_, err := fmt.Fprintf(w, "| %s | %s | %s | %s | %s |\n", markdownCell(r.RepoID), markdownCell(r.Revision), shortCommit(r.Commit), analyzedAt, age)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// markdownCell escapes the pipes of a table cell
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+func testInventory() *analyzer.Inventory {
+
+	now := time.Date(2023, 6, 10, 12, 0, 0, 0, time.UTC)
+	results := make([]*analyzer.AnalyzerResultValue, 0, len(testResults))
+	for _, r := range testResults {
+		analyzed := *r
+		analyzed.AnalyzedAt = now.Add(-time.Hour)
+		results = append(results, &analyzed)
+	}
+
+	return analyzer.BuildInventory(results, []string{"helm"}, now)
+}
+
+func Test_printInventoryCSV(t *testing.T) {
+
+	var out bytes.Buffer
+	assert.NoError(t, printInventory(&out, outputCSV, testInventory()))
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"technology", "technology_repositories", "repository", "url", "revision", "present", "paths", "analyzed_at", "age_seconds"},
+		{"docker", "1", "github.com/fluxcd/flux2", "https://github.com/fluxcd/flux2", "main", "true", ".", "2023-06-10T11:00:00Z", "3600"},
+		{"golang", "1", "github.com/fluxcd/flux2", "https://github.com/fluxcd/flux2", "main", "true", ".;tests/integration", "2023-06-10T11:00:00Z", "3600"},
+		{"helm", "1", "github.com/aws-samples/eks-gitops-crossplane-argocd", "https://github.com/aws-samples/eks-gitops-crossplane-argocd", "main", "true", "crossplane-complete", "2023-06-10T11:00:00Z", "3600"},
+		{"helm", "1", "github.com/fluxcd/flux2", "https://github.com/fluxcd/flux2", "main", "false", "", "2023-06-10T11:00:00Z", "3600"},
+	}, rows)
+}
+
+func Test_printInventoryMarkdown(t *testing.T) {
+
+	var out bytes.Buffer
+	assert.NoError(t, printInventory(&out, outputMarkdown, testInventory()))
+
+	md := out.String()
+	assert.True(t, strings.HasPrefix(md, "# Technology inventory\n\n2 repositories, generated 2023-06-10T12:00:00Z"))
+	assert.Contains(t, md, "| golang | 1 | github.com/fluxcd/flux2@main: ., tests/integration |\n")
+	assert.Contains(t, md, "| github.com/fluxcd/flux2 | main | helm |\n")
+	assert.Contains(t, md, "| github.com/fluxcd/flux2 | main | 1daa7a8a | 2023-06-10T11:00:00Z | 1h0m0s |\n")
+}
+
+func Test_splitList(t *testing.T) {
+	assert.Equal(t, []string{"golang", "helm"}, splitList(" golang,, helm "))
+	assert.Empty(t, splitList(""))
+}
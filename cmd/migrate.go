@@ -0,0 +1,80 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+)
+
+const migrateUsage = "migrate [--dry-run] [--output json|yaml|table]"
+
+// runMigrate rewrites results and cache entries stored by older versions
+func runMigrate(ctx context.Context, args []string) int {
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sweatShop-analyzer %s\n", migrateUsage)
+		fs.PrintDefaults()
+	}
+
+	dryRun := fs.Bool("dry-run", false, "only count the keys to migrate")
+	output := fs.String("output", outputTable, "output format: json, yaml or table")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	if err := validOutput(*output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+
+	r, err := redisutil.NewRedisWithClientFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	ac := analyzer.NewAnalyzerCache(r.Client, time.Hour)
+	ajh := analyzer.NewAnalyzerJSONHandlerWithClient(r.JSONHandler, r.Client)
+
+	report, err := analyzer.MigrateRevisionKeys(ctx, ac, ajh, *dryRun)
+	if report != nil {
+		if err := printMigrationReport(os.Stdout, *output, report); err != nil {
+			fmt.Fprintf(os.Stderr, "could not print report: %v\n", err)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migration failed: %v\n", err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+func printMigrationReport(w io.Writer, format string, report *analyzer.MigrationReport) error {
+
+	if format != outputTable {
+		return printStructured(w, format, report)
+	}
+
+	fmt.Fprintf(w, "RESULTS:       %d\n", report.Results)
+	fmt.Fprintf(w, "CACHE ENTRIES: %d\n", report.CacheEntries)
+	_, err := fmt.Fprintf(w, "DROPPED:       %d\n", report.Dropped)
+
+	return err
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+func validOutput(format string) error {
+	switch format {
+	case outputJSON, outputYAML, outputTable:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected json, yaml or table", format)
+}
+
+// printStructured writes v as json or yaml
+func printStructured(w io.Writer, format string, v interface{}) error {
+
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case outputYAML:
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	}
+
+	return validOutput(format)
+}
+
+// printResult writes a single analyzer result in the given format
+func printResult(w io.Writer, format string, result *analyzer.AnalyzerResultValue) error {
+
+	if format != outputTable {
+		return printStructured(w, format, result)
+	}
+
+	fmt.Fprintf(w, "REPOSITORY: %s\n", result.Repo.Url)
+	if revision := resultRevision(result); revision != "" {
+		fmt.Fprintf(w, "REVISION:   %s\n", revision)
+	}
+	fmt.Fprintf(w, "COMMIT:     %s\n\n", result.Commit)
+
+	if err := printTechAndPaths(w, result.Results); err != nil {
+		return err
+	}
+
+	if result.History == nil {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\nHISTORY:    %d commits\n\n", result.History.Commits)
+	return printTechnologyHistory(w, result.History)
+}
+
+// printTechnologyHistory writes the periods of each technology and path with
+// the commits which introduced and removed them
+func printTechnologyHistory(w io.Writer, history *analyzer.TechnologyHistory) error {
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TECHNOLOGY\tPATH\tINTRODUCED\tCOMMIT\tAUTHOR\tREMOVED\tCOMMIT\tAUTHOR")
+	for _, e := range history.Entries {
+		removed, removedCommit, removedBy := "-", "-", "-"
+		if e.Removed != nil {
+			removed, removedCommit, removedBy = e.Removed.Date.Format(time.RFC3339), shortCommit(e.Removed.Commit), e.Removed.Author
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Technology, e.Path,
+			e.Introduced.Date.Format(time.RFC3339), shortCommit(e.Introduced.Commit), e.Introduced.Author,
+			removed, removedCommit, removedBy)
+	}
+
+	return tw.Flush()
+}
+
+// resultRevision returns the revision a result is stored under, or the
+// requested one for results which are not stored
+func resultRevision(result *analyzer.AnalyzerResultValue) string {
+	if result.Revision != "" {
+		return result.Revision
+	}
+	return result.Repo.Revision
+}
+
+func printTechAndPaths(w io.Writer, res []*analyzer.TechAndPath) error {
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TECHNOLOGY\tPATH")
+	for _, tp := range res {
+		fmt.Fprintf(tw, "%s\t%s\n", tp.Technology, tp.Path)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,357 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+)
+
+const resultsUsage = "results get <url> [--revision <revision>] [--commit <commit>] | list [--technology <name>] | search [--technology <name>] [--path <path>] [--repo <glob>] [--since <duration>] [--offset <n>] [--limit <n>] | revisions <url> | history <url> [--revision <revision>] | diff <url> --from <commit> [--to <commit>] [--revision <revision>] [--output json|yaml|table]"
+
+// runResults queries the results stored in redis json
+func runResults(ctx context.Context, args []string) int {
+
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "usage: sweatShop-analyzer %s\n", resultsUsage)
+		return ExitUsage
+	}
+
+	if len(args) == 0 {
+		return usage()
+	}
+
+	fs := flag.NewFlagSet("results "+args[0], flag.ContinueOnError)
+	output := fs.String("output", outputTable, "output format: json, yaml or table")
+
+	var revision, commit, technology, from, to, path, repo *string
+	var since *time.Duration
+	var offset, limit *int
+	var expectedArgs int
+
+	switch args[0] {
+	case "get":
+		revision = fs.String("revision", "", "revision of a stored result (default: the most recently analyzed revision)")
+		commit = fs.String("commit", "", "commit of a stored result (default: the latest result of the revision)")
+		expectedArgs = 1
+	case "list":
+		technology = fs.String("technology", "", "only list repositories containing the technology")
+	case "search":
+		technology = fs.String("technology", "", "only results containing the technology")
+		path = fs.String("path", "", "only results with a path at or below the path")
+		repo = fs.String("repo", "", "only results of repositories with a name or url matching the glob pattern")
+		since = fs.Duration("since", 0, "only results analyzed within the duration")
+		offset = fs.Int("offset", 0, "number of results to skip, the most recently analyzed first")
+		limit = fs.Int("limit", 50, "maximum number of results, 0 for all")
+	case "revisions":
+		expectedArgs = 1
+	case "history":
+		revision = fs.String("revision", "", "only use snapshots of this revision (default: all revisions)")
+		expectedArgs = 1
+	case "diff":
+		from = fs.String("from", "", "commit of the older result")
+		to = fs.String("to", "", "commit of the newer result (default: the latest result of the revision)")
+		revision = fs.String("revision", "", "revision of the newer result (default: the most recently analyzed revision)")
+		expectedArgs = 1
+	default:
+		return usage()
+	}
+
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != expectedArgs || (from != nil && *from == "") {
+		return usage()
+	}
+	if err := validOutput(*output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+
+	ajh, err := newAnalyzerJSONHandler()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	switch args[0] {
+	case "get":
+		var result *analyzer.AnalyzerResultValue
+		result, err = getResult(ctx, ajh, positional[0], *revision, *commit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitFailure
+		}
+		err = printResult(os.Stdout, *output, result)
+
+	case "list":
+		var results []*analyzer.AnalyzerResultValue
+		results, err = ajh.ListAnalyzerResults(ctx, *technology)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not list results: %v\n", err)
+			return ExitFailure
+		}
+		err = printResultList(os.Stdout, *output, results)
+
+	case "search":
+		q := &analyzer.ResultQuery{
+			Technology: *technology,
+			Path:       *path,
+			Repo:       *repo,
+			Offset:     *offset,
+			Limit:      *limit,
+		}
+		if *since > 0 {
+			q.AnalyzedAfter = time.Now().Add(-*since)
+		}
+
+		var page *analyzer.ResultPage
+		page, err = ajh.QueryResults(ctx, q)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not search results: %v\n", err)
+			return ExitFailure
+		}
+		err = printResultPage(os.Stdout, *output, page)
+
+	case "revisions":
+		var revisions []*analyzer.RevisionEntry
+		revisions, err = ajh.ListRevisions(ctx, positional[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not list revisions: %v\n", err)
+			return ExitFailure
+		}
+		err = printRevisions(os.Stdout, *output, revisions)
+
+	case "history":
+		var timeline *analyzer.PresenceTimeline
+		timeline, err = ajh.GetTechnologyPresence(ctx, positional[0], *revision)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not get history: %v\n", err)
+			return ExitFailure
+		}
+		err = printHistory(os.Stdout, *output, timeline)
+
+	case "diff":
+		var fromResult, toResult *analyzer.AnalyzerResultValue
+		fromResult, err = getResult(ctx, ajh, positional[0], "", *from)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitFailure
+		}
+		toResult, err = getResult(ctx, ajh, positional[0], *revision, *to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitFailure
+		}
+		err = printDiff(os.Stdout, *output, fromResult, toResult)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not print results: %v\n", err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+func newAnalyzerJSONHandler() (*analyzer.AnalyzerJSONHandler, error) {
+
+	r, err := redisutil.NewRedisWithClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return analyzer.NewAnalyzerJSONHandlerWithClient(r.JSONHandler, r.Client), nil
+}
+
+// getResult returns the result of the commit, or the latest one of the
+// revision if commit is empty
+func getResult(ctx context.Context, ajh *analyzer.AnalyzerJSONHandler, repoURL, revision, commit string) (*analyzer.AnalyzerResultValue, error) {
+
+	var result *analyzer.AnalyzerResultValue
+	var err error
+
+	version := commit
+	if commit == "" {
+		version = revision
+		result, err = ajh.GetAnalyzerResult(ctx, repoURL, revision)
+	} else {
+		result, err = ajh.GetAnalyzerResultAt(ctx, repoURL, commit)
+	}
+
+	if err != nil {
+		if err.Error() == analyzer.ErrJSONMissWithGoRedisClient {
+			return nil, fmt.Errorf("no result stored for %s %s", repoURL, version)
+		}
+		return nil, fmt.Errorf("could not get result for %s %s: %w", repoURL, version, err)
+	}
+
+	return result, nil
+}
+
+func printResultList(w io.Writer, format string, results []*analyzer.AnalyzerResultValue) error {
+
+	results = append([]*analyzer.AnalyzerResultValue{}, results...)
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Repo.Url != results[j].Repo.Url {
+			return results[i].Repo.Url < results[j].Repo.Url
+		}
+		return resultRevision(results[i]) < resultRevision(results[j])
+	})
+
+	if format != outputTable {
+		return printStructured(w, format, results)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tREVISION\tCOMMIT\tTECHNOLOGIES")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Repo.Url, resultRevision(r), r.Commit, strings.Join(technologies(r.Results), ","))
+	}
+
+	return tw.Flush()
+}
+
+func printResultPage(w io.Writer, format string, page *analyzer.ResultPage) error {
+
+	if format != outputTable {
+		return printStructured(w, format, page)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tREVISION\tCOMMIT\tANALYZED\tTECHNOLOGY\tPATH")
+	for _, r := range page.Results {
+		for _, tp := range r.Results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Repo.Url, resultRevision(r), shortCommit(r.Commit), r.AnalyzedAt.Format(time.RFC3339), tp.Technology, tp.Path)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	first := page.Offset + 1
+	if len(page.Results) == 0 {
+		first = page.Offset
+	}
+	_, err := fmt.Fprintf(w, "\n%d-%d of %d results\n", first, page.Offset+len(page.Results), page.Total)
+	return err
+}
+
+func printRevisions(w io.Writer, format string, revisions []*analyzer.RevisionEntry) error {
+
+	if format != outputTable {
+		return printStructured(w, format, revisions)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REVISION\tANALYZED")
+	for _, r := range revisions {
+		analyzed := "unknown"
+		if !r.AnalyzedAt.IsZero() {
+			analyzed = r.AnalyzedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", r.Revision, analyzed)
+	}
+
+	return tw.Flush()
+}
+
+func printHistory(w io.Writer, format string, timeline *analyzer.PresenceTimeline) error {
+
+	if format != outputTable {
+		return printStructured(w, format, timeline)
+	}
+
+	fmt.Fprintf(w, "REPOSITORY: %s\n", timeline.RepoID)
+	fmt.Fprintf(w, "SNAPSHOTS:  %d\n\n", len(timeline.Snapshots))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TECHNOLOGY\tSINCE\tCOMMIT\tUNTIL\tCOMMIT")
+	for _, tp := range timeline.Technologies {
+		for _, p := range tp.Periods {
+			until, untilCommit := "-", "-"
+			if p.Until != nil {
+				until, untilCommit = p.Until.Format(time.RFC3339), shortCommit(p.UntilCommit)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", tp.Technology, p.Since.Format(time.RFC3339), shortCommit(p.SinceCommit), until, untilCommit)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+	return commit
+}
+
+// technologies returns the sorted, distinct technologies of the results
+func technologies(res []*analyzer.TechAndPath) []string {
+
+	seen := make(map[string]bool)
+	techs := make([]string, 0)
+
+	for _, tp := range res {
+		if !seen[tp.Technology] {
+			seen[tp.Technology] = true
+			techs = append(techs, tp.Technology)
+		}
+	}
+	sort.Strings(techs)
+
+	return techs
+}
+
+type resultDiff struct {
+	Url     string
+	From    string
+	To      string
+	Added   []*analyzer.TechAndPath
+	Removed []*analyzer.TechAndPath
+}
+
+func printDiff(w io.Writer, format string, from, to *analyzer.AnalyzerResultValue) error {
+
+	d := analyzer.DiffResults(from.Results, to.Results)
+	diff := &resultDiff{
+		Url:     to.Repo.Url,
+		From:    from.Commit,
+		To:      to.Commit,
+		Added:   d.Added,
+		Removed: d.Removed,
+	}
+
+	if format != outputTable {
+		return printStructured(w, format, diff)
+	}
+
+	fmt.Fprintf(w, "REPOSITORY: %s\n", diff.Url)
+	fmt.Fprintf(w, "FROM:       %s\n", diff.From)
+	fmt.Fprintf(w, "TO:         %s\n\n", diff.To)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tTECHNOLOGY\tPATH")
+	for _, tp := range diff.Added {
+		fmt.Fprintf(tw, "+\t%s\t%s\n", tp.Technology, tp.Path)
+	}
+	for _, tp := range diff.Removed {
+		fmt.Fprintf(tw, "-\t%s\t%s\n", tp.Technology, tp.Path)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+var testResults = []*analyzer.AnalyzerResultValue{
+	{
+		Repo:     &analyzer.Repository{Url: "https://github.com/fluxcd/flux2", Revision: "main"},
+		Revision: "main",
+		Commit:   "1daa7a8aa4e79fd3d6d788b628c85942e340cbc7",
+		Results: []*analyzer.TechAndPath{
+			{Technology: "golang", Path: "."},
+			{Technology: "docker", Path: "."},
+			{Technology: "golang", Path: "tests/integration"},
+		},
+	},
+	{
+		Repo:     &analyzer.Repository{Url: "https://github.com/aws-samples/eks-gitops-crossplane-argocd"},
+		Revision: "main",
+		Commit:   "6ca884922959c9d7c44287875c41b6218bb32185",
+		Results: []*analyzer.TechAndPath{
+			{Technology: "helm", Path: "crossplane-complete"},
+		},
+	},
+}
+
+func Test_printResultList(t *testing.T) {
+
+	var out bytes.Buffer
+	assert.NoError(t, printResultList(&out, outputTable, testResults))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, []string{"URL", "REVISION", "COMMIT", "TECHNOLOGIES"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{testResults[1].Repo.Url, "main", testResults[1].Commit, "helm"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{testResults[0].Repo.Url, "main", testResults[0].Commit, "docker,golang"}, strings.Fields(lines[2]))
+}
+
+func Test_printDiff(t *testing.T) {
+
+	from := testResults[0]
+	to := &analyzer.AnalyzerResultValue{
+		Repo:   from.Repo,
+		Commit: "f2a3d5c0d2b8f0bd1f0c5c3a9d1c2b3a4e5f6a7b",
+		Results: []*analyzer.TechAndPath{
+			{Technology: "golang", Path: "."},
+			{Technology: "helm", Path: "charts/flux"},
+		},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, printDiff(&out, outputJSON, from, to))
+
+	diff := &resultDiff{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), diff))
+	assert.Equal(t, from.Commit, diff.From)
+	assert.Equal(t, to.Commit, diff.To)
+	assert.Equal(t, []*analyzer.TechAndPath{{Technology: "helm", Path: "charts/flux"}}, diff.Added)
+	assert.Equal(t, []*analyzer.TechAndPath{
+		{Technology: "docker", Path: "."},
+		{Technology: "golang", Path: "tests/integration"},
+	}, diff.Removed)
+}
+
+func Test_printHistory(t *testing.T) {
+
+	since := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	until := since.AddDate(0, 0, 7)
+	timeline := &analyzer.PresenceTimeline{
+		RepoID:    "github.com/fluxcd/flux2",
+		Snapshots: []*analyzer.SnapshotPresence{{}, {}},
+		Technologies: []*analyzer.TechnologyPresence{
+			{Technology: "ansible", Periods: []*analyzer.PresencePeriod{
+				{SinceCommit: testResults[0].Commit, Since: since, UntilCommit: testResults[1].Commit, Until: &until},
+			}},
+			{Technology: "helm", Periods: []*analyzer.PresencePeriod{
+				{SinceCommit: testResults[1].Commit, Since: until},
+			}},
+		},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, printHistory(&out, outputTable, timeline))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 6)
+	assert.Equal(t, []string{"ansible", "2023-08-01T12:00:00Z", "1daa7a8a", "2023-08-08T12:00:00Z", "6ca88492"}, strings.Fields(lines[4]))
+	assert.Equal(t, []string{"helm", "2023-08-08T12:00:00Z", "6ca88492", "-", "-"}, strings.Fields(lines[5]))
+}
+
+func Test_printResultPage(t *testing.T) {
+
+	page := &analyzer.ResultPage{Total: 5, Offset: 2, Results: testResults[1:]}
+
+	var out bytes.Buffer
+	assert.NoError(t, printResultPage(&out, outputTable, page))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, []string{"URL", "REVISION", "COMMIT", "ANALYZED", "TECHNOLOGY", "PATH"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{testResults[1].Repo.Url, "main", "6ca88492", "0001-01-01T00:00:00Z", "helm", "crossplane-complete"}, strings.Fields(lines[1]))
+	assert.Equal(t, "3-3 of 5 results", lines[3])
+}
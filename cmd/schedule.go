@@ -0,0 +1,196 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+)
+
+const scheduleUsage = "schedule add <url> --cron <expression> [--revision <revision>] [--history ...] | list [--output json|yaml|table] | remove <url> [--revision <revision>]"
+
+// runSchedule administrates the schedules of repeated analyses
+func runSchedule(ctx context.Context, args []string) int {
+
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "usage: sweatShop-analyzer %s\n", scheduleUsage)
+		return ExitUsage
+	}
+
+	if len(args) == 0 {
+		return usage()
+	}
+
+	fs := flag.NewFlagSet("schedule "+args[0], flag.ContinueOnError)
+	output := fs.String("output", outputTable, "output format: json, yaml or table")
+
+	var revision, cronExpr, name, username, credentialRef, sample *string
+	var insecure, history, firstParent *bool
+	var expectedArgs int
+
+	switch args[0] {
+	case "add":
+		revision = fs.String("revision", "", "revision of the repository")
+		cronExpr = fs.String("cron", "", `cron expression in UTC, e.g. "0 3 * * *", "@daily" or "@every 6h"`)
+		name = fs.String("name", "", "name of the repository")
+		username = fs.String("username", "", "username for the repository")
+		credentialRef = fs.String("credential-ref", "", "credential of the repository, resolved by the analyzer: env:<variable>, file:<name> or redis:<name>")
+		insecure = fs.Bool("insecure", false, "skip tls verification")
+		history = fs.Bool("history", false, "backfill the technology history from the git log")
+		firstParent = fs.Bool("first-parent", false, "only follow the first parent of merge commits in the history")
+		sample = fs.String("sample", "commit", "walk every commit of the history, or only the last one of each day or week")
+		expectedArgs = 1
+	case "remove":
+		revision = fs.String("revision", "", "revision of the repository")
+		expectedArgs = 1
+	case "list":
+	default:
+		return usage()
+	}
+
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return ExitUsage
+	}
+	if len(positional) != expectedArgs {
+		return usage()
+	}
+	if err := validOutput(*output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+
+	var schedule *analyzer.Schedule
+	if args[0] == "add" {
+		values := map[string]interface{}{
+			stream.FieldURL:                positional[0],
+			stream.FieldRevision:           *revision,
+			stream.FieldName:               *name,
+			stream.FieldUsername:           *username,
+			stream.FieldCredentialRef:      *credentialRef,
+			stream.FieldInsecure:           strconv.FormatBool(*insecure),
+			stream.FieldHistory:            strconv.FormatBool(*history),
+			stream.FieldHistoryFirstParent: strconv.FormatBool(*firstParent),
+			stream.FieldHistorySample:      *sample,
+		}
+
+		schedule, err = newSchedule(*cronExpr, values)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid schedule: %v\n", err)
+			return ExitUsage
+		}
+	}
+
+	ajh, err := newAnalyzerJSONHandler()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	switch args[0] {
+	case "add":
+		err = ajh.SetSchedule(ctx, schedule, time.Now())
+		if err == nil {
+			err = printSchedules(os.Stdout, *output, []*analyzer.Schedule{schedule}, time.Now())
+		}
+
+	case "list":
+		var schedules []*analyzer.Schedule
+		schedules, err = ajh.ListSchedules(ctx)
+		if err == nil {
+			err = printSchedules(os.Stdout, *output, schedules, time.Now())
+		}
+
+	case "remove":
+		var removed bool
+		removed, err = ajh.RemoveSchedule(ctx, positional[0], *revision)
+		if err == nil {
+			err = printStructuredOrLine(os.Stdout, *output, map[string]interface{}{"removed": removed},
+				fmt.Sprintf("removed: %t", removed))
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "schedule %s failed: %v\n", args[0], err)
+		return ExitFailure
+	}
+
+	return ExitOK
+}
+
+// newSchedule validates the cron expression and the analysis request of a
+// schedule. The request keeps all fields but the url and the revision; it is
+// stored, so it must not carry a password, neither in the url. The username
+// of the url is kept as the username of the request.
+func newSchedule(cronExpr string, values map[string]interface{}) (*analyzer.Schedule, error) {
+
+	if cronExpr == "" {
+		return nil, fmt.Errorf("no cron expression given")
+	}
+	if _, err := analyzer.ParseCron(cronExpr); err != nil {
+		return nil, err
+	}
+
+	if err := prepareRequest(values, stream.ValidateValues); err != nil {
+		return nil, err
+	}
+	if values[stream.FieldPassword] != nil {
+		return nil, fmt.Errorf("passwords are not stored with schedules, reference the credential with %s", stream.FieldCredentialRef)
+	}
+
+	rawURL := fmt.Sprint(values[stream.FieldURL])
+	if u, err := url.Parse(rawURL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return nil, fmt.Errorf("passwords are not stored with schedules, remove it from the url and reference the credential with %s", stream.FieldCredentialRef)
+		}
+		if values[stream.FieldUsername] == nil && u.User.Username() != "" {
+			values[stream.FieldUsername] = u.User.Username()
+		}
+	}
+
+	s := &analyzer.Schedule{Cron: cronExpr, Request: make(map[string]string)}
+	for key, value := range values {
+		switch key {
+		case stream.FieldURL:
+			s.Url = analyzer.URLWithoutUserinfo(rawURL)
+		case stream.FieldRevision:
+			s.Revision = fmt.Sprint(value)
+		default:
+			s.Request[key] = fmt.Sprint(value)
+		}
+	}
+
+	return s, nil
+}
+
+func printSchedules(w io.Writer, format string, schedules []*analyzer.Schedule, now time.Time) error {
+
+	if format != outputTable {
+		return printStructured(w, format, schedules)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tREVISION\tCRON\tNEXT RUN\tLAST RUN\tLAST JOB")
+	for _, s := range schedules {
+		lastRun := "never"
+		if !s.LastRun.IsZero() {
+			lastRun = formatAge(s.LastRun, now) + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", analyzer.RepositoryID(s.Url), s.Revision, s.Cron,
+			s.NextRun.Format(time.RFC3339), lastRun, s.LastJobID)
+	}
+
+	return tw.Flush()
+}
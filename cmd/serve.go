@@ -0,0 +1,135 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/api"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	"github.com/stuttgart-things/sweatShop-analyzer/rpc"
+	"github.com/stuttgart-things/sweatShop-analyzer/sink"
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+)
+
+const serveUsage = "serve [--addr :8080] [--grpc-addr :9090] [--no-auth]"
+
+// runServe serves the rest api and, if enabled, the grpc service. The bearer
+// tokens are read from API_TOKENS, separated by commas.
+func runServe(ctx context.Context, args []string) int {
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sweatShop-analyzer %s\n", serveUsage)
+		fs.PrintDefaults()
+	}
+
+	addr := fs.String("addr", ":8080", "listen address of the api")
+	grpcAddr := fs.String("grpc-addr", "", "listen address of the grpc service, disabled if empty")
+	noAuth := fs.Bool("no-auth", false, "serve the api without bearer tokens")
+
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return ExitUsage
+	}
+
+	if len(positional) != 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	tokens := apiTokens(os.Getenv("API_TOKENS"))
+	if len(tokens) == 0 && !*noAuth {
+		fmt.Fprintln(os.Stderr, "no API_TOKENS set, pass --no-auth to serve the api without authentication")
+		return ExitUsage
+	}
+	if *noAuth {
+		tokens = nil
+	}
+
+	r, err := redisutil.NewRedisWithClientFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	p, err := stream.ProducerFromEnv(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+	defer p.Close()
+
+	ajh := analyzer.NewAnalyzerJSONHandlerWithClient(r.JSONHandler, r.Client)
+	ajh.SetRetention(stream.RetentionFromEnv())
+
+	// synchronous analyses use the same cache, sinks and credentials as the poller
+	cache, closeCache, err := analyzer.OpenAnalyzerCache(r.Client, stream.CacheConfigFromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+	defer closeCache()
+
+	sinks, closeSinks, err := sink.FromEnv(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+	defer closeSinks()
+
+	resolver, err := credentials.FromEnv(r.Client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	if err := analyzer.InstallProxy(stream.ProxyConfigFromEnv()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitFailure
+	}
+
+	// the first server to fail stops the command
+	errs := make(chan error, 2)
+
+	go func() {
+		fmt.Fprintf(os.Stderr, "serving the api on %s\n", *addr)
+		if err := api.NewServer(p, ajh, tokens).ListenAndServe(*addr); err != nil {
+			errs <- fmt.Errorf("could not serve the api: %w", err)
+		}
+	}()
+
+	if *grpcAddr != "" {
+		go func() {
+			fmt.Fprintf(os.Stderr, "serving the grpc service on %s\n", *grpcAddr)
+			if err := rpc.NewServer(p, ajh, rpc.LockedAnalyzer(r.Client, cache, append(analyzer.MultiSink{{Name: "redisjson", ResultSink: ajh}}, sinks...), resolver), tokens).ListenAndServe(*grpcAddr); err != nil {
+				errs <- fmt.Errorf("could not serve the grpc service: %w", err)
+			}
+		}()
+	}
+
+	fmt.Fprintln(os.Stderr, <-errs)
+
+	return ExitFailure
+}
+
+// apiTokens splits the comma separated tokens, ignoring empty ones
+func apiTokens(s string) []string {
+
+	tokens := make([]string, 0)
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+
+	return tokens
+}
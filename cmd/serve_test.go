@@ -0,0 +1,16 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_apiTokens(t *testing.T) {
+	assert.Equal(t, []string{}, apiTokens(""))
+	assert.Equal(t, []string{"first", "second"}, apiTokens(" first,, second ,"))
+}
@@ -0,0 +1,257 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+// Package credentials resolves references to git credentials, so analysis
+// requests don't need to carry passwords in cleartext. A reference is
+// "<provider>:<name>", e.g. "env:CREDENTIAL_GITHUB", "file:github" or
+// "redis:github". Requests without credential get the one configured for
+// the host of their url, if any.
+package credentials
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	goredis "github.com/redis/go-redis/v9"
+	sthingsBase "github.com/stuttgart-things/sthingsBase"
+)
+
+var (
+	log         = sthingsBase.StdOutFileLogger(logfilePath, "2006-01-02 15:04:05", 50, 3, 28)
+	logfilePath = "/tmp/sweatShop-analyzer.log"
+)
+
+// SetLogOutput redirects the console output of the credentials log, like
+// analyzer.SetLogOutput
+func SetLogOutput(w io.Writer) {
+	log.SetOutput(w)
+}
+
+const (
+	ProviderEnv   = "env"
+	ProviderFile  = "file"
+	ProviderRedis = "redis"
+)
+
+// Credential authenticates against a git server, with a username and a
+// password or token over http, with a private key over ssh
+type Credential struct {
+	Username string `json:",omitempty"`
+	Password string `json:",omitempty"`
+	// SSHKey is a PEM encoded private key, e.g. a deploy key
+	SSHKey string `json:",omitempty"`
+	// Hosts are the hosts the credential is sent to, like the entries of
+	// MatchHost, e.g. "github.com" or "*.example.com"
+	Hosts []string `json:",omitempty"`
+}
+
+// Provider looks up the credential of a name
+type Provider interface {
+	Lookup(ctx context.Context, name string) (*Credential, error)
+}
+
+// HostSource finds the credential of the git server of a url. It returns
+// nil, if it has none.
+type HostSource interface {
+	ForURL(ctx context.Context, u *url.URL) (*Credential, error)
+}
+
+// Resolver resolves references with the provider of their prefix and finds
+// the credentials of git servers
+type Resolver struct {
+	Providers map[string]Provider
+	// HostRefs references the credentials of hosts, see MatchHost
+	HostRefs map[string]string
+	// Hosts are asked in order after HostRefs, e.g. a netrc file and
+	// credential helpers
+	Hosts []HostSource
+}
+
+// ParseRef splits a reference into its provider and name
+func ParseRef(ref string) (provider, name string, err error) {
+
+	provider, name, ok := strings.Cut(ref, ":")
+	if !ok || provider == "" || name == "" {
+		return "", "", fmt.Errorf("invalid credential reference %q, expected <provider>:<name>", ref)
+	}
+
+	return provider, name, nil
+}
+
+// Resolve returns the credential of the reference
+func (r *Resolver) Resolve(ctx context.Context, ref string) (*Credential, error) {
+
+	provider, name, err := ParseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := r.Providers[provider]
+	if !ok {
+		return nil, fmt.Errorf("credential provider %q is not configured", provider)
+	}
+
+	c, err := p.Lookup(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve credential %s: %w", ref, err)
+	}
+
+	return c, nil
+}
+
+// ResolveFor returns the credential of the reference for a repository or
+// forge api url. The reference must be bound to the host of the url, else a
+// request could send the credential to any server: CREDENTIALS_HOSTS maps the
+// host to the reference, or the host is one of the hosts of the credential.
+func (r *Resolver) ResolveFor(ctx context.Context, ref, rawURL string) (*Credential, error) {
+
+	u := parseRepositoryURL(rawURL)
+	if u == nil {
+		return nil, fmt.Errorf("credential %s is only sent to the hosts bound to it, %s has no host", ref, rawURL)
+	}
+
+	c, err := r.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if hostRef, ok := MatchHost(r.HostRefs, u); ok && hostRef == ref {
+		return c, nil
+	}
+
+	hosts := make(map[string]string, len(c.Hosts))
+	for _, host := range c.Hosts {
+		hosts[strings.ToLower(host)] = ref
+	}
+	if _, ok := MatchHost(hosts, u); ok {
+		return c, nil
+	}
+
+	return nil, fmt.Errorf("credential %s is not bound to host %s, see CREDENTIALS_HOSTS", ref, u.Host)
+}
+
+// ForURL returns the credential of the git server of the repository url:
+// the reference of its host, else the credential of the first host source
+// knowing it. A failing source, e.g. a missing credential helper, is logged
+// and the next one asked. It returns nil for local repositories and unknown
+// hosts, the repository is then cloned anonymously and fails if the server
+// requires a credential. Only the reference of a host must resolve.
+func (r *Resolver) ForURL(ctx context.Context, repoURL string) (*Credential, error) {
+
+	u := parseRepositoryURL(repoURL)
+	if u == nil {
+		return nil, nil
+	}
+
+	if ref, ok := MatchHost(r.HostRefs, u); ok {
+		return r.Resolve(ctx, ref)
+	}
+
+	for _, source := range r.Hosts {
+		c, err := source.ForURL(ctx, u)
+		if err != nil {
+			log.Warnf("could not get credential of %s: %v", u.Host, err)
+			continue
+		}
+		if c != nil {
+			return c, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// parseRepositoryURL parses urls and the scp-like ssh syntax, e.g.
+// git@github.com:org/repo.git. It returns nil for urls without host.
+func parseRepositoryURL(repoURL string) *url.URL {
+
+	if !strings.Contains(repoURL, "://") {
+		userHost, path, ok := strings.Cut(repoURL, ":")
+		if !ok || strings.Contains(userHost, "/") {
+			return nil
+		}
+		repoURL = "ssh://" + userHost + "/" + path
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+
+	return u
+}
+
+// FromEnv returns the resolver of the providers configured in the
+// environment. Environment variables are always available, mounted files
+// with CREDENTIALS_DIR and the redis store with CREDENTIALS_KEY, the base64
+// encoded 32 byte key of the credentials. The credentials of hosts are
+// configured by
+//   - CREDENTIALS_HOSTS, comma separated <host>=<reference>
+//   - CREDENTIALS_NETRC, the path of a netrc file
+//   - CREDENTIALS_HELPERS, comma separated git credential helpers
+func FromEnv(client goredis.UniversalClient) (*Resolver, error) {
+
+	r := &Resolver{Providers: map[string]Provider{ProviderEnv: EnvProvider{}}}
+
+	if dir := os.Getenv("CREDENTIALS_DIR"); dir != "" {
+		r.Providers[ProviderFile] = FileProvider{Dir: dir}
+	}
+
+	if client != nil && os.Getenv("CREDENTIALS_KEY") != "" {
+		store, err := StoreFromEnv(client)
+		if err != nil {
+			return nil, err
+		}
+		r.Providers[ProviderRedis] = store
+	}
+
+	hostRefs, err := ParseHostRefs(os.Getenv("CREDENTIALS_HOSTS"))
+	if err != nil {
+		return nil, err
+	}
+	r.HostRefs = hostRefs
+
+	if path := os.Getenv("CREDENTIALS_NETRC"); path != "" {
+		netrc, err := ReadNetrc(path)
+		if err != nil {
+			return nil, err
+		}
+		r.Hosts = append(r.Hosts, netrc)
+	}
+
+	for _, helper := range splitList(os.Getenv("CREDENTIALS_HELPERS")) {
+		r.Hosts = append(r.Hosts, &Helper{Command: helper})
+	}
+
+	return r, nil
+}
+
+// StoreFromEnv returns the redis store encrypting with CREDENTIALS_KEY
+func StoreFromEnv(client goredis.UniversalClient) (*RedisStore, error) {
+
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("CREDENTIALS_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode CREDENTIALS_KEY: %w", err)
+	}
+
+	return NewRedisStore(client, key)
+}
+
+// splitList splits a comma separated list, without empty entries
+func splitList(s string) []string {
+
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
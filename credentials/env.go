@@ -0,0 +1,34 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvPrefix prefixes the environment variables which may be referenced, so a
+// request can't send e.g. REDIS_PASSWORD to a git server
+const EnvPrefix = "CREDENTIAL_"
+
+// EnvProvider takes the password from an environment variable, the username
+// is left to the request
+type EnvProvider struct{}
+
+func (EnvProvider) Lookup(_ context.Context, name string) (*Credential, error) {
+
+	if !strings.HasPrefix(name, EnvPrefix) {
+		return nil, fmt.Errorf("environment variable %s does not start with %s", name, EnvPrefix)
+	}
+
+	password, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return &Credential{Password: password}, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileProvider reads credentials below a directory, e.g. mounted kubernetes
+// secrets. A name is either a file holding the password or a directory with
+// the files "username" and "password", like the keys of a secret of type
+// kubernetes.io/basic-auth, or "ssh-privatekey", like the key of a secret of
+// type kubernetes.io/ssh-auth. The username is optional, the file "hosts"
+// lists the hosts of the credential separated by commas or lines.
+type FileProvider struct {
+	Dir string
+}
+
+func (p FileProvider) Lookup(_ context.Context, name string) (*Credential, error) {
+
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("credential file %s is not below the credentials directory", name)
+	}
+	path := filepath.Join(p.Dir, name)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read credential file: %w", err)
+	}
+
+	if !info.IsDir() {
+		password, err := readSecret(path)
+		if err != nil {
+			return nil, err
+		}
+		return &Credential{Password: password}, nil
+	}
+
+	c := &Credential{}
+	for file, value := range map[string]*string{"username": &c.Username, "password": &c.Password, "ssh-privatekey": &c.SSHKey} {
+		*value, err = readSecret(filepath.Join(path, file))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if c.Password == "" && c.SSHKey == "" {
+		return nil, fmt.Errorf("credential directory %s has no password or ssh-privatekey", name)
+	}
+
+	hosts, err := readSecret(filepath.Join(path, "hosts"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, host := range strings.FieldsFunc(hosts, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		if host = strings.TrimSpace(host); host != "" {
+			c.Hosts = append(c.Hosts, host)
+		}
+	}
+
+	return c, nil
+}
+
+// readSecret reads a file without its trailing line break
+func readSecret(path string) (string, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read credential file: %w", err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
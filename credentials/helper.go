@@ -0,0 +1,87 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Helper asks a git credential helper for the credential of http and https
+// urls, like git does. The command is the name of a helper, run as
+// "git credential-<name>", e.g. "store --file /etc/git-credentials", or the
+// path of an executable, e.g. "/usr/local/bin/git-credential-vault".
+type Helper struct {
+	Command string
+}
+
+func (h *Helper) ForURL(ctx context.Context, u *url.URL) (*Credential, error) {
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, nil
+	}
+
+	args := strings.Fields(h.Command)
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if !filepath.IsAbs(args[0]) {
+		args = append([]string{"git", "credential-" + args[0]}, args[1:]...)
+	}
+	args = append(args, "get")
+
+	// a newline in the decoded url would add attributes to the request,
+	// e.g. the host of another server, like CVE-2020-5260 of git
+	username := ""
+	if u.User != nil {
+		username = u.User.Username()
+	}
+	if strings.ContainsAny(u.Host, "\n\r\x00") || strings.ContainsAny(username, "\n\r\x00") {
+		return nil, fmt.Errorf("url of %s has a newline or nul character", u.Redacted())
+	}
+
+	// the path is left out, like git does without credential.useHttpPath
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "protocol=%s\nhost=%s\n", u.Scheme, u.Host)
+	if username != "" {
+		fmt.Fprintf(&request, "username=%s\n", username)
+	}
+	request.WriteString("\n")
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = &request
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	c := &Credential{}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimRight(line, "\r"), "=")
+		switch key {
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		}
+	}
+
+	// the helper does not know the host
+	if c.Password == "" {
+		return nil, nil
+	}
+
+	return c, nil
+}
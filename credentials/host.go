@@ -0,0 +1,58 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package credentials
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ParseHostRefs parses comma separated <host>=<reference> entries, e.g.
+// "git.example.com=env:CREDENTIAL_EXAMPLE,*.internal=file:internal"
+func ParseHostRefs(s string) (map[string]string, error) {
+
+	refs := make(map[string]string)
+	for _, entry := range splitList(s) {
+		host, ref, ok := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		if !ok || host == "" {
+			return nil, fmt.Errorf("invalid host credential %q, expected <host>=<reference>", entry)
+		}
+		if _, _, err := ParseRef(strings.TrimSpace(ref)); err != nil {
+			return nil, fmt.Errorf("invalid host credential of %s: %w", host, err)
+		}
+		refs[host] = strings.TrimSpace(ref)
+	}
+
+	return refs, nil
+}
+
+// MatchHost returns the entry of the host of the url. The most specific
+// entry wins: the host with port, the host, then the longest matching
+// wildcard, e.g. "*.example.com".
+func MatchHost(entries map[string]string, u *url.URL) (string, bool) {
+
+	host := strings.ToLower(u.Hostname())
+
+	if u.Port() != "" {
+		if v, ok := entries[strings.ToLower(u.Host)]; ok {
+			return v, true
+		}
+	}
+	if v, ok := entries[host]; ok {
+		return v, true
+	}
+
+	var match, longest string
+	for pattern, v := range entries {
+		suffix, ok := strings.CutPrefix(pattern, "*")
+		if ok && strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) && len(pattern) > len(longest) {
+			match, longest = v, pattern
+		}
+	}
+
+	return match, longest != ""
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package credentials
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type hostSourceMock map[string]*Credential
+
+func (m hostSourceMock) ForURL(_ context.Context, u *url.URL) (*Credential, error) {
+	return m[u.Hostname()], nil
+}
+
+// failingSource fails like a missing credential helper
+type failingSource struct{}
+
+func (failingSource) ForURL(_ context.Context, u *url.URL) (*Credential, error) {
+	return nil, errors.New("credential helper failed")
+}
+
+func TestParseHostRefs(t *testing.T) {
+
+	refs, err := ParseHostRefs(" Git.Example.com=env:CREDENTIAL_EXAMPLE, *.internal=file:internal ,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"git.example.com": "env:CREDENTIAL_EXAMPLE", "*.internal": "file:internal"}, refs)
+
+	for _, invalid := range []string{"git.example.com", "=env:CREDENTIAL_EXAMPLE", "git.example.com=secret"} {
+		_, err := ParseHostRefs(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+
+	entries := map[string]string{
+		"git.example.com":      "host",
+		"git.example.com:8443": "port",
+		"*.example.com":        "wildcard",
+		"*.team.example.com":   "longer wildcard",
+	}
+
+	for rawURL, expected := range map[string]string{
+		"https://git.example.com/org/repo.git":      "host",
+		"https://GIT.example.com:8443/org/repo.git": "port",
+		"https://git.example.com:9443/org/repo.git": "host",
+		"https://gitea.example.com/org/repo.git":    "wildcard",
+		"https://gitea.team.example.com/repo.git":   "longer wildcard",
+		"https://example.com/org/repo.git":          "",
+		"https://github.com/org/repo.git":           "",
+	} {
+		u, _ := url.Parse(rawURL)
+		match, ok := MatchHost(entries, u)
+		assert.Equal(t, expected, match, rawURL)
+		assert.Equal(t, expected != "", ok, rawURL)
+	}
+}
+
+func TestForURL(t *testing.T) {
+
+	ctx := context.Background()
+	t.Setenv("CREDENTIAL_EXAMPLE", "configured")
+
+	r := &Resolver{
+		Providers: map[string]Provider{ProviderEnv: EnvProvider{}},
+		HostRefs:  map[string]string{"git.example.com": "env:CREDENTIAL_EXAMPLE", "broken.example.com": "env:CREDENTIAL_MISSING"},
+		Hosts: []HostSource{
+			failingSource{},
+			hostSourceMock{"git.example.com": {Password: "netrc"}, "gitea.internal": {Username: "netrc", Password: "netrc"}},
+			hostSourceMock{"gitea.internal": {Password: "helper"}, "gitlab.internal": {Password: "helper"}},
+		},
+	}
+
+	// the configured host first, then the sources in order, skipping the
+	// failing one
+	for repoURL, expected := range map[string]*Credential{
+		"https://git.example.com/org/repo.git":   {Password: "configured"},
+		"git@git.example.com:org/repo.git":       {Password: "configured"},
+		"https://gitea.internal/org/repo.git":    {Username: "netrc", Password: "netrc"},
+		"https://gitlab.internal/org/repo.git":   {Password: "helper"},
+		"https://github.com/org/repo.git":        nil,
+		"/srv/git/repo.git":                      nil,
+		"file:///srv/git/repo.git":               nil,
+		"ssh://git@gitlab.internal/org/repo.git": {Password: "helper"},
+	} {
+		c, err := r.ForURL(ctx, repoURL)
+		assert.NoError(t, err, repoURL)
+		assert.Equal(t, expected, c, repoURL)
+	}
+
+	_, err := r.ForURL(ctx, "https://broken.example.com/org/repo.git")
+	assert.ErrorContains(t, err, "is not set")
+
+	// a missing helper is skipped, the repository is cloned anonymously
+	r = &Resolver{Hosts: []HostSource{&Helper{Command: filepath.Join(t.TempDir(), "missing")}}}
+	c, err := r.ForURL(ctx, "https://gitlab.internal/org/repo.git")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestFromEnvHosts(t *testing.T) {
+
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	require.NoError(t, os.WriteFile(netrc, []byte("machine git.example.com login bot password secret\n"), 0o600))
+
+	t.Setenv("CREDENTIALS_DIR", "")
+	t.Setenv("CREDENTIALS_KEY", "")
+	t.Setenv("CREDENTIALS_HOSTS", "gitea.internal=file:gitea")
+	t.Setenv("CREDENTIALS_NETRC", netrc)
+	t.Setenv("CREDENTIALS_HELPERS", "store --file /etc/git-credentials, /usr/local/bin/git-credential-vault")
+
+	r, err := FromEnv(nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"gitea.internal": "file:gitea"}, r.HostRefs)
+	require.Len(t, r.Hosts, 3)
+	assert.IsType(t, &Netrc{}, r.Hosts[0])
+	assert.Equal(t, &Helper{Command: "store --file /etc/git-credentials"}, r.Hosts[1])
+	assert.Equal(t, &Helper{Command: "/usr/local/bin/git-credential-vault"}, r.Hosts[2])
+
+	t.Setenv("CREDENTIALS_NETRC", filepath.Join(t.TempDir(), "missing"))
+	_, err = FromEnv(nil)
+	assert.Error(t, err)
+
+	t.Setenv("CREDENTIALS_NETRC", "")
+	t.Setenv("CREDENTIALS_HOSTS", "gitea.internal")
+	_, err = FromEnv(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package credentials
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Netrc holds the logins of a netrc file, used like curl does for http and
+// https urls: the entry of the machine, else the default entry
+type Netrc struct {
+	machines map[string]*Credential
+	fallback *Credential
+}
+
+// ReadNetrc reads a netrc file
+func ReadNetrc(path string) (*Netrc, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read netrc file: %w", err)
+	}
+
+	return ParseNetrc(string(data))
+}
+
+// ParseNetrc parses the machine, default, login and password tokens of a
+// netrc file. Macro definitions and accounts are skipped.
+func ParseNetrc(data string) (*Netrc, error) {
+
+	n := &Netrc{machines: make(map[string]*Credential)}
+
+	var current *Credential
+	var macro bool
+	for _, line := range strings.Split(data, "\n") {
+
+		// a macro definition ends with an empty line
+		if macro {
+			macro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		s := bufio.NewScanner(strings.NewReader(line))
+		s.Split(bufio.ScanWords)
+
+		next := func(token string) (string, error) {
+			if !s.Scan() {
+				return "", fmt.Errorf("invalid netrc file: no value of %s", token)
+			}
+			return s.Text(), nil
+		}
+
+		for s.Scan() {
+			token := s.Text()
+			if strings.HasPrefix(token, "#") {
+				break
+			}
+
+			switch token {
+			case "machine":
+				name, err := next(token)
+				if err != nil {
+					return nil, err
+				}
+				current = &Credential{}
+				n.machines[strings.ToLower(name)] = current
+
+			case "default":
+				current = &Credential{}
+				n.fallback = current
+
+			case "login", "password", "account":
+				value, err := next(token)
+				if err != nil {
+					return nil, err
+				}
+				if current == nil {
+					return nil, fmt.Errorf("invalid netrc file: %s outside of a machine", token)
+				}
+				if token == "login" {
+					current.Username = value
+				} else if token == "password" {
+					current.Password = value
+				}
+
+			case "macdef":
+				macro = true
+
+			default:
+				return nil, fmt.Errorf("invalid netrc file: unknown token %q", token)
+			}
+
+			if macro {
+				break
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (n *Netrc) ForURL(_ context.Context, u *url.URL) (*Credential, error) {
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, nil
+	}
+
+	if c, ok := n.machines[strings.ToLower(u.Hostname())]; ok {
+		return c, nil
+	}
+
+	return n.fallback, nil
+}
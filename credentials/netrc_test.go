@@ -0,0 +1,58 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package credentials
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetrc(t *testing.T) {
+
+	ctx := context.Background()
+	n, err := ParseNetrc(`# tokens of the analyzer
+machine git.example.com
+  login bot
+  password secret
+
+machine GITEA.internal login analyzer password token account ignored
+
+macdef init
+machine not.a.machine login nobody password nothing
+
+default login anonymous password guest
+`)
+	require.NoError(t, err)
+
+	for rawURL, expected := range map[string]*Credential{
+		"https://git.example.com/org/repo.git":    {Username: "bot", Password: "secret"},
+		"http://gitea.internal:3000/org/repo.git": {Username: "analyzer", Password: "token"},
+		"https://not.a.machine/org/repo.git":      {Username: "anonymous", Password: "guest"},
+		"ssh://git@git.example.com/org/repo.git":  nil,
+		"git://git.example.com/org/repo.git":      nil,
+	} {
+		u, _ := url.Parse(rawURL)
+		c, err := n.ForURL(ctx, u)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, c, rawURL)
+	}
+
+	// without default entry
+	n, err = ParseNetrc("machine git.example.com login bot password secret")
+	require.NoError(t, err)
+	u, _ := url.Parse("https://github.com/org/repo.git")
+	c, err := n.ForURL(ctx, u)
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	for _, invalid := range []string{"machine", "login bot", "machine git.example.com login", "machine git.example.com user bot"} {
+		_, err := ParseNetrc(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
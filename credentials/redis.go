@@ -0,0 +1,119 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package credentials
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+)
+
+// RedisStore keeps the credentials in a redis hash, encrypted with AES-GCM.
+// The name is authenticated with the credential, so an encrypted credential
+// can't be moved to another name.
+type RedisStore struct {
+	client goredis.UniversalClient
+	aead   cipher.AEAD
+}
+
+// NewRedisStore returns the store encrypting with the 32 byte key
+func NewRedisStore(client goredis.UniversalClient, key []byte) (*RedisStore, error) {
+
+	if len(key) != 32 {
+		return nil, fmt.Errorf("credentials key has %d bytes, expected 32", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+
+	return &RedisStore{client: client, aead: aead}, nil
+}
+
+func (s *RedisStore) Lookup(ctx context.Context, name string) (*Credential, error) {
+
+	sealed, err := s.client.HGet(ctx, schema.CredentialsKey, name).Result()
+	if err == goredis.Nil {
+		return nil, fmt.Errorf("credential %s not found", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get credential %s: %w", name, err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("invalid credential %s", name)
+	}
+
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt credential %s: %w", name, err)
+	}
+
+	c := &Credential{}
+	if err := json.Unmarshal(plaintext, c); err != nil {
+		return nil, fmt.Errorf("could not unmarshal credential %s: %w", name, err)
+	}
+
+	return c, nil
+}
+
+// Set encrypts and stores the credential of the name
+func (s *RedisStore) Set(ctx context.Context, name string, c *Credential) error {
+
+	plaintext, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("could not marshal credential %s: %w", name, err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("could not create nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, plaintext, []byte(name))
+
+	if err := s.client.HSet(ctx, schema.CredentialsKey, name, base64.StdEncoding.EncodeToString(sealed)).Err(); err != nil {
+		return fmt.Errorf("could not set credential %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// Remove removes the credential of the name and reports whether it existed
+func (s *RedisStore) Remove(ctx context.Context, name string) (bool, error) {
+
+	n, err := s.client.HDel(ctx, schema.CredentialsKey, name).Result()
+	if err != nil {
+		return false, fmt.Errorf("could not remove credential %s: %w", name, err)
+	}
+
+	return n > 0, nil
+}
+
+// Names returns the sorted names of the stored credentials
+func (s *RedisStore) Names(ctx context.Context) ([]string, error) {
+
+	names, err := s.client.HKeys(ctx, schema.CredentialsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not list credentials: %w", err)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
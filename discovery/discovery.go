@@ -0,0 +1,216 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+// Package discovery lists the repositories of an organization, group or user
+// through the api of a forge, to analyze all of them.
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	ProviderGitHub = "github"
+	ProviderGitea  = "gitea"
+	ProviderGitLab = "gitlab"
+)
+
+// errNotFound is returned for a missing owner, to try the next kind of owner
+var errNotFound = errors.New("not found")
+
+// Repository is a repository listed by a forge
+type Repository struct {
+	Name string
+	// FullName includes the owner, e.g. "org/repo" or "group/subgroup/repo"
+	FullName      string
+	CloneURL      string
+	DefaultBranch string
+	Archived      bool
+	Fork          bool
+	Topics        []string
+}
+
+// Provider lists the repositories of an owner, i.e. an organization, a group
+// or a user, on all pages
+type Provider interface {
+	ListRepositories(ctx context.Context, owner string) ([]*Repository, error)
+}
+
+// BaseURL returns the url of the forge kind, baseURL if set or else the url of
+// the public instance
+func BaseURL(kind, baseURL string) string {
+
+	if baseURL != "" {
+		return baseURL
+	}
+
+	switch kind {
+	case ProviderGitHub:
+		return "https://api.github.com"
+	case ProviderGitea:
+		return "https://gitea.com"
+	case ProviderGitLab:
+		return "https://gitlab.com"
+	}
+
+	return ""
+}
+
+// NewProvider returns the provider of the forge kind. baseURL is the url of
+// the forge, the public instance if empty. token authenticates the requests,
+// if set.
+func NewProvider(kind, baseURL, token string, client *http.Client) (Provider, error) {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	api := &apiClient{client: client, token: token}
+	baseURL = BaseURL(kind, baseURL)
+
+	switch kind {
+	case ProviderGitHub:
+		api.baseURL = strings.TrimSuffix(baseURL, "/")
+		api.authHeader, api.authPrefix = "Authorization", "Bearer "
+		return &gitHub{api: api}, nil
+
+	case ProviderGitea:
+		api.baseURL = strings.TrimSuffix(baseURL, "/") + "/api/v1"
+		api.authHeader, api.authPrefix = "Authorization", "token "
+		return &gitea{api: api}, nil
+
+	case ProviderGitLab:
+		api.baseURL = strings.TrimSuffix(baseURL, "/") + "/api/v4"
+		api.authHeader = "PRIVATE-TOKEN"
+		return &gitLab{api: api}, nil
+	}
+
+	return nil, fmt.Errorf("unknown provider %q, expected github, gitea or gitlab", kind)
+}
+
+// Filter selects the discovered repositories to analyze. Archived
+// repositories and forks are excluded, unless included explicitly.
+type Filter struct {
+	IncludeArchived bool
+	IncludeForks    bool
+	// Include matches the names or full names to analyze, all if nil
+	Include *regexp.Regexp
+	// Exclude matches the names or full names to skip
+	Exclude *regexp.Regexp
+	// Topics requires at least one of the topics, if set
+	Topics []string
+}
+
+// Match reports whether the repository passes the filter
+func (f *Filter) Match(r *Repository) bool {
+
+	if r.Archived && !f.IncludeArchived {
+		return false
+	}
+	if r.Fork && !f.IncludeForks {
+		return false
+	}
+
+	matches := func(re *regexp.Regexp) bool {
+		return re.MatchString(r.Name) || re.MatchString(r.FullName)
+	}
+	if f.Include != nil && !matches(f.Include) {
+		return false
+	}
+	if f.Exclude != nil && matches(f.Exclude) {
+		return false
+	}
+
+	if len(f.Topics) == 0 {
+		return true
+	}
+	for _, want := range f.Topics {
+		for _, topic := range r.Topics {
+			if strings.EqualFold(want, topic) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Apply returns the repositories passing the filter
+func (f *Filter) Apply(repos []*Repository) []*Repository {
+
+	matching := make([]*Repository, 0, len(repos))
+	for _, r := range repos {
+		if f.Match(r) {
+			matching = append(matching, r)
+		}
+	}
+
+	return matching
+}
+
+// apiClient sends the authenticated requests of a provider
+type apiClient struct {
+	client     *http.Client
+	baseURL    string
+	token      string
+	authHeader string
+	authPrefix string
+}
+
+// get decodes the json response of the path into v. It returns the status
+// code, also if it is no success.
+func (a *apiClient) get(ctx context.Context, path string, v interface{}) (int, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.baseURL+path, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if a.token != "" {
+		req.Header.Set(a.authHeader, a.authPrefix+a.token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not request %s: %w", req.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("request %s failed with %s: %s", req.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("could not decode response of %s: %w", req.URL, err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// maxPages stops the paging of a misbehaving api
+const maxPages = 1000
+
+// listPages calls fetch for the pages, starting at 1, until one has less
+// than perPage entries
+func listPages(perPage int, fetch func(page int) (int, error)) error {
+
+	for page := 1; page <= maxPages; page++ {
+		n, err := fetch(page)
+		if err != nil {
+			return err
+		}
+		if n < perPage {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("more than %d pages", maxPages)
+}
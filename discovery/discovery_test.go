@@ -0,0 +1,164 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubForge serves pages of repositories below path, if the request carries
+// the auth header, and 404 for all other paths
+func stubForge(t *testing.T, path, authHeader, auth, perPageParam string, repos []map[string]interface{}) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get(authHeader) != auth {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		perPage, _ := strconv.Atoi(r.URL.Query().Get(perPageParam))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		assert.Greater(t, perPage, 0)
+
+		batch := make([]map[string]interface{}, 0)
+		for i := (page - 1) * perPage; i < page*perPage && i < len(repos); i++ {
+			batch = append(batch, repos[i])
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(batch))
+	}))
+}
+
+func names(repos []*Repository) []string {
+	n := make([]string, 0)
+	for _, r := range repos {
+		n = append(n, r.FullName)
+	}
+	return n
+}
+
+func gitHubRepos(n int) []map[string]interface{} {
+	repos := make([]map[string]interface{}, 0)
+	for i := 0; i < n; i++ {
+		repos = append(repos, map[string]interface{}{
+			"name":           fmt.Sprintf("repo-%d", i),
+			"full_name":      fmt.Sprintf("org/repo-%d", i),
+			"clone_url":      fmt.Sprintf("https://github.com/org/repo-%d.git", i),
+			"default_branch": "main",
+			"archived":       i == 1,
+			"fork":           i == 2,
+			"topics":         []string{"team-" + strconv.Itoa(i%2)},
+		})
+	}
+	return repos
+}
+
+func TestGitHub(t *testing.T) {
+
+	srv := stubForge(t, "/users/someone/repos", "Authorization", "Bearer secret", "per_page", gitHubRepos(2))
+	defer srv.Close()
+
+	p, err := NewProvider(ProviderGitHub, srv.URL, "secret", srv.Client())
+	assert.NoError(t, err)
+
+	// no organization, but a user
+	repos, err := p.ListRepositories(context.Background(), "someone")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Repository{
+		{Name: "repo-0", FullName: "org/repo-0", CloneURL: "https://github.com/org/repo-0.git", DefaultBranch: "main", Topics: []string{"team-0"}},
+		{Name: "repo-1", FullName: "org/repo-1", CloneURL: "https://github.com/org/repo-1.git", DefaultBranch: "main", Archived: true, Topics: []string{"team-1"}},
+	}, repos)
+
+	_, err = p.ListRepositories(context.Background(), "nobody")
+	assert.EqualError(t, err, "github owner nobody not found")
+
+	// unauthorized
+	p, _ = NewProvider(ProviderGitHub, srv.URL, "wrong", srv.Client())
+	_, err = p.ListRepositories(context.Background(), "someone")
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestGitea(t *testing.T) {
+
+	srv := stubForge(t, "/api/v1/orgs/org/repos", "Authorization", "token secret", "limit", gitHubRepos(60))
+	defer srv.Close()
+
+	p, err := NewProvider(ProviderGitea, srv.URL+"/", "secret", srv.Client())
+	assert.NoError(t, err)
+
+	// two pages
+	repos, err := p.ListRepositories(context.Background(), "org")
+	assert.NoError(t, err)
+	assert.Len(t, repos, 60)
+	assert.Equal(t, "org/repo-59", repos[59].FullName)
+}
+
+func TestGitLab(t *testing.T) {
+
+	projects := []map[string]interface{}{
+		{"path": "app", "path_with_namespace": "group/sub/app", "http_url_to_repo": "https://gitlab.com/group/sub/app.git", "default_branch": "main", "topics": []string{"go"}},
+		{"path": "fork", "path_with_namespace": "group/fork", "http_url_to_repo": "https://gitlab.com/group/fork.git", "default_branch": "master", "forked_from_project": map[string]interface{}{"id": 1}, "tag_list": []string{"legacy"}},
+	}
+	srv := stubForge(t, "/api/v4/groups/group/sub/projects", "PRIVATE-TOKEN", "secret", "per_page", projects)
+	defer srv.Close()
+
+	p, err := NewProvider(ProviderGitLab, srv.URL, "secret", srv.Client())
+	assert.NoError(t, err)
+
+	repos, err := p.ListRepositories(context.Background(), "group/sub")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Repository{
+		{Name: "app", FullName: "group/sub/app", CloneURL: "https://gitlab.com/group/sub/app.git", DefaultBranch: "main", Topics: []string{"go"}},
+		{Name: "fork", FullName: "group/fork", CloneURL: "https://gitlab.com/group/fork.git", DefaultBranch: "master", Fork: true, Topics: []string{"legacy"}},
+	}, repos)
+
+	_, err = NewProvider("bitbucket", "", "", nil)
+	assert.Error(t, err)
+}
+
+func Test_listPages(t *testing.T) {
+
+	pages := make([]int, 0)
+	err := listPages(2, func(page int) (int, error) {
+		pages = append(pages, page)
+		if page < 3 {
+			return 2, nil
+		}
+		return 1, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, pages)
+}
+
+func TestFilter(t *testing.T) {
+
+	repos := []*Repository{
+		{Name: "api", FullName: "org/api", Topics: []string{"Backend"}},
+		{Name: "old", FullName: "org/old", Archived: true},
+		{Name: "fork", FullName: "org/fork", Fork: true},
+		{Name: "web", FullName: "org/web", Topics: []string{"frontend"}},
+		{Name: "api-docs", FullName: "org/api-docs"},
+	}
+
+	assert.Equal(t, []string{"org/api", "org/web", "org/api-docs"}, names((&Filter{}).Apply(repos)))
+	assert.Equal(t, []string{"org/api", "org/old", "org/fork", "org/web", "org/api-docs"}, names((&Filter{IncludeArchived: true, IncludeForks: true}).Apply(repos)))
+	assert.Equal(t, []string{"org/api", "org/api-docs"}, names((&Filter{Include: regexp.MustCompile(`^api`)}).Apply(repos)))
+	assert.Equal(t, []string{"org/api", "org/web"}, names((&Filter{Exclude: regexp.MustCompile(`docs$`)}).Apply(repos)))
+	assert.Equal(t, []string{"org/api"}, names((&Filter{Topics: []string{"backend"}}).Apply(repos)))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const giteaPerPage = 50
+
+type gitea struct {
+	api *apiClient
+}
+
+type giteaRepository struct {
+	Name          string   `json:"name"`
+	FullName      string   `json:"full_name"`
+	CloneURL      string   `json:"clone_url"`
+	DefaultBranch string   `json:"default_branch"`
+	Archived      bool     `json:"archived"`
+	Fork          bool     `json:"fork"`
+	Topics        []string `json:"topics"`
+}
+
+// ListRepositories lists the repositories of an organization, or of a user
+// if there is no organization of the name
+func (g *gitea) ListRepositories(ctx context.Context, owner string) ([]*Repository, error) {
+
+	repos, err := g.list(ctx, fmt.Sprintf("/orgs/%s/repos", url.PathEscape(owner)))
+	if err == errNotFound {
+		repos, err = g.list(ctx, fmt.Sprintf("/users/%s/repos", url.PathEscape(owner)))
+	}
+	if err == errNotFound {
+		return nil, fmt.Errorf("gitea owner %s not found", owner)
+	}
+
+	return repos, err
+}
+
+func (g *gitea) list(ctx context.Context, path string) ([]*Repository, error) {
+
+	repos := make([]*Repository, 0)
+
+	err := listPages(giteaPerPage, func(page int) (int, error) {
+		batch := make([]*giteaRepository, 0)
+		status, err := g.api.get(ctx, fmt.Sprintf("%s?limit=%d&page=%d", path, giteaPerPage, page), &batch)
+		if status == http.StatusNotFound {
+			return 0, errNotFound
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		for _, r := range batch {
+			repos = append(repos, &Repository{
+				Name:          r.Name,
+				FullName:      r.FullName,
+				CloneURL:      r.CloneURL,
+				DefaultBranch: r.DefaultBranch,
+				Archived:      r.Archived,
+				Fork:          r.Fork,
+				Topics:        r.Topics,
+			})
+		}
+		return len(batch), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return repos, nil
+}
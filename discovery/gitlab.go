@@ -0,0 +1,83 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const gitLabPerPage = 100
+
+type gitLab struct {
+	api *apiClient
+}
+
+type gitLabProject struct {
+	Path              string      `json:"path"`
+	PathWithNamespace string      `json:"path_with_namespace"`
+	HTTPURLToRepo     string      `json:"http_url_to_repo"`
+	DefaultBranch     string      `json:"default_branch"`
+	Archived          bool        `json:"archived"`
+	ForkedFromProject interface{} `json:"forked_from_project"`
+	Topics            []string    `json:"topics"`
+	// TagList are the topics of gitlab before 14.0
+	TagList []string `json:"tag_list"`
+}
+
+// ListRepositories lists the projects of a group and its subgroups, or of a
+// user if there is no group of the path
+func (g *gitLab) ListRepositories(ctx context.Context, owner string) ([]*Repository, error) {
+
+	repos, err := g.list(ctx, fmt.Sprintf("/groups/%s/projects?include_subgroups=true&", url.PathEscape(owner)))
+	if err == errNotFound {
+		repos, err = g.list(ctx, fmt.Sprintf("/users/%s/projects?", url.PathEscape(owner)))
+	}
+	if err == errNotFound {
+		return nil, fmt.Errorf("gitlab group or user %s not found", owner)
+	}
+
+	return repos, err
+}
+
+func (g *gitLab) list(ctx context.Context, path string) ([]*Repository, error) {
+
+	repos := make([]*Repository, 0)
+
+	err := listPages(gitLabPerPage, func(page int) (int, error) {
+		batch := make([]*gitLabProject, 0)
+		status, err := g.api.get(ctx, fmt.Sprintf("%sper_page=%d&page=%d", path, gitLabPerPage, page), &batch)
+		if status == http.StatusNotFound {
+			return 0, errNotFound
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		for _, p := range batch {
+			topics := p.Topics
+			if len(topics) == 0 {
+				topics = p.TagList
+			}
+			repos = append(repos, &Repository{
+				Name:          p.Path,
+				FullName:      p.PathWithNamespace,
+				CloneURL:      p.HTTPURLToRepo,
+				DefaultBranch: p.DefaultBranch,
+				Archived:      p.Archived,
+				Fork:          p.ForkedFromProject != nil,
+				Topics:        topics,
+			})
+		}
+		return len(batch), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return repos, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+// Package schema names the redis streams, message fields and keys of the
+// analyzer. The analyzer and the client package share them, so a change
+// reaches both.
+package schema
+
+import "fmt"
+
+// streams
+const (
+	// AnalyzeStream receives the analysis requests
+	AnalyzeStream = "sweatShop:analyze"
+	// AnalyzedStream receives a completion event per analysis request
+	AnalyzedStream = "sweatShop:analyzed"
+	// ControlStream receives administrative commands for the analyzer
+	ControlStream = "sweatShop:control"
+)
+
+// fields of an analysis request message
+const (
+	FieldName                  = "name"
+	FieldURL                   = "url"
+	FieldRevision              = "revision"
+	FieldUsername              = "username"
+	FieldPassword              = "password"
+	FieldCredentialRef         = "credential_ref"
+	FieldInsecure              = "insecure"
+	FieldForceCompleteAnalysis = "force_complete_analysis"
+	FieldAllowPlainDirectory   = "allow_plain_directory"
+	FieldJobID                 = "job_id"
+	FieldHistory               = "history"
+	FieldHistoryFirstParent    = "history_first_parent"
+	FieldHistorySample         = "history_sample"
+)
+
+// fields of an analysis request triggered by a push webhook
+const (
+	FieldPushBefore       = "push_before"
+	FieldPushAfter        = "push_after"
+	FieldPushAddedFiles   = "push_added_files"
+	FieldPushRemovedFiles = "push_removed_files"
+)
+
+// job types of a request message
+const (
+	FieldJobType     = "job_type"
+	JobTypeAnalyze   = "analyze"
+	JobTypeDiscovery = "discovery"
+)
+
+// fields of a discovery request message, which fans out an analysis request
+// per discovered repository
+const (
+	FieldProvider        = "provider"
+	FieldProviderURL     = "provider_url"
+	FieldOwner           = "owner"
+	FieldToken           = "token"
+	FieldTokenRef        = "token_ref"
+	FieldIncludeArchived = "include_archived"
+	FieldIncludeForks    = "include_forks"
+	FieldInclude         = "include"
+	FieldExclude         = "exclude"
+	FieldTopics          = "topics"
+)
+
+// additional fields of a completion event message
+const (
+	FieldState  = "state"
+	FieldCommit = "commit"
+	FieldError  = "error"
+)
+
+// keys of the redis documents
+const (
+	// SearchIndex is the RediSearch index of the results
+	SearchIndex = "analyzerresults-v1"
+	// ResultKeyPrefix prefixes the keys of the results
+	ResultKeyPrefix = "analyzerresult|"
+	InventoryKey    = "analyzerinventory"
+	SchedulesKey    = "analyzerschedules"
+	ScheduleRunsKey = "analyzerscheduleruns"
+	// CredentialsKey is the hash of the encrypted credentials by name
+	CredentialsKey = "analyzercredentials"
+)
+
+// ResultKey is the key of the latest result of a revision, by the identity
+// of the repository
+func ResultKey(repoID, revision string) string {
+	return fmt.Sprintf("%s%s|%s", ResultKeyPrefix, repoID, revision)
+}
+
+// SnapshotKey is the key of the result of a commit
+func SnapshotKey(repoID, commitID string) string {
+	return fmt.Sprintf("analyzersnapshot|%s|%s", repoID, commitID)
+}
+
+// SnapshotRevisionsKey is the key of the set of revisions the commit of a
+// snapshot was analyzed on
+func SnapshotRevisionsKey(repoID, commitID string) string {
+	return fmt.Sprintf("analyzersnapshotrevisions|%s|%s", repoID, commitID)
+}
+
+// SnapshotsKey is the key of the snapshots of a repository by commit time
+func SnapshotsKey(repoID string) string {
+	return fmt.Sprintf("analyzersnapshots|%s", repoID)
+}
+
+// RevisionsKey is the key of the analyzed revisions of a repository
+func RevisionsKey(repoID string) string {
+	return fmt.Sprintf("analyzerrevisions|%s", repoID)
+}
+
+// MatchingFilesKey is the key of the cached matching files of a revision
+func MatchingFilesKey(repoID, revision string) string {
+	return fmt.Sprintf("matchingfiles|%s|%s", repoID, revision)
+}
+
+// JobKey is the key of the status of a job
+func JobKey(jobID string) string {
+	return fmt.Sprintf("analyzerjob|%s", jobID)
+}
+
+// LockKey is the key of the analysis lock of a repository
+func LockKey(repoID string) string {
+	return fmt.Sprintf("analyzerlock|%s", repoID)
+}
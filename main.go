@@ -1,15 +1,34 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/cmd"
 	"github.com/stuttgart-things/sweatShop-analyzer/internal"
 	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+	"github.com/stuttgart-things/sweatShop-analyzer/utils/tracing"
 )
 
 func main() {
 
+	// RUN COMMAND LINE MODE, IF A COMMAND IS GIVEN
+	if len(os.Args) > 1 {
+		os.Exit(cmd.Run(os.Args[1:]))
+	}
+
 	// PRINT BANNER + VERSION INFO
 	internal.PrintBanner()
 
+	// INIT TRACING
+	shutdown, err := tracing.InitTracerProvider(context.Background())
+	if err != nil {
+		fmt.Printf("COULD NOT INIT TRACING: %v\n", err)
+	} else {
+		defer shutdown(context.Background())
+	}
+
 	// POLL STREAM
 	stream.PollRedisStreams()
 
@@ -0,0 +1,302 @@
+// The typed contract of the analyzer for go services, an alternative to the
+// maps of the redis streams.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: analyzer/v1/analyzer.proto
+
+package analyzerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	AnalyzerService_Analyze_FullMethodName     = "/sweatshop.analyzer.v1.AnalyzerService/Analyze"
+	AnalyzerService_Submit_FullMethodName      = "/sweatshop.analyzer.v1.AnalyzerService/Submit"
+	AnalyzerService_WatchJob_FullMethodName    = "/sweatshop.analyzer.v1.AnalyzerService/WatchJob"
+	AnalyzerService_GetResult_FullMethodName   = "/sweatshop.analyzer.v1.AnalyzerService/GetResult"
+	AnalyzerService_ListResults_FullMethodName = "/sweatshop.analyzer.v1.AnalyzerService/ListResults"
+)
+
+// AnalyzerServiceClient is the client API for AnalyzerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AnalyzerServiceClient interface {
+	// Analyze analyzes a repository and stores its result. It runs until the
+	// deadline of the call, at most as long as the server allows.
+	Analyze(ctx context.Context, in *AnalyzeRequest, opts ...grpc.CallOption) (*AnalyzerResultValue, error)
+	// Submit enqueues an analysis on the analyze stream and returns its job id
+	Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error)
+	// WatchJob streams the status of a job on every change, until it succeeded
+	// or failed
+	WatchJob(ctx context.Context, in *WatchJobRequest, opts ...grpc.CallOption) (AnalyzerService_WatchJobClient, error)
+	// GetResult returns the result of a repository
+	GetResult(ctx context.Context, in *GetResultRequest, opts ...grpc.CallOption) (*AnalyzerResultValue, error)
+	// ListResults returns the latest results matching a query
+	ListResults(ctx context.Context, in *ListResultsRequest, opts ...grpc.CallOption) (*ListResultsResponse, error)
+}
+
+type analyzerServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAnalyzerServiceClient(cc grpc.ClientConnInterface) AnalyzerServiceClient {
+	return &analyzerServiceClient{cc}
+}
+
+func (c *analyzerServiceClient) Analyze(ctx context.Context, in *AnalyzeRequest, opts ...grpc.CallOption) (*AnalyzerResultValue, error) {
+	out := new(AnalyzerResultValue)
+	err := c.cc.Invoke(ctx, AnalyzerService_Analyze_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyzerServiceClient) Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error) {
+	out := new(SubmitResponse)
+	err := c.cc.Invoke(ctx, AnalyzerService_Submit_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyzerServiceClient) WatchJob(ctx context.Context, in *WatchJobRequest, opts ...grpc.CallOption) (AnalyzerService_WatchJobClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AnalyzerService_ServiceDesc.Streams[0], AnalyzerService_WatchJob_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &analyzerServiceWatchJobClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AnalyzerService_WatchJobClient interface {
+	Recv() (*JobStatus, error)
+	grpc.ClientStream
+}
+
+type analyzerServiceWatchJobClient struct {
+	grpc.ClientStream
+}
+
+func (x *analyzerServiceWatchJobClient) Recv() (*JobStatus, error) {
+	m := new(JobStatus)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *analyzerServiceClient) GetResult(ctx context.Context, in *GetResultRequest, opts ...grpc.CallOption) (*AnalyzerResultValue, error) {
+	out := new(AnalyzerResultValue)
+	err := c.cc.Invoke(ctx, AnalyzerService_GetResult_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyzerServiceClient) ListResults(ctx context.Context, in *ListResultsRequest, opts ...grpc.CallOption) (*ListResultsResponse, error) {
+	out := new(ListResultsResponse)
+	err := c.cc.Invoke(ctx, AnalyzerService_ListResults_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AnalyzerServiceServer is the server API for AnalyzerService service.
+// All implementations must embed UnimplementedAnalyzerServiceServer
+// for forward compatibility
+type AnalyzerServiceServer interface {
+	// Analyze analyzes a repository and stores its result. It runs until the
+	// deadline of the call, at most as long as the server allows.
+	Analyze(context.Context, *AnalyzeRequest) (*AnalyzerResultValue, error)
+	// Submit enqueues an analysis on the analyze stream and returns its job id
+	Submit(context.Context, *SubmitRequest) (*SubmitResponse, error)
+	// WatchJob streams the status of a job on every change, until it succeeded
+	// or failed
+	WatchJob(*WatchJobRequest, AnalyzerService_WatchJobServer) error
+	// GetResult returns the result of a repository
+	GetResult(context.Context, *GetResultRequest) (*AnalyzerResultValue, error)
+	// ListResults returns the latest results matching a query
+	ListResults(context.Context, *ListResultsRequest) (*ListResultsResponse, error)
+	mustEmbedUnimplementedAnalyzerServiceServer()
+}
+
+// UnimplementedAnalyzerServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAnalyzerServiceServer struct {
+}
+
+func (UnimplementedAnalyzerServiceServer) Analyze(context.Context, *AnalyzeRequest) (*AnalyzerResultValue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Analyze not implemented")
+}
+func (UnimplementedAnalyzerServiceServer) Submit(context.Context, *SubmitRequest) (*SubmitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Submit not implemented")
+}
+func (UnimplementedAnalyzerServiceServer) WatchJob(*WatchJobRequest, AnalyzerService_WatchJobServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchJob not implemented")
+}
+func (UnimplementedAnalyzerServiceServer) GetResult(context.Context, *GetResultRequest) (*AnalyzerResultValue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetResult not implemented")
+}
+func (UnimplementedAnalyzerServiceServer) ListResults(context.Context, *ListResultsRequest) (*ListResultsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListResults not implemented")
+}
+func (UnimplementedAnalyzerServiceServer) mustEmbedUnimplementedAnalyzerServiceServer() {}
+
+// UnsafeAnalyzerServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AnalyzerServiceServer will
+// result in compilation errors.
+type UnsafeAnalyzerServiceServer interface {
+	mustEmbedUnimplementedAnalyzerServiceServer()
+}
+
+func RegisterAnalyzerServiceServer(s grpc.ServiceRegistrar, srv AnalyzerServiceServer) {
+	s.RegisterService(&AnalyzerService_ServiceDesc, srv)
+}
+
+func _AnalyzerService_Analyze_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AnalyzeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalyzerServiceServer).Analyze(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnalyzerService_Analyze_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalyzerServiceServer).Analyze(ctx, req.(*AnalyzeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnalyzerService_Submit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalyzerServiceServer).Submit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnalyzerService_Submit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalyzerServiceServer).Submit(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnalyzerService_WatchJob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchJobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AnalyzerServiceServer).WatchJob(m, &analyzerServiceWatchJobServer{stream})
+}
+
+type AnalyzerService_WatchJobServer interface {
+	Send(*JobStatus) error
+	grpc.ServerStream
+}
+
+type analyzerServiceWatchJobServer struct {
+	grpc.ServerStream
+}
+
+func (x *analyzerServiceWatchJobServer) Send(m *JobStatus) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _AnalyzerService_GetResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalyzerServiceServer).GetResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnalyzerService_GetResult_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalyzerServiceServer).GetResult(ctx, req.(*GetResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnalyzerService_ListResults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListResultsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalyzerServiceServer).ListResults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnalyzerService_ListResults_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalyzerServiceServer).ListResults(ctx, req.(*ListResultsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AnalyzerService_ServiceDesc is the grpc.ServiceDesc for AnalyzerService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AnalyzerService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sweatshop.analyzer.v1.AnalyzerService",
+	HandlerType: (*AnalyzerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Analyze",
+			Handler:    _AnalyzerService_Analyze_Handler,
+		},
+		{
+			MethodName: "Submit",
+			Handler:    _AnalyzerService_Submit_Handler,
+		},
+		{
+			MethodName: "GetResult",
+			Handler:    _AnalyzerService_GetResult_Handler,
+		},
+		{
+			MethodName: "ListResults",
+			Handler:    _AnalyzerService_ListResults_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchJob",
+			Handler:       _AnalyzerService_WatchJob_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "analyzer/v1/analyzer.proto",
+}
@@ -0,0 +1,158 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package rpc
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	analyzerv1 "github.com/stuttgart-things/sweatShop-analyzer/proto/analyzer/v1"
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var jobStates = map[analyzer.JobState]analyzerv1.JobState{
+	analyzer.JobQueued:    analyzerv1.JobState_JOB_STATE_QUEUED,
+	analyzer.JobRunning:   analyzerv1.JobState_JOB_STATE_RUNNING,
+	analyzer.JobSucceeded: analyzerv1.JobState_JOB_STATE_SUCCEEDED,
+	analyzer.JobFailed:    analyzerv1.JobState_JOB_STATE_FAILED,
+}
+
+// requestValues returns the message values of an analysis request of the
+// repository
+func requestValues(repo *analyzerv1.Repository) map[string]interface{} {
+
+	values := make(map[string]interface{})
+	set := func(key, value string) {
+		if value != "" {
+			values[key] = value
+		}
+	}
+
+	set(stream.FieldName, repo.GetName())
+	set(stream.FieldURL, repo.GetUrl())
+	set(stream.FieldRevision, repo.GetRevision())
+	set(stream.FieldUsername, repo.GetUsername())
+	set(stream.FieldPassword, repo.GetPassword())
+	set(stream.FieldCredentialRef, repo.GetCredentialRef())
+
+	if repo.GetInsecure() {
+		values[stream.FieldInsecure] = "true"
+	}
+	if repo.ForceCompleteAnalysis != nil {
+		values[stream.FieldForceCompleteAnalysis] = strconv.FormatBool(repo.GetForceCompleteAnalysis())
+	}
+	if repo.GetHistory() != nil {
+		values[stream.FieldHistory] = "true"
+		values[stream.FieldHistoryFirstParent] = strconv.FormatBool(repo.GetHistory().GetFirstParent())
+		set(stream.FieldHistorySample, repo.GetHistory().GetSample())
+	}
+
+	return values
+}
+
+// toRepository returns the repository of a validated request
+func toRepository(repo *analyzerv1.Repository) *analyzer.Repository {
+
+	r := &analyzer.Repository{
+		Name:          repo.GetName(),
+		Url:           repo.GetUrl(),
+		Revision:      repo.GetRevision(),
+		Username:      repo.GetUsername(),
+		Password:      repo.GetPassword(),
+		CredentialRef: repo.GetCredentialRef(),
+		Insecure:      repo.GetInsecure(),
+	}
+
+	if repo.ForceCompleteAnalysis != nil {
+		force := repo.GetForceCompleteAnalysis()
+		r.ForceCompleteAnalysis = &force
+	}
+
+	if repo.GetHistory() != nil {
+		sample, _ := analyzer.ParseHistorySampling(repo.GetHistory().GetSample())
+		r.History = &analyzer.HistoryOptions{
+			FirstParent: repo.GetHistory().GetFirstParent(),
+			Sample:      sample,
+		}
+	}
+
+	return r
+}
+
+// repositoryToProto returns the repository without its password
+func repositoryToProto(repo *analyzer.Repository) *analyzerv1.Repository {
+
+	if repo == nil {
+		return nil
+	}
+
+	r := &analyzerv1.Repository{
+		Name:                  repo.Name,
+		Url:                   repo.Url,
+		Revision:              repo.Revision,
+		Username:              repo.Username,
+		CredentialRef:         repo.CredentialRef,
+		Insecure:              repo.Insecure,
+		ForceCompleteAnalysis: repo.ForceCompleteAnalysis,
+	}
+
+	if repo.History != nil {
+		r.History = &analyzerv1.HistoryOptions{
+			FirstParent: repo.History.FirstParent,
+			Sample:      string(repo.History.Sample),
+		}
+	}
+
+	return r
+}
+
+func resultToProto(v *analyzer.AnalyzerResultValue) *analyzerv1.AnalyzerResultValue {
+
+	res := &analyzerv1.AnalyzerResultValue{
+		Repo:        repositoryToProto(v.Repo),
+		Revision:    v.Revision,
+		Commit:      v.Commit,
+		CommittedAt: timestamp(v.CommittedAt),
+		AnalyzedAt:  timestamp(v.AnalyzedAt),
+		Results:     make([]*analyzerv1.TechAndPath, 0, len(v.Results)),
+		RepoId:      v.RepoID,
+	}
+
+	if res.RepoId == "" && v.Repo != nil {
+		res.RepoId = v.Repo.ID()
+	}
+
+	for _, tp := range v.Results {
+		res.Results = append(res.Results, &analyzerv1.TechAndPath{Technology: tp.Technology, Path: tp.Path})
+	}
+
+	return res
+}
+
+func jobToProto(job *analyzer.JobStatus) *analyzerv1.JobStatus {
+	return &analyzerv1.JobStatus{
+		Id:        job.ID,
+		Type:      job.Type,
+		RepoUrl:   job.RepoURL,
+		Revision:  job.Revision,
+		State:     jobStates[job.State],
+		Children:  job.Children,
+		Commit:    job.Commit,
+		Error:     job.Error,
+		UpdatedAt: timestamp(job.UpdatedAt),
+	}
+}
+
+// timestamp returns nil for the zero time
+func timestamp(t time.Time) *timestamppb.Timestamp {
+
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
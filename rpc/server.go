@@ -0,0 +1,328 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+// Package rpc implements the grpc service of the analyzer, defined in
+// proto/analyzer/v1.
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	analyzerv1 "github.com/stuttgart-things/sweatShop-analyzer/proto/analyzer/v1"
+	"github.com/stuttgart-things/sweatShop-analyzer/stream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultAnalyzeTimeout limits synchronous analyses, also if the call has
+	// a later deadline
+	defaultAnalyzeTimeout = 10 * time.Minute
+	// defaultWatchInterval is the interval jobs are polled for changes
+	defaultWatchInterval = time.Second
+	lockInterval         = time.Second
+)
+
+var tracer = otel.Tracer("github.com/stuttgart-things/sweatShop-analyzer/rpc")
+
+// Enqueuer validates and enqueues analysis requests, implemented by the
+// stream producer
+type Enqueuer interface {
+	Enqueue(ctx context.Context, values map[string]interface{}) (string, error)
+}
+
+// Store reads the jobs and results, implemented by the analyzer json handler
+type Store interface {
+	GetJobStatus(ctx context.Context, jobID string) (*analyzer.JobStatus, error)
+	GetAnalyzerResult(ctx context.Context, repoURL, revision string) (*analyzer.AnalyzerResultValue, error)
+	GetAnalyzerResultAt(ctx context.Context, repoURL, commitId string) (*analyzer.AnalyzerResultValue, error)
+	QueryResults(ctx context.Context, q *analyzer.ResultQuery) (*analyzer.ResultPage, error)
+}
+
+// Analyzer analyzes a repository synchronously
+type Analyzer func(ctx context.Context, repo *analyzer.Repository) (*analyzer.AnalyzerResultValue, error)
+
+// LockedAnalyzer analyzes like the poller: it resolves the credential
+// reference, waits for the lock of the repository until the deadline, caches
+// the matching files and writes the result to the sink
+func LockedAnalyzer(client goredis.UniversalClient, cache analyzer.AnalyzerCacheInterface, sink analyzer.ResultSink, resolver analyzer.CredentialResolver) Analyzer {
+	return func(ctx context.Context, repo *analyzer.Repository) (*analyzer.AnalyzerResultValue, error) {
+
+		if err := repo.ResolveCredentials(ctx, resolver); err != nil {
+			return nil, err
+		}
+
+		ttl := defaultAnalyzeTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			ttl = time.Until(deadline)
+		}
+
+		lock, err := analyzer.AcquireRepositoryLock(ctx, client, repo.Url, ttl, ttl, lockInterval)
+		if err != nil {
+			return nil, err
+		}
+		// release the lock also if the call was cancelled
+		defer lock.Release(context.Background())
+
+		return repo.GetMatchingFiles(ctx, cache, sink)
+	}
+}
+
+// Server implements the analyzer service
+type Server struct {
+	analyzerv1.UnimplementedAnalyzerServiceServer
+
+	producer Enqueuer
+	store    Store
+	analyze  Analyzer
+	// tokens are the accepted bearer tokens, none disables the authentication
+	tokens         []string
+	analyzeTimeout time.Duration
+	watchInterval  time.Duration
+}
+
+// NewServer returns the analyzer service. Calls must carry one of the tokens
+// as bearer token in the authorization metadata, unless there is none.
+func NewServer(producer Enqueuer, store Store, analyze Analyzer, tokens []string) *Server {
+	return &Server{
+		producer:       producer,
+		store:          store,
+		analyze:        analyze,
+		tokens:         tokens,
+		analyzeTimeout: defaultAnalyzeTimeout,
+		watchInterval:  defaultWatchInterval,
+	}
+}
+
+// GRPCServer returns a grpc server with the service and the authentication
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+
+	opts = append(opts, grpc.ChainUnaryInterceptor(s.unaryInterceptor), grpc.ChainStreamInterceptor(s.streamInterceptor))
+
+	srv := grpc.NewServer(opts...)
+	analyzerv1.RegisterAnalyzerServiceServer(srv, s)
+
+	return srv
+}
+
+// ListenAndServe serves the service on addr until the server fails
+func (s *Server) ListenAndServe(addr string) error {
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.GRPCServer().Serve(lis)
+}
+
+func (s *Server) Analyze(ctx context.Context, req *analyzerv1.AnalyzeRequest) (*analyzerv1.AnalyzerResultValue, error) {
+
+	if _, err := validRequest(req.GetRepository()); err != nil {
+		return nil, err
+	}
+
+	// the earlier deadline of the call and the server wins
+	ctx, cancel := context.WithTimeout(ctx, s.analyzeTimeout)
+	defer cancel()
+
+	result, err := s.analyze(ctx, toRepository(req.GetRepository()))
+	switch {
+	case err == nil:
+		return resultToProto(result), nil
+	case ctx.Err() != nil:
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case errors.Is(err, analyzer.ErrLocked):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return nil, status.Errorf(codes.Internal, "could not analyze %s: %v", req.GetRepository().GetUrl(), err)
+}
+
+func (s *Server) Submit(ctx context.Context, req *analyzerv1.SubmitRequest) (*analyzerv1.SubmitResponse, error) {
+
+	values, err := validRequest(req.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.producer.Enqueue(ctx, values)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &analyzerv1.SubmitResponse{JobId: id}, nil
+}
+
+func (s *Server) WatchJob(req *analyzerv1.WatchJobRequest, srv analyzerv1.AnalyzerService_WatchJobServer) error {
+
+	if req.GetJobId() == "" {
+		return status.Error(codes.InvalidArgument, "no job id received")
+	}
+
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+
+	var last *analyzer.JobStatus
+	for {
+		job, err := s.store.GetJobStatus(srv.Context(), req.GetJobId())
+		if err != nil {
+			return storeError(err, "job "+req.GetJobId()+" not found")
+		}
+
+		if last == nil || job.State != last.State || !job.UpdatedAt.Equal(last.UpdatedAt) {
+			if err := srv.Send(jobToProto(job)); err != nil {
+				return err
+			}
+			last = job
+		}
+
+		if job.State.Done() {
+			return nil
+		}
+
+		select {
+		case <-srv.Context().Done():
+			return status.FromContextError(srv.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) GetResult(ctx context.Context, req *analyzerv1.GetResultRequest) (*analyzerv1.AnalyzerResultValue, error) {
+
+	if req.GetRepository() == "" {
+		return nil, status.Error(codes.InvalidArgument, "no repository received")
+	}
+
+	var result *analyzer.AnalyzerResultValue
+	var err error
+	if req.GetCommit() != "" {
+		result, err = s.store.GetAnalyzerResultAt(ctx, req.GetRepository(), req.GetCommit())
+	} else {
+		result, err = s.store.GetAnalyzerResult(ctx, req.GetRepository(), req.GetRevision())
+	}
+	if err != nil {
+		return nil, storeError(err, "no result of "+req.GetRepository()+" found")
+	}
+
+	return resultToProto(result), nil
+}
+
+func (s *Server) ListResults(ctx context.Context, req *analyzerv1.ListResultsRequest) (*analyzerv1.ListResultsResponse, error) {
+
+	if req.GetOffset() < 0 || req.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset and limit must not be negative")
+	}
+
+	page, err := s.store.QueryResults(ctx, &analyzer.ResultQuery{
+		Technology: req.GetTechnology(),
+		Path:       req.GetPath(),
+		Repo:       req.GetRepository(),
+		Offset:     int(req.GetOffset()),
+		Limit:      int(req.GetLimit()),
+	})
+	if err != nil {
+		return nil, storeError(err, "")
+	}
+
+	res := &analyzerv1.ListResultsResponse{
+		Total:   int32(page.Total),
+		Offset:  int32(page.Offset),
+		Results: make([]*analyzerv1.AnalyzerResultValue, 0, len(page.Results)),
+	}
+	for _, v := range page.Results {
+		res.Results = append(res.Results, resultToProto(v))
+	}
+
+	return res, nil
+}
+
+// validRequest validates the repository against the message schema of the
+// analyze stream and returns its message values
+func validRequest(repo *analyzerv1.Repository) (map[string]interface{}, error) {
+
+	if repo == nil {
+		return nil, status.Error(codes.InvalidArgument, "no repository received")
+	}
+
+	values := requestValues(repo)
+	if err := stream.ValidateValues(values); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return values, nil
+}
+
+// storeError returns NotFound for missing keys and Internal for other errors
+func storeError(err error, notFound string) error {
+
+	if err.Error() == analyzer.ErrJSONMissWithGoRedisClient && notFound != "" {
+		return status.Error(codes.NotFound, notFound)
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, span := tracer.Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *Server) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	_, span := tracer.Start(ss.Context(), info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	if err := s.authorize(ss.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+// authorize checks the bearer token of the authorization metadata
+func (s *Server) authorize(ctx context.Context) error {
+
+	if len(s.tokens) == 0 {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if !ok || token == "" {
+			continue
+		}
+
+		// compare with all tokens, so the time does not tell which one matched
+		authorized := 0
+		for _, t := range s.tokens {
+			authorized |= subtle.ConstantTimeCompare([]byte(t), []byte(token))
+		}
+		if authorized == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
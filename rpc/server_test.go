@@ -0,0 +1,334 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	analyzerv1 "github.com/stuttgart-things/sweatShop-analyzer/proto/analyzer/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+type enqueuerMock struct {
+	requests []map[string]interface{}
+}
+
+func (e *enqueuerMock) Enqueue(ctx context.Context, values map[string]interface{}) (string, error) {
+	e.requests = append(e.requests, values)
+	return fmt.Sprintf("job-%d", len(e.requests)), nil
+}
+
+// storeMock returns the states of job-1 one after another
+type storeMock struct {
+	mu      sync.Mutex
+	jobs    []*analyzer.JobStatus
+	result  *analyzer.AnalyzerResultValue
+	queries []*analyzer.ResultQuery
+}
+
+func (s *storeMock) GetJobStatus(ctx context.Context, jobID string) (*analyzer.JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jobID != "job-1" {
+		return nil, goredis.Nil
+	}
+
+	job := s.jobs[0]
+	if len(s.jobs) > 1 {
+		s.jobs = s.jobs[1:]
+	}
+
+	return job, nil
+}
+
+func (s *storeMock) GetAnalyzerResult(ctx context.Context, repoURL, revision string) (*analyzer.AnalyzerResultValue, error) {
+	if repoURL == "github.com/org/repo" && revision != "dev" {
+		return s.result, nil
+	}
+	return nil, errors.New(analyzer.ErrJSONMissWithGoRedisClient)
+}
+
+func (s *storeMock) GetAnalyzerResultAt(ctx context.Context, repoURL, commitId string) (*analyzer.AnalyzerResultValue, error) {
+	if commitId == "abc" {
+		return s.GetAnalyzerResult(ctx, repoURL, "")
+	}
+	return nil, errors.New(analyzer.ErrJSONMissWithGoRedisClient)
+}
+
+func (s *storeMock) QueryResults(ctx context.Context, q *analyzer.ResultQuery) (*analyzer.ResultPage, error) {
+	s.queries = append(s.queries, q)
+	return &analyzer.ResultPage{Total: 2, Offset: q.Offset, Results: []*analyzer.AnalyzerResultValue{s.result}}, nil
+}
+
+var testResult = &analyzer.AnalyzerResultValue{
+	Repo:       &analyzer.Repository{Name: "repo", Url: "https://github.com/org/repo.git", Revision: "main", Username: "bot", Password: "secret"},
+	Revision:   "main",
+	Commit:     "abc",
+	AnalyzedAt: time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC),
+	Results:    []*analyzer.TechAndPath{{Technology: "golang", Path: "."}},
+}
+
+// newTestClient serves the server on an in-memory connection
+func newTestClient(t *testing.T, s *Server) analyzerv1.AnalyzerServiceClient {
+
+	lis := bufconn.Listen(1 << 20)
+	srv := s.GRPCServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return analyzerv1.NewAnalyzerServiceClient(conn)
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestAnalyze(t *testing.T) {
+
+	var analyzed *analyzer.Repository
+	analyze := func(ctx context.Context, repo *analyzer.Repository) (*analyzer.AnalyzerResultValue, error) {
+		analyzed = repo
+		if repo.Revision == "slow" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return testResult, nil
+	}
+
+	s := NewServer(&enqueuerMock{}, &storeMock{}, analyze, nil)
+	client := newTestClient(t, s)
+
+	res, err := client.Analyze(context.Background(), &analyzerv1.AnalyzeRequest{Repository: &analyzerv1.Repository{
+		Url:                   "https://github.com/org/repo.git",
+		Revision:              "main",
+		Password:              "secret",
+		ForceCompleteAnalysis: proto.Bool(true),
+		History:               &analyzerv1.HistoryOptions{FirstParent: true, Sample: "week"},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", res.GetCommit())
+	assert.Equal(t, "github.com/org/repo", res.GetRepoId())
+	assert.Equal(t, []*analyzerv1.TechAndPath{{Technology: "golang", Path: "."}}, normalize(res.GetResults()))
+	assert.Equal(t, testResult.AnalyzedAt, res.GetAnalyzedAt().AsTime())
+	assert.Nil(t, res.GetCommittedAt())
+	assert.Equal(t, "bot", res.GetRepo().GetUsername())
+	assert.Empty(t, res.GetRepo().GetPassword())
+
+	force := true
+	assert.Equal(t, &analyzer.Repository{
+		Url:                   "https://github.com/org/repo.git",
+		Revision:              "main",
+		Password:              "secret",
+		ForceCompleteAnalysis: &force,
+		History:               &analyzer.HistoryOptions{FirstParent: true, Sample: analyzer.SampleWeek},
+	}, analyzed)
+
+	_, err = client.Analyze(context.Background(), &analyzerv1.AnalyzeRequest{Repository: &analyzerv1.Repository{Url: "https://github.com/org/repo.git"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the credential reference is passed on and returned
+	_, err = client.Analyze(context.Background(), &analyzerv1.AnalyzeRequest{Repository: &analyzerv1.Repository{
+		Url:           "https://github.com/org/repo.git",
+		Revision:      "main",
+		CredentialRef: "redis:github",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "redis:github", analyzed.CredentialRef)
+	assert.Equal(t, "redis:github", repositoryToProto(analyzed).GetCredentialRef())
+
+	_, err = client.Analyze(context.Background(), &analyzerv1.AnalyzeRequest{Repository: &analyzerv1.Repository{
+		Url:           "https://github.com/org/repo.git",
+		Revision:      "main",
+		Password:      "secret",
+		CredentialRef: "redis:github",
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the file system of the server is not analyzed
+	analyzed = nil
+	for _, url := range []string{"/", "file:///etc"} {
+		_, err = client.Analyze(context.Background(), &analyzerv1.AnalyzeRequest{Repository: &analyzerv1.Repository{Url: url, Revision: "main"}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), url)
+	}
+	assert.Nil(t, analyzed)
+
+	// the deadline of the call
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.Analyze(ctx, &analyzerv1.AnalyzeRequest{Repository: &analyzerv1.Repository{Url: "https://github.com/org/repo.git", Revision: "slow"}})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	// the deadline of the server, set before it serves
+	slow := NewServer(&enqueuerMock{}, &storeMock{}, func(ctx context.Context, _ *analyzer.Repository) (*analyzer.AnalyzerResultValue, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, nil)
+	slow.analyzeTimeout = 50 * time.Millisecond
+	_, err = newTestClient(t, slow).Analyze(context.Background(), &analyzerv1.AnalyzeRequest{Repository: &analyzerv1.Repository{Url: "https://github.com/org/repo.git", Revision: "slow"}})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestLockedAnalyzerCredentials(t *testing.T) {
+
+	// unresolvable credentials fail before the repository is locked
+	analyze := LockedAnalyzer(nil, nil, nil, &credentials.Resolver{})
+	_, err := analyze(context.Background(), &analyzer.Repository{Url: "https://github.com/org/repo.git", CredentialRef: "redis:github"})
+	assert.ErrorContains(t, err, `credential provider "redis" is not configured`)
+}
+
+func TestSubmitAndWatchJob(t *testing.T) {
+
+	producer := &enqueuerMock{}
+	queued := &analyzer.JobStatus{ID: "job-1", State: analyzer.JobQueued, UpdatedAt: time.Unix(1, 0)}
+	store := &storeMock{jobs: []*analyzer.JobStatus{
+		queued,
+		queued,
+		{ID: "job-1", State: analyzer.JobRunning, UpdatedAt: time.Unix(2, 0)},
+		{ID: "job-1", State: analyzer.JobSucceeded, Commit: "abc", UpdatedAt: time.Unix(3, 0)},
+	}}
+	s := NewServer(producer, store, nil, []string{"token"})
+	s.watchInterval = time.Millisecond
+	client := newTestClient(t, s)
+
+	res, err := client.Submit(withToken("token"), &analyzerv1.SubmitRequest{Repository: &analyzerv1.Repository{
+		Url:      "https://github.com/org/repo.git",
+		Revision: "main",
+		Insecure: true,
+		History:  &analyzerv1.HistoryOptions{},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "job-1", res.GetJobId())
+	assert.Equal(t, []map[string]interface{}{{
+		"url":                  "https://github.com/org/repo.git",
+		"revision":             "main",
+		"insecure":             "true",
+		"history":              "true",
+		"history_first_parent": "false",
+	}}, producer.requests)
+
+	_, err = client.Submit(withToken("token"), &analyzerv1.SubmitRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the file system of the server is not analyzed
+	_, err = client.Submit(withToken("token"), &analyzerv1.SubmitRequest{Repository: &analyzerv1.Repository{Url: "/", Revision: "main"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Len(t, producer.requests, 1)
+
+	watch, err := client.WatchJob(withToken("token"), &analyzerv1.WatchJobRequest{JobId: res.GetJobId()})
+	assert.NoError(t, err)
+
+	states := make([]analyzerv1.JobState, 0)
+	for {
+		job, err := watch.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		states = append(states, job.GetState())
+	}
+	// unchanged states are not sent again
+	assert.Equal(t, []analyzerv1.JobState{
+		analyzerv1.JobState_JOB_STATE_QUEUED,
+		analyzerv1.JobState_JOB_STATE_RUNNING,
+		analyzerv1.JobState_JOB_STATE_SUCCEEDED,
+	}, states)
+
+	watch, err = client.WatchJob(withToken("token"), &analyzerv1.WatchJobRequest{JobId: "job-2"})
+	assert.NoError(t, err)
+	_, err = watch.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGetAndListResults(t *testing.T) {
+
+	store := &storeMock{result: testResult}
+	client := newTestClient(t, NewServer(&enqueuerMock{}, store, nil, nil))
+
+	for _, req := range []*analyzerv1.GetResultRequest{
+		{Repository: "github.com/org/repo"},
+		{Repository: "github.com/org/repo", Revision: "main"},
+		{Repository: "github.com/org/repo", Revision: "dev", Commit: "abc"},
+	} {
+		res, err := client.GetResult(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, "abc", res.GetCommit())
+	}
+
+	_, err := client.GetResult(context.Background(), &analyzerv1.GetResultRequest{Repository: "github.com/org/repo", Revision: "dev"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.GetResult(context.Background(), &analyzerv1.GetResultRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	page, err := client.ListResults(context.Background(), &analyzerv1.ListResultsRequest{Technology: "golang", Repository: "github.com/org/*", Offset: 1, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), page.GetTotal())
+	assert.Equal(t, int32(1), page.GetOffset())
+	assert.Len(t, page.GetResults(), 1)
+	assert.Equal(t, &analyzer.ResultQuery{Technology: "golang", Repo: "github.com/org/*", Offset: 1, Limit: 1}, store.queries[0])
+
+	_, err = client.ListResults(context.Background(), &analyzerv1.ListResultsRequest{Limit: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAuthentication(t *testing.T) {
+
+	client := newTestClient(t, NewServer(&enqueuerMock{}, &storeMock{result: testResult}, nil, []string{"first", "second"}))
+	req := &analyzerv1.GetResultRequest{Repository: "github.com/org/repo"}
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		want codes.Code
+	}{
+		{context.Background(), codes.Unauthenticated},
+		{withToken("third"), codes.Unauthenticated},
+		{metadata.AppendToOutgoingContext(context.Background(), "authorization", "first"), codes.Unauthenticated},
+		{withToken("first"), codes.OK},
+		{withToken("second"), codes.OK},
+	} {
+		_, err := client.GetResult(tc.ctx, req)
+		assert.Equal(t, tc.want, status.Code(err))
+	}
+
+	watch, err := client.WatchJob(context.Background(), &analyzerv1.WatchJobRequest{JobId: "job-1"})
+	assert.NoError(t, err)
+	_, err = watch.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// normalize drops the internal state of received messages, to compare them
+func normalize(tps []*analyzerv1.TechAndPath) []*analyzerv1.TechAndPath {
+
+	res := make([]*analyzerv1.TechAndPath, 0, len(tps))
+	for _, tp := range tps {
+		res = append(res, &analyzerv1.TechAndPath{Technology: tp.GetTechnology(), Path: tp.GetPath()})
+	}
+
+	return res
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FileSink writes the latest result of each revision to a file
+// <dir>/<repository id>/<revision>.<format>, both path escaped
+type FileSink struct {
+	dir    string
+	format string
+}
+
+// NewFileSink returns a sink writing json or yaml files below dir
+func NewFileSink(dir, format string) (*FileSink, error) {
+
+	switch format {
+	case "":
+		format = "json"
+	case "json", "yaml":
+	default:
+		return nil, fmt.Errorf("unknown file format %q, expected json or yaml", format)
+	}
+
+	if dir == "" {
+		return nil, fmt.Errorf("no directory for the result files")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create result directory: %w", err)
+	}
+
+	return &FileSink{dir: dir, format: format}, nil
+}
+
+// Path returns the file of the latest result of a revision
+func (f *FileSink) Path(repoURL, revision string) string {
+	return filepath.Join(f.dir, url.PathEscape(analyzer.RepositoryID(repoURL)), url.PathEscape(revision)+"."+f.format)
+}
+
+func (f *FileSink) SetAnalyzerResult(ctx context.Context, result *analyzer.AnalyzerResultValue) error {
+
+	var data []byte
+	var err error
+	if f.format == "yaml" {
+		data, err = yaml.Marshal(result)
+	} else {
+		data, err = json.MarshalIndent(result, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("could not marshal result: %w", err)
+	}
+
+	path := f.Path(result.Repo.Url, result.Revision)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create result directory: %w", err)
+	}
+
+	// readers never see a partly written file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".result-*")
+	if err != nil {
+		return fmt.Errorf("could not create result file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write result file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write result file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write result file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not write result file: %w", err)
+	}
+
+	return nil
+}
+
+func (f *FileSink) Close() error {
+	return nil
+}
@@ -0,0 +1,293 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+
+	// database/sql drivers "pgx" and "sqlite"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// dialect holds the differences of the databases
+type dialect struct {
+	name   string
+	driver string
+	// types of the id and timestamp columns
+	id        string
+	timestamp string
+	// numbered placeholders $1, $2, ... instead of ?
+	numbered bool
+	// lock serializes the migrations of concurrent replicas, if needed
+	lock string
+}
+
+var (
+	sqlite = dialect{
+		name:      "sqlite",
+		driver:    "sqlite",
+		id:        "INTEGER PRIMARY KEY AUTOINCREMENT",
+		timestamp: "TIMESTAMP",
+	}
+	postgres = dialect{
+		name:      "postgres",
+		driver:    "pgx",
+		id:        "BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY",
+		timestamp: "TIMESTAMPTZ",
+		numbered:  true,
+		lock:      "SELECT pg_advisory_xact_lock(4208512031)",
+	}
+)
+
+// migrations create the normalized tables: a row per repository, per
+// analyzed commit of a revision and per technology and path of an analysis.
+// Migrations are applied in order and recorded in schema_migrations; an
+// applied migration must never change, a new schema gets a new migration.
+var migrations = [][]string{
+	{
+		`CREATE TABLE repositories (
+			id {{id}},
+			repo_id TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			url TEXT NOT NULL
+		)`,
+		`CREATE TABLE analyses (
+			id {{id}},
+			repository_id BIGINT NOT NULL REFERENCES repositories (id) ON DELETE CASCADE,
+			revision TEXT NOT NULL,
+			commit_id TEXT NOT NULL,
+			committed_at {{timestamp}},
+			analyzed_at {{timestamp}} NOT NULL,
+			UNIQUE (repository_id, revision, commit_id)
+		)`,
+		`CREATE INDEX analyses_analyzed_at ON analyses (repository_id, revision, analyzed_at)`,
+		`CREATE TABLE tech_paths (
+			analysis_id BIGINT NOT NULL REFERENCES analyses (id) ON DELETE CASCADE,
+			technology TEXT NOT NULL,
+			path TEXT NOT NULL,
+			PRIMARY KEY (analysis_id, technology, path)
+		)`,
+		`CREATE INDEX tech_paths_technology ON tech_paths (technology, path)`,
+	},
+	{
+		// the latest analysis of each revision
+		`CREATE VIEW latest_analyses AS
+			SELECT a.* FROM analyses a
+			WHERE a.analyzed_at = (
+				SELECT MAX(b.analyzed_at) FROM analyses b
+				WHERE b.repository_id = a.repository_id AND b.revision = a.revision
+			)`,
+	},
+}
+
+// SQLSink writes the results to normalized tables of a sqlite or postgresql
+// database
+type SQLSink struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// OpenSQLite opens or creates the sqlite database at path and migrates it
+func OpenSQLite(ctx context.Context, path string) (*SQLSink, error) {
+
+	if path == "" {
+		return nil, fmt.Errorf("no path of the sqlite database")
+	}
+
+	// a single connection, sqlite locks the whole database for writes
+	db, err := sql.Open(sqlite.driver, "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("could not open sqlite database %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	return newSQLSink(ctx, db, sqlite)
+}
+
+// OpenPostgres connects to the postgresql database of the dsn and migrates it
+func OpenPostgres(ctx context.Context, dsn string) (*SQLSink, error) {
+
+	db, err := sql.Open(postgres.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open postgres database %s: %w", Redact(dsn), err)
+	}
+
+	return newSQLSink(ctx, db, postgres)
+}
+
+func newSQLSink(ctx context.Context, db *sql.DB, d dialect) (*SQLSink, error) {
+
+	s := &SQLSink{db: db, dialect: d}
+
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// migrate applies the missing migrations, each in a transaction
+func (s *SQLSink) migrate(ctx context.Context) error {
+
+	_, err := s.db.ExecContext(ctx, s.sql(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at {{timestamp}} NOT NULL
+	)`))
+	if err != nil {
+		return fmt.Errorf("could not create %s schema_migrations: %w", s.dialect.name, err)
+	}
+
+	for i, stmts := range migrations {
+		if err := s.applyMigration(ctx, i+1, stmts); err != nil {
+			return fmt.Errorf("could not apply %s migration %d: %w", s.dialect.name, i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLSink) applyMigration(ctx context.Context, version int, stmts []string) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if s.dialect.lock != "" {
+		if _, err := tx.ExecContext(ctx, s.dialect.lock); err != nil {
+			return err
+		}
+	}
+
+	var applied int
+	err = tx.QueryRowContext(ctx, s.sql(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), version).Scan(&applied)
+	if err != nil || applied > 0 {
+		return err
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, s.sql(stmt)); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, s.sql(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), version, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SchemaVersion returns the version of the last applied migration
+func (s *SQLSink) SchemaVersion(ctx context.Context) (int, error) {
+
+	var version sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+
+	return int(version.Int64), err
+}
+
+// SetAnalyzerResult stores the analysis of the commit of the revision and
+// replaces its technologies and paths, if the commit was analyzed before
+func (s *SQLSink) SetAnalyzerResult(ctx context.Context, result *analyzer.AnalyzerResultValue) error {
+
+	analyzedAt := result.AnalyzedAt
+	if analyzedAt.IsZero() {
+		analyzedAt = time.Now()
+	}
+	committedAt := sql.NullTime{Time: result.CommittedAt.UTC(), Valid: !result.CommittedAt.IsZero()}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var repoID int64
+	err = tx.QueryRowContext(ctx, s.sql(`INSERT INTO repositories (repo_id, name, url) VALUES (?, ?, ?)
+		ON CONFLICT (repo_id) DO UPDATE SET name = excluded.name, url = excluded.url
+		RETURNING id`),
+		result.Repo.ID(), result.Repo.Name, result.Repo.Url,
+	).Scan(&repoID)
+	if err != nil {
+		return fmt.Errorf("could not store repository %s: %w", result.Repo.ID(), err)
+	}
+
+	var analysisID int64
+	err = tx.QueryRowContext(ctx, s.sql(`INSERT INTO analyses (repository_id, revision, commit_id, committed_at, analyzed_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (repository_id, revision, commit_id) DO UPDATE SET committed_at = excluded.committed_at, analyzed_at = excluded.analyzed_at
+		RETURNING id`),
+		repoID, result.Revision, result.Commit, committedAt, analyzedAt.UTC(),
+	).Scan(&analysisID)
+	if err != nil {
+		return fmt.Errorf("could not store analysis of %s: %w", result.Repo.ID(), err)
+	}
+
+	if _, err := tx.ExecContext(ctx, s.sql(`DELETE FROM tech_paths WHERE analysis_id = ?`), analysisID); err != nil {
+		return fmt.Errorf("could not replace paths of %s: %w", result.Repo.ID(), err)
+	}
+
+	insert, err := tx.PrepareContext(ctx, s.sql(`INSERT INTO tech_paths (analysis_id, technology, path) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`))
+	if err != nil {
+		return fmt.Errorf("could not store paths of %s: %w", result.Repo.ID(), err)
+	}
+	defer insert.Close()
+
+	for _, tp := range result.Results {
+		if _, err := insert.ExecContext(ctx, analysisID, tp.Technology, tp.Path); err != nil {
+			return fmt.Errorf("could not store paths of %s: %w", result.Repo.ID(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit result of %s: %w", result.Repo.ID(), err)
+	}
+
+	return nil
+}
+
+// DB returns the database, e.g. to query the results
+func (s *SQLSink) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLSink) Close() error {
+	return s.db.Close()
+}
+
+// sql fills the column types of the dialect into a statement and numbers its
+// placeholders, if the dialect needs it
+func (s *SQLSink) sql(stmt string) string {
+
+	stmt = strings.NewReplacer("{{id}}", s.dialect.id, "{{timestamp}}", s.dialect.timestamp).Replace(stmt)
+
+	if !s.dialect.numbered {
+		return stmt
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range stmt {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,155 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+func testResult(commit string, analyzedAt time.Time, paths ...*analyzer.TechAndPath) *analyzer.AnalyzerResultValue {
+	return &analyzer.AnalyzerResultValue{
+		Repo:        &analyzer.Repository{Name: "repo", Url: "https://github.com/org/repo.git", Revision: "main", Password: "secret"},
+		Revision:    "main",
+		Commit:      commit,
+		CommittedAt: analyzedAt.Add(-time.Hour),
+		AnalyzedAt:  analyzedAt,
+		Results:     paths,
+	}
+}
+
+// testSQLSink writes results and checks the rows of the normalized tables
+func testSQLSink(t *testing.T, open func() (*SQLSink, error)) {
+
+	ctx := context.Background()
+	s, err := open()
+	require.NoError(t, err)
+
+	version, err := s.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	now := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	golang := &analyzer.TechAndPath{Technology: "golang", Path: "."}
+	docker := &analyzer.TechAndPath{Technology: "docker", Path: "build"}
+
+	assert.NoError(t, s.SetAnalyzerResult(ctx, testResult("abc", now, golang, docker)))
+	assert.NoError(t, s.SetAnalyzerResult(ctx, testResult("def", now.Add(time.Hour), golang)))
+	// the same commit again replaces its paths
+	assert.NoError(t, s.SetAnalyzerResult(ctx, testResult("def", now.Add(2*time.Hour), golang, docker)))
+
+	count := func(query string) int {
+		var n int
+		assert.NoError(t, s.DB().QueryRowContext(ctx, query).Scan(&n))
+		return n
+	}
+	assert.Equal(t, 1, count(`SELECT COUNT(*) FROM repositories`))
+	assert.Equal(t, 2, count(`SELECT COUNT(*) FROM analyses`))
+	assert.Equal(t, 4, count(`SELECT COUNT(*) FROM tech_paths`))
+
+	var repoID, commit string
+	var analyzedAt, committedAt time.Time
+	err = s.DB().QueryRowContext(ctx, `SELECT r.repo_id, a.commit_id, a.analyzed_at, a.committed_at
+		FROM latest_analyses a JOIN repositories r ON r.id = a.repository_id`).Scan(&repoID, &commit, &analyzedAt, &committedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, "github.com/org/repo", repoID)
+	assert.Equal(t, "def", commit)
+	assert.True(t, now.Add(2*time.Hour).Equal(analyzedAt), analyzedAt)
+	assert.True(t, now.Add(time.Hour).Equal(committedAt), committedAt)
+
+	// a plain directory has no commit time
+	plain := testResult("", now)
+	plain.CommittedAt = time.Time{}
+	assert.NoError(t, s.SetAnalyzerResult(ctx, plain))
+	assert.Equal(t, 1, count(`SELECT COUNT(*) FROM analyses WHERE committed_at IS NULL`))
+
+	// migrating again is a no-op
+	assert.NoError(t, s.Close())
+	s, err = open()
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 3, count(`SELECT COUNT(*) FROM analyses`))
+}
+
+func TestSQLite(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "results.db")
+	testSQLSink(t, func() (*SQLSink, error) {
+		return OpenSQLite(context.Background(), path)
+	})
+
+	// foreign keys are enforced
+	s, err := OpenSQLite(context.Background(), path)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.DB().Exec(`INSERT INTO tech_paths (analysis_id, technology, path) VALUES (42, 'golang', '.')`)
+	assert.Error(t, err)
+}
+
+// TestPostgres runs against the database of POSTGRES_TEST_DSN or an embedded
+// postgres, whose binaries are downloaded on the first run
+func TestPostgres(t *testing.T) {
+
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		dsn = startPostgres(t)
+	}
+
+	testSQLSink(t, func() (*SQLSink, error) {
+		return OpenPostgres(context.Background(), dsn)
+	})
+}
+
+func startPostgres(t *testing.T) string {
+
+	if testing.Short() {
+		t.Skip("no embedded postgres in short mode")
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := uint32(lis.Addr().(*net.TCPAddr).Port)
+	lis.Close()
+
+	dir := t.TempDir()
+	logs := &bytes.Buffer{}
+	config := embeddedpostgres.DefaultConfig().
+		Port(port).
+		RuntimePath(filepath.Join(dir, "runtime")).
+		DataPath(filepath.Join(dir, "data")).
+		StartTimeout(time.Minute).
+		Logger(logs)
+
+	pg := embeddedpostgres.NewDatabase(config)
+	if err := pg.Start(); err != nil {
+		t.Skipf("could not start embedded postgres, set POSTGRES_TEST_DSN: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pg.Stop(); err != nil {
+			t.Log(err)
+		}
+	})
+
+	dsn := config.GetConnectionURL() + "?sslmode=disable"
+
+	// the server accepts connections shortly after the start
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Eventually(t, func() bool { return db.Ping() == nil }, 10*time.Second, 100*time.Millisecond)
+
+	return dsn
+}
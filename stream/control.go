@@ -0,0 +1,104 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stuttgart-things/redisqueue"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"github.com/stuttgart-things/sweatShop-analyzer/utils/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ControlStream receives administrative commands for the analyzer
+const ControlStream = schema.ControlStream
+
+// fields of a control message
+const (
+	FieldCommand = "command"
+	FieldPattern = "pattern"
+)
+
+// control commands
+const (
+	// CommandInvalidate removes the cached results of the url, optionally only
+	// those of the revision and commit
+	CommandInvalidate = "invalidate"
+	// CommandPurge removes the cached results of all urls matching the pattern
+	CommandPurge = "purge"
+)
+
+type cacheAdmin interface {
+	Invalidate(ctx context.Context, repoURL, revision, commitId string) (bool, error)
+	Purge(ctx context.Context, urlPattern string) (int, error)
+}
+
+func processControl(msg *redisqueue.Message) error {
+
+	ctx := tracing.ExtractFromMessage(context.Background(), msg.Values)
+	ctx, span := tracer.Start(ctx, "processControl", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		attribute.String("messaging.system", "redis"),
+		attribute.String("messaging.source.name", msg.Stream),
+		attribute.String("messaging.message.id", msg.ID),
+	))
+	defer span.End()
+
+	// LOCAL CACHES ONLY HOLD THE ENTRIES OF THE REPLICA RECEIVING THE COMMAND
+	ac, ok := analyzerCache.(cacheAdmin)
+	if !ok {
+		log.Warnf("CACHE BACKEND HAS NO ENTRIES, IGNORING CONTROL COMMAND %v", msg.Values[FieldCommand])
+		return nil
+	}
+
+	err := executeControl(ctx, ac, msg.Values)
+	if err != nil {
+		span.RecordError(err)
+		log.Errorf("INVALID CONTROL COMMAND RECEIVED: %s", err.Error())
+	}
+
+	// invalid commands would fail again, so they are acknowledged anyway
+	return nil
+}
+
+func executeControl(ctx context.Context, ac cacheAdmin, values map[string]interface{}) error {
+
+	command, _ := values[FieldCommand].(string)
+	repoURL, _ := values[FieldURL].(string)
+	revision, _ := values[FieldRevision].(string)
+	commit, _ := values[FieldCommit].(string)
+	pattern, _ := values[FieldPattern].(string)
+
+	switch command {
+	case CommandInvalidate:
+		if repoURL == "" {
+			return fmt.Errorf("no url received for %s", command)
+		}
+
+		removed, err := ac.Invalidate(ctx, repoURL, revision, commit)
+		if err != nil {
+			return err
+		}
+		log.Infof("INVALIDATED CACHE OF %s %s %s: %t", repoURL, revision, commit, removed)
+
+	case CommandPurge:
+		if pattern == "" {
+			return fmt.Errorf("no pattern received for %s", command)
+		}
+
+		purged, err := ac.Purge(ctx, pattern)
+		if err != nil {
+			return err
+		}
+		log.Infof("PURGED %d CACHE ENTRIES MATCHING %s", purged, pattern)
+
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type cacheAdminMock struct {
+	invalidated []string
+	purged      []string
+}
+
+func (m *cacheAdminMock) Invalidate(ctx context.Context, repoURL, revision, commitId string) (bool, error) {
+	m.invalidated = append(m.invalidated, repoURL+"@"+revision+"@"+commitId)
+	return true, nil
+}
+
+func (m *cacheAdminMock) Purge(ctx context.Context, urlPattern string) (int, error) {
+	m.purged = append(m.purged, urlPattern)
+	return 1, nil
+}
+
+func Test_executeControl(t *testing.T) {
+
+	ac := &cacheAdminMock{}
+	ctx := context.Background()
+
+	assert.NoError(t, executeControl(ctx, ac, map[string]interface{}{
+		FieldCommand:  CommandInvalidate,
+		FieldURL:      "https://github.com/fluxcd/flux2",
+		FieldRevision: "main",
+		FieldCommit:   "my-commit-id",
+	}))
+	assert.NoError(t, executeControl(ctx, ac, map[string]interface{}{
+		FieldCommand: CommandPurge,
+		FieldPattern: "*",
+	}))
+	assert.Equal(t, []string{"https://github.com/fluxcd/flux2@main@my-commit-id"}, ac.invalidated)
+	assert.Equal(t, []string{"*"}, ac.purged)
+
+	assert.Error(t, executeControl(ctx, ac, map[string]interface{}{FieldCommand: CommandInvalidate}))
+	assert.Error(t, executeControl(ctx, ac, map[string]interface{}{FieldCommand: CommandPurge}))
+	assert.Error(t, executeControl(ctx, ac, map[string]interface{}{FieldCommand: "flushall"}))
+}
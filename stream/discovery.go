@@ -0,0 +1,276 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	"github.com/stuttgart-things/sweatShop-analyzer/discovery"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// enqueuer adds analysis requests to the analyze stream
+type enqueuer interface {
+	Enqueue(ctx context.Context, values map[string]interface{}) (string, error)
+}
+
+// fanOutProducer enqueues the analysis requests of discovery jobs, schedules
+// and webhooks, if it could be created
+var fanOutProducer enqueuer
+
+// newProvider creates the forge provider of a discovery request
+var newProvider = func(kind, baseURL, token string) (discovery.Provider, error) {
+	return discovery.NewProvider(kind, baseURL, token, http.DefaultClient)
+}
+
+// validateDiscoveryValues checks the values of a discovery request message
+func validateDiscoveryValues(values map[string]interface{}) error {
+
+	for _, key := range requiredDiscoveryFields {
+		if values[key] == nil {
+			return fmt.Errorf("no %s received", key)
+		}
+	}
+
+	if _, err := discovery.NewProvider(values[FieldProvider].(string), "", "", nil); err != nil {
+		return err
+	}
+
+	if values[FieldPassword] != nil {
+		return fmt.Errorf("%s is not passed on to the discovered repositories, reference the credential with %s", FieldPassword, FieldCredentialRef)
+	}
+
+	if values[FieldToken] != nil && values[FieldTokenRef] != nil {
+		return fmt.Errorf("%s and %s are exclusive", FieldToken, FieldTokenRef)
+	}
+	if ref, ok := values[FieldTokenRef].(string); ok {
+		if _, _, err := credentials.ParseRef(ref); err != nil {
+			return err
+		}
+	}
+
+	if u, _ := values[FieldProviderURL].(string); u != "" {
+		if _, err := url.ParseRequestURI(u); err != nil {
+			return fmt.Errorf("invalid provider url received: %s", u)
+		}
+	}
+
+	for _, key := range regexpFields {
+		if re, _ := values[key].(string); re != "" {
+			if _, err := regexp.Compile(re); err != nil {
+				return fmt.Errorf("invalid regular expression received for %s: %w", key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// discoveryFilter builds the filter of validated message values
+func discoveryFilter(values map[string]interface{}) *discovery.Filter {
+
+	str := func(key string) string {
+		s, _ := values[key].(string)
+		return s
+	}
+
+	f := &discovery.Filter{}
+	f.IncludeArchived, _ = strconv.ParseBool(str(FieldIncludeArchived))
+	f.IncludeForks, _ = strconv.ParseBool(str(FieldIncludeForks))
+
+	if re := str(FieldInclude); re != "" {
+		f.Include = regexp.MustCompile(re)
+	}
+	if re := str(FieldExclude); re != "" {
+		f.Exclude = regexp.MustCompile(re)
+	}
+
+	for _, topic := range strings.Split(str(FieldTopics), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			f.Topics = append(f.Topics, topic)
+		}
+	}
+
+	return f
+}
+
+// analysisRequest returns the analysis request of a discovered repository,
+// with the analysis fields of the discovery request
+func analysisRequest(values map[string]interface{}, repo *discovery.Repository) map[string]interface{} {
+
+	request := map[string]interface{}{
+		FieldURL:      repo.CloneURL,
+		FieldRevision: repo.DefaultBranch,
+		FieldName:     repo.Name,
+	}
+
+	for _, key := range forwardedFields {
+		if v, ok := values[key]; ok {
+			request[key] = v
+		}
+	}
+
+	return request
+}
+
+// childJobID returns the job id of the analysis of a discovered repository,
+// the same for every delivery of the discovery job
+func childJobID(parentID string, repo *discovery.Repository) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(parentID+"/"+repo.CloneURL)).String()
+}
+
+// onceEnqueuer skips the requests of existing jobs, the children a former
+// delivery of a discovery job enqueued before it was interrupted
+type onceEnqueuer struct {
+	enqueuer
+	ajh *analyzer.AnalyzerJSONHandler
+}
+
+func (e onceEnqueuer) Enqueue(ctx context.Context, values map[string]interface{}) (string, error) {
+
+	if id, _ := values[FieldJobID].(string); id != "" {
+		if _, err := e.ajh.GetJobStatus(ctx, id); err == nil {
+			log.Infof("JOB %s IS ENQUEUED ALREADY", id)
+			return id, nil
+		}
+	}
+
+	return e.enqueuer.Enqueue(ctx, values)
+}
+
+// discoveryToken returns the forge api token of a discovery request, the
+// token or the password of the credential referenced by token_ref. The
+// reference is only resolved if it is bound to the host of the forge.
+func discoveryToken(ctx context.Context, values map[string]interface{}, resolver analyzer.CredentialResolver) (string, error) {
+
+	ref, _ := values[FieldTokenRef].(string)
+	if ref == "" {
+		token, _ := values[FieldToken].(string)
+		return token, nil
+	}
+
+	if resolver == nil {
+		return "", fmt.Errorf("could not resolve token %s: no credential providers configured", ref)
+	}
+
+	kind, _ := values[FieldProvider].(string)
+	baseURL, _ := values[FieldProviderURL].(string)
+
+	c, err := resolver.ResolveFor(ctx, ref, discovery.BaseURL(kind, baseURL))
+	if err != nil {
+		return "", fmt.Errorf("could not resolve token: %w", err)
+	}
+
+	return c.Password, nil
+}
+
+// discover lists the repositories of a discovery request and enqueues an
+// analysis request for each of them passing the filter. The token reference
+// is resolved by the resolver. It returns the ids of the enqueued jobs.
+func discover(ctx context.Context, values map[string]interface{}, producer enqueuer, resolver analyzer.CredentialResolver) (_ []string, err error) {
+
+	kind, _ := values[FieldProvider].(string)
+	owner, _ := values[FieldOwner].(string)
+
+	ctx, span := tracer.Start(ctx, "discover", trace.WithAttributes(
+		attribute.String("discovery.provider", kind),
+		attribute.String("discovery.owner", owner),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	baseURL, _ := values[FieldProviderURL].(string)
+	token, err := discoveryToken(ctx, values, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := newProvider(kind, baseURL, token)
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := provider.ListRepositories(ctx, owner)
+	if err != nil {
+		return nil, fmt.Errorf("could not list repositories of %s: %w", owner, err)
+	}
+
+	matching := discoveryFilter(values).Apply(repos)
+	span.SetAttributes(
+		attribute.Int("discovery.repositories", len(repos)),
+		attribute.Int("discovery.matching", len(matching)),
+	)
+	log.Infof("DISCOVERED %d REPOSITORIES OF %s, %d MATCHING", len(repos), owner, len(matching))
+
+	jobIDs := make([]string, 0, len(matching))
+	for _, repo := range matching {
+		// empty repositories have no default branch to analyze
+		if repo.CloneURL == "" || repo.DefaultBranch == "" {
+			log.Warnf("SKIPPING DISCOVERED REPOSITORY %s WITHOUT CLONE URL OR DEFAULT BRANCH", repo.FullName)
+			continue
+		}
+
+		request := analysisRequest(values, repo)
+		if parentID, _ := values[FieldJobID].(string); parentID != "" {
+			request[FieldJobID] = childJobID(parentID, repo)
+		}
+
+		id, err := producer.Enqueue(ctx, request)
+		if err != nil {
+			return jobIDs, fmt.Errorf("could not enqueue analysis of %s: %w", repo.FullName, err)
+		}
+		jobIDs = append(jobIDs, id)
+	}
+
+	return jobIDs, nil
+}
+
+// processDiscovery handles a validated discovery request message
+func processDiscovery(ctx context.Context, ajh *analyzer.AnalyzerJSONHandler, job *analyzer.JobStatus, values map[string]interface{}) error {
+
+	if fanOutProducer == nil {
+		err := fmt.Errorf("no producer to enqueue the discovered repositories")
+		log.Errorf("COULD NOT PROCESS DISCOVERY: %s", err.Error())
+		return err
+	}
+
+	job.State = analyzer.JobRunning
+	updateJob(ctx, ajh, job)
+
+	// the failed job is complete, its message is acknowledged instead of delivered again
+	jobIDs, err := discover(ctx, values, onceEnqueuer{enqueuer: fanOutProducer, ajh: ajh}, credentialResolver)
+	job.Children = jobIDs
+	if err != nil {
+		log.Errorf("COULD NOT DISCOVER REPOSITORIES: %s", err.Error())
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		job.State = analyzer.JobFailed
+		job.Error = err.Error()
+		completeJob(ctx, ajh, job)
+		return nil
+	}
+
+	job.State = analyzer.JobSucceeded
+	completeJob(ctx, ajh, job)
+
+	return nil
+}
@@ -0,0 +1,335 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisserver "github.com/alicebob/miniredis/v2/server"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/nats-io/nats.go"
+	"github.com/nitishm/go-rejson/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+)
+
+type enqueuerMock struct {
+	requests []map[string]interface{}
+}
+
+func (e *enqueuerMock) Enqueue(ctx context.Context, values map[string]interface{}) (string, error) {
+	if err := ValidateValues(values); err != nil {
+		return "", err
+	}
+	e.requests = append(e.requests, values)
+	return fmt.Sprintf("job-%d", len(e.requests)), nil
+}
+
+func TestValidateDiscoveryValues(t *testing.T) {
+
+	assert.NoError(t, ValidateValues(map[string]interface{}{
+		FieldJobType:  JobTypeDiscovery,
+		FieldProvider: "gitlab",
+		FieldOwner:    "group/subgroup",
+		FieldInclude:  "^app-",
+	}))
+
+	for _, invalid := range []map[string]interface{}{
+		{FieldJobType: "cleanup", FieldURL: "https://github.com/fluxcd/flux2", FieldRevision: "main"},
+		{FieldJobType: JobTypeDiscovery, FieldProvider: "github"},
+		{FieldJobType: JobTypeDiscovery, FieldProvider: "bitbucket", FieldOwner: "org"},
+		{FieldJobType: JobTypeDiscovery, FieldProvider: "github", FieldOwner: "org", FieldExclude: "(["},
+		{FieldJobType: JobTypeDiscovery, FieldProvider: "github", FieldOwner: "org", FieldIncludeForks: "maybe"},
+		{FieldJobType: JobTypeDiscovery, FieldProvider: "github", FieldOwner: "org", FieldProviderURL: "no url"},
+		{FieldJobType: JobTypeDiscovery, FieldProvider: "github", FieldOwner: "org", FieldPassword: "clone-secret"},
+		{FieldJobType: JobTypeDiscovery, FieldProvider: "github", FieldOwner: "org", FieldToken: "secret", FieldTokenRef: "env:CREDENTIAL_TOKEN"},
+		{FieldJobType: JobTypeDiscovery, FieldProvider: "github", FieldOwner: "org", FieldTokenRef: "token"},
+	} {
+		assert.Error(t, ValidateValues(invalid), invalid)
+	}
+}
+
+func Test_discover(t *testing.T) {
+
+	// stub github organization
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orgs/org/repos" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `[
+			{"name": "api", "full_name": "org/api", "clone_url": "https://github.com/org/api.git", "default_branch": "main", "topics": ["backend"]},
+			{"name": "web", "full_name": "org/web", "clone_url": "https://github.com/org/web.git", "default_branch": "develop", "topics": ["frontend"]},
+			{"name": "old", "full_name": "org/old", "clone_url": "https://github.com/org/old.git", "default_branch": "main", "archived": true},
+			{"name": "empty", "full_name": "org/empty", "clone_url": "https://github.com/org/empty.git"}
+		]`)
+	}))
+	defer srv.Close()
+
+	values := map[string]interface{}{
+		FieldJobType:       JobTypeDiscovery,
+		FieldProvider:      "github",
+		FieldProviderURL:   srv.URL,
+		FieldOwner:         "org",
+		FieldToken:         "secret",
+		FieldExclude:       "^web$",
+		FieldUsername:      "bot",
+		FieldCredentialRef: "redis:github",
+		FieldHistory:       "true",
+	}
+	assert.NoError(t, ValidateValues(values))
+
+	// the archived, the excluded and the empty repository are skipped
+	producer := &enqueuerMock{}
+	jobIDs, err := discover(context.Background(), values, producer, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"job-1"}, jobIDs)
+	assert.Equal(t, []map[string]interface{}{
+		{
+			FieldURL:           "https://github.com/org/api.git",
+			FieldRevision:      "main",
+			FieldName:          "api",
+			FieldUsername:      "bot",
+			FieldCredentialRef: "redis:github",
+			FieldHistory:       "true",
+		},
+	}, producer.requests)
+
+	// the token is resolved from its reference
+	t.Setenv("CREDENTIAL_FORGE_TOKEN", "secret")
+	resolver := &credentials.Resolver{Providers: map[string]credentials.Provider{credentials.ProviderEnv: credentials.EnvProvider{}}}
+	delete(values, FieldToken)
+	values[FieldTokenRef] = "env:CREDENTIAL_FORGE_TOKEN"
+	assert.NoError(t, ValidateValues(values))
+
+	// only if it is bound to the host of the forge
+	_, err = discover(context.Background(), values, &enqueuerMock{}, resolver)
+	assert.ErrorContains(t, err, "is not bound to host")
+
+	resolver.HostRefs = map[string]string{"127.0.0.1": "env:CREDENTIAL_FORGE_TOKEN"}
+	producer = &enqueuerMock{}
+	_, err = discover(context.Background(), values, producer, resolver)
+	assert.NoError(t, err)
+	assert.Len(t, producer.requests, 1)
+	for _, request := range producer.requests {
+		assert.NotContains(t, request, FieldTokenRef)
+	}
+
+	// the reference is not resolved without providers
+	_, err = discover(context.Background(), values, &enqueuerMock{}, nil)
+	assert.ErrorContains(t, err, "no credential providers configured")
+
+	// topics and archived repositories
+	values[FieldExclude] = ""
+	values[FieldTopics] = "frontend, archive"
+	producer = &enqueuerMock{}
+	_, err = discover(context.Background(), values, producer, resolver)
+	assert.NoError(t, err)
+	assert.Len(t, producer.requests, 1)
+	assert.Equal(t, "https://github.com/org/web.git", producer.requests[0][FieldURL])
+	assert.Equal(t, "develop", producer.requests[0][FieldRevision])
+
+	// the children of a discovery job have the same ids on every delivery
+	values[FieldJobID] = "discovery-1"
+	producer = &enqueuerMock{}
+	_, err = discover(context.Background(), values, producer, resolver)
+	assert.NoError(t, err)
+	_, err = discover(context.Background(), values, producer, resolver)
+	assert.NoError(t, err)
+	assert.Len(t, producer.requests, 2)
+	assert.NotEmpty(t, producer.requests[0][FieldJobID])
+	assert.Equal(t, producer.requests[0][FieldJobID], producer.requests[1][FieldJobID])
+	delete(values, FieldJobID)
+
+	// unknown owner
+	values[FieldOwner] = "nobody"
+	_, err = discover(context.Background(), values, producer, resolver)
+	assert.Error(t, err)
+}
+
+// withJSON adds the json commands used by the json handler to miniredis,
+// storing the documents as strings
+func withJSON(t *testing.T, m *miniredis.Miniredis) {
+
+	require.NoError(t, m.Server().Register("JSON.SET", func(c *redisserver.Peer, cmd string, args []string) {
+		if len(args) < 3 {
+			c.WriteError("ERR wrong number of arguments")
+			return
+		}
+		require.NoError(t, m.Set(args[0], args[2]))
+		c.WriteOK()
+	}))
+	require.NoError(t, m.Server().Register("JSON.GET", func(c *redisserver.Peer, cmd string, args []string) {
+		v, err := m.Get(args[0])
+		if err != nil {
+			c.WriteNull()
+			return
+		}
+		c.WriteBulk(v)
+	}))
+}
+
+func TestNATSDiscovery(t *testing.T) {
+
+	ctx := context.Background()
+
+	// the discovered repositories are local, below ANALYZER_LOCAL_ROOTS
+	root := t.TempDir()
+	t.Setenv("ANALYZER_LOCAL_ROOTS", root)
+	for _, name := range []string{"api", "web"} {
+		r, err := git.PlainInit(filepath.Join(root, name), false)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, name, "go.mod"), []byte("module "+name+"\n"), 0o600))
+		w, err := r.Worktree()
+		require.NoError(t, err)
+		_, err = w.Add("go.mod")
+		require.NoError(t, err)
+		_, err = w.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+		require.NoError(t, err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orgs/org/repos" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `[
+			{"name": "api", "full_name": "org/api", "clone_url": %q, "default_branch": "master"},
+			{"name": "web", "full_name": "org/web", "clone_url": %q, "default_branch": "master"}
+		]`, filepath.Join(root, "api"), filepath.Join(root, "web"))
+	}))
+	defer srv.Close()
+
+	m := miniredis.RunT(t)
+	withJSON(t, m)
+	client := goredis.NewClient(&goredis.Options{Addr: m.Addr()})
+	rh := rejson.NewReJSONHandler()
+	rh.SetGoRedisClientWithContext(ctx, client)
+
+	savedRedis, savedCache, savedProducer := redisUtil, analyzerCache, fanOutProducer
+	t.Cleanup(func() { redisUtil, analyzerCache, fanOutProducer = savedRedis, savedCache, savedProducer })
+	redisUtil = &redisutil.Redis{Client: client, JSONHandler: rh}
+	analyzerCache = analyzer.NewMemoryAnalyzerCache(10, time.Hour)
+
+	// the poller and the producers of a JOB_SOURCE=nats environment
+	url := runNATSServer(t)
+	t.Setenv("JOB_SOURCE", JobSourceNATS)
+	t.Setenv("NATS_URL", url)
+
+	producer, err := ProducerFromEnv(redisUtil)
+	require.NoError(t, err)
+	defer producer.Close()
+	fanOutProducer = producer
+
+	source, err := jobSourceFromEnv()
+	require.NoError(t, err)
+	source.Register(AnalyzeStream, processStreams)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		source.Run()
+	}()
+	defer func() {
+		source.Shutdown()
+		<-done
+	}()
+
+	jobID, err := producer.Enqueue(ctx, map[string]interface{}{
+		FieldJobType:     JobTypeDiscovery,
+		FieldProvider:    "github",
+		FieldProviderURL: srv.URL,
+		FieldOwner:       "org",
+	})
+	require.NoError(t, err)
+
+	// the discovery job succeeds and its children are analyzed
+	ajh := analyzer.NewAnalyzerJSONHandlerWithClient(rh, client)
+	var children []string
+	require.Eventually(t, func() bool {
+		job, err := ajh.GetJobStatus(ctx, jobID)
+		if err != nil || job.State != analyzer.JobSucceeded {
+			return false
+		}
+		children = job.Children
+		return true
+	}, 20*time.Second, 50*time.Millisecond)
+	require.Len(t, children, 2)
+
+	for _, id := range children {
+		require.Eventually(t, func() bool {
+			job, err := ajh.GetJobStatus(ctx, id)
+			return err == nil && job.State.Done()
+		}, 20*time.Second, 50*time.Millisecond, id)
+
+		job, err := ajh.GetJobStatus(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, analyzer.JobSucceeded, job.State, job.Error)
+		assert.NotEmpty(t, job.Commit)
+	}
+	// a failed discovery job is acknowledged, not delivered again
+	jobID, err = producer.Enqueue(ctx, map[string]interface{}{
+		FieldJobType:     JobTypeDiscovery,
+		FieldProvider:    "github",
+		FieldProviderURL: srv.URL,
+		FieldOwner:       "nobody",
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err := ajh.GetJobStatus(ctx, jobID)
+		return err == nil && job.State == analyzer.JobFailed
+	}, 20*time.Second, 50*time.Millisecond)
+
+	nc, err := nats.Connect(url)
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		consumer, err := js.ConsumerInfo("sweatShop", "sweatShop-analyzer_sweatShop_analyze")
+		return err == nil && consumer.NumAckPending == 0 && consumer.NumPending == 0
+	}, 10*time.Second, 50*time.Millisecond)
+	consumer, err := js.ConsumerInfo("sweatShop", "sweatShop-analyzer_sweatShop_analyze")
+	require.NoError(t, err)
+	assert.Zero(t, consumer.NumRedelivered)
+}
+
+func Test_onceEnqueuer(t *testing.T) {
+
+	ctx := context.Background()
+	m := miniredis.RunT(t)
+	withJSON(t, m)
+	client := goredis.NewClient(&goredis.Options{Addr: m.Addr()})
+	rh := rejson.NewReJSONHandler()
+	rh.SetGoRedisClientWithContext(ctx, client)
+	ajh := analyzer.NewAnalyzerJSONHandlerWithClient(rh, client)
+	require.NoError(t, ajh.SetJobStatus(ctx, &analyzer.JobStatus{ID: "known", State: analyzer.JobSucceeded}))
+
+	producer := &enqueuerMock{}
+	e := onceEnqueuer{enqueuer: producer, ajh: ajh}
+
+	// the job of a former delivery is not enqueued again
+	id, err := e.Enqueue(ctx, map[string]interface{}{FieldJobID: "known", FieldURL: "https://github.com/org/api.git", FieldRevision: "main"})
+	assert.NoError(t, err)
+	assert.Equal(t, "known", id)
+	assert.Empty(t, producer.requests)
+
+	_, err = e.Enqueue(ctx, map[string]interface{}{FieldJobID: "new", FieldURL: "https://github.com/org/api.git", FieldRevision: "main"})
+	assert.NoError(t, err)
+	assert.Len(t, producer.requests, 1)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+// defaultInventoryInterval is used if INVENTORY_REFRESH_INTERVAL is not set
+const defaultInventoryInterval = time.Hour
+
+// inventoryFromEnv reads the refresh interval of the inventory document and
+// the expected technologies from the environment. An interval of 0 disables
+// the refresh.
+func inventoryFromEnv() (time.Duration, []string) {
+
+	interval := defaultInventoryInterval
+	if i := os.Getenv("INVENTORY_REFRESH_INTERVAL"); i != "" {
+		d, err := time.ParseDuration(i)
+		if err != nil {
+			log.Errorf("COULD NOT PARSE INVENTORY_REFRESH_INTERVAL: %s", i)
+		} else {
+			interval = d
+		}
+	}
+
+	expected := make([]string, 0)
+	for _, t := range strings.Split(os.Getenv("INVENTORY_EXPECTED_TECHNOLOGIES"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			expected = append(expected, t)
+		}
+	}
+
+	return interval, expected
+}
+
+// refreshInventory stores the inventory of all results now and every interval
+func refreshInventory(ctx context.Context, ajh *analyzer.AnalyzerJSONHandler, interval time.Duration, expected []string) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		inv, err := ajh.RefreshInventory(ctx, expected)
+		if err != nil {
+			log.Errorf("COULD NOT REFRESH INVENTORY: %s", err.Error())
+		} else {
+			log.Infof("REFRESHED INVENTORY OF %d REPOSITORIES", inv.Repositories)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
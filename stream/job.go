@@ -0,0 +1,75 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+
+	"github.com/stuttgart-things/redisqueue"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/utils/tracing"
+)
+
+// eventProducer publishes the completion events, if it could be created
+var eventProducer *redisqueue.Producer
+
+// newJob returns the status of the job a message belongs to
+func newJob(values map[string]interface{}) *analyzer.JobStatus {
+
+	job := &analyzer.JobStatus{}
+	job.ID, _ = values[FieldJobID].(string)
+	repoURL, _ := values[FieldURL].(string)
+	job.RepoURL = analyzer.URLWithoutUserinfo(repoURL)
+	job.Revision, _ = values[FieldRevision].(string)
+	if t := jobType(values); t != JobTypeAnalyze {
+		job.Type = t
+	}
+
+	return job
+}
+
+// updateJob stores the job status, if the message carried a job id
+func updateJob(ctx context.Context, ajh *analyzer.AnalyzerJSONHandler, job *analyzer.JobStatus) {
+
+	if job.ID == "" {
+		return
+	}
+
+	if err := ajh.SetJobStatus(ctx, job); err != nil {
+		log.Errorf("COULD NOT SET STATUS OF JOB %s: %s", job.ID, err.Error())
+	}
+}
+
+// completeJob stores the final job status and publishes the completion event
+func completeJob(ctx context.Context, ajh *analyzer.AnalyzerJSONHandler, job *analyzer.JobStatus) {
+
+	updateJob(ctx, ajh, job)
+
+	if eventProducer == nil {
+		return
+	}
+
+	values := completionEvent(job)
+	tracing.InjectIntoMessage(ctx, values)
+
+	err := eventProducer.Enqueue(&redisqueue.Message{
+		Stream: AnalyzedStream,
+		Values: values,
+	})
+	if err != nil {
+		log.Errorf("COULD NOT PUBLISH COMPLETION EVENT TO STREAM %s: %s", AnalyzedStream, err.Error())
+	}
+}
+
+func completionEvent(job *analyzer.JobStatus) map[string]interface{} {
+	return map[string]interface{}{
+		FieldJobID:    job.ID,
+		FieldURL:      job.RepoURL,
+		FieldRevision: job.Revision,
+		FieldState:    string(job.State),
+		FieldCommit:   job.Commit,
+		FieldError:    job.Error,
+	}
+}
@@ -0,0 +1,301 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+)
+
+const (
+	// AnalyzeStream receives the analysis requests
+	AnalyzeStream = schema.AnalyzeStream
+	// AnalyzedStream receives a completion event per analysis request
+	AnalyzedStream = schema.AnalyzedStream
+)
+
+// fields of an analysis request message
+const (
+	FieldName                  = schema.FieldName
+	FieldURL                   = schema.FieldURL
+	FieldRevision              = schema.FieldRevision
+	FieldUsername              = schema.FieldUsername
+	FieldPassword              = schema.FieldPassword
+	FieldCredentialRef         = schema.FieldCredentialRef
+	FieldInsecure              = schema.FieldInsecure
+	FieldForceCompleteAnalysis = schema.FieldForceCompleteAnalysis
+	FieldAllowPlainDirectory   = schema.FieldAllowPlainDirectory
+	FieldJobID                 = schema.FieldJobID
+	FieldHistory               = schema.FieldHistory
+	FieldHistoryFirstParent    = schema.FieldHistoryFirstParent
+	FieldHistorySample         = schema.FieldHistorySample
+)
+
+// fields of an analysis request triggered by a push webhook. The added and
+// removed files of all pushed commits are separated by newlines; they are
+// only set if the webhook listed all of them.
+const (
+	FieldPushBefore       = schema.FieldPushBefore
+	FieldPushAfter        = schema.FieldPushAfter
+	FieldPushAddedFiles   = schema.FieldPushAddedFiles
+	FieldPushRemovedFiles = schema.FieldPushRemovedFiles
+)
+
+// job types of a request message
+const (
+	FieldJobType     = schema.FieldJobType
+	JobTypeAnalyze   = schema.JobTypeAnalyze
+	JobTypeDiscovery = schema.JobTypeDiscovery
+)
+
+// fields of a discovery request message, which fans out an analysis request
+// per discovered repository. The analysis fields, e.g. username, credential
+// reference or history, are passed on.
+const (
+	FieldProvider        = schema.FieldProvider
+	FieldProviderURL     = schema.FieldProviderURL
+	FieldOwner           = schema.FieldOwner
+	FieldToken           = schema.FieldToken
+	FieldTokenRef        = schema.FieldTokenRef
+	FieldIncludeArchived = schema.FieldIncludeArchived
+	FieldIncludeForks    = schema.FieldIncludeForks
+	FieldInclude         = schema.FieldInclude
+	FieldExclude         = schema.FieldExclude
+	FieldTopics          = schema.FieldTopics
+)
+
+// additional fields of a completion event message
+const (
+	FieldState  = schema.FieldState
+	FieldCommit = schema.FieldCommit
+	FieldError  = schema.FieldError
+)
+
+var (
+	requiredFields          = []string{FieldURL, FieldRevision}
+	requiredDiscoveryFields = []string{FieldProvider, FieldOwner}
+	booleanFields           = []string{FieldInsecure, FieldForceCompleteAnalysis, FieldAllowPlainDirectory, FieldHistory, FieldHistoryFirstParent, FieldIncludeArchived, FieldIncludeForks}
+	regexpFields            = []string{FieldInclude, FieldExclude}
+
+	// forwardedFields are passed on from a discovery request to the analysis
+	// requests of the discovered repositories. Passwords are not, the
+	// requests would carry them in cleartext.
+	forwardedFields = []string{FieldUsername, FieldCredentialRef, FieldInsecure, FieldForceCompleteAnalysis, FieldHistory, FieldHistoryFirstParent, FieldHistorySample}
+)
+
+// ValidateValues checks the values of an analysis request message against the
+// message schema, without connecting to the repository. Requests of remote
+// producers must not read the file system of the analyzer: local
+// repositories are only accepted below ANALYZER_LOCAL_ROOTS, plain
+// directories not at all.
+func ValidateValues(values map[string]interface{}) error {
+	return validateValues(values, false)
+}
+
+// ValidateLocalValues checks the values of a request of the analyze command,
+// which analyzes any local repository or plain directory
+func ValidateLocalValues(values map[string]interface{}) error {
+	return validateValues(values, true)
+}
+
+func validateValues(values map[string]interface{}, local bool) error {
+
+	if len(values) == 0 {
+		return fmt.Errorf("no values received")
+	}
+
+	for key, value := range values {
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("value of %s is no string: %v", key, value)
+		}
+	}
+
+	switch jobType(values) {
+	case JobTypeAnalyze:
+		for _, key := range requiredFields {
+			if values[key] == nil {
+				return fmt.Errorf("no %s received", key)
+			}
+		}
+
+		u, err := url.ParseRequestURI(values[FieldURL].(string))
+		if err != nil {
+			return fmt.Errorf("invalid url received: %s", values[FieldURL])
+		}
+
+		if !local {
+			if err := validateRemoteURL(values[FieldURL].(string), u); err != nil {
+				return err
+			}
+			if b, _ := values[FieldAllowPlainDirectory].(string); b != "" && b != "false" {
+				return fmt.Errorf("%s is only accepted by the analyze command", FieldAllowPlainDirectory)
+			}
+		}
+
+		if values[FieldPassword] != nil && values[FieldCredentialRef] != nil {
+			return fmt.Errorf("%s and %s are exclusive", FieldPassword, FieldCredentialRef)
+		}
+
+		if (values[FieldPushAddedFiles] != nil || values[FieldPushRemovedFiles] != nil) &&
+			(values[FieldPushBefore] == nil || values[FieldPushAfter] == nil) {
+			return fmt.Errorf("no %s and %s received for the pushed files", FieldPushBefore, FieldPushAfter)
+		}
+
+	case JobTypeDiscovery:
+		if err := validateDiscoveryValues(values); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown job type received: %s", values[FieldJobType])
+	}
+
+	for _, key := range booleanFields {
+		if values[key] == nil {
+			continue
+		}
+		if _, err := strconv.ParseBool(values[key].(string)); err != nil {
+			return fmt.Errorf("invalid boolean received for %s: %s", key, values[key])
+		}
+	}
+
+	if ref, ok := values[FieldCredentialRef].(string); ok {
+		if _, _, err := credentials.ParseRef(ref); err != nil {
+			return err
+		}
+	}
+
+	if sample, ok := values[FieldHistorySample].(string); ok {
+		if _, err := analyzer.ParseHistorySampling(sample); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateRemoteURL rejects urls of remote producers pointing to the file
+// system of the analyzer outside of the roots of ANALYZER_LOCAL_ROOTS
+func validateRemoteURL(rawURL string, u *url.URL) error {
+
+	if u.Scheme != "" && !strings.EqualFold(u.Scheme, "file") {
+		return nil
+	}
+	if u.Host != "" {
+		return fmt.Errorf("invalid url received: %s", rawURL)
+	}
+
+	// the analyzer opens paths as they are
+	path := rawURL
+	if u.Scheme != "" {
+		path = u.Path
+	}
+	path = localRealPath(path)
+	for _, root := range strings.Split(os.Getenv("ANALYZER_LOCAL_ROOTS"), ",") {
+		if root = strings.TrimSpace(root); root == "" {
+			continue
+		}
+		root = localRealPath(root)
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("local repository %s is not below the roots of ANALYZER_LOCAL_ROOTS", rawURL)
+}
+
+// localRealPath returns the absolute path without symbolic links, as far as
+// it exists
+func localRealPath(path string) string {
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+
+	return path
+}
+
+// jobType returns the type of a request message, analyze if it is not set
+func jobType(values map[string]interface{}) string {
+	if t, _ := values[FieldJobType].(string); t != "" {
+		return t
+	}
+	return JobTypeAnalyze
+}
+
+// RepositoryFromValues builds the repository of validated message values
+func RepositoryFromValues(values map[string]interface{}) *analyzer.Repository {
+
+	str := func(key string) string {
+		if s, ok := values[key].(string); ok {
+			return s
+		}
+		return ""
+	}
+	boolean := func(key string) bool {
+		b, _ := strconv.ParseBool(str(key))
+		return b
+	}
+
+	r := &analyzer.Repository{
+		Name:                str(FieldName),
+		Url:                 str(FieldURL),
+		Revision:            str(FieldRevision),
+		Username:            str(FieldUsername),
+		Password:            str(FieldPassword),
+		CredentialRef:       str(FieldCredentialRef),
+		Insecure:            boolean(FieldInsecure),
+		AllowPlainDirectory: boolean(FieldAllowPlainDirectory),
+	}
+
+	if values[FieldForceCompleteAnalysis] != nil {
+		force := boolean(FieldForceCompleteAnalysis)
+		r.ForceCompleteAnalysis = &force
+	}
+
+	if str(FieldPushBefore) != "" && str(FieldPushAfter) != "" {
+		r.Push = &analyzer.PushChanges{
+			Before:  str(FieldPushBefore),
+			After:   str(FieldPushAfter),
+			Added:   splitFiles(str(FieldPushAddedFiles)),
+			Removed: splitFiles(str(FieldPushRemovedFiles)),
+		}
+	}
+
+	if boolean(FieldHistory) {
+		sample, _ := analyzer.ParseHistorySampling(str(FieldHistorySample))
+		r.History = &analyzer.HistoryOptions{
+			FirstParent: boolean(FieldHistoryFirstParent),
+			Sample:      sample,
+		}
+	}
+
+	return r
+}
+
+// splitFiles splits the newline separated files of a push
+func splitFiles(s string) []string {
+
+	files := make([]string, 0)
+	for _, f := range strings.Split(s, "\n") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
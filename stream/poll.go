@@ -5,72 +5,262 @@ Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
 package stream
 
 import (
+	"context"
 	"fmt"
-	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	"github.com/stuttgart-things/sweatShop-analyzer/credentials"
+	"github.com/stuttgart-things/sweatShop-analyzer/internal/schema"
+	"github.com/stuttgart-things/sweatShop-analyzer/sink"
 
 	"github.com/stuttgart-things/redisqueue"
 	sthingsBase "github.com/stuttgart-things/sthingsBase"
 	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+	"github.com/stuttgart-things/sweatShop-analyzer/utils/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http/httpproxy"
 )
 
 const (
-	streamName = "sweatShop:analyze"
+	streamName = schema.AnalyzeStream
+
+	// AN ANALYSIS HOLDS THE LOCK OF ITS REPOSITORY AT MOST lockTTL. A MESSAGE
+	// WAITS lockWait FOR IT, BELOW THE VISIBILITY TIMEOUT, AND IS RECLAIMED LATER
+	lockTTL      = 30 * time.Minute
+	lockWait     = 30 * time.Second
+	lockInterval = time.Second
+
+	// A MESSAGE NOT ACKNOWLEDGED WITHIN THE VISIBILITY TIMEOUT IS DELIVERED AGAIN,
+	// concurrency MESSAGES ARE HANDLED AT A TIME
+	visibilityTimeout = 60 * time.Second
+	concurrency       = 10
 )
 
 var (
 	log         = sthingsBase.StdOutFileLogger(logfilePath, "2006-01-02 15:04:05", 50, 3, 28)
 	logfilePath = "/tmp/sweatShop-analyzer.log"
+	tracer      = otel.Tracer("github.com/stuttgart-things/sweatShop-analyzer/stream")
 )
 
 var redisUtil *redisutil.Redis
 
-func init() {
-	// Get redis port from environment variable and convert it to int
-	redisport, err := strconv.Atoi(os.Getenv("REDIS_PORT"))
+// retention limits the snapshots kept per repository
+var retention analyzer.RetentionPolicy
+
+// analyzerCache caches the matching files, configured by ANALYZER_CACHE
+var analyzerCache analyzer.AnalyzerCacheInterface
+
+// resultSinks receive the results besides redis, configured by RESULT_SINKS
+var resultSinks []analyzer.NamedSink
+
+// credentialResolver resolves the credential references of the requests,
+// configured by CREDENTIALS_DIR and CREDENTIALS_KEY
+var credentialResolver analyzer.CredentialResolver
+
+// connectRedis creates the global redis client from the environment, see
+// redisutil.OptionsFromEnv
+func connectRedis() {
+
+	r, err := redisutil.NewRedisWithClientFromEnv()
 	if err != nil {
-		log.Errorf("COULD NOT CONVERT REDIS PORT INTO INT: %s", os.Getenv("REDIS_PORT"))
+		log.Errorf("COULD NOT CONNECT TO REDIS: %s", err.Error())
+		panic(err)
+	}
+
+	// Create a global redisUtil object with a JSON handler
+	redisUtil = r
+}
+
+// RetentionFromEnv reads the snapshot retention policy from the environment,
+// shared by the poller and serve
+func RetentionFromEnv() analyzer.RetentionPolicy {
+
+	var r analyzer.RetentionPolicy
+
+	if count := os.Getenv("SNAPSHOT_RETENTION_COUNT"); count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			log.Errorf("COULD NOT CONVERT SNAPSHOT_RETENTION_COUNT INTO INT: %s", count)
+		}
+		r.MaxSnapshots = n
 	}
 
-	// Create a global redisUtil object
-	redisUtil = redisutil.NewRedisWithClient(
-		os.Getenv("REDIS_SERVER"),
-		redisport,
-		os.Getenv("REDIS_PASSWORD"),
-	)
+	if maxAge := os.Getenv("SNAPSHOT_RETENTION_MAX_AGE"); maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			log.Errorf("COULD NOT PARSE SNAPSHOT_RETENTION_MAX_AGE: %s", maxAge)
+		}
+		r.MaxAge = d
+	}
+
+	return r
+}
+
+// CacheConfigFromEnv reads the backend of the analyzer cache from the
+// environment, redis with entries expiring after an hour by default
+func CacheConfigFromEnv() analyzer.CacheConfig {
+
+	config := analyzer.CacheConfig{
+		Backend:    os.Getenv("ANALYZER_CACHE"),
+		Expiration: time.Hour,
+		MaxEntries: 1000,
+		Path:       os.Getenv("ANALYZER_CACHE_PATH"),
+	}
+
+	if ttl := os.Getenv("ANALYZER_CACHE_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Errorf("COULD NOT PARSE ANALYZER_CACHE_TTL: %s", ttl)
+		} else {
+			config.Expiration = d
+		}
+	}
+
+	if size := os.Getenv("ANALYZER_CACHE_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			log.Errorf("COULD NOT CONVERT ANALYZER_CACHE_SIZE INTO INT: %s", size)
+		} else {
+			config.MaxEntries = n
+		}
+	}
 
-	// Create a new JSON handler
-	redisUtil.SetJSONHandler()
+	return config
+}
+
+// ProxyConfigFromEnv reads the http proxies of git servers from the
+// environment: HTTPS_PROXY, HTTP_PROXY and NO_PROXY, ANALYZER_PROXY as proxy
+// of all schemes and the proxies of hosts ANALYZER_PROXY_HOSTS
+func ProxyConfigFromEnv() analyzer.ProxyConfig {
+
+	env := httpproxy.FromEnvironment()
+	config := analyzer.ProxyConfig{
+		HTTPProxy:  env.HTTPProxy,
+		HTTPSProxy: env.HTTPSProxy,
+		NoProxy:    env.NoProxy,
+		Hosts:      make(map[string]string),
+	}
+
+	if proxy := os.Getenv("ANALYZER_PROXY"); proxy != "" {
+		config.HTTPProxy, config.HTTPSProxy = proxy, proxy
+	}
+
+	// e.g. "github.com=http://proxy:3128,*.internal=direct"
+	for _, entry := range strings.Split(os.Getenv("ANALYZER_PROXY_HOSTS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		host, proxy, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Errorf("COULD NOT PARSE ANALYZER_PROXY_HOSTS ENTRY: %s", entry)
+			continue
+		}
+		config.Hosts[strings.ToLower(strings.TrimSpace(host))] = strings.TrimSpace(proxy)
+	}
+
+	return config
 }
 
 func PollRedisStreams() {
 
-	c, err := redisqueue.NewConsumerWithOptions(&redisqueue.ConsumerOptions{
-		VisibilityTimeout: 60 * time.Second,
-		BlockingTimeout:   5 * time.Second,
-		ReclaimInterval:   1 * time.Second,
-		BufferSize:        100,
-		Concurrency:       10,
-		RedisClient:       redisUtil.Client,
-	})
+	connectRedis()
+	retention = RetentionFromEnv()
+
+	cache, closeCache, err := analyzer.OpenAnalyzerCache(redisUtil.Client, CacheConfigFromEnv())
+	if err != nil {
+		panic(err)
+	}
+	analyzerCache = cache
+	defer func() {
+		if err := closeCache(); err != nil {
+			log.Errorf("COULD NOT CLOSE ANALYZER CACHE: %s", err.Error())
+		}
+	}()
 
+	resolver, err := credentials.FromEnv(redisUtil.Client)
+	if err != nil {
+		panic(err)
+	}
+	credentialResolver = resolver
+
+	if err := analyzer.InstallProxy(ProxyConfigFromEnv()); err != nil {
+		panic(err)
+	}
+
+	sinks, closeSinks, err := sink.FromEnv(context.Background())
+	if err != nil {
+		log.Errorf("COULD NOT OPEN RESULT SINKS: %s", err.Error())
+	}
+	resultSinks = sinks
+	defer func() {
+		if err := closeSinks(); err != nil {
+			log.Errorf("COULD NOT CLOSE RESULT SINKS: %s", err.Error())
+		}
+	}()
+
+	// RESULTS ARE INDEXED BY REDIS WHEN THEY ARE WRITTEN, ONCE THE INDEX EXISTS
+	indexed, err := analyzer.NewAnalyzerJSONHandlerWithClient(redisUtil.JSONHandler, redisUtil.Client).EnsureSearchIndex(context.Background())
+	switch {
+	case err != nil:
+		log.Errorf("COULD NOT CREATE SEARCH INDEX %s: %s", analyzer.SearchIndex, err.Error())
+	case !indexed:
+		log.Warnf("SEARCH MODULE NOT AVAILABLE, RESULT QUERIES SCAN ALL KEYS")
+	}
+
+	if interval, expected := inventoryFromEnv(); interval > 0 {
+		go refreshInventory(context.Background(), analyzer.NewAnalyzerJSONHandlerWithClient(redisUtil.JSONHandler, redisUtil.Client), interval, expected)
+	}
+
+	c, err := jobSourceFromEnv()
 	if err != nil {
 		panic(err)
 	}
 
 	c.Register(streamName, processStreams)
+	c.Register(ControlStream, processControl)
+
+	// Create a producer for the completion events
+	eventProducer, err = redisqueue.NewProducerWithOptions(&redisqueue.ProducerOptions{
+		ApproximateMaxLength: true,
+		MaxLen:               10000,
+		RedisClient:          redisUtil.Client,
+	})
+	if err != nil {
+		log.Errorf("COULD NOT CREATE PRODUCER FOR STREAM %s: %s", AnalyzedStream, err.Error())
+	}
+
+	// Create a producer for the requests of discovery jobs, webhooks and
+	// schedules, publishing to the job source polled above
+	if p, err := ProducerFromEnv(redisUtil); err != nil {
+		log.Errorf("COULD NOT CREATE PRODUCER FOR STREAM %s: %s", AnalyzeStream, err.Error())
+	} else {
+		fanOutProducer = p
+	}
+
+	// PUSHES TRIGGER ANALYSES, IF THE WEBHOOK RECEIVER IS ENABLED
+	if addr, path, secrets := webhooksFromEnv(); addr != "" && fanOutProducer != nil {
+		go serveWebhooks(addr, path, &webhookHandler{secrets: secrets, producer: fanOutProducer})
+	}
+
+	// EVERY REPLICA CHECKS THE SCHEDULES, EACH RUN IS CLAIMED BY ONE OF THEM
+	if config := schedulerFromEnv(); config.Interval > 0 && fanOutProducer != nil {
+		go runScheduler(context.Background(), analyzer.NewAnalyzerJSONHandlerWithClient(redisUtil.JSONHandler, redisUtil.Client), fanOutProducer, config)
+	}
 
 	go func() {
-		for err := range c.Errors {
+		for err := range c.Errors() {
 			fmt.Printf("err: %+v\n", err)
 		}
 	}()
 
-	log.Info("START POLLING STREAM ", streamName+" ON "+redisUtil.GetServerPort())
+	log.Info("START POLLING STREAMS ", streamName+", "+ControlStream+" ON "+redisUtil.GetServerPort())
 
 	c.Run()
 
@@ -78,73 +268,124 @@ func PollRedisStreams() {
 
 }
 
-func processStreams(msg *redisqueue.Message) error {
+func processStreams(msg *redisqueue.Message) (err error) {
+
+	// CONTINUE THE PRODUCER'S TRACE, IF THE MESSAGE CARRIES ONE
+	ctx := tracing.ExtractFromMessage(context.Background(), msg.Values)
+	ctx, span := tracer.Start(ctx, "processStreams", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		attribute.String("messaging.system", "redis"),
+		attribute.String("messaging.source.name", msg.Stream),
+		attribute.String("messaging.message.id", msg.ID),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
 
-	// ADD VALUE VALIDATION HERE
-	repo := buildValidRepository(msg.Values)
-	if repo == nil {
-		log.Error("INVALID INPUT RECEIVED")
-		return nil
+	// Create a new analyzer redis json handler
+	ajh := analyzer.NewAnalyzerJSONHandlerWithClient(redisUtil.JSONHandler, redisUtil.Client)
+	ajh.SetRetention(retention)
+
+	job := newJob(msg.Values)
+
+	// DISCOVERY JOBS FAN OUT AN ANALYSIS REQUEST PER DISCOVERED REPOSITORY
+	if jobType(msg.Values) == JobTypeDiscovery {
+		if err := ValidateValues(msg.Values); err != nil {
+			log.Errorf("INVALID INPUT RECEIVED: %s", err.Error())
+			span.SetStatus(codes.Error, "invalid input received")
+			job.State = analyzer.JobFailed
+			job.Error = err.Error()
+			completeJob(ctx, ajh, job)
+			return nil
+		}
+		return processDiscovery(ctx, ajh, job, msg.Values)
 	}
 
-	// Create a new analyzer cache client
-	ac := analyzer.NewAnalyzerCache(redisUtil.Client, time.Hour)
+	// VALIDATE VALUES AND CONNECTION
+	repo, err := validRepository(ctx, msg.Values)
+	if err != nil {
+		log.Errorf("INVALID INPUT RECEIVED: %s", err.Error())
+		span.SetStatus(codes.Error, "invalid input received")
+		job.State = analyzer.JobFailed
+		job.Error = err.Error()
+		completeJob(ctx, ajh, job)
+		return nil
+	}
 
-	// Create a new analyzer redis json handler
-	ajh := analyzer.NewAnalyzerJSONHandler(redisUtil.JSONHandler)
+	// ONE ANALYSIS PER REPOSITORY AT A TIME, ALSO IF IT IS REQUESTED WITH DIFFERENT URLS
+	lock, err := analyzer.AcquireRepositoryLock(ctx, redisUtil.Client, repo.Url, lockTTL, lockWait, lockInterval)
+	if err != nil {
+		log.Warnf("COULD NOT LOCK REPOSITORY %s, RETRY LATER: %s", repo.ID(), err.Error())
+		return err
+	}
+	defer func() {
+		if err := lock.Release(ctx); err != nil {
+			log.Errorf("COULD NOT UNLOCK REPOSITORY %s: %s", repo.ID(), err.Error())
+		}
+	}()
 
-	return repo.GetMatchingFiles(ac, ajh)
-}
+	job.State = analyzer.JobRunning
+	updateJob(ctx, ajh, job)
 
-func buildValidRepository(values map[string]interface{}) *analyzer.Repository {
+	// REDIS KEEPS THE RESULTS FOR THE CACHE AND THE QUERIES, THE OTHER SINKS ARE OPTIONAL
+	sinks := append(analyzer.MultiSink{{Name: "redisjson", ResultSink: ajh}}, resultSinks...)
 
-	if len(values) == 0 {
-		log.Error("NO VALUES RECEIVED")
+	// A FAILED JOB IS COMPLETE, ITS MESSAGE IS ACKNOWLEDGED INSTEAD OF DELIVERED AGAIN
+	result, err := repo.GetMatchingFiles(ctx, analyzerCache, sinks)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		job.State = analyzer.JobFailed
+		job.Error = err.Error()
+		completeJob(ctx, ajh, job)
 		return nil
 	}
 
-	if values["url"] == nil {
-		log.Error("NO URL RECEIVED")
-		return nil
-	}
+	job.State = analyzer.JobSucceeded
+	job.Commit = result.Commit
+	completeJob(ctx, ajh, job)
+
+	return nil
+}
+
+func buildValidRepository(values map[string]interface{}) *analyzer.Repository {
 
-	_, err := url.ParseRequestURI(values["url"].(string))
+	r, err := validRepository(context.Background(), values)
 	if err != nil {
-		log.Errorf("INVALID URL RECEIVED: %s", values["url"].(string))
+		log.Error(strings.ToUpper(err.Error()))
 		return nil
 	}
 
-	if values["revision"] == nil {
-		log.Error("NO REVISION RECEIVED")
-		return nil
+	return r
+}
+
+// validRepository validates the values against the message schema and tries
+// to connect to the repository
+func validRepository(ctx context.Context, values map[string]interface{}) (*analyzer.Repository, error) {
+
+	err := ValidateValues(values)
+	if err != nil {
+		return nil, err
 	}
 
 	// try to construct repository using the received values
-	r := &analyzer.Repository{
-		Url:      values["url"].(string),
-		Revision: values["revision"].(string),
-	}
-	if values["name"] != nil {
-		r.Name = values["name"].(string)
-	}
-	if values["username"] != nil {
-		r.Username = values["username"].(string)
-	}
-	if values["password"] != nil {
-		r.Password = values["password"].(string)
-	}
-	if values["insecure"] != nil {
-		r.Insecure = sthingsBase.ConvertStringToBoolean(values["insecure"].(string))
+	r := RepositoryFromValues(values)
+
+	// the password of a credential reference is only kept in memory
+	if err := r.ResolveCredentials(ctx, credentialResolver); err != nil {
+		return nil, err
 	}
 
 	// try to connect to the repository
 	err = r.ConnectRepository()
 	if err != nil {
-		log.Errorf("COULD NOT CONNECT TO REPOSITORY: %s", err.Error())
-		return nil
+		return nil, fmt.Errorf("could not connect to repository: %w", err)
 	}
 
 	// TODO: check if the revision exists
 
-	return r
+	return r, nil
 }
@@ -7,6 +7,7 @@ package stream
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
 )
@@ -67,3 +68,44 @@ func Test_buildValidRepository(t *testing.T) {
 		}
 	}
 }
+
+func TestRetentionFromEnv(t *testing.T) {
+
+	t.Setenv("SNAPSHOT_RETENTION_COUNT", "10")
+	t.Setenv("SNAPSHOT_RETENTION_MAX_AGE", "720h")
+	expected := analyzer.RetentionPolicy{MaxSnapshots: 10, MaxAge: 30 * 24 * time.Hour}
+	if actual := RetentionFromEnv(); actual != expected {
+		t.Errorf("RetentionFromEnv(): expected %+v, actual %+v", expected, actual)
+	}
+
+	t.Setenv("SNAPSHOT_RETENTION_COUNT", "")
+	t.Setenv("SNAPSHOT_RETENTION_MAX_AGE", "")
+	if actual := RetentionFromEnv(); actual != (analyzer.RetentionPolicy{}) {
+		t.Errorf("RetentionFromEnv(): expected no retention, actual %+v", actual)
+	}
+}
+
+func TestProxyConfigFromEnv(t *testing.T) {
+
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy", "ANALYZER_PROXY", "ANALYZER_PROXY_HOSTS"} {
+		t.Setenv(name, "")
+	}
+
+	t.Setenv("HTTPS_PROXY", "http://proxy:3128")
+	t.Setenv("NO_PROXY", ".internal")
+	t.Setenv("ANALYZER_PROXY_HOSTS", "GitHub.com=http://github:3128, *.gitea.internal = direct,broken")
+	expected := analyzer.ProxyConfig{
+		HTTPSProxy: "http://proxy:3128",
+		NoProxy:    ".internal",
+		Hosts:      map[string]string{"github.com": "http://github:3128", "*.gitea.internal": analyzer.ProxyDirect},
+	}
+	if actual := ProxyConfigFromEnv(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ProxyConfigFromEnv(): expected %+v, actual %+v", expected, actual)
+	}
+
+	// the proxy of all schemes
+	t.Setenv("ANALYZER_PROXY", "http://corporate:8080")
+	if actual := ProxyConfigFromEnv(); actual.HTTPProxy != "http://corporate:8080" || actual.HTTPSProxy != "http://corporate:8080" {
+		t.Errorf("ProxyConfigFromEnv(): expected ANALYZER_PROXY for http and https, actual %+v", actual)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stuttgart-things/redisqueue"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+	redisutil "github.com/stuttgart-things/sweatShop-analyzer/utils/redis"
+	"github.com/stuttgart-things/sweatShop-analyzer/utils/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Producer enqueues analysis requests into the job source and tracks them as
+// jobs in redis
+type Producer struct {
+	publisher publisher
+	ajh       *analyzer.AnalyzerJSONHandler
+}
+
+// publisher adds a message to a stream of a job source
+type publisher interface {
+	Publish(ctx context.Context, stream string, values map[string]interface{}) error
+	// System is the messaging system of the traces, e.g. "redis"
+	System() string
+	Close() error
+}
+
+// NewProducer returns a producer adding the requests to the redis streams
+func NewProducer(r *redisutil.Redis) (*Producer, error) {
+
+	p, err := redisqueue.NewProducerWithOptions(&redisqueue.ProducerOptions{
+		ApproximateMaxLength: true,
+		MaxLen:               10000,
+		RedisClient:          r.Client,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create producer: %w", err)
+	}
+
+	return &Producer{
+		publisher: redisPublisher{p: p},
+		ajh:       analyzer.NewAnalyzerJSONHandlerWithClient(r.JSONHandler, r.Client),
+	}, nil
+}
+
+// NewNATSProducer returns a producer publishing the requests to the NATS
+// JetStream subjects read by the NATS job source. The jobs are still tracked
+// in redis.
+func NewNATSProducer(r *redisutil.Redis, options NATSOptions) (*Producer, error) {
+
+	p, err := newNATSPublisher(options)
+	if err != nil {
+		return nil, fmt.Errorf("could not create producer: %w", err)
+	}
+
+	return &Producer{
+		publisher: p,
+		ajh:       analyzer.NewAnalyzerJSONHandlerWithClient(r.JSONHandler, r.Client),
+	}, nil
+}
+
+// ProducerFromEnv returns the producer of the job source of JOB_SOURCE, redis
+// by default, so requests reach the poller of the same environment
+func ProducerFromEnv(r *redisutil.Redis) (*Producer, error) {
+
+	switch source := os.Getenv("JOB_SOURCE"); source {
+	case "", JobSourceRedis:
+		return NewProducer(r)
+	case JobSourceNATS:
+		options, err := natsOptionsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewNATSProducer(r, options)
+	default:
+		return nil, fmt.Errorf("unknown job source %q, expected %s or %s", source, JobSourceRedis, JobSourceNATS)
+	}
+}
+
+// Close closes the connection of the producer to NATS, the redis client is
+// closed by its owner
+func (p *Producer) Close() error {
+	return p.publisher.Close()
+}
+
+// Enqueue validates the values against the message schema, assigns a job id
+// unless one is given, stores the queued job status and adds the message to
+// the analyze stream. It returns the job id.
+func (p *Producer) Enqueue(ctx context.Context, values map[string]interface{}) (_ string, err error) {
+
+	ctx, span := tracer.Start(ctx, "Producer.Enqueue", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		attribute.String("messaging.system", p.publisher.System()),
+		attribute.String("messaging.destination.name", AnalyzeStream),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	if err := ValidateValues(values); err != nil {
+		return "", err
+	}
+
+	if values[FieldJobID] == nil {
+		values[FieldJobID] = uuid.NewString()
+	}
+
+	job := newJob(values)
+	job.State = analyzer.JobQueued
+	span.SetAttributes(attribute.String("job.id", job.ID))
+
+	if err := p.ajh.SetJobStatus(ctx, job); err != nil {
+		return "", fmt.Errorf("could not set status of job %s: %w", job.ID, err)
+	}
+
+	tracing.InjectIntoMessage(ctx, values)
+
+	if err := p.publisher.Publish(ctx, AnalyzeStream, values); err != nil {
+		return "", fmt.Errorf("could not enqueue job %s: %w", job.ID, err)
+	}
+
+	return job.ID, nil
+}
+
+// redisPublisher adds the messages to the redis streams
+type redisPublisher struct {
+	p *redisqueue.Producer
+}
+
+func (p redisPublisher) Publish(_ context.Context, stream string, values map[string]interface{}) error {
+	return p.p.Enqueue(&redisqueue.Message{Stream: stream, Values: values})
+}
+
+func (redisPublisher) System() string {
+	return "redis"
+}
+
+func (redisPublisher) Close() error {
+	return nil
+}
+
+// WaitForJob polls the status of the job until it is done or ctx expires
+func (p *Producer) WaitForJob(ctx context.Context, jobID string, interval time.Duration) (*analyzer.JobStatus, error) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job, err := p.ajh.GetJobStatus(ctx, jobID)
+		if err != nil {
+			return nil, fmt.Errorf("could not get status of job %s: %w", jobID, err)
+		}
+
+		if job.State.Done() {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, fmt.Errorf("job %s is still %s: %w", jobID, job.State, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetResult returns the stored result of an analyzed revision, of the most
+// recently analyzed one if revision is empty
+func (p *Producer) GetResult(ctx context.Context, repoURL, revision string) (*analyzer.AnalyzerResultValue, error) {
+	return p.ajh.GetAnalyzerResult(ctx, repoURL, revision)
+}
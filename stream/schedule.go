@@ -0,0 +1,178 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+const (
+	// defaultSchedulerInterval is used if SCHEDULER_INTERVAL is not set
+	defaultSchedulerInterval = 30 * time.Second
+	// defaultScheduleRateWindow is used if SCHEDULE_RATE_WINDOW is not set
+	defaultScheduleRateWindow = time.Minute
+)
+
+// schedulerConfig controls how the registered schedules are fired
+type schedulerConfig struct {
+	// Interval is the time between two checks for due schedules, 0 disables
+	// the scheduler
+	Interval time.Duration
+	// Jitter is the maximum delay of a run after its cron time
+	Jitter time.Duration
+	// RateLimit is the maximum number of runs per git server and window, 0
+	// is unlimited
+	RateLimit  int
+	RateWindow time.Duration
+	// HostRateLimits override the rate limit of single git servers
+	HostRateLimits map[string]int
+}
+
+// rateLimit returns the run limit of the git server
+func (c *schedulerConfig) rateLimit(host string) int {
+	if limit, ok := c.HostRateLimits[host]; ok {
+		return limit
+	}
+	return c.RateLimit
+}
+
+// schedulerFromEnv reads the scheduler config from the environment
+func schedulerFromEnv() *schedulerConfig {
+
+	c := &schedulerConfig{
+		Interval:       defaultSchedulerInterval,
+		RateWindow:     defaultScheduleRateWindow,
+		HostRateLimits: make(map[string]int),
+	}
+
+	duration := func(name string, d *time.Duration) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				log.Errorf("COULD NOT PARSE %s: %s", name, v)
+				return
+			}
+			*d = parsed
+		}
+	}
+	duration("SCHEDULER_INTERVAL", &c.Interval)
+	duration("SCHEDULE_JITTER", &c.Jitter)
+	duration("SCHEDULE_RATE_WINDOW", &c.RateWindow)
+
+	if v := os.Getenv("SCHEDULE_RATE_LIMIT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Errorf("COULD NOT CONVERT SCHEDULE_RATE_LIMIT INTO INT: %s", v)
+		}
+		c.RateLimit = n
+	}
+
+	// e.g. "github.com=30,git.example.com=5"
+	for _, entry := range strings.Split(os.Getenv("SCHEDULE_HOST_RATE_LIMITS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		host, limit, _ := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil {
+			log.Errorf("COULD NOT PARSE SCHEDULE_HOST_RATE_LIMITS ENTRY: %s", entry)
+			continue
+		}
+		c.HostRateLimits[strings.ToLower(strings.TrimSpace(host))] = n
+	}
+
+	return c
+}
+
+// scheduleRequest returns the analysis request of a schedule
+func scheduleRequest(s *analyzer.Schedule) map[string]interface{} {
+
+	values := make(map[string]interface{}, len(s.Request)+2)
+	for key, value := range s.Request {
+		values[key] = value
+	}
+	values[FieldURL] = s.Url
+	values[FieldRevision] = s.Revision
+
+	return values
+}
+
+// runScheduler fires the due schedules now and every interval
+func runScheduler(ctx context.Context, ajh *analyzer.AnalyzerJSONHandler, producer enqueuer, config *schedulerConfig) {
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := fireSchedules(ctx, ajh, producer, config, time.Now()); err != nil {
+			log.Errorf("COULD NOT FIRE SCHEDULES: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fireSchedules enqueues an analysis for every due schedule this replica
+// claims and returns the number of enqueued analyses. Runs over the rate
+// limit of their git server are released and due again on the next check.
+func fireSchedules(ctx context.Context, ajh *analyzer.AnalyzerJSONHandler, producer enqueuer, config *schedulerConfig, now time.Time) (int, error) {
+
+	due, err := ajh.DueSchedules(ctx, now, config.Jitter)
+	if err != nil {
+		return 0, err
+	}
+
+	fired := 0
+	for _, s := range due {
+		claimed, err := ajh.ClaimScheduleRun(ctx, s)
+		if err != nil {
+			return fired, err
+		}
+		// fired by another replica
+		if !claimed {
+			continue
+		}
+
+		allowed, err := ajh.AllowHostRun(ctx, s.Host(), config.rateLimit(s.Host()), config.RateWindow, now)
+		if err != nil || !allowed {
+			if releaseErr := ajh.ReleaseScheduleRun(ctx, s); releaseErr != nil {
+				log.Errorf("COULD NOT RELEASE SCHEDULE %s: %s", s.ID(), releaseErr.Error())
+			}
+			if err != nil {
+				return fired, err
+			}
+			log.Warnf("RATE LIMIT OF %s REACHED, POSTPONING SCHEDULE %s", s.Host(), s.ID())
+			continue
+		}
+
+		jobID, err := producer.Enqueue(ctx, scheduleRequest(s))
+		if err != nil {
+			if releaseErr := ajh.ReleaseScheduleRun(ctx, s); releaseErr != nil {
+				log.Errorf("COULD NOT RELEASE SCHEDULE %s: %s", s.ID(), releaseErr.Error())
+			}
+			// the other schedules are not held up by it
+			log.Errorf("COULD NOT ENQUEUE ANALYSIS OF SCHEDULE %s: %s", s.ID(), err.Error())
+			continue
+		}
+		fired++
+
+		if err := ajh.CompleteScheduleRun(ctx, s, jobID, now); err != nil {
+			return fired, err
+		}
+		log.Infof("SCHEDULED ANALYSIS OF %s ENQUEUED AS JOB %s", s.ID(), jobID)
+	}
+
+	return fired, nil
+}
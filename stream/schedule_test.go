@@ -0,0 +1,117 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nitishm/go-rejson/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stuttgart-things/sweatShop-analyzer/analyzer"
+)
+
+func Test_fireSchedules(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+	now := time.Date(2023, 8, 10, 12, 30, 0, 0, time.UTC)
+
+	// two replicas with their own clients
+	replica := func() *analyzer.AnalyzerJSONHandler {
+		return analyzer.NewAnalyzerJSONHandlerWithClient(rejson.NewReJSONHandler(), goredis.NewClient(&goredis.Options{Addr: s.Addr()}))
+	}
+	first, second := replica(), replica()
+
+	for _, url := range []string{"https://github.com/org/a", "https://github.com/org/b", "https://gitlab.com/org/c"} {
+		assert.NoError(t, first.SetSchedule(ctx, &analyzer.Schedule{
+			Url:      url,
+			Revision: "main",
+			Cron:     "@hourly",
+			Request:  map[string]string{FieldHistory: "true"},
+		}, now))
+	}
+
+	config := &schedulerConfig{RateLimit: 5, RateWindow: time.Minute, HostRateLimits: map[string]int{"github.com": 1}}
+	producer := &enqueuerMock{}
+
+	// nothing is due yet
+	fired, err := fireSchedules(ctx, first, producer, config, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fired)
+
+	// one run of github.com per minute, the other one is postponed
+	at := now.Add(30 * time.Minute)
+	fired, err = fireSchedules(ctx, first, producer, config, at)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fired)
+	fired, err = fireSchedules(ctx, second, producer, config, at)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fired)
+
+	fired, err = fireSchedules(ctx, second, producer, config, at.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fired)
+
+	assert.Len(t, producer.requests, 3)
+	assert.Equal(t, map[string]interface{}{
+		FieldURL:      "https://github.com/org/a",
+		FieldRevision: "main",
+		FieldHistory:  "true",
+	}, producer.requests[0])
+
+	schedules, err := first.ListSchedules(ctx)
+	assert.NoError(t, err)
+	for _, s := range schedules {
+		assert.NotEmpty(t, s.LastJobID)
+		assert.Equal(t, time.Date(2023, 8, 10, 14, 0, 0, 0, time.UTC), s.NextRun)
+	}
+
+	// runs are not repeated until their next cron time
+	fired, err = fireSchedules(ctx, first, producer, config, at.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fired)
+}
+
+func Test_fireSchedulesClaimed(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+	now := time.Date(2023, 8, 10, 12, 30, 0, 0, time.UTC)
+
+	ajh := analyzer.NewAnalyzerJSONHandlerWithClient(rejson.NewReJSONHandler(), goredis.NewClient(&goredis.Options{Addr: s.Addr()}))
+	schedule := &analyzer.Schedule{Url: "https://github.com/org/a", Cron: "@hourly"}
+	assert.NoError(t, ajh.SetSchedule(ctx, schedule, now))
+
+	// claimed by a replica, which has not completed it yet
+	claimed, err := ajh.ClaimScheduleRun(ctx, schedule)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	producer := &enqueuerMock{}
+	fired, err := fireSchedules(ctx, ajh, producer, &schedulerConfig{}, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fired)
+	assert.Len(t, producer.requests, 0)
+}
+
+func Test_schedulerFromEnv(t *testing.T) {
+
+	t.Setenv("SCHEDULER_INTERVAL", "1m")
+	t.Setenv("SCHEDULE_JITTER", "5m")
+	t.Setenv("SCHEDULE_RATE_LIMIT", "10")
+	t.Setenv("SCHEDULE_HOST_RATE_LIMITS", "GitHub.com=30, git.example.com = 2,broken")
+
+	config := schedulerFromEnv()
+	assert.Equal(t, time.Minute, config.Interval)
+	assert.Equal(t, 5*time.Minute, config.Jitter)
+	assert.Equal(t, defaultScheduleRateWindow, config.RateWindow)
+	assert.Equal(t, 30, config.rateLimit("github.com"))
+	assert.Equal(t, 2, config.rateLimit("git.example.com"))
+	assert.Equal(t, 10, config.rateLimit("gitlab.com"))
+}
@@ -0,0 +1,210 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stuttgart-things/redisqueue"
+)
+
+// job sources
+const (
+	JobSourceRedis = "redis"
+	JobSourceNATS  = "nats"
+)
+
+// JobSource delivers the messages of the analyzer's streams to the
+// registered handlers. A message is acknowledged if its handler returns nil,
+// otherwise it is delivered again later, also to another replica.
+type JobSource interface {
+	// Register sets the handler of a stream, before Run is called
+	Register(stream string, fn redisqueue.ConsumerFunc)
+	// Run delivers messages until Shutdown is called and the messages in
+	// flight are handled
+	Run()
+	Shutdown()
+	// Errors receives the errors of the source and the handlers
+	Errors() <-chan error
+}
+
+// redisSource reads the redis streams with a consumer group, messages are
+// reclaimed after their visibility timeout
+type redisSource struct {
+	c *redisqueue.Consumer
+}
+
+// NewRedisSource returns the redisqueue consumer as job source
+func NewRedisSource(c *redisqueue.Consumer) JobSource {
+	return redisSource{c: c}
+}
+
+func (s redisSource) Register(stream string, fn redisqueue.ConsumerFunc) {
+	s.c.Register(stream, fn)
+}
+
+func (s redisSource) Run() {
+	s.c.Run()
+}
+
+func (s redisSource) Shutdown() {
+	s.c.Shutdown()
+}
+
+func (s redisSource) Errors() <-chan error {
+	return s.c.Errors
+}
+
+// streamPerConsumerSource reads each stream with its own consumer. The
+// streams of one XREADGROUP must be in the same hash slot of a cluster,
+// which the names of the analyzer's streams are not.
+type streamPerConsumerSource struct {
+	newConsumer func() (*redisqueue.Consumer, error)
+	consumers   []*redisqueue.Consumer
+	errs        chan error
+}
+
+// NewStreamPerConsumerSource returns a source creating a consumer per
+// registered stream
+func NewStreamPerConsumerSource(newConsumer func() (*redisqueue.Consumer, error)) JobSource {
+	return &streamPerConsumerSource{newConsumer: newConsumer, errs: make(chan error, 100)}
+}
+
+func (s *streamPerConsumerSource) Register(stream string, fn redisqueue.ConsumerFunc) {
+
+	c, err := s.newConsumer()
+	if err != nil {
+		sendError(s.errs, fmt.Errorf("could not create consumer of %s: %w", stream, err))
+		return
+	}
+
+	c.Register(stream, fn)
+	s.consumers = append(s.consumers, c)
+}
+
+func (s *streamPerConsumerSource) Run() {
+
+	consumers := &sync.WaitGroup{}
+	for _, c := range s.consumers {
+		consumers.Add(1)
+		go func(c *redisqueue.Consumer) {
+			defer consumers.Done()
+			c.Run()
+		}(c)
+
+		// the errors of all consumers are merged
+		go func(c *redisqueue.Consumer) {
+			for err := range c.Errors {
+				sendError(s.errs, err)
+			}
+		}(c)
+	}
+
+	consumers.Wait()
+}
+
+func (s *streamPerConsumerSource) Shutdown() {
+	for _, c := range s.consumers {
+		c.Shutdown()
+	}
+}
+
+func (s *streamPerConsumerSource) Errors() <-chan error {
+	return s.errs
+}
+
+// jobSourceFromEnv creates the job source of JOB_SOURCE, redis by default
+func jobSourceFromEnv() (JobSource, error) {
+
+	switch source := os.Getenv("JOB_SOURCE"); source {
+	case "", JobSourceRedis:
+		newConsumer := func() (*redisqueue.Consumer, error) {
+			return redisqueue.NewConsumerWithOptions(&redisqueue.ConsumerOptions{
+				VisibilityTimeout: visibilityTimeout,
+				BlockingTimeout:   5 * time.Second,
+				ReclaimInterval:   1 * time.Second,
+				BufferSize:        100,
+				Concurrency:       concurrency,
+				RedisClient:       redisUtil.Client,
+			})
+		}
+		if redisUtil.IsCluster() {
+			return NewStreamPerConsumerSource(newConsumer), nil
+		}
+		c, err := newConsumer()
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisSource(c), nil
+
+	case JobSourceNATS:
+		options, err := natsOptionsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewNATSSource(options)
+
+	default:
+		return nil, fmt.Errorf("unknown job source %q, expected %s or %s", source, JobSourceRedis, JobSourceNATS)
+	}
+}
+
+// natsOptionsFromEnv reads the options of the NATS job source and producer:
+// NATS_URL, NATS_STREAM and NATS_MAX_DELIVER
+func natsOptionsFromEnv() (NATSOptions, error) {
+
+	options := NATSOptions{
+		URL:    os.Getenv("NATS_URL"),
+		Stream: os.Getenv("NATS_STREAM"),
+	}
+	if maxDeliver := os.Getenv("NATS_MAX_DELIVER"); maxDeliver != "" {
+		n, err := strconv.Atoi(maxDeliver)
+		if err != nil {
+			return options, fmt.Errorf("could not convert NATS_MAX_DELIVER into int: %s", maxDeliver)
+		}
+		options.MaxDeliver = n
+	}
+
+	return options, nil
+}
+
+// decodeValues decodes the values of a message published as json object.
+// Strings are taken as they are, other values as their json text, e.g. true,
+// like the values of a redis stream message.
+func decodeValues(data []byte) (map[string]interface{}, error) {
+
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("message is no json object: %w", err)
+	}
+
+	values := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		var s string
+		switch {
+		case string(v) == "null":
+		case json.Unmarshal(v, &s) == nil:
+			values[k] = s
+		default:
+			values[k] = string(v)
+		}
+	}
+
+	return values, nil
+}
+
+// sendError sends the error to the errors of a source, unless they are not
+// read and the buffer is full
+func sendError(errs chan error, err error) {
+	select {
+	case errs <- err:
+	default:
+	}
+}
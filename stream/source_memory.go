@@ -0,0 +1,171 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stuttgart-things/redisqueue"
+)
+
+// MemorySource delivers the messages added to it in the process, for tests
+// and pipelines feeding the handlers directly, like analyze --file. Failed
+// messages are added again after the retry delay.
+type MemorySource struct {
+	// RetryDelay is the time a failed message is delivered again after
+	RetryDelay  time.Duration
+	Concurrency int
+
+	mu       sync.Mutex
+	handlers map[string]redisqueue.ConsumerFunc
+	queue    chan *redisqueue.Message
+	seq      int
+	// unacked are the added messages not yet acknowledged
+	unacked  sync.WaitGroup
+	stop     chan struct{}
+	stopOnce sync.Once
+	errs     chan error
+}
+
+// NewMemorySource returns a source buffering up to bufferSize messages, Add
+// blocks while the buffer is full
+func NewMemorySource(bufferSize int) *MemorySource {
+	return &MemorySource{
+		RetryDelay:  time.Second,
+		Concurrency: concurrency,
+		handlers:    make(map[string]redisqueue.ConsumerFunc),
+		queue:       make(chan *redisqueue.Message, bufferSize),
+		stop:        make(chan struct{}),
+		errs:        make(chan error, 100),
+	}
+}
+
+func (s *MemorySource) Register(stream string, fn redisqueue.ConsumerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[stream] = fn
+}
+
+// Add adds a message to the stream and returns its id
+func (s *MemorySource) Add(stream string, values map[string]interface{}) string {
+
+	s.mu.Lock()
+	s.seq++
+	id := fmt.Sprintf("%d-0", s.seq)
+	s.mu.Unlock()
+
+	s.unacked.Add(1)
+	s.enqueue(&redisqueue.Message{ID: id, Stream: stream, Values: values})
+
+	return id
+}
+
+// enqueue queues a message, or drops it once the source is shut down
+func (s *MemorySource) enqueue(msg *redisqueue.Message) {
+
+	select {
+	case s.queue <- msg:
+		// queued while shutting down, no worker takes it anymore
+		select {
+		case <-s.stop:
+			s.drop()
+		default:
+		}
+	case <-s.stop:
+		s.unacked.Done()
+	}
+}
+
+// drop drops the queued messages
+func (s *MemorySource) drop() {
+	for {
+		select {
+		case <-s.queue:
+			s.unacked.Done()
+		default:
+			return
+		}
+	}
+}
+
+// Wait blocks until all added messages are acknowledged or dropped by
+// Shutdown
+func (s *MemorySource) Wait() {
+	s.unacked.Wait()
+}
+
+// Run handles the messages until Shutdown is called
+func (s *MemorySource) Run() {
+
+	s.mu.Lock()
+	registered := len(s.handlers)
+	s.mu.Unlock()
+	if registered == 0 {
+		sendError(s.errs, errors.New("at least one consumer function needs to be registered"))
+		return
+	}
+
+	workers := &sync.WaitGroup{}
+	for i := 0; i < s.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case <-s.stop:
+					return
+				case msg := <-s.queue:
+					s.handle(msg)
+				}
+			}
+		}()
+	}
+
+	workers.Wait()
+}
+
+// Shutdown stops the workers once their messages are handled, messages not
+// yet handled and failed messages waiting for their retry are dropped
+func (s *MemorySource) Shutdown() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.drop()
+}
+
+func (s *MemorySource) Errors() <-chan error {
+	return s.errs
+}
+
+func (s *MemorySource) handle(msg *redisqueue.Message) {
+
+	s.mu.Lock()
+	fn, ok := s.handlers[msg.Stream]
+	s.mu.Unlock()
+
+	if !ok {
+		sendError(s.errs, fmt.Errorf("no consumer function registered for %q stream", msg.Stream))
+		s.unacked.Done()
+		return
+	}
+
+	if err := fn(msg); err != nil {
+		sendError(s.errs, fmt.Errorf("error calling ConsumerFunc for %q stream and %q message: %w", msg.Stream, msg.ID, err))
+		go func() {
+			retry := time.NewTimer(s.RetryDelay)
+			defer retry.Stop()
+			select {
+			case <-retry.C:
+				s.enqueue(msg)
+			case <-s.stop:
+				s.unacked.Done()
+			}
+		}()
+		return
+	}
+
+	s.unacked.Done()
+}
@@ -0,0 +1,382 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stuttgart-things/redisqueue"
+)
+
+// NATSOptions configure the NATS JetStream job source
+type NATSOptions struct {
+	// URL of the NATS server, nats://127.0.0.1:4222 by default
+	URL string
+	// Stream is the JetStream stream holding the subjects, named like the
+	// redis streams. It is created if missing, "sweatShop" by default.
+	Stream string
+	// Durable prefixes the names of the durable consumers, which are shared
+	// by the replicas like the redis consumer group, "sweatShop-analyzer" by
+	// default
+	Durable string
+	// AckWait is the time a message may be handled before it is delivered
+	// again, like the visibility timeout of the redis consumer
+	AckWait time.Duration
+	// RetryDelay is the time a failed message is delivered again after,
+	// AckWait by default
+	RetryDelay time.Duration
+	// MaxDeliver limits the deliveries of a message, unlimited if zero
+	MaxDeliver  int
+	Concurrency int
+	// FetchTimeout is the time a fetch waits for messages
+	FetchTimeout time.Duration
+}
+
+// setDefaults sets the url and the stream shared by the source and the
+// producer, if they are empty
+func (o *NATSOptions) setDefaults() {
+	if o.URL == "" {
+		o.URL = nats.DefaultURL
+	}
+	if o.Stream == "" {
+		o.Stream = "sweatShop"
+	}
+}
+
+// connectNATS connects to the NATS server of the url with the client name
+func connectNATS(url, name string) (*nats.Conn, nats.JetStreamContext, error) {
+
+	nc, err := nats.Connect(url, nats.Name(name))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to nats %s: %w", url, err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, nil, fmt.Errorf("could not get jetstream context: %w", err)
+	}
+
+	return nc, js, nil
+}
+
+// NATSSource reads the streams from NATS JetStream pull consumers, messages
+// are json objects of the values of a redis stream message
+type NATSSource struct {
+	options  NATSOptions
+	nc       *nats.Conn
+	js       nats.JetStreamContext
+	handlers map[string]redisqueue.ConsumerFunc
+	queue    chan *nats.Msg
+	stop     chan struct{}
+	stopOnce sync.Once
+	errs     chan error
+}
+
+// NewNATSSource connects to the NATS server of the options
+func NewNATSSource(options NATSOptions) (*NATSSource, error) {
+
+	options.setDefaults()
+	if options.Durable == "" {
+		options.Durable = "sweatShop-analyzer"
+	}
+	if options.AckWait == 0 {
+		options.AckWait = visibilityTimeout
+	}
+	if options.RetryDelay == 0 {
+		options.RetryDelay = options.AckWait
+	}
+	if options.Concurrency == 0 {
+		options.Concurrency = concurrency
+	}
+	if options.FetchTimeout == 0 {
+		options.FetchTimeout = 5 * time.Second
+	}
+
+	nc, js, err := connectNATS(options.URL, options.Durable)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATSSource{
+		options:  options,
+		nc:       nc,
+		js:       js,
+		handlers: make(map[string]redisqueue.ConsumerFunc),
+		queue:    make(chan *nats.Msg),
+		stop:     make(chan struct{}),
+		errs:     make(chan error, 100),
+	}, nil
+}
+
+func (s *NATSSource) Register(stream string, fn redisqueue.ConsumerFunc) {
+	s.handlers[stream] = fn
+}
+
+// Run creates the stream and the consumers and handles the messages until
+// Shutdown is called or the process is interrupted. The connection is closed
+// when it returns.
+func (s *NATSSource) Run() {
+
+	defer s.nc.Close()
+
+	if len(s.handlers) == 0 {
+		sendError(s.errs, errors.New("at least one consumer function needs to be registered"))
+		return
+	}
+
+	if err := s.ensureStream(); err != nil {
+		sendError(s.errs, err)
+		return
+	}
+
+	subs := make([]*nats.Subscription, 0, len(s.handlers))
+	for subject := range s.handlers {
+		opts := []nats.SubOpt{nats.BindStream(s.options.Stream), nats.ManualAck(), nats.AckWait(s.options.AckWait), nats.DeliverAll()}
+		if s.options.MaxDeliver > 0 {
+			opts = append(opts, nats.MaxDeliver(s.options.MaxDeliver))
+		}
+
+		sub, err := s.js.PullSubscribe(subject, s.durable(subject), opts...)
+		if err != nil {
+			sendError(s.errs, fmt.Errorf("could not subscribe to %s: %w", subject, err))
+			return
+		}
+		subs = append(subs, sub)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			s.Shutdown()
+		case <-s.stop:
+		}
+	}()
+
+	fetchers := &sync.WaitGroup{}
+	for _, sub := range subs {
+		fetchers.Add(1)
+		go func(sub *nats.Subscription) {
+			defer fetchers.Done()
+			s.fetch(sub)
+		}(sub)
+	}
+
+	workers := &sync.WaitGroup{}
+	for i := 0; i < s.options.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for msg := range s.queue {
+				s.handle(msg)
+			}
+		}()
+	}
+
+	fetchers.Wait()
+	close(s.queue)
+	workers.Wait()
+}
+
+// Shutdown stops fetching messages, Run returns once the fetched ones are
+// handled
+func (s *NATSSource) Shutdown() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *NATSSource) Errors() <-chan error {
+	return s.errs
+}
+
+// ensureStream creates the stream or adds the registered subjects to it
+func (s *NATSSource) ensureStream() error {
+
+	subjects := make([]string, 0, len(s.handlers))
+	for subject := range s.handlers {
+		subjects = append(subjects, subject)
+	}
+
+	return ensureNATSStream(s.js, s.options.Stream, subjects)
+}
+
+// ensureNATSStream creates the stream or adds the missing subjects to it
+func ensureNATSStream(js nats.JetStreamContext, stream string, subjects []string) error {
+
+	info, err := js.StreamInfo(stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		if _, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: subjects}); err != nil {
+			return fmt.Errorf("could not create stream %s: %w", stream, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get stream %s: %w", stream, err)
+	}
+
+	config := info.Config
+	missing := false
+	for _, subject := range subjects {
+		if !containsString(config.Subjects, subject) {
+			config.Subjects = append(config.Subjects, subject)
+			missing = true
+		}
+	}
+	if missing {
+		if _, err := js.UpdateStream(&config); err != nil {
+			return fmt.Errorf("could not add subjects to stream %s: %w", stream, err)
+		}
+	}
+
+	return nil
+}
+
+// fetch passes the messages of the subscription to the workers until the
+// source is stopped
+func (s *NATSSource) fetch(sub *nats.Subscription) {
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		// one at a time, so a fetched message does not wait for a worker until its ack wait passed
+		msgs, err := sub.Fetch(1, nats.MaxWait(s.options.FetchTimeout))
+		if err != nil && !errors.Is(err, nats.ErrTimeout) {
+			sendError(s.errs, fmt.Errorf("error fetching %s: %w", sub.Subject, err))
+			// the connection is reconnecting or closed
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+
+		for _, msg := range msgs {
+			s.queue <- msg
+		}
+	}
+}
+
+// handle calls the handler of the message and acknowledges it, or delivers
+// it again after the retry delay if the handler fails
+func (s *NATSSource) handle(msg *nats.Msg) {
+
+	id := ""
+	if meta, err := msg.Metadata(); err == nil {
+		id = strconv.FormatUint(meta.Sequence.Stream, 10)
+	}
+
+	values, err := decodeValues(msg.Data)
+	if err != nil {
+		// it would fail again
+		sendError(s.errs, fmt.Errorf("invalid message %s of %s: %w", id, msg.Subject, err))
+		if err := msg.Term(); err != nil {
+			sendError(s.errs, fmt.Errorf("error terminating %q message: %w", id, err))
+		}
+		return
+	}
+
+	err = s.handlers[msg.Subject](&redisqueue.Message{ID: id, Stream: msg.Subject, Values: values})
+	if err != nil {
+		sendError(s.errs, fmt.Errorf("error calling ConsumerFunc for %q stream and %q message: %w", msg.Subject, id, err))
+		if err := msg.NakWithDelay(s.options.RetryDelay); err != nil {
+			sendError(s.errs, fmt.Errorf("error rejecting after failure for %q stream and %q message: %w", msg.Subject, id, err))
+		}
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		sendError(s.errs, fmt.Errorf("error acknowledging after success for %q stream and %q message: %w", msg.Subject, id, err))
+	}
+}
+
+// durable returns the name of the consumer of a subject, which must not
+// contain dots, wildcards or whitespace
+func (s *NATSSource) durable(subject string) string {
+	return s.options.Durable + "_" + strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", ":", "_").Replace(subject)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// natsPublisher publishes messages as json objects to the subjects of the
+// NATS job source
+type natsPublisher struct {
+	nc      *nats.Conn
+	js      nats.JetStreamContext
+	stream  string
+	mu      sync.Mutex
+	ensured map[string]bool
+}
+
+// newNATSPublisher connects to the NATS server of the options
+func newNATSPublisher(options NATSOptions) (*natsPublisher, error) {
+
+	options.setDefaults()
+
+	nc, js, err := connectNATS(options.URL, "sweatShop-analyzer-producer")
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsPublisher{nc: nc, js: js, stream: options.Stream, ensured: make(map[string]bool)}, nil
+}
+
+// Publish publishes the values to the subject of the stream, which is added
+// to the JetStream stream first, a publish to a subject of no stream is lost
+func (p *natsPublisher) Publish(ctx context.Context, stream string, values map[string]interface{}) error {
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("could not encode message: %w", err)
+	}
+
+	p.mu.Lock()
+	if !p.ensured[stream] {
+		if err := ensureNATSStream(p.js, p.stream, []string{stream}); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+		p.ensured[stream] = true
+	}
+	p.mu.Unlock()
+
+	if _, err := p.js.Publish(stream, data, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("could not publish to %s: %w", stream, err)
+	}
+
+	return nil
+}
+
+func (*natsPublisher) System() string {
+	return "nats"
+}
+
+func (p *natsPublisher) Close() error {
+	p.nc.Close()
+	return nil
+}
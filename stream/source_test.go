@@ -0,0 +1,316 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stuttgart-things/redisqueue"
+)
+
+// recorder records the messages of a stream and fails their first delivery
+type recorder struct {
+	mu       sync.Mutex
+	messages []*redisqueue.Message
+}
+
+func (r *recorder) handle(msg *redisqueue.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, msg)
+	if len(r.messages) == 1 {
+		return errors.New("temporary failure")
+	}
+	return nil
+}
+
+func (r *recorder) received() []*redisqueue.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*redisqueue.Message(nil), r.messages...)
+}
+
+// testJobSource checks that a failed message is delivered again and an
+// acknowledged one is not
+func testJobSource(t *testing.T, source JobSource, add func(stream string, values map[string]interface{})) {
+
+	analyze, control := &recorder{}, &recorder{}
+	source.Register(AnalyzeStream, analyze.handle)
+	source.Register(ControlStream, control.handle)
+
+	done := make(chan struct{})
+	go func() {
+		source.Run()
+		close(done)
+	}()
+
+	add(AnalyzeStream, map[string]interface{}{FieldURL: "https://github.com/org/repo", FieldRevision: "main"})
+
+	require.Eventually(t, func() bool { return len(analyze.received()) == 2 }, 10*time.Second, 10*time.Millisecond)
+	messages := analyze.received()
+	assert.Equal(t, messages[0].ID, messages[1].ID)
+	assert.Equal(t, AnalyzeStream, messages[1].Stream)
+	assert.Equal(t, map[string]interface{}{FieldURL: "https://github.com/org/repo", FieldRevision: "main"}, messages[1].Values)
+
+	add(ControlStream, map[string]interface{}{FieldCommand: CommandPurge, FieldPattern: "*"})
+	require.Eventually(t, func() bool { return len(control.received()) == 2 }, 10*time.Second, 10*time.Millisecond)
+
+	// nothing is delivered again after the acknowledgement
+	time.Sleep(200 * time.Millisecond)
+	assert.Len(t, analyze.received(), 2)
+	assert.Len(t, control.received(), 2)
+
+	source.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("source did not stop")
+	}
+
+	select {
+	case err := <-source.Errors():
+		assert.ErrorContains(t, err, "temporary failure")
+	default:
+		t.Error("the failure was not reported")
+	}
+}
+
+func TestMemorySource(t *testing.T) {
+
+	source := NewMemorySource(10)
+	source.RetryDelay = 10 * time.Millisecond
+
+	testJobSource(t, source, func(stream string, values map[string]interface{}) {
+		source.Add(stream, values)
+	})
+}
+
+func TestMemorySourceWait(t *testing.T) {
+
+	source := NewMemorySource(10)
+	source.RetryDelay = time.Millisecond
+
+	r := &recorder{}
+	source.Register(AnalyzeStream, r.handle)
+	go source.Run()
+	defer source.Shutdown()
+
+	for i := 0; i < 3; i++ {
+		source.Add(AnalyzeStream, map[string]interface{}{FieldURL: "https://github.com/org/repo"})
+	}
+	source.Wait()
+	assert.Len(t, r.received(), 4)
+}
+
+func TestMemorySourceShutdown(t *testing.T) {
+
+	// waits for the messages dropped by the shutdown
+	wait := func(source *MemorySource) {
+		done := make(chan struct{})
+		go func() {
+			source.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("wait did not return after the shutdown")
+		}
+	}
+
+	// a message waiting for its retry and queued messages
+	source := NewMemorySource(10)
+	source.RetryDelay = time.Hour
+	source.Concurrency = 1
+	source.Register(AnalyzeStream, func(msg *redisqueue.Message) error {
+		return errors.New("temporary failure")
+	})
+	go source.Run()
+
+	source.Add(AnalyzeStream, map[string]interface{}{FieldURL: "https://github.com/org/repo"})
+	select {
+	case err := <-source.Errors():
+		assert.ErrorContains(t, err, "temporary failure")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the message was not handled")
+	}
+
+	source.Shutdown()
+	wait(source)
+
+	// messages of a source that never ran and added after the shutdown
+	source = NewMemorySource(1)
+	source.Add(AnalyzeStream, map[string]interface{}{FieldURL: "https://github.com/org/repo"})
+	source.Shutdown()
+	source.Add(AnalyzeStream, map[string]interface{}{FieldURL: "https://github.com/org/repo"})
+	source.Add(AnalyzeStream, map[string]interface{}{FieldURL: "https://github.com/org/repo"})
+	wait(source)
+}
+
+// serverInfo answers INFO server, which redisqueue checks for the version
+// of redis, but miniredis does not support
+type serverInfo struct{}
+
+func (serverInfo) DialHook(next goredis.DialHook) goredis.DialHook {
+	return next
+}
+
+func (serverInfo) ProcessHook(next goredis.ProcessHook) goredis.ProcessHook {
+	return func(ctx context.Context, cmd goredis.Cmder) error {
+		if info, ok := cmd.(*goredis.StringCmd); ok && cmd.Name() == "info" {
+			info.SetVal("# Server\r\nredis_version:7.0.0\r\n")
+			return nil
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (serverInfo) ProcessPipelineHook(next goredis.ProcessPipelineHook) goredis.ProcessPipelineHook {
+	return next
+}
+
+func TestStreamPerConsumerSource(t *testing.T) {
+
+	s := miniredis.RunT(t)
+	client := goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{s.Addr()}})
+	client.AddHook(serverInfo{})
+
+	source := NewStreamPerConsumerSource(func() (*redisqueue.Consumer, error) {
+		return redisqueue.NewConsumerWithOptions(&redisqueue.ConsumerOptions{
+			VisibilityTimeout: 50 * time.Millisecond,
+			BlockingTimeout:   10 * time.Millisecond,
+			ReclaimInterval:   10 * time.Millisecond,
+			BufferSize:        10,
+			Concurrency:       1,
+			RedisClient:       client,
+		})
+	})
+
+	testJobSource(t, source, func(stream string, values map[string]interface{}) {
+		require.NoError(t, client.XAdd(context.Background(), &goredis.XAddArgs{Stream: stream, Values: values}).Err())
+	})
+}
+
+// runNATSServer runs an embedded nats server with jetstream
+func runNATSServer(t *testing.T) string {
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+
+	go srv.Start()
+	t.Cleanup(srv.Shutdown)
+	require.True(t, srv.ReadyForConnections(10*time.Second))
+
+	return srv.ClientURL()
+}
+
+func TestNATSSource(t *testing.T) {
+
+	url := runNATSServer(t)
+
+	nc, err := nats.Connect(url)
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+
+	// the missing subjects are added to an existing stream
+	_, err = js.AddStream(&nats.StreamConfig{Name: "sweatShop", Subjects: []string{AnalyzeStream}})
+	require.NoError(t, err)
+
+	source, err := NewNATSSource(NATSOptions{URL: url, AckWait: time.Second, RetryDelay: 10 * time.Millisecond, FetchTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	testJobSource(t, source, func(stream string, values map[string]interface{}) {
+		data, err := json.Marshal(values)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			_, err := js.Publish(stream, data)
+			return err == nil
+		}, 10*time.Second, 10*time.Millisecond)
+	})
+
+	info, err := js.StreamInfo("sweatShop")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{AnalyzeStream, ControlStream}, info.Config.Subjects)
+
+	consumer, err := js.ConsumerInfo("sweatShop", "sweatShop-analyzer_sweatShop_analyze")
+	require.NoError(t, err)
+	assert.Zero(t, consumer.NumAckPending)
+	assert.Zero(t, consumer.NumPending)
+}
+
+func TestNATSSourceInvalidMessage(t *testing.T) {
+
+	url := runNATSServer(t)
+
+	source, err := NewNATSSource(NATSOptions{URL: url, Stream: "invalid", FetchTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	r := &recorder{}
+	source.Register(AnalyzeStream, r.handle)
+	go source.Run()
+	defer source.Shutdown()
+
+	nc, err := nats.Connect(url)
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := js.Publish(AnalyzeStream, []byte("url=https://github.com/org/repo"))
+		return err == nil
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// it is terminated instead of being delivered again
+	select {
+	case err := <-source.Errors():
+		assert.ErrorContains(t, err, "message is no json object")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the invalid message was not reported")
+	}
+	assert.Empty(t, r.received())
+}
+
+func Test_decodeValues(t *testing.T) {
+
+	values, err := decodeValues([]byte(`{"url": "https://github.com/org/repo", "insecure": true, "depth": 2, "revision": null}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"url": "https://github.com/org/repo", "insecure": "true", "depth": "2"}, values)
+
+	_, err = decodeValues([]byte(`["url"]`))
+	assert.Error(t, err)
+}
+
+func TestProducerFromEnv(t *testing.T) {
+
+	t.Setenv("JOB_SOURCE", "kafka")
+	_, err := ProducerFromEnv(nil)
+	assert.ErrorContains(t, err, `unknown job source "kafka"`)
+
+	t.Setenv("JOB_SOURCE", JobSourceNATS)
+	t.Setenv("NATS_MAX_DELIVER", "many")
+	_, err = ProducerFromEnv(nil)
+	assert.ErrorContains(t, err, "NATS_MAX_DELIVER")
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stuttgart-things/sweatShop-analyzer/webhook"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultWebhookPath is used if WEBHOOK_PATH is not set
+const defaultWebhookPath = "/webhook"
+
+// webhookHandler enqueues an analysis request per verified push webhook
+type webhookHandler struct {
+	secrets  webhook.Secrets
+	producer enqueuer
+}
+
+// webhooksFromEnv reads the listen address, the path and the secrets of the
+// webhook receiver from the environment. Without an address, no webhooks
+// are received.
+func webhooksFromEnv() (string, string, webhook.Secrets) {
+
+	path := os.Getenv("WEBHOOK_PATH")
+	if path == "" {
+		path = defaultWebhookPath
+	}
+
+	return os.Getenv("WEBHOOK_ADDR"), path, webhook.Secrets{
+		GitHub: os.Getenv("WEBHOOK_SECRET_GITHUB"),
+		Gitea:  os.Getenv("WEBHOOK_SECRET_GITEA"),
+		GitLab: os.Getenv("WEBHOOK_SECRET_GITLAB"),
+	}
+}
+
+// serveWebhooks receives the push webhooks on addr until the server fails
+func serveWebhooks(addr, path string, handler http.Handler) {
+
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+	}
+
+	log.Info("RECEIVING WEBHOOKS ON ", addr+path)
+
+	if err := srv.ListenAndServe(); err != nil {
+		log.Errorf("COULD NOT RECEIVE WEBHOOKS: %s", err.Error())
+	}
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	ctx, span := tracer.Start(r.Context(), "webhook", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeWebhookResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	event, err := webhook.Parse(r, h.secrets)
+	var ignored *webhook.IgnoredError
+	switch {
+	case err == nil:
+	case errors.As(err, &ignored):
+		writeWebhookResponse(w, http.StatusOK, map[string]string{"ignored": ignored.Reason})
+		return
+	case errors.Is(err, webhook.ErrInvalidSignature):
+		log.Warnf("INVALID WEBHOOK SIGNATURE FROM %s", r.RemoteAddr)
+		span.SetStatus(codes.Error, err.Error())
+		writeWebhookResponse(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, webhook.ErrDisabled):
+		span.SetStatus(codes.Error, err.Error())
+		writeWebhookResponse(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return
+	default:
+		span.SetStatus(codes.Error, err.Error())
+		writeWebhookResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("webhook.provider", event.Provider),
+		attribute.String("repo.url", event.CloneURL),
+		attribute.String("repo.revision", event.Revision),
+		attribute.String("git.commit", event.After),
+		attribute.Bool("webhook.files", event.Complete),
+	)
+
+	jobID, err := h.producer.Enqueue(ctx, pushRequest(event))
+	if err != nil {
+		log.Errorf("COULD NOT ENQUEUE ANALYSIS OF PUSH TO %s: %s", event.CloneURL, err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		writeWebhookResponse(w, http.StatusInternalServerError, map[string]string{"error": "could not enqueue analysis"})
+		return
+	}
+
+	log.Infof("PUSH OF %s TO %s ENQUEUED AS JOB %s", event.Revision, event.CloneURL, jobID)
+	writeWebhookResponse(w, http.StatusAccepted, map[string]string{FieldJobID: jobID})
+}
+
+// pushRequest returns the analysis request of a push. The pushed files are
+// passed on, if the webhook listed all of them.
+func pushRequest(e *webhook.PushEvent) map[string]interface{} {
+
+	values := map[string]interface{}{
+		FieldURL:      e.CloneURL,
+		FieldRevision: e.Revision,
+		FieldName:     e.Name,
+	}
+
+	if e.Complete {
+		values[FieldPushBefore] = e.Before
+		values[FieldPushAfter] = e.After
+		values[FieldPushAddedFiles] = strings.Join(e.Added, "\n")
+		values[FieldPushRemovedFiles] = strings.Join(e.Removed, "\n")
+	}
+
+	return values
+}
+
+func writeWebhookResponse(w http.ResponseWriter, status int, body map[string]string) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("COULD NOT WRITE WEBHOOK RESPONSE: %s", err.Error())
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package stream
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stuttgart-things/sweatShop-analyzer/webhook"
+)
+
+func TestWebhookHandler(t *testing.T) {
+
+	payload := `{
+		"ref": "refs/heads/main",
+		"before": "a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1",
+		"after": "b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2",
+		"repository": {"name": "flux2", "clone_url": "https://github.com/fluxcd/flux2.git"},
+		"commits": [{"added": ["Dockerfile", "chart/Chart.yaml"], "removed": ["Makefile"], "modified": ["go.mod"]}]
+	}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(payload))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	producer := &enqueuerMock{}
+	h := &webhookHandler{secrets: webhook.Secrets{GitHub: "secret"}, producer: producer}
+
+	serve := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/webhook", bytes.NewBufferString(payload))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodPost, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": signature})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"job_id": "job-1"}`, w.Body.String())
+	assert.Equal(t, []map[string]interface{}{{
+		FieldURL:              "https://github.com/fluxcd/flux2.git",
+		FieldRevision:         "main",
+		FieldName:             "flux2",
+		FieldPushBefore:       "a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1",
+		FieldPushAfter:        "b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2",
+		FieldPushAddedFiles:   "Dockerfile\nchart/Chart.yaml",
+		FieldPushRemovedFiles: "Makefile",
+	}}, producer.requests)
+
+	for _, tc := range []struct {
+		method  string
+		headers map[string]string
+		code    int
+	}{
+		{http.MethodPost, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=00"}, http.StatusUnauthorized},
+		{http.MethodPost, map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": signature}, http.StatusOK},
+		{http.MethodPost, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "secret"}, http.StatusForbidden},
+		{http.MethodPost, map[string]string{}, http.StatusBadRequest},
+		{http.MethodGet, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": signature}, http.StatusMethodNotAllowed},
+	} {
+		assert.Equal(t, tc.code, serve(tc.method, tc.headers).Code, tc.headers)
+	}
+
+	// only the first request was enqueued
+	assert.Len(t, producer.requests, 1)
+}
+
+func Test_pushRequest(t *testing.T) {
+
+	// without the complete list of files, the analysis computes the diff
+	assert.Equal(t, map[string]interface{}{
+		FieldURL:      "https://gitlab.com/group/infra.git",
+		FieldRevision: "v1.0.0",
+		FieldName:     "infra",
+	}, pushRequest(&webhook.PushEvent{
+		CloneURL: "https://gitlab.com/group/infra.git",
+		Name:     "infra",
+		Revision: "v1.0.0",
+		Before:   "a1",
+		After:    "b2",
+	}))
+}
@@ -2,7 +2,14 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/nitishm/go-rejson/v4"
 	goredis "github.com/redis/go-redis/v9"
@@ -14,13 +21,47 @@ var ErrJSONMissWithGoRedisClient = "redis: nil"
 // var ErrJSONMissWithRedigoConn = errors.New("redigo: nil returned")
 
 type Redis struct {
-	Server      string
-	Port        int
-	Password    string
-	Client      *goredis.Client
+	Server   string
+	Port     int
+	Password string
+	// Client is a *goredis.Client, or a *goredis.ClusterClient in cluster mode
+	Client      goredis.UniversalClient
 	JSONHandler *rejson.Handler
 }
 
+// Options configure the connection to a single server, the master of a
+// sentinel group or a cluster
+type Options struct {
+	// Addrs are the host:port of the server, the sentinels or the cluster nodes
+	Addrs    []string
+	Username string
+	Password string
+	// DB is the database of a single server or sentinel master, clusters
+	// only have database 0
+	DB int
+	// MasterName is the name of the master monitored by the sentinels
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+	// Cluster connects to a cluster, also with a single seed node
+	Cluster bool
+	// TLS is used if not nil
+	TLS *TLSOptions
+}
+
+// TLSOptions configure the tls connections, by default the server is
+// verified with the system's CA pool
+type TLSOptions struct {
+	// CAFile replaces the system's CA pool to verify the server
+	CAFile string
+	// CertFile and KeyFile are the client certificate
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	// InsecureSkipVerify does not verify the server certificate
+	InsecureSkipVerify bool
+}
+
 func newRedis(server string, port int, password string) *Redis {
 	return &Redis{
 		Server:   server,
@@ -39,6 +80,190 @@ func NewRedisWithClient(server string, port int, password string) *Redis {
 	return r
 }
 
+// NewRedisWithOptions creates the client of the options, a failover client
+// with a master name and a cluster client in cluster mode
+func NewRedisWithOptions(o *Options) (*Redis, error) {
+
+	if len(o.Addrs) == 0 {
+		return nil, errors.New("no redis address configured")
+	}
+	if o.Cluster && o.MasterName != "" {
+		return nil, errors.New("redis cluster and sentinel master are exclusive")
+	}
+	if o.Cluster && o.DB != 0 {
+		return nil, fmt.Errorf("redis cluster has no database %d", o.DB)
+	}
+
+	uo := &goredis.UniversalOptions{
+		Addrs:            o.Addrs,
+		Username:         o.Username,
+		Password:         o.Password,
+		DB:               o.DB,
+		MasterName:       o.MasterName,
+		SentinelUsername: o.SentinelUsername,
+		SentinelPassword: o.SentinelPassword,
+	}
+
+	if o.TLS != nil {
+		config, err := o.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+		uo.TLSConfig = config
+	}
+
+	r := &Redis{Password: o.Password}
+	if host, port, err := net.SplitHostPort(o.Addrs[0]); err == nil {
+		r.Server = host
+		r.Port, _ = strconv.Atoi(port)
+	}
+
+	switch {
+	case o.Cluster:
+		r.Client = goredis.NewClusterClient(uo.Cluster())
+	case o.MasterName != "":
+		r.Client = goredis.NewFailoverClient(uo.Failover())
+	default:
+		r.Client = goredis.NewClient(uo.Simple())
+	}
+
+	return r, nil
+}
+
+// Config returns the tls config of the options
+func (o *TLSOptions) Config() (*tls.Config, error) {
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		ca, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read redis ca file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in redis ca file %s", o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load redis client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// OptionsFromEnv reads the options from the environment:
+//   - REDIS_ADDRS, comma separated host:port, or REDIS_SERVER and REDIS_PORT
+//   - REDIS_USERNAME, REDIS_PASSWORD and REDIS_DB
+//   - REDIS_MASTER_NAME, REDIS_SENTINEL_USERNAME and REDIS_SENTINEL_PASSWORD
+//     for a sentinel master, REDIS_CLUSTER=true for a cluster
+//   - REDIS_TLS=true, REDIS_TLS_CA_FILE, REDIS_TLS_CERT_FILE,
+//     REDIS_TLS_KEY_FILE, REDIS_TLS_SERVER_NAME and
+//     REDIS_TLS_INSECURE_SKIP_VERIFY
+func OptionsFromEnv() (*Options, error) {
+
+	o := &Options{
+		Username:         os.Getenv("REDIS_USERNAME"),
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		MasterName:       os.Getenv("REDIS_MASTER_NAME"),
+		SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+	}
+
+	if addrs := os.Getenv("REDIS_ADDRS"); addrs != "" {
+		for _, addr := range strings.Split(addrs, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				o.Addrs = append(o.Addrs, addr)
+			}
+		}
+	} else {
+		port, err := strconv.Atoi(os.Getenv("REDIS_PORT"))
+		if err != nil {
+			return nil, fmt.Errorf("could not convert redis port %q into int: %w", os.Getenv("REDIS_PORT"), err)
+		}
+		o.Addrs = []string{net.JoinHostPort(os.Getenv("REDIS_SERVER"), strconv.Itoa(port))}
+	}
+
+	if db := os.Getenv("REDIS_DB"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert redis db %q into int: %w", db, err)
+		}
+		o.DB = n
+	}
+
+	var err error
+	if o.Cluster, err = envBool("REDIS_CLUSTER"); err != nil {
+		return nil, err
+	}
+
+	useTLS, err := envBool("REDIS_TLS")
+	if err != nil {
+		return nil, err
+	}
+	if useTLS {
+		o.TLS = &TLSOptions{
+			CAFile:     os.Getenv("REDIS_TLS_CA_FILE"),
+			CertFile:   os.Getenv("REDIS_TLS_CERT_FILE"),
+			KeyFile:    os.Getenv("REDIS_TLS_KEY_FILE"),
+			ServerName: os.Getenv("REDIS_TLS_SERVER_NAME"),
+		}
+		if o.TLS.InsecureSkipVerify, err = envBool("REDIS_TLS_INSECURE_SKIP_VERIFY"); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+func envBool(name string) (bool, error) {
+
+	v := os.Getenv(name)
+	if v == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q for %s", v, name)
+	}
+
+	return b, nil
+}
+
+// NewRedisWithClientFromEnv creates a client with json handler from the
+// environment, see OptionsFromEnv
+func NewRedisWithClientFromEnv() (*Redis, error) {
+
+	o, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewRedisWithOptions(o)
+	if err != nil {
+		return nil, err
+	}
+	r.SetJSONHandler()
+
+	return r, nil
+}
+
+// IsCluster reports whether the client is connected to a redis cluster
+func (r *Redis) IsCluster() bool {
+	_, ok := r.Client.(*goredis.ClusterClient)
+	return ok
+}
+
 func (r *Redis) GetServerPort() string {
 	return fmt.Sprintf("%s:%d", r.Server, r.Port)
 }
@@ -0,0 +1,311 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stuttgart-things/redisqueue"
+)
+
+// withJSON adds the json commands used by the json handler to miniredis,
+// storing the documents as strings
+func withJSON(t *testing.T, m *miniredis.Miniredis) {
+
+	require.NoError(t, m.Server().Register("JSON.SET", func(c *server.Peer, cmd string, args []string) {
+		if len(args) < 3 {
+			c.WriteError("ERR wrong number of arguments")
+			return
+		}
+		require.NoError(t, m.Set(args[0], args[2]))
+		c.WriteOK()
+	}))
+	require.NoError(t, m.Server().Register("JSON.GET", func(c *server.Peer, cmd string, args []string) {
+		v, err := m.Get(args[0])
+		if err != nil {
+			c.WriteNull()
+			return
+		}
+		c.WriteBulk(v)
+	}))
+}
+
+// serverInfo answers INFO server, which redisqueue checks for the version
+// of redis, but miniredis does not support
+type serverInfo struct{}
+
+func (serverInfo) DialHook(next goredis.DialHook) goredis.DialHook {
+	return next
+}
+
+func (serverInfo) ProcessHook(next goredis.ProcessHook) goredis.ProcessHook {
+	return func(ctx context.Context, cmd goredis.Cmder) error {
+		if info, ok := cmd.(*goredis.StringCmd); ok && cmd.Name() == "info" {
+			info.SetVal("# Server\r\nredis_version:7.0.0\r\n")
+			return nil
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (serverInfo) ProcessPipelineHook(next goredis.ProcessPipelineHook) goredis.ProcessPipelineHook {
+	return next
+}
+
+// checkRedis checks the commands of the json handler and redisqueue
+func checkRedis(t *testing.T, r *Redis) {
+
+	ctx := context.Background()
+	r.Client.AddHook(serverInfo{})
+
+	require.NoError(t, r.Client.Ping(ctx).Err())
+
+	r.SetJSONHandler()
+	_, err := r.JSONHandler.JSONSet("analyzerresult|github.com/org/repo|main", ".", map[string]string{"Commit": "abc"})
+	assert.NoError(t, err)
+	doc, err := r.JSONHandler.JSONGet("analyzerresult|github.com/org/repo|main", ".")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Commit": "abc"}`, string(doc.([]byte)))
+
+	p, err := redisqueue.NewProducerWithOptions(&redisqueue.ProducerOptions{RedisClient: r.Client, MaxLen: 100, ApproximateMaxLength: true})
+	require.NoError(t, err)
+	assert.NoError(t, p.Enqueue(&redisqueue.Message{Stream: "sweatShop:analyze", Values: map[string]interface{}{"url": "https://github.com/org/repo"}}))
+
+	c, err := redisqueue.NewConsumerWithOptions(&redisqueue.ConsumerOptions{RedisClient: r.Client, BlockingTimeout: 10 * time.Millisecond, Concurrency: 1})
+	require.NoError(t, err)
+	received := make(chan *redisqueue.Message, 1)
+	c.Register("sweatShop:analyze", func(msg *redisqueue.Message) error {
+		received <- msg
+		return nil
+	})
+	go func() {
+		for range c.Errors {
+		}
+	}()
+	go c.Run()
+	defer c.Shutdown()
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "https://github.com/org/repo", msg.Values["url"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestNewRedisWithOptionsTLS(t *testing.T) {
+
+	dir := t.TempDir()
+	ca, caFile := writeCA(t, dir)
+	serverCert, _, _ := writeCertificate(t, dir, "server", ca)
+	_, certFile, keyFile := writeCertificate(t, dir, "client", ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	m, err := miniredis.RunTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	require.NoError(t, err)
+	defer m.Close()
+	m.RequireUserAuth("analyzer", "secret")
+	withJSON(t, m)
+
+	o := &Options{
+		Addrs:    []string{m.Addr()},
+		Username: "analyzer",
+		Password: "secret",
+		DB:       2,
+		TLS:      &TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "localhost"},
+	}
+	r, err := NewRedisWithOptions(o)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", r.Server)
+	checkRedis(t, r)
+
+	assert.NoError(t, r.Client.Set(context.Background(), "key", "value", 0).Err())
+	v, err := m.DB(2).Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", v)
+
+	// without the client certificate
+	o.TLS = &TLSOptions{CAFile: caFile, ServerName: "localhost"}
+	r, err = NewRedisWithOptions(o)
+	require.NoError(t, err)
+	assert.Error(t, r.Client.Ping(context.Background()).Err())
+
+	// with the wrong user
+	o.TLS = &TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "localhost"}
+	o.Username = "default"
+	r, err = NewRedisWithOptions(o)
+	require.NoError(t, err)
+	assert.Error(t, r.Client.Ping(context.Background()).Err())
+}
+
+func TestNewRedisWithOptionsSentinel(t *testing.T) {
+
+	m := miniredis.RunT(t)
+	m.RequireAuth("secret")
+	withJSON(t, m)
+
+	sentinel, err := server.NewServer("127.0.0.1:0")
+	require.NoError(t, err)
+	defer sentinel.Close()
+	require.NoError(t, sentinel.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch {
+		case len(args) == 2 && args[0] == "get-master-addr-by-name" && args[1] == "mymaster":
+			host, port, _ := net.SplitHostPort(m.Addr())
+			c.WriteStrings([]string{host, port})
+		case len(args) == 2 && args[0] == "get-master-addr-by-name":
+			c.WriteNull()
+		default:
+			c.WriteLen(0)
+		}
+	}))
+	require.NoError(t, sentinel.Register("SUBSCRIBE", func(c *server.Peer, cmd string, args []string) {
+		c.WriteLen(3)
+		c.WriteBulk("subscribe")
+		c.WriteBulk(args[0])
+		c.WriteInt(1)
+	}))
+
+	r, err := NewRedisWithOptions(&Options{Addrs: []string{sentinel.Addr().String()}, MasterName: "mymaster", Password: "secret", DB: 1})
+	require.NoError(t, err)
+	assert.IsType(t, &goredis.Client{}, r.Client)
+	assert.False(t, r.IsCluster())
+	checkRedis(t, r)
+
+	r, err = NewRedisWithOptions(&Options{Addrs: []string{sentinel.Addr().String()}, MasterName: "other"})
+	require.NoError(t, err)
+	assert.Error(t, r.Client.Ping(context.Background()).Err())
+}
+
+func TestNewRedisWithOptionsCluster(t *testing.T) {
+
+	m := miniredis.RunT(t)
+	withJSON(t, m)
+
+	r, err := NewRedisWithOptions(&Options{Addrs: []string{m.Addr()}, Cluster: true})
+	require.NoError(t, err)
+	assert.True(t, r.IsCluster())
+	checkRedis(t, r)
+
+	_, err = NewRedisWithOptions(&Options{Addrs: []string{m.Addr()}, Cluster: true, DB: 1})
+	assert.EqualError(t, err, "redis cluster has no database 1")
+	_, err = NewRedisWithOptions(&Options{Addrs: []string{m.Addr()}, Cluster: true, MasterName: "mymaster"})
+	assert.Error(t, err)
+	_, err = NewRedisWithOptions(&Options{})
+	assert.EqualError(t, err, "no redis address configured")
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+
+	t.Setenv("REDIS_SERVER", "redis")
+	t.Setenv("REDIS_PORT", "6379")
+	t.Setenv("REDIS_PASSWORD", "secret")
+
+	o, err := OptionsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &Options{Addrs: []string{"redis:6379"}, Password: "secret"}, o)
+
+	t.Setenv("REDIS_ADDRS", "sentinel-0:26379, sentinel-1:26379")
+	t.Setenv("REDIS_MASTER_NAME", "mymaster")
+	t.Setenv("REDIS_USERNAME", "analyzer")
+	t.Setenv("REDIS_DB", "3")
+	t.Setenv("REDIS_TLS", "true")
+	t.Setenv("REDIS_TLS_CA_FILE", "/etc/redis/ca.crt")
+	o, err = OptionsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &Options{
+		Addrs:      []string{"sentinel-0:26379", "sentinel-1:26379"},
+		Username:   "analyzer",
+		Password:   "secret",
+		DB:         3,
+		MasterName: "mymaster",
+		TLS:        &TLSOptions{CAFile: "/etc/redis/ca.crt"},
+	}, o)
+
+	t.Setenv("REDIS_CLUSTER", "yes")
+	_, err = OptionsFromEnv()
+	assert.EqualError(t, err, `invalid boolean "yes" for REDIS_CLUSTER`)
+
+	t.Setenv("REDIS_ADDRS", "")
+	t.Setenv("REDIS_PORT", "")
+	_, err = OptionsFromEnv()
+	assert.Error(t, err)
+}
+
+// writeCA writes a self signed CA to dir
+func writeCA(t *testing.T, dir string) (tls.Certificate, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sweatShop test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, path
+}
+
+// writeCertificate writes a certificate for localhost signed by the CA to dir
+func writeCertificate(t *testing.T, dir, name string, ca tls.Certificate) (tls.Certificate, string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Leaf, &key.PublicKey, ca.PrivateKey)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, certFile, keyFile
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	serviceName = "sweatShop-analyzer"
+
+	// ExporterEnv selects the span exporter: otlp, stdout, file or none (default)
+	ExporterEnv = "OTEL_TRACES_EXPORTER"
+	// FileEnv sets the output path of the file exporter
+	FileEnv = "OTEL_TRACES_FILE"
+
+	defaultTraceFile = "/tmp/sweatShop-analyzer-traces.json"
+)
+
+// ShutdownFunc flushes and stops the tracer provider
+type ShutdownFunc func(context.Context) error
+
+// InitTracerProvider registers a global tracer provider and the W3C trace
+// context propagator. The exporter is selected by OTEL_TRACES_EXPORTER; the
+// otlp exporter is configured by the standard OTEL_EXPORTER_OTLP_* variables.
+func InitTracerProvider(ctx context.Context) (ShutdownFunc, error) {
+
+	// always propagate, even if nothing is exported, so trace context passes through
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, closer, err := newExporter(ctx, strings.ToLower(os.Getenv(ExporterEnv)))
+	if err != nil {
+		return nil, err
+	}
+
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, name string) (sdktrace.SpanExporter, io.Closer, error) {
+
+	switch name {
+	case "", "none":
+		return nil, nil, nil
+
+	case "otlp":
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create otlp exporter: %w", err)
+		}
+		return exporter, nil, nil
+
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create stdout exporter: %w", err)
+		}
+		return exporter, nil, nil
+
+	case "file":
+		path := os.Getenv(FileEnv)
+		if path == "" {
+			path = defaultTraceFile
+		}
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open trace file %s: %w", path, err)
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("could not create file exporter: %w", err)
+		}
+		return exporter, f, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown trace exporter %q", name)
+}
+
+// MessageCarrier adapts the values of a stream message to a TextMapCarrier
+type MessageCarrier map[string]interface{}
+
+func (c MessageCarrier) Get(key string) string {
+	if v, ok := c[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (c MessageCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c MessageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ExtractFromMessage returns a context continuing the trace found in the message values
+func ExtractFromMessage(ctx context.Context, values map[string]interface{}) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, MessageCarrier(values))
+}
+
+// InjectIntoMessage writes the trace context of ctx into the message values
+func InjectIntoMessage(ctx context.Context, values map[string]interface{}) {
+	otel.GetTextMapPropagator().Inject(ctx, MessageCarrier(values))
+}
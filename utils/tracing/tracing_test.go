@@ -0,0 +1,51 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package tracing
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMessagePropagation(t *testing.T) {
+
+	t.Setenv(ExporterEnv, "none")
+	shutdown, err := InitTracerProvider(context.Background())
+	assert.NoError(t, err)
+	defer shutdown(context.Background())
+
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "producer")
+	defer span.End()
+
+	values := map[string]interface{}{
+		"url": "https://github.com/fluxcd/flux2",
+	}
+	InjectIntoMessage(ctx, values)
+	assert.Contains(t, values, "traceparent")
+
+	extracted := trace.SpanContextFromContext(ExtractFromMessage(context.Background(), values))
+	assert.True(t, extracted.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+}
+
+func TestInitTracerProvider(t *testing.T) {
+
+	t.Setenv(ExporterEnv, "file")
+	t.Setenv(FileEnv, filepath.Join(t.TempDir(), "traces.json"))
+	shutdown, err := InitTracerProvider(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	t.Setenv(ExporterEnv, "unknown")
+	_, err = InitTracerProvider(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,33 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// giteaPush is the push payload of Gitea, with the number of pushed commits
+// as it truncates the commits
+type giteaPush struct {
+	githubPush
+	TotalCommits int `json:"total_commits"`
+}
+
+func parseGitea(event string, body []byte) (*PushEvent, error) {
+
+	if event != "push" {
+		return nil, &IgnoredError{Reason: "gitea event " + event}
+	}
+
+	p := &giteaPush{}
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, fmt.Errorf("could not parse gitea push: %w", err)
+	}
+
+	complete := p.TotalCommits == len(p.Commits)
+
+	return newPushEvent(ProviderGitea, p.Ref, p.Before, p.After, p.Repository.CloneURL, p.Repository.Name, p.Commits, complete)
+}
@@ -0,0 +1,43 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// githubMaxCommits is the maximum number of commits of a push payload
+const githubMaxCommits = 2048
+
+// githubPush is the push payload of GitHub, which Gitea shares
+type githubPush struct {
+	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	Forced     bool   `json:"forced"`
+	Repository struct {
+		Name     string `json:"name"`
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+	Commits []pushCommit `json:"commits"`
+}
+
+func parseGitHub(event string, body []byte) (*PushEvent, error) {
+
+	if event != "push" {
+		return nil, &IgnoredError{Reason: "github event " + event}
+	}
+
+	p := &githubPush{}
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, fmt.Errorf("could not parse github push: %w", err)
+	}
+
+	// the commits of a force push miss the replaced ones
+	complete := !p.Forced && len(p.Commits) < githubMaxCommits
+
+	return newPushEvent(ProviderGitHub, p.Ref, p.Before, p.After, p.Repository.CloneURL, p.Repository.Name, p.Commits, complete)
+}
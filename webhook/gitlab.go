@@ -0,0 +1,40 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// gitlabPush is the push and tag push payload of GitLab, which truncates the
+// commits to the latest 20
+type gitlabPush struct {
+	Ref     string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Project struct {
+		Name       string `json:"name"`
+		GitHTTPURL string `json:"git_http_url"`
+	} `json:"project"`
+	Commits           []pushCommit `json:"commits"`
+	TotalCommitsCount int          `json:"total_commits_count"`
+}
+
+func parseGitLab(event string, body []byte) (*PushEvent, error) {
+
+	if event != "Push Hook" && event != "Tag Push Hook" {
+		return nil, &IgnoredError{Reason: "gitlab event " + event}
+	}
+
+	p := &gitlabPush{}
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, fmt.Errorf("could not parse gitlab push: %w", err)
+	}
+
+	complete := p.TotalCommitsCount == len(p.Commits)
+
+	return newPushEvent(ProviderGitLab, p.Ref, p.Before, p.After, p.Project.GitHTTPURL, p.Project.Name, p.Commits, complete)
+}
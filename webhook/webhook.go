@@ -0,0 +1,240 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+// Package webhook verifies and parses the push webhooks of forges, to analyze
+// repositories as soon as they change.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	ProviderGitHub = "github"
+	ProviderGitea  = "gitea"
+	ProviderGitLab = "gitlab"
+)
+
+// maxPayload is the maximum size of a payload, as sent by GitHub
+const maxPayload = 25 << 20
+
+// zeroCommit is the before commit of a created and the after commit of a
+// deleted ref
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+var (
+	// ErrUnknownProvider is returned for requests without the event header of
+	// a supported forge
+	ErrUnknownProvider = errors.New("unknown webhook provider")
+	// ErrDisabled is returned for a forge without a secret
+	ErrDisabled = errors.New("webhooks of the provider are not enabled")
+	// ErrInvalidSignature is returned if the signature or token does not
+	// match the secret
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// IgnoredError is returned for verified events that trigger no analysis,
+// e.g. pings or deleted branches
+type IgnoredError struct {
+	Reason string
+}
+
+func (e *IgnoredError) Error() string {
+	return "ignored: " + e.Reason
+}
+
+// Secrets are the webhook secrets of the forges. Webhooks of a forge without
+// a secret are rejected.
+type Secrets struct {
+	GitHub string
+	Gitea  string
+	// GitLab is compared with the secret token of the webhook
+	GitLab string
+}
+
+// PushEvent is a verified push of a branch or tag
+type PushEvent struct {
+	Provider string
+	// CloneURL is the http clone url of the repository
+	CloneURL string
+	Name     string
+	// Ref is the pushed ref, e.g. "refs/heads/main"
+	Ref string
+	// Revision is the branch or tag name of the ref
+	Revision string
+	Before   string
+	After    string
+	// Added and Removed are the files of all pushed commits. A file added
+	// and removed again is listed in both.
+	Added   []string
+	Removed []string
+	// Complete reports whether the payload listed the files of all pushed
+	// commits. Forges truncate the commits of large pushes.
+	Complete bool
+}
+
+// pushCommit is a pushed commit of the payloads of all forges
+type pushCommit struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// Parse detects the forge of the webhook request, verifies its signature or
+// secret token and parses the push event
+func Parse(r *http.Request, secrets Secrets) (*PushEvent, error) {
+
+	provider := detectProvider(r.Header)
+	if provider == "" {
+		return nil, ErrUnknownProvider
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayload+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not read payload: %w", err)
+	}
+	if len(body) > maxPayload {
+		return nil, fmt.Errorf("payload exceeds %d bytes", maxPayload)
+	}
+
+	switch provider {
+	case ProviderGitea:
+		// gitea signs with the hex digest only
+		if err := verifySignature(body, secrets.Gitea, r.Header.Get("X-Gitea-Signature"), ""); err != nil {
+			return nil, err
+		}
+		return parseGitea(r.Header.Get("X-Gitea-Event"), body)
+
+	case ProviderGitLab:
+		if err := verifyToken(secrets.GitLab, r.Header.Get("X-Gitlab-Token")); err != nil {
+			return nil, err
+		}
+		return parseGitLab(r.Header.Get("X-Gitlab-Event"), body)
+
+	default:
+		if err := verifySignature(body, secrets.GitHub, r.Header.Get("X-Hub-Signature-256"), "sha256="); err != nil {
+			return nil, err
+		}
+		return parseGitHub(r.Header.Get("X-GitHub-Event"), body)
+	}
+}
+
+// detectProvider returns the forge by its event header. Gitea also sends the
+// event headers of GitHub, so it is checked first.
+func detectProvider(h http.Header) string {
+
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		return ProviderGitea
+	case h.Get("X-Gitlab-Event") != "":
+		return ProviderGitLab
+	case h.Get("X-GitHub-Event") != "":
+		return ProviderGitHub
+	}
+
+	return ""
+}
+
+// verifySignature checks the hex encoded HMAC-SHA256 of the body
+func verifySignature(body []byte, secret, signature, prefix string) error {
+
+	if secret == "" {
+		return ErrDisabled
+	}
+
+	sig, ok := strings.CutPrefix(signature, prefix)
+	if !ok || sig == "" {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(strings.ToLower(sig)), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// verifyToken compares the secret token in constant time
+func verifyToken(secret, token string) error {
+
+	if secret == "" {
+		return ErrDisabled
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(token)) != 1 {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// newPushEvent builds the event of a push of a branch or tag. complete
+// reports whether the commits are all pushed commits.
+func newPushEvent(provider, ref, before, after, cloneURL, name string, commits []pushCommit, complete bool) (*PushEvent, error) {
+
+	if after == zeroCommit {
+		return nil, &IgnoredError{Reason: "deleted " + ref}
+	}
+
+	var revision string
+	switch {
+	case strings.HasPrefix(ref, "refs/heads/"):
+		revision = strings.TrimPrefix(ref, "refs/heads/")
+	case strings.HasPrefix(ref, "refs/tags/"):
+		revision = strings.TrimPrefix(ref, "refs/tags/")
+	default:
+		return nil, &IgnoredError{Reason: "push of " + ref}
+	}
+
+	if cloneURL == "" || after == "" {
+		return nil, fmt.Errorf("no clone url or commit in push of %s", ref)
+	}
+
+	e := &PushEvent{
+		Provider: provider,
+		CloneURL: cloneURL,
+		Name:     name,
+		Ref:      ref,
+		Revision: revision,
+		Before:   before,
+		After:    after,
+		// a created ref has no commit to compare with
+		Complete: complete && len(commits) > 0 && before != "" && before != zeroCommit,
+	}
+
+	if !e.Complete {
+		return e, nil
+	}
+
+	e.Added = make([]string, 0)
+	e.Removed = make([]string, 0)
+	added, removed := make(map[string]bool), make(map[string]bool)
+	for _, c := range commits {
+		for _, f := range c.Added {
+			if !added[f] {
+				added[f] = true
+				e.Added = append(e.Added, f)
+			}
+		}
+		for _, f := range c.Removed {
+			if !removed[f] {
+				removed[f] = true
+				e.Removed = append(e.Removed, f)
+			}
+		}
+	}
+
+	return e, nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright © 2023 PATRICK HERMANN patrick.hermann@sva.de
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var secrets = Secrets{GitHub: "github-secret", Gitea: "gitea-secret", GitLab: "gitlab-secret"}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func request(body string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+const githubPayload = `{
+	"ref": "refs/heads/main",
+	"before": "a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1",
+	"after": "b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2",
+	"forced": false,
+	"repository": {"name": "flux2", "full_name": "fluxcd/flux2", "clone_url": "https://github.com/fluxcd/flux2.git"},
+	"commits": [
+		{"added": ["Dockerfile", "tmp/Chart.yaml"], "removed": [], "modified": ["go.mod"]},
+		{"added": ["chart/Chart.yaml"], "removed": ["tmp/Chart.yaml"], "modified": []}
+	]
+}`
+
+func TestParseGitHub(t *testing.T) {
+
+	e, err := Parse(request(githubPayload, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=" + sign("github-secret", githubPayload),
+	}), secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, &PushEvent{
+		Provider: ProviderGitHub,
+		CloneURL: "https://github.com/fluxcd/flux2.git",
+		Name:     "flux2",
+		Ref:      "refs/heads/main",
+		Revision: "main",
+		Before:   "a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1",
+		After:    "b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2",
+		Added:    []string{"Dockerfile", "tmp/Chart.yaml", "chart/Chart.yaml"},
+		Removed:  []string{"tmp/Chart.yaml"},
+		Complete: true,
+	}, e)
+
+	// signed with another secret, or not at all
+	for _, signature := range []string{"sha256=" + sign("other", githubPayload), sign("github-secret", githubPayload), ""} {
+		_, err = Parse(request(githubPayload, map[string]string{
+			"X-GitHub-Event":      "push",
+			"X-Hub-Signature-256": signature,
+		}), secrets)
+		assert.Equal(t, ErrInvalidSignature, err)
+	}
+
+	// without a secret
+	_, err = Parse(request(githubPayload, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=" + sign("", githubPayload),
+	}), Secrets{GitLab: "gitlab-secret"})
+	assert.Equal(t, ErrDisabled, err)
+
+	// other events
+	ping := `{"zen": "Keep it logically awesome."}`
+	_, err = Parse(request(ping, map[string]string{
+		"X-GitHub-Event":      "ping",
+		"X-Hub-Signature-256": "sha256=" + sign("github-secret", ping),
+	}), secrets)
+	var ignored *IgnoredError
+	assert.True(t, errors.As(err, &ignored))
+}
+
+func TestParseGitea(t *testing.T) {
+
+	payload := `{
+		"ref": "refs/tags/v1.0.0",
+		"before": "a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1",
+		"after": "b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2",
+		"repository": {"name": "app", "full_name": "org/app", "clone_url": "https://gitea.example.com/org/app.git"},
+		"commits": [{"added": ["go.mod"], "removed": ["Dockerfile"], "modified": []}],
+		"total_commits": 3
+	}`
+
+	// gitea also sends the github headers
+	e, err := Parse(request(payload, map[string]string{
+		"X-Gitea-Event":       "push",
+		"X-GitHub-Event":      "push",
+		"X-Gitea-Signature":   sign("gitea-secret", payload),
+		"X-Hub-Signature-256": "sha256=" + sign("gitea-secret", payload),
+	}), secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, ProviderGitea, e.Provider)
+	assert.Equal(t, "v1.0.0", e.Revision)
+	assert.Equal(t, "https://gitea.example.com/org/app.git", e.CloneURL)
+
+	// the commits are truncated
+	assert.False(t, e.Complete)
+	assert.Nil(t, e.Added)
+}
+
+func TestParseGitLab(t *testing.T) {
+
+	payload := func(before, after string, total int) string {
+		return `{
+			"object_kind": "push",
+			"ref": "refs/heads/develop",
+			"before": "` + before + `",
+			"after": "` + after + `",
+			"project": {"name": "infra", "path_with_namespace": "group/infra", "git_http_url": "https://gitlab.example.com/group/infra.git"},
+			"commits": [{"added": ["main.tf"], "removed": [], "modified": []}],
+			"total_commits_count": ` + strconv.Itoa(total) + `
+		}`
+	}
+	headers := map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "gitlab-secret"}
+
+	e, err := Parse(request(payload("a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1", "b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2", 1), headers), secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, "develop", e.Revision)
+	assert.Equal(t, "https://gitlab.example.com/group/infra.git", e.CloneURL)
+	assert.True(t, e.Complete)
+	assert.Equal(t, []string{"main.tf"}, e.Added)
+	assert.Equal(t, []string{}, e.Removed)
+
+	// a created branch has no commit to compare with
+	e, err = Parse(request(payload(zeroCommit, "b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2", 1), headers), secrets)
+	assert.NoError(t, err)
+	assert.False(t, e.Complete)
+
+	// a deleted branch is not analyzed
+	_, err = Parse(request(payload("a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1", zeroCommit, 0), headers), secrets)
+	var ignored *IgnoredError
+	assert.True(t, errors.As(err, &ignored))
+
+	_, err = Parse(request(payload("a1", "b2", 1), map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}), secrets)
+	assert.Equal(t, ErrInvalidSignature, err)
+
+	_, err = Parse(request(payload("a1", "b2", 1), map[string]string{"X-Gitlab-Event": "Merge Request Hook", "X-Gitlab-Token": "gitlab-secret"}), secrets)
+	assert.True(t, errors.As(err, &ignored))
+
+	_, err = Parse(request(payload("a1", "b2", 1), map[string]string{}), secrets)
+	assert.Equal(t, ErrUnknownProvider, err)
+}